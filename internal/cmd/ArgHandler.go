@@ -20,9 +20,87 @@ import (
 	"flag"
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
+	// Registers the built-in log parsers
+	_ "github.com/vs-eth/microkube/internal/log"
 	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/handlers/kube"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	log3 "github.com/vs-eth/microkube/pkg/log"
+	"github.com/vs-eth/microkube/pkg/pki"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultServiceTimeout is the time a service may take to become healthy if no override is given
+const DefaultServiceTimeout = 30 * time.Second
+
+// DefaultCNIPlugins are the CNI plugins kubenet needs
+const DefaultCNIPlugins = "bridge,host-local,loopback"
+
+// DefaultPodRange and DefaultServiceRange are the pod and service IP ranges used unless overridden
+const (
+	DefaultPodRange     = "10.233.42.1/24"
+	DefaultServiceRange = "10.233.43.1/24"
+)
+
+// DefaultSudoMethod is the tool used to run services as root unless overridden
+const DefaultSudoMethod = "/usr/bin/pkexec"
+
+// DefaultPortBase is the first port assigned to services
+const DefaultPortBase = 7000
+
+// DefaultMaxStartupParallelism is the number of services that may start at the same time once the apiserver is up
+const DefaultMaxStartupParallelism = 4
+
+// DefaultHealthCheckWorkers is the number of health probes that may run at the same time
+const DefaultHealthCheckWorkers = 4
+
+// DefaultHealthFailureThreshold is the number of consecutive failed health probes after which microkube aborts
+const DefaultHealthFailureThreshold = 10
+
+// DefaultShutdownTimeout is the maximum time to wait for all services to exit when stopping
+const DefaultShutdownTimeout = 30 * time.Second
+
+// DefaultDrainTimeout is the maximum time to wait for all pods to be evicted from the node when stopping
+const DefaultDrainTimeout = 2 * time.Minute
+
+// DefaultEtcdQuotaBytes and DefaultEtcdAutoCompactionRetention keep etcd from running out of space after a few days of
+// churn. The quota is the maximum etcd recommends, four times its default.
+const (
+	DefaultEtcdQuotaBytes              = 8 << 30
+	DefaultEtcdAutoCompactionRetention = time.Hour
+)
+
+// DefaultDashboardNamespace and DefaultDashboardServiceAccount identify the service account whose token is used to
+// sign in to the dashboard, as created by the dashboard addon
+const (
+	DefaultDashboardNamespace      = "kube-system"
+	DefaultDashboardServiceAccount = "admin-user"
+)
+
+// serviceNames are the names of all services as used in per-service arguments like '-service-timeout'
+var serviceNames = []string{"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler", "kubelet",
+	"kube-proxy"}
+
+// secureHealthProbeServices are the services that can serve their health endpoints over HTTPS with client
+// certificate authentication. kube-proxy only serves them over plain HTTP.
+var secureHealthProbeServices = []string{"kube-scheduler"}
+
+// Settings applied by '-constrained' unless given explicitly
+const (
+	// ConstrainedGoMaxProcs is the GOMAXPROCS etcd and the apiserver run with
+	ConstrainedGoMaxProcs = 2
+	// ConstrainedMinRequestTimeout is the minimum timeout of long-running apiserver requests (default: 30m)
+	ConstrainedMinRequestTimeout = 5 * time.Minute
+	// ConstrainedMaxRequestsInflight is the number of concurrent non-mutating apiserver requests (default: 400)
+	ConstrainedMaxRequestsInflight = 100
+	// ConstrainedMaxMutatingRequestsInflight is the number of concurrent mutating apiserver requests (default: 200)
+	ConstrainedMaxMutatingRequestsInflight = 50
 )
 
 // argHandlerGlobalState contains the values of all arguments, because flag.CommandLine is a) global and b) cannot be
@@ -33,11 +111,117 @@ type argHandlerGlobalState struct {
 	verbose        bool
 	root           string
 	extraBinDir    string
+	strictVersion  bool
+	etcdBinary     string
+	hyperkubeBin   string
 	podRange       string
 	serviceRange   string
 	sudoMethod     string
 	enableDns      bool
 	enableKubeDash bool
+	// Service account to sign in to the dashboard with, as 'namespace/name'
+	dashboardServiceAccount string
+	serviceTimeout          time.Duration
+	// Per-service overrides of serviceTimeout
+	serviceTimeouts durationMapValue
+	// Maximum time to wait for all services to exit
+	shutdownTimeout time.Duration
+	// Maximum time to wait for the node to be drained
+	drainTimeout   time.Duration
+	fresh          bool
+	seccompDefault bool
+	// Backoff between startup health checks
+	startupBackoffInitial  time.Duration
+	startupBackoffMax      time.Duration
+	startupBackoffAttempts int
+	// Graceful node shutdown settings of the kubelet
+	shutdownGracePeriod             time.Duration
+	shutdownGracePeriodCriticalPods time.Duration
+	etcdTmpfs                       bool
+	dryRun                          bool
+	resourceReportInterval          time.Duration
+	etcdAutoDefrag                  bool
+	etcdQuotaBytes                  string
+	etcdAutoCompactionRetention     time.Duration
+	proxyClusterCIDR                string
+	defaultNamespace                string
+	defaultQuota                    string
+	defaultLimitRange               string
+	dnsReplicas                     int
+	captureOutputDir                string
+	rawLogs                         bool
+	maxStartupParallelism           int
+	egressSelectorConfig            string
+	enableAudit                     bool
+	auditLog                        string
+	auditPolicy                     string
+	enableAdmissionPlugins          commaListValue
+	disableAdmissionPlugins         commaListValue
+	konnectivity                    bool
+	metricsServer                   bool
+	clockSkewTolerance              time.Duration
+	// Per-service users to run as
+	runAsUsers stringMapValue
+	// Additional environment variables for all services
+	extraEnv stringMapValue
+	// Resource settings for small hosts
+	goMaxProcs                     int
+	apiMinRequestTimeout           time.Duration
+	apiMaxRequestsInflight         int
+	apiMaxMutatingRequestsInflight int
+	constrained                    bool
+	apiGoawayChance                float64
+	// Per-service log parser overrides
+	logParsers stringMapValue
+	// Services to probe over HTTPS with a client certificate
+	secureHealthProbes commaListValue
+	// Faults to inject
+	chaosPolicies chaosPolicyValue
+	profiling     optionalBoolValue
+	leaderElect   bool
+	featureGates  boolMapValue
+	// Resource management settings of the kubelet
+	cpuManagerPolicy       string
+	topologyManagerPolicy  string
+	systemReserved         string
+	kubeReserved           string
+	containerRuntime       string
+	rootless               bool
+	cniPlugins             string
+	statusUI               bool
+	statusListen           string
+	metricsListen          string
+	healthCheckWorkers     int
+	healthFailureThreshold int
+	healthCheckTimeout     time.Duration
+	maxRestarts            int
+	outputTailLines        int
+	etcdSANs               stringSliceValue
+	kubeSANs               stringSliceValue
+	oidcIssuerURL          string
+	oidcClientID           string
+	oidcUsernameClaim      string
+	kubeCACert             string
+	kubeCAKey              string
+	caBundle               string
+	progressFile           string
+	progressSocket         string
+	healthEvents           string
+	validateManifests      bool
+	keyAlgorithm           string
+	certValidity           time.Duration
+	join                   string
+	noKubelet              bool
+	snapshotOnExit         bool
+	teardown               bool
+	restoreFrom            string
+	forceRestore           bool
+	portBase               int
+	autoPortBase           bool
+	apiBindAddress         string
+	ctrlMgrBindAddress     string
+	extraKubeconfigs       stringSliceValue
+	manifestDir            string
 }
 
 // gs contains the instance of argHandlerGlobalState
@@ -50,18 +234,132 @@ type ArgHandler struct {
 	BaseDir string
 	// Directory to additionally include in the binary search path
 	ExtraBinDir string
+	// Paths of the etcd and hyperkube binaries to use instead of searching them, empty to search them
+	EtcdBinary      string
+	HyperkubeBinary string
+	// Whether to fail if the version of etcd or hyperkube is unsupported, instead of only warning
+	StrictVersion bool
 	// Network range to use for pods
 	PodRangeNet *net.IPNet
 	// Network range to use for services
 	ServiceRangeNet *net.IPNet
 	// Network range that contains both pod and service range
 	ClusterIPRange *net.IPNet
+	// Network range kube-proxy considers cluster-internal. Traffic to services from outside of it is masqueraded.
+	ProxyClusterCIDR *net.IPNet
+	// CNI plugins to copy from ExtraBinDir to the kubelet's CNI directory
+	CNIPlugins []string
 	// Whether to deploy the kubernetes dashboard cluster addon
 	EnableKubeDash bool
+	// Namespace and name of the service account whose token is printed to sign in to the dashboard
+	DashboardNamespace      string
+	DashboardServiceAccount string
 	// Whether to deploy the CoreDNS cluster addon
 	EnableDns bool
+	// Whether to deploy the konnectivity cluster addon and route apiserver-to-cluster traffic through it
+	EnableKonnectivity bool
+	// Whether to deploy the metrics-server cluster addon
+	EnableMetricsServer bool
+	// Whether the apiserver writes an audit log
+	EnableAudit bool
+	// Number of CoreDNS replicas, 0 to derive it from the number of nodes
+	DNSReplicas int
+	// Directory to record the raw output of all services to, empty if disabled
+	CaptureOutputDir string
+	// Whether to record the raw output of each service to '<BaseDir>/logs/<service>.log'
+	RawLogs bool
 	// Whether to include verbose log output
 	Verbose bool
+	// Time a service may take to become healthy during startup, unless overridden in ServiceTimeouts
+	ServiceTimeout time.Duration
+	// Per-service startup timeouts, indexed by service name (e.g. 'etcd')
+	ServiceTimeouts map[string]time.Duration
+	// Maximum time to wait for all services to exit when stopping
+	ShutdownTimeout time.Duration
+	// Maximum time to wait for all pods to be evicted from the node when stopping, 0 for no limit
+	DrainTimeout time.Duration
+	// Backoff between health checks of a service during startup, bounded by its startup timeout
+	StartupBackoff helpers.Backoff
+	// Per-service users to run as, indexed by service name (e.g. 'etcd')
+	RunAsUsers map[string]string
+	// Per-service log parsers overriding the default, indexed by service name (e.g. 'kube-apiserver')
+	LogParsers map[string]string
+	// Services whose health endpoints are served and probed over HTTPS with a client certificate, indexed by service
+	// name (e.g. 'kube-scheduler')
+	SecureHealthProbes map[string]bool
+	// Faults to inject into services after startup, empty if disabled
+	ChaosPolicies []ChaosPolicy
+	// Whether to wipe the kubelet's pod state before starting
+	Fresh bool
+	// Namespace to create during startup
+	DefaultNamespace string
+	// Resource quota to apply to DefaultNamespace (e.g. 'cpu=2,pods=10'), empty if none
+	DefaultQuota string
+	// Default container limits to apply to DefaultNamespace (e.g. 'cpu=500m,memory=512Mi'), empty if none
+	DefaultLimitRange string
+	// Whether to keep etcd's data in memory (tmpfs)
+	EtcdTmpfs bool
+	// Whether to save a snapshot of etcd when stopping
+	SnapshotOnExit bool
+	// Whether to delete all addons and user manifests when stopping
+	Teardown bool
+	// Snapshot to restore etcd's data from before starting it, empty if none
+	RestoreFrom string
+	// Whether to remove existing etcd data in order to restore RestoreFrom
+	ForceRestore bool
+	// Whether to only print the command lines of all services instead of starting them
+	DryRun bool
+	// Interval in which to log the resource usage of all services, 0 if disabled
+	ResourceReportInterval time.Duration
+	// Maximum number of services that depend only on the apiserver to start at the same time
+	MaxStartupParallelism int
+	// How far the host clock may be off when checking the validity of existing certificates
+	ClockSkewTolerance time.Duration
+	// Type of keys to create for new certificates
+	KeyAlgorithm pki.KeyAlgorithm
+	// How long new certificates are valid
+	CertValidity time.Duration
+	// Additional IPs or DNS names to include in the etcd server certificate
+	EtcdSANs []string
+	// Additional IPs or DNS names to include in the kube-apiserver serving certificate
+	KubeSANs []string
+	// OpenID Connect authentication settings of the apiserver, disabled if empty
+	OIDC kube.OIDCConfig
+	// Paths of an existing CA certificate and it's key to use as kubernetes CA, empty to use a self-signed one
+	ExternalKubeCACert string
+	ExternalKubeCAKey  string
+	// File to write the etcd and kubernetes CA certificates to, empty if disabled
+	CABundle string
+	// Address to serve the built-in status page on, empty if disabled
+	StatusListen string
+	// Address to serve microkube's own Prometheus metrics on, empty if disabled
+	MetricsListen string
+	// Maximum number of health probes to run at the same time
+	HealthCheckWorkers int
+	// Number of consecutive failed health probes of a service after which microkube aborts, 0 to only warn
+	HealthFailureThreshold int
+	// File to append startup progress events to, empty if disabled
+	ProgressFile string
+	// Unix socket to send startup progress events to, empty if disabled
+	ProgressSocket string
+	// Unix socket or HTTP(S) URL to publish health probe results to, empty if disabled
+	HealthEvents string
+	// Whether to validate addon manifests against the apiserver before applying them
+	ValidateManifests bool
+	// Directory of manifests to apply after the cluster addons, empty if none
+	ManifestDir string
+	// Address (host:port) of the apiserver of an existing cluster to join as worker node, empty to run the control
+	// plane
+	JoinAPIServer string
+	// Whether to only run the control plane, without kubelet and kube-proxy
+	NoKubelet bool
+	// Whether to move all service ports to the next free range if one of them is in use on startup
+	AutoPortBase bool
+	// Kinds of kubeconfigs to create in addition to the admin one (e.g. 'readonly')
+	ExtraKubeconfigs []string
+
+	// Subcommand given after all flags (e.g. 'check-pki'), empty to run the cluster
+	Command string
 
 	// Whether we should set up all arguments (main binary) or only shared arguments (cluster parameters)
 	isMainBinary bool
@@ -98,18 +396,260 @@ func (a *ArgHandler) setupStringArg(name, description string, global *string, de
 	}
 }
 
+// setupIntArg creates an integer argument if necessary. Subsequent calls will be ignored.
+func (a *ArgHandler) setupIntArg(name, description string, global *int, defaultVal int) {
+	lk := flag.Lookup(name)
+	if lk == nil {
+		flag.IntVar(global, name, defaultVal, description)
+	}
+}
+
+// setupFloat64Arg creates a floating point argument if necessary. Subsequent calls will be ignored.
+func (a *ArgHandler) setupFloat64Arg(name, description string, global *float64, defaultVal float64) {
+	lk := flag.Lookup(name)
+	if lk == nil {
+		flag.Float64Var(global, name, defaultVal, description)
+	}
+}
+
+// setupDurationArg creates a duration argument if necessary. Subsequent calls will be ignored.
+func (a *ArgHandler) setupDurationArg(name, description string, global *time.Duration, defaultVal time.Duration) {
+	lk := flag.Lookup(name)
+	if lk == nil {
+		flag.DurationVar(global, name, defaultVal, description)
+	}
+}
+
+// setupVarArg creates an argument with a custom type if necessary. Subsequent calls will be ignored.
+func (a *ArgHandler) setupVarArg(name, description string, global flag.Value) {
+	lk := flag.Lookup(name)
+	if lk == nil {
+		flag.Var(global, name, description)
+	}
+}
+
 // setupArg registers command line arguments
 func (a *ArgHandler) setupArgs() {
 	a.setupBoolArg("verbose", "Enable verbose output", &gs.verbose, false)
-	a.setupStringArg("pod-range", "Pod IP range to use", &gs.podRange, "10.233.42.1/24")
-	a.setupStringArg("service-range", "Service IP range to use", &gs.serviceRange, "10.233.43.1/24")
+	a.setupStringArg("pod-range", "Pod IP range to use", &gs.podRange, DefaultPodRange)
+	a.setupStringArg("service-range", "Service IP range to use", &gs.serviceRange, DefaultServiceRange)
+	a.setupIntArg("port-base", "First of the ten consecutive ports assigned to services", &gs.portBase,
+		DefaultPortBase)
 
 	if a.isMainBinary {
 		a.setupStringArg("root", "Microkube root directory", &gs.root, "~/.mukube")
 		a.setupStringArg("extra-bin-dir", "Additional directory to search for executables", &gs.extraBinDir, "")
-		a.setupStringArg("sudo", "Sudo tool to use", &gs.sudoMethod, "/usr/bin/pkexec")
+		a.setupStringArg("etcd-binary", "Path of the etcd binary to use instead of searching it",
+			&gs.etcdBinary, "")
+		a.setupStringArg("hyperkube-binary", "Path of the hyperkube binary to use instead of searching it",
+			&gs.hyperkubeBin, "")
+		a.setupBoolArg("strict-version", "Fail if the version of etcd or the kubernetes binaries isn't supported "+
+			"(Kubernetes 1.11 to 1.18, with or without hyperkube), instead of only warning", &gs.strictVersion, false)
+		a.setupStringArg("sudo", "Sudo tool to use", &gs.sudoMethod, DefaultSudoMethod)
 		a.setupBoolArg("kube-dash", "Enable the kubernetes dashboard deployment", &gs.enableKubeDash, true)
+		a.setupStringArg("dashboard-service-account", "Service account whose token to print for signing in to the "+
+			"dashboard, as 'namespace/name'", &gs.dashboardServiceAccount,
+			DefaultDashboardNamespace+"/"+DefaultDashboardServiceAccount)
 		a.setupBoolArg("dns", "Enable the DNS deployment", &gs.enableDns, true)
+		a.setupIntArg("dns-replicas", "Number of CoreDNS replicas (0: one per node, at most two)", &gs.dnsReplicas, 0)
+		a.setupDurationArg("service-timeout-default", "Time a service may take to become healthy during startup",
+			&gs.serviceTimeout, DefaultServiceTimeout)
+		a.setupVarArg("service-timeout", "Startup timeout for a single service as 'service=duration' (e.g. "+
+			"'etcd=2m'), may be repeated", &gs.serviceTimeouts)
+		a.setupDurationArg("shutdown-timeout", "Maximum time to wait for all services to exit when stopping (0: "+
+			"don't wait)", &gs.shutdownTimeout, DefaultShutdownTimeout)
+		a.setupDurationArg("drain-timeout", "Maximum time to wait for all pods to be evicted from the node when "+
+			"stopping (0: no limit)", &gs.drainTimeout, DefaultDrainTimeout)
+		a.setupDurationArg("startup-backoff-initial", "Delay before the first health check of a starting service, "+
+			"doubled after each failed check", &gs.startupBackoffInitial, helpers.DefaultStartupBackoff.Initial)
+		a.setupDurationArg("startup-backoff-max", "Maximum delay between health checks of a starting service",
+			&gs.startupBackoffMax, helpers.DefaultStartupBackoff.Max)
+		a.setupIntArg("startup-backoff-attempts", "Maximum number of health checks of a starting service (0: "+
+			"until its startup timeout runs out)", &gs.startupBackoffAttempts, helpers.DefaultStartupBackoff.MaxAttempts)
+		a.setupBoolArg("fresh", "Unmount leftovers and remove all pod state of the kubelet before starting",
+			&gs.fresh, false)
+		a.setupBoolArg("seccomp-default", "Apply the RuntimeDefault seccomp profile to all pods by default "+
+			"(requires a kubelet supporting the SeccompDefault feature)", &gs.seccompDefault, false)
+		a.setupDurationArg("shutdown-grace-period", "Time the kubelet delays a node shutdown to terminate pods "+
+			"(0 disables graceful node shutdown)", &gs.shutdownGracePeriod, 0)
+		a.setupDurationArg("shutdown-grace-period-critical-pods", "Part of the shutdown grace period reserved for "+
+			"critical pods", &gs.shutdownGracePeriodCriticalPods, 0)
+		a.setupStringArg("cpu-manager-policy", "CPU manager policy of the kubelet, 'none' or 'static' (requires "+
+			"CPU to be reserved with -system-reserved or -kube-reserved)", &gs.cpuManagerPolicy, "")
+		a.setupStringArg("topology-manager-policy", "Topology manager policy of the kubelet, 'none', "+
+			"'best-effort', 'restricted' or 'single-numa-node' (requires Kubernetes 1.18+)",
+			&gs.topologyManagerPolicy, "")
+		a.setupStringArg("system-reserved", "Resources the kubelet reserves for system daemons, e.g. "+
+			"'cpu=500m,memory=512Mi'", &gs.systemReserved, "")
+		a.setupStringArg("kube-reserved", "Resources the kubelet reserves for kubernetes components, e.g. "+
+			"'cpu=500m,memory=512Mi'", &gs.kubeReserved, "")
+		a.setupStringArg("container-runtime-endpoint", "CRI endpoint of the container runtime for the kubelet to "+
+			"use instead of docker, e.g. 'unix:///run/containerd/containerd.sock' (a plain path is a unix socket)",
+			&gs.containerRuntime, "")
+		a.setupBoolArg("rootless", "Experimental: run the kubelet in a user namespace using rootlesskit instead of "+
+			"the sudo method. Requires a rootless CRI runtime (-container-runtime-endpoint) and Kubernetes 1.22+, "+
+			"which isn't supported yet. kube-proxy isn't started", &gs.rootless, false)
+		a.setupStringArg("cni-plugins", "Comma-separated list of CNI plugins to copy from the extra binary "+
+			"directory to the kubelet's CNI directory, e.g. to add 'portmap'", &gs.cniPlugins, DefaultCNIPlugins)
+		a.setupBoolArg("etcd-tmpfs", "Keep etcd data on a tmpfs. This speeds up startup considerably, but all "+
+			"cluster state is lost on exit", &gs.etcdTmpfs, false)
+		a.setupBoolArg("dry-run", "Print the command line of each service instead of starting it",
+			&gs.dryRun, false)
+		a.setupDurationArg("resource-report-interval", "Interval in which to log CPU and memory usage of each "+
+			"service (0 to disable)", &gs.resourceReportInterval, 0)
+		a.setupStringArg("proxy-cluster-cidr", "Range kube-proxy considers cluster-internal, traffic from "+
+			"outside of it to services is masqueraded (defaults to the pod range)", &gs.proxyClusterCIDR, "")
+		a.setupStringArg("default-namespace", "Namespace to create during startup", &gs.defaultNamespace,
+			"default")
+		a.setupStringArg("default-quota", "Resource quota to apply to the default namespace, e.g. "+
+			"'cpu=2,memory=4Gi,pods=20'", &gs.defaultQuota, "")
+		a.setupStringArg("default-limitrange", "Default container limits and requests to apply to the default "+
+			"namespace, e.g. 'cpu=500m,memory=512Mi'", &gs.defaultLimitRange, "")
+		a.setupStringArg("capture-output", "Directory to record the raw output of all services to (e.g. for "+
+			"log parser test fixtures)", &gs.captureOutputDir, "")
+		a.setupBoolArg("raw-logs", "Also write the unmodified output of each service to '<root>/logs/<service>.log', "+
+			"keeping the previous run's output as '<service>.log.1'", &gs.rawLogs, false)
+		a.setupIntArg("max-startup-parallelism", "Maximum number of services to start at the same time once the "+
+			"apiserver is up (1: start them sequentially)", &gs.maxStartupParallelism,
+			DefaultMaxStartupParallelism)
+		a.setupStringArg("egress-selector-config", "Egress selector config to pass to the apiserver (requires "+
+			"Kubernetes 1.18+, which reads apiserver.k8s.io/v1alpha1 up to 1.19)", &gs.egressSelectorConfig, "")
+		a.setupBoolArg("enable-audit", "Let the apiserver write an audit log of the requests it handles",
+			&gs.enableAudit, false)
+		a.setupStringArg("audit-log", "Path of the audit log (default '<root>/logs/audit.log')", &gs.auditLog, "")
+		a.setupStringArg("audit-policy", "Audit policy deciding which requests are recorded (default: metadata "+
+			"of all requests and bodies of all changes except for secrets and config maps)", &gs.auditPolicy, "")
+		a.setupVarArg("enable-admission-plugins", "Admission plugins to enable in addition to the apiserver's "+
+			"defaults, comma-separated (e.g. 'PodSecurity'), may be repeated", &gs.enableAdmissionPlugins)
+		a.setupVarArg("disable-admission-plugins", "Admission plugins to disable although enabled by default, "+
+			"comma-separated (e.g. 'ServiceAccount'), may be repeated", &gs.disableAdmissionPlugins)
+		a.setupBoolArg("konnectivity", "Deploy the konnectivity server and agent and proxy apiserver-to-cluster "+
+			"traffic through them, unless -egress-selector-config is given (requires Kubernetes 1.18+)",
+			&gs.konnectivity, false)
+		a.setupBoolArg("enable-metrics-server", "Deploy metrics-server to serve the resource metrics API (e.g. for "+
+			"'kubectl top') through the apiserver's aggregation layer", &gs.metricsServer, false)
+		a.setupBoolArg("etcd-auto-defrag", "Compact and defragment etcd automatically when it runs out of space",
+			&gs.etcdAutoDefrag, false)
+		a.setupStringArg("etcd-quota-bytes", "Size of etcd's database at which it refuses writes, e.g. '4Gi' (0: "+
+			"etcd's default of 2Gi)", &gs.etcdQuotaBytes, strconv.Itoa(DefaultEtcdQuotaBytes>>30)+"Gi")
+		a.setupDurationArg("etcd-auto-compaction-retention", "Age of the revisions etcd compacts periodically "+
+			"(0: no automatic compaction, durations other than whole hours require etcd 3.3+)",
+			&gs.etcdAutoCompactionRetention, DefaultEtcdAutoCompactionRetention)
+		a.setupDurationArg("clock-skew-tolerance", "How far the host clock may be off before existing "+
+			"certificates are considered outside of their validity window", &gs.clockSkewTolerance,
+			pki.DefaultClockSkewTolerance)
+		a.setupStringArg("key-type", "Type of keys to create for new certificates, 'rsa' or 'ecdsa' (P-256, much "+
+			"faster to generate). Existing certificates are kept", &gs.keyAlgorithm, "rsa")
+		a.setupDurationArg("cert-validity", "How long new certificates are valid (e.g. 8760h). Existing "+
+			"certificates are kept, certificates never outlive the CA that signed them", &gs.certValidity,
+			pki.DefaultCertValidity)
+		a.setupVarArg("etcd-san", "Additional IP or DNS name to include in the etcd server certificate, e.g. to "+
+			"reach etcd at something other than localhost, may be repeated. Existing certificates are regenerated "+
+			"if necessary", &gs.etcdSANs)
+		a.setupVarArg("extra-san", "Additional IP or DNS name to include in the kube-apiserver serving "+
+			"certificate, e.g. to reach it through a reverse proxy, may be repeated. Existing certificates are "+
+			"regenerated if necessary", &gs.kubeSANs)
+		a.setupStringArg("oidc-issuer-url", "URL of an OpenID Connect provider whose ID tokens the apiserver "+
+			"accepts, has to use https. Requires -oidc-client-id", &gs.oidcIssuerURL, "")
+		a.setupStringArg("oidc-client-id", "Client ID all OpenID Connect ID tokens must be issued for",
+			&gs.oidcClientID, "")
+		a.setupStringArg("oidc-username-claim", "Claim of OpenID Connect ID tokens to use as user name (default "+
+			"'sub')", &gs.oidcUsernameClaim, "")
+		a.setupStringArg("kube-ca-cert", "Existing CA certificate to sign the kubernetes certificates with instead "+
+			"of a self-signed CA, requires -kube-ca-key. Certificates are regenerated if the CA changes",
+			&gs.kubeCACert, "")
+		a.setupStringArg("kube-ca-key", "Private key of the CA given with -kube-ca-cert", &gs.kubeCAKey, "")
+		a.setupStringArg("ca-bundle", "File to write the etcd and kubernetes CA certificates to as a single PEM "+
+			"bundle on startup, e.g. for curl's --cacert", &gs.caBundle, "")
+		a.setupVarArg("run-as", "User to run a service as, as 'service=user' (e.g. 'etcd=mkube'), may be "+
+			"repeated. The user needs access to the service's files, which aren't chowned. Requires microkubed to "+
+			"run as root, kubelet and kube-proxy always use the sudo method",
+			&gs.runAsUsers)
+		a.setupVarArg("env", "Environment variable to pass to all services, as 'KEY=value' (e.g. "+
+			"'ETCD_UNSUPPORTED_ARCH=arm64'), may be repeated. Overrides variables set by other options like "+
+			"-gomaxprocs", &gs.extraEnv)
+		a.setupIntArg("gomaxprocs", "GOMAXPROCS to run etcd and the apiserver with (0: number of CPUs)",
+			&gs.goMaxProcs, 0)
+		a.setupDurationArg("apiserver-min-request-timeout", "Minimum timeout of long-running apiserver requests "+
+			"like watches (0: apiserver default)", &gs.apiMinRequestTimeout, 0)
+		a.setupIntArg("apiserver-max-requests-inflight", "Maximum number of non-mutating requests the apiserver "+
+			"handles at once (0: apiserver default)", &gs.apiMaxRequestsInflight, 0)
+		a.setupIntArg("apiserver-max-mutating-requests-inflight", "Maximum number of mutating requests the "+
+			"apiserver handles at once (0: apiserver default)", &gs.apiMaxMutatingRequestsInflight, 0)
+		a.setupFloat64Arg("apiserver-goaway-chance", "Probability (0 - 0.02) with which the apiserver asks HTTP/2 "+
+			"clients to reconnect, for testing client reconnection logic (requires Kubernetes 1.18+)",
+			&gs.apiGoawayChance, 0)
+		a.setupBoolArg("constrained", "Tune etcd and the apiserver for small hosts like 2-core CI runners. Explicit "+
+			"-gomaxprocs and -apiserver-* settings take precedence", &gs.constrained, false)
+		a.setupVarArg("log-parser", "Log parser to use for a service as 'service=parser' (e.g. "+
+			"'kube-apiserver=kube'), may be repeated. Available parsers: "+strings.Join(log3.ParserNames(), ", "),
+			&gs.logParsers)
+		a.setupVarArg("chaos", "Inject a fault for resilience testing as 'service:action:interval', may be "+
+			"repeated. Actions: 'restart' kills the service every interval, 'latency' delays apiserver-etcd "+
+			"traffic by interval, 'disconnect' drops apiserver-etcd connections every interval, 'errors' refuses "+
+			"apiserver-etcd connections every other interval (e.g. 'kube-scheduler:restart:5m', "+
+			"'etcd:latency:200ms')", &gs.chaosPolicies)
+		a.setupBoolArg("status-ui", "Serve a read-only status page showing component health, nodes, pods and "+
+			"recent events", &gs.statusUI, false)
+		a.setupStringArg("status-listen", "Address to serve the status page on", &gs.statusListen,
+			"127.0.0.1:7010")
+		a.setupStringArg("metrics-listen", "Address to serve Prometheus metrics about the services (restarts, "+
+			"health checks, uptime) on at /metrics, e.g. '127.0.0.1:7011'", &gs.metricsListen, "")
+		a.setupStringArg("progress-file", "File to append machine-readable startup progress events (JSON lines) to",
+			&gs.progressFile, "")
+		a.setupStringArg("progress-socket", "Unix socket to send machine-readable startup progress events (JSON "+
+			"lines) to. The consumer has to listen on it before microkube starts", &gs.progressSocket, "")
+		a.setupStringArg("health-events", "Unix socket (JSON lines) or HTTP(S) URL (one POST per event) to publish "+
+			"the result of each health probe to as JSON. A socket has to be listened on before microkube starts",
+			&gs.healthEvents, "")
+		a.setupBoolArg("validate-manifests", "Validate addon manifests against the apiserver (server-side dry run) "+
+			"before applying them, skipping addons with invalid objects", &gs.validateManifests, false)
+		a.setupIntArg("health-check-workers", "Maximum number of service health probes to run at the same time",
+			&gs.healthCheckWorkers, DefaultHealthCheckWorkers)
+		a.setupIntArg("health-failure-threshold", "Number of consecutive failed health probes of a service after "+
+			"which microkube aborts (0: never abort, only warn)", &gs.healthFailureThreshold,
+			DefaultHealthFailureThreshold)
+		a.setupDurationArg("health-check-timeout", "Maximum time a single health probe request may take before the "+
+			"service is considered unhealthy", &gs.healthCheckTimeout, handlers.DefaultHealthCheckTimeout)
+		a.setupVarArg("secure-health-probes", "Services to serve health and metrics endpoints over HTTPS requiring a "+
+			"client certificate, comma-separated. They are probed with the admin certificate. Available services: "+
+			strings.Join(secureHealthProbeServices, ", "), &gs.secureHealthProbes)
+		a.setupIntArg("max-restarts", "Number of times a service that exits unexpectedly is restarted before "+
+			"microkube gives up on it (0: never restart)", &gs.maxRestarts, 0)
+		a.setupIntArg("output-tail-lines", "Number of most recent output lines of a service to log when it crashes "+
+			"(0 to log none)", &gs.outputTailLines, helpers.DefaultOutputTailLines)
+		a.setupVarArg("profiling", "Serve pprof endpoints (/debug/pprof) in the apiserver, controller-manager, "+
+			"scheduler and kube-proxy. If not given, the apiserver and controller-manager keep their default of "+
+			"serving them", &gs.profiling)
+		a.setupBoolArg("leader-elect", "Use leader election in the controller-manager and scheduler. Only useful "+
+			"when running additional instances of them against this cluster", &gs.leaderElect, false)
+		a.setupBoolArg("snapshot-on-exit", "Save a snapshot of etcd to 'etcdbackups' in the root directory when "+
+			"stopping. Requires etcdctl", &gs.snapshotOnExit, false)
+		a.setupBoolArg("teardown", "Delete all addons and manifests of -manifest-dir from the cluster when stopping, "+
+			"keeping everything else in etcd", &gs.teardown, false)
+		a.setupStringArg("restore-from", "Restore etcd's data from this snapshot before starting it. Refuses to "+
+			"overwrite existing data unless -force-restore is given. Requires etcdctl", &gs.restoreFrom, "")
+		a.setupBoolArg("force-restore", "Remove existing etcd data in order to restore -restore-from",
+			&gs.forceRestore, false)
+		a.setupStringArg("join", "Join the cluster whose apiserver listens on this address (host:port) as worker "+
+			"node, running only kubelet and kube-proxy. Requires -kube-ca-cert and -kube-ca-key of that cluster",
+			&gs.join, "")
+		a.setupBoolArg("no-kubelet", "Only run the control plane (etcd, apiserver, controller-manager, scheduler), "+
+			"without kubelet and kube-proxy. Pods aren't run, therefore no cluster addons are deployed",
+			&gs.noKubelet, false)
+		a.setupVarArg("feature-gates", "Feature gates to set in the apiserver, controller-manager, scheduler and "+
+			"kubelet, as 'Name=true,Other=false' (e.g. 'CSIBlockVolume=true'), may be repeated", &gs.featureGates)
+		a.setupStringArg("apiserver-bind-address", "Address the apiserver binds to, e.g. '0.0.0.0' for remote "+
+			"kubectl (defaults to the host address)", &gs.apiBindAddress, "")
+		a.setupStringArg("controller-manager-bind-address", "Address the controller-manager binds to (defaults to "+
+			"the host address)", &gs.ctrlMgrBindAddress, "")
+		a.setupVarArg("extra-kubeconfig", "Additional kubeconfig to create in '<root>/kube', may be repeated. "+
+			"'readonly' creates 'kubeconfig-readonly', which may view most objects but not modify them, e.g. to "+
+			"share with teammates", &gs.extraKubeconfigs)
+		a.setupStringArg("manifest-dir", "Directory of YAML or JSON manifests (multiple documents per file are "+
+			"fine) to apply after the cluster addons, in order of their file names", &gs.manifestDir, "")
+		a.setupBoolArg("auto-port-base", "If one of the service ports is in use on startup, move all of them to "+
+			"the next free range instead of failing", &gs.autoPortBase, false)
 	}
 }
 
@@ -127,6 +667,9 @@ func (a *ArgHandler) evalArgs() *handlers.ExecutionEnvironment {
 	if err != nil {
 		log.WithError(err).WithField("extraBinDir", gs.extraBinDir).Fatal("Couldn't expand extraBin directory")
 	}
+	a.EtcdBinary = absolutePath("etcd-binary", gs.etcdBinary)
+	a.HyperkubeBinary = absolutePath("hyperkube-binary", gs.hyperkubeBin)
+	a.StrictVersion = gs.strictVersion
 
 	var serviceRangeIP net.IP
 	var bindAddr net.IP
@@ -134,24 +677,334 @@ func (a *ArgHandler) evalArgs() *handlers.ExecutionEnvironment {
 	if err != nil {
 		log.Fatal("IP calculation returned error, aborting now!")
 	}
-	dnsIP := net.IPv4(0, 0, 0, 0)
-	copy(dnsIP, serviceRangeIP)
-	dnsIP[15]++
+	a.ProxyClusterCIDR = a.PodRangeNet
+	if gs.proxyClusterCIDR != "" {
+		_, a.ProxyClusterCIDR, err = net.ParseCIDR(gs.proxyClusterCIDR)
+		if err != nil {
+			log.WithError(err).WithField("range", gs.proxyClusterCIDR).Fatal("Couldn't parse proxy cluster CIDR")
+		}
+	}
 
 	file, err := os.Stat(gs.sudoMethod)
 	if err != nil || !file.Mode().IsRegular() {
 		log.WithError(err).WithField("sudo", gs.sudoMethod).Fatal("Sudo method is not a regular file!")
 	}
 
+	if gs.shutdownGracePeriodCriticalPods > gs.shutdownGracePeriod {
+		log.WithFields(log.Fields{
+			"gracePeriod":             gs.shutdownGracePeriod,
+			"gracePeriodCriticalPods": gs.shutdownGracePeriodCriticalPods,
+		}).Fatal("Shutdown grace period for critical pods exceeds the total shutdown grace period!")
+	}
+
+	for _, plugin := range strings.Split(gs.cniPlugins, ",") {
+		if plugin = strings.TrimSpace(plugin); plugin != "" {
+			a.CNIPlugins = append(a.CNIPlugins, plugin)
+		}
+	}
 	a.EnableKubeDash = gs.enableKubeDash
+	if a.isMainBinary {
+		parts := strings.Split(gs.dashboardServiceAccount, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.WithField("dashboardServiceAccount", gs.dashboardServiceAccount).Fatal("Dashboard service " +
+				"account must be given as 'namespace/name'")
+		}
+		a.DashboardNamespace, a.DashboardServiceAccount = parts[0], parts[1]
+	}
 	a.EnableDns = gs.enableDns
+	a.EnableKonnectivity = gs.konnectivity
+	a.EnableAudit = gs.enableAudit
+	a.EnableMetricsServer = gs.metricsServer
+	a.DNSReplicas = gs.dnsReplicas
+	a.CaptureOutputDir, err = homedir.Expand(gs.captureOutputDir)
+	if err != nil {
+		log.WithError(err).WithField("captureOutput", gs.captureOutputDir).Fatal("Couldn't expand capture directory")
+	}
+	a.RawLogs = gs.rawLogs
 	a.Verbose = gs.verbose
+	a.ServiceTimeout = gs.serviceTimeout
+	a.ServiceTimeouts = gs.serviceTimeouts
+	a.ShutdownTimeout = gs.shutdownTimeout
+	a.DrainTimeout = gs.drainTimeout
+	if a.isMainBinary && (gs.startupBackoffInitial <= 0 || gs.startupBackoffMax < gs.startupBackoffInitial ||
+		gs.startupBackoffAttempts < 0) {
+		log.WithFields(log.Fields{
+			"initial":  gs.startupBackoffInitial,
+			"max":      gs.startupBackoffMax,
+			"attempts": gs.startupBackoffAttempts,
+		}).Fatal("Invalid startup backoff, the initial delay must be positive and not exceed the maximum")
+	}
+	a.StartupBackoff = helpers.Backoff{
+		Initial:     gs.startupBackoffInitial,
+		Max:         gs.startupBackoffMax,
+		MaxAttempts: gs.startupBackoffAttempts,
+	}
+	for _, service := range []string{"kubelet", "kube-proxy"} {
+		if _, ok := gs.runAsUsers[service]; ok {
+			log.WithField("service", service).Fatal("Service always runs as root using the sudo method")
+		}
+	}
+	a.RunAsUsers = gs.runAsUsers
+	for service, parser := range gs.logParsers {
+		if !hasLogParser(parser) {
+			log.WithFields(log.Fields{
+				"service":   service,
+				"parser":    parser,
+				"available": log3.ParserNames(),
+			}).Fatal("Unknown log parser")
+		}
+	}
+	a.LogParsers = gs.logParsers
+	a.SecureHealthProbes = make(map[string]bool)
+	for _, service := range gs.secureHealthProbes {
+		if !hasSecureHealthProbe(service) {
+			log.WithFields(log.Fields{
+				"service":   service,
+				"available": secureHealthProbeServices,
+			}).Fatal("Service can't serve health checks over HTTPS")
+		}
+		a.SecureHealthProbes[service] = true
+	}
+	a.ChaosPolicies = gs.chaosPolicies
+	a.Fresh = gs.fresh
+	a.EtcdTmpfs = gs.etcdTmpfs
+	a.DryRun = gs.dryRun
+	a.DefaultNamespace = gs.defaultNamespace
+	a.DefaultQuota = gs.defaultQuota
+	a.DefaultLimitRange = gs.defaultLimitRange
+	a.ResourceReportInterval = gs.resourceReportInterval
+	if a.isMainBinary && gs.maxStartupParallelism < 1 {
+		log.WithField("maxStartupParallelism", gs.maxStartupParallelism).Fatal("Startup parallelism must be at least 1")
+	}
+	a.MaxStartupParallelism = gs.maxStartupParallelism
+	if a.isMainBinary && gs.healthCheckWorkers < 1 {
+		log.WithField("healthCheckWorkers", gs.healthCheckWorkers).Fatal("Health check workers must be at least 1")
+	}
+	a.HealthCheckWorkers = gs.healthCheckWorkers
+	if a.isMainBinary && gs.healthFailureThreshold < 0 {
+		log.WithField("healthFailureThreshold", gs.healthFailureThreshold).Fatal("Health failure threshold must " +
+			"not be negative")
+	}
+	a.HealthFailureThreshold = gs.healthFailureThreshold
+	if a.isMainBinary && gs.outputTailLines < 0 {
+		log.WithField("outputTailLines", gs.outputTailLines).Fatal("Output tail lines must not be negative")
+	}
+	if a.isMainBinary && gs.healthCheckTimeout <= 0 {
+		log.WithField("healthCheckTimeout", gs.healthCheckTimeout).Fatal("Health check timeout must be positive")
+	}
+	if a.isMainBinary && gs.maxRestarts < 0 {
+		log.WithField("maxRestarts", gs.maxRestarts).Fatal("Maximum number of restarts must not be negative")
+	}
+	a.ClockSkewTolerance = gs.clockSkewTolerance
+	a.EtcdSANs = gs.etcdSANs
+	a.KubeSANs = gs.kubeSANs
+	a.OIDC = kube.OIDCConfig{
+		IssuerURL:     gs.oidcIssuerURL,
+		ClientID:      gs.oidcClientID,
+		UsernameClaim: gs.oidcUsernameClaim,
+	}
+	err = a.OIDC.Validate()
+	if err != nil {
+		log.WithError(err).Fatal("Invalid OIDC settings")
+	}
+	if (gs.kubeCACert == "") != (gs.kubeCAKey == "") {
+		log.WithFields(log.Fields{
+			"kubeCACert": gs.kubeCACert,
+			"kubeCAKey":  gs.kubeCAKey,
+		}).Fatal("-kube-ca-cert and -kube-ca-key must be given together")
+	}
+	a.ExternalKubeCACert, err = homedir.Expand(gs.kubeCACert)
+	if err != nil {
+		log.WithError(err).WithField("kubeCACert", gs.kubeCACert).Fatal("Couldn't expand CA certificate path")
+	}
+	a.ExternalKubeCAKey, err = homedir.Expand(gs.kubeCAKey)
+	if err != nil {
+		log.WithError(err).WithField("kubeCAKey", gs.kubeCAKey).Fatal("Couldn't expand CA key path")
+	}
+	a.CABundle, err = homedir.Expand(gs.caBundle)
+	if err != nil {
+		log.WithError(err).WithField("caBundle", gs.caBundle).Fatal("Couldn't expand CA bundle path")
+	}
+	if a.isMainBinary && gs.certValidity <= 0 {
+		log.WithField("certValidity", gs.certValidity).Fatal("Certificate validity must be positive")
+	}
+	a.CertValidity = gs.certValidity
+	if a.isMainBinary {
+		a.KeyAlgorithm, err = pki.ParseKeyAlgorithm(gs.keyAlgorithm)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid key type")
+		}
+	}
+	a.ProgressFile = gs.progressFile
+	a.ProgressSocket = gs.progressSocket
+	a.HealthEvents = gs.healthEvents
+	a.ValidateManifests = gs.validateManifests
+	a.ManifestDir, err = homedir.Expand(gs.manifestDir)
+	if err != nil {
+		log.WithError(err).WithField("manifestDir", gs.manifestDir).Fatal("Couldn't expand manifest directory")
+	}
+	if a.isMainBinary && gs.join != "" && gs.kubeCACert == "" {
+		log.WithField("join", gs.join).Fatal("-join requires the kubernetes CA of the cluster to join, pass it " +
+			"with -kube-ca-cert and -kube-ca-key")
+	}
+	a.JoinAPIServer = gs.join
+	if a.isMainBinary && gs.join != "" && gs.noKubelet {
+		log.WithField("join", gs.join).Fatal("-join runs only kubelet and kube-proxy and can't be combined with " +
+			"-no-kubelet")
+	}
+	a.NoKubelet = gs.noKubelet
+	a.SnapshotOnExit = gs.snapshotOnExit
+	a.Teardown = gs.teardown
+	if a.isMainBinary && gs.forceRestore && gs.restoreFrom == "" {
+		log.Fatal("-force-restore requires -restore-from")
+	}
+	a.RestoreFrom, err = homedir.Expand(gs.restoreFrom)
+	if err != nil {
+		log.WithError(err).WithField("restoreFrom", gs.restoreFrom).Fatal("Couldn't expand snapshot path")
+	}
+	a.ForceRestore = gs.forceRestore
+	if gs.portBase < 1 || gs.portBase+handlers.PortCount-1 > 65535 {
+		log.WithField("portBase", gs.portBase).Fatal("Port base out of range")
+	}
+	a.AutoPortBase = gs.autoPortBase
+	a.ExtraKubeconfigs = gs.extraKubeconfigs
+	if gs.statusUI {
+		a.StatusListen = gs.statusListen
+	}
+	a.MetricsListen = gs.metricsListen
+	a.Command = flag.Arg(0)
 
 	baseExecEnv := handlers.ExecutionEnvironment{}
 	baseExecEnv.ListenAddress = bindAddr
+	baseExecEnv.KubeAPIBindAddress, err = ParseBindAddress(gs.apiBindAddress)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid apiserver bind address")
+	}
+	baseExecEnv.KubeControllerManagerBindAddress, err = ParseBindAddress(gs.ctrlMgrBindAddress)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid controller-manager bind address")
+	}
 	baseExecEnv.ServiceAddress = serviceRangeIP
-	baseExecEnv.DNSAddress = dnsIP
+	baseExecEnv.DNSAddress = DNSAddress(serviceRangeIP)
 	baseExecEnv.SudoMethod = gs.sudoMethod
-	baseExecEnv.InitPorts(7000)
+	baseExecEnv.KubeletSeccompDefault = gs.seccompDefault
+	baseExecEnv.KubeletShutdownGracePeriod = gs.shutdownGracePeriod
+	baseExecEnv.KubeletShutdownGracePeriodCriticalPods = gs.shutdownGracePeriodCriticalPods
+	baseExecEnv.KubeletCPUManagerPolicy = gs.cpuManagerPolicy
+	baseExecEnv.KubeletTopologyManagerPolicy = gs.topologyManagerPolicy
+	baseExecEnv.KubeletSystemReserved = gs.systemReserved
+	baseExecEnv.KubeletKubeReserved = gs.kubeReserved
+	baseExecEnv.KubeletContainerRuntimeEndpoint = gs.containerRuntime
+	if gs.containerRuntime != "" && !strings.Contains(gs.containerRuntime, "://") {
+		baseExecEnv.KubeletContainerRuntimeEndpoint = "unix://" + gs.containerRuntime
+	}
+	if a.isMainBinary && gs.rootless && gs.containerRuntime == "" {
+		log.Fatal("-rootless requires a rootless CRI runtime, pass it's endpoint with -container-runtime-endpoint")
+	}
+	baseExecEnv.Rootless = gs.rootless
+	err = kube.ValidateKubeletConfig(baseExecEnv)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid kubelet resource management settings")
+	}
+	baseExecEnv.EtcdAutoDefrag = gs.etcdAutoDefrag
+	baseExecEnv.EtcdQuotaBytes, err = parseByteSize(gs.etcdQuotaBytes)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid etcd quota")
+	}
+	if gs.etcdAutoCompactionRetention < 0 {
+		log.WithField("retention", gs.etcdAutoCompactionRetention).Fatal("etcd compaction retention must not be " +
+			"negative")
+	}
+	baseExecEnv.EtcdAutoCompactionRetention = gs.etcdAutoCompactionRetention
+	baseExecEnv.MaxRestarts = gs.maxRestarts
+	baseExecEnv.HealthCheckTimeout = gs.healthCheckTimeout
+	baseExecEnv.OutputTailLines = gs.outputTailLines
+	baseExecEnv.KubeAPIEgressSelectorConfig, err = homedir.Expand(gs.egressSelectorConfig)
+	if err != nil {
+		log.WithError(err).WithField("egressSelectorConfig", gs.egressSelectorConfig).Fatal("Couldn't expand " +
+			"egress selector config path")
+	}
+	if !gs.enableAudit && (gs.auditLog != "" || gs.auditPolicy != "") {
+		log.Fatal("-audit-log and -audit-policy require -enable-audit")
+	}
+	baseExecEnv.KubeAPIAuditLog = absolutePath("audit-log", gs.auditLog)
+	baseExecEnv.KubeAPIAuditPolicy = absolutePath("audit-policy", gs.auditPolicy)
+	err = kube.ValidateAdmissionPlugins(gs.enableAdmissionPlugins, gs.disableAdmissionPlugins)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid admission plugins")
+	}
+	baseExecEnv.KubeAPIEnableAdmissionPlugins = gs.enableAdmissionPlugins
+	baseExecEnv.KubeAPIDisableAdmissionPlugins = gs.disableAdmissionPlugins
+	baseExecEnv.GoMaxProcs = gs.goMaxProcs
+	baseExecEnv.KubeAPIMinRequestTimeout = gs.apiMinRequestTimeout
+	baseExecEnv.KubeAPIMaxRequestsInflight = gs.apiMaxRequestsInflight
+	baseExecEnv.KubeAPIMaxMutatingRequestsInflight = gs.apiMaxMutatingRequestsInflight
+	if gs.apiGoawayChance < 0 || gs.apiGoawayChance > 0.02 {
+		log.WithField("goawayChance", gs.apiGoawayChance).Fatal("GOAWAY chance must be between 0 and 0.02")
+	}
+	baseExecEnv.KubeAPIGoawayChance = gs.apiGoawayChance
+	baseExecEnv.Profiling = gs.profiling.value
+	baseExecEnv.EnableLeaderElection = gs.leaderElect
+	baseExecEnv.FeatureGates = gs.featureGates
+	for key, value := range gs.extraEnv {
+		baseExecEnv.ExtraEnv = append(baseExecEnv.ExtraEnv, key+"="+value)
+	}
+	sort.Strings(baseExecEnv.ExtraEnv)
+	if gs.constrained {
+		applyConstrainedPreset(&baseExecEnv)
+	}
+	baseExecEnv.InitPorts(gs.portBase)
 	return &baseExecEnv
 }
+
+// absolutePath returns the absolute path of the file given with flag 'flagName', empty if none was given
+func absolutePath(flagName, value string) string {
+	if value == "" {
+		return ""
+	}
+	expanded, err := homedir.Expand(value)
+	if err == nil {
+		expanded, err = filepath.Abs(expanded)
+	}
+	if err != nil {
+		log.WithError(err).WithField(flagName, value).Fatal("Couldn't resolve path")
+	}
+	return expanded
+}
+
+// hasLogParser returns whether a log parser is registered as 'name'
+func hasLogParser(name string) bool {
+	for _, parser := range log3.ParserNames() {
+		if parser == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSecureHealthProbe returns whether 'service' can be probed over HTTPS, see secureHealthProbeServices
+func hasSecureHealthProbe(service string) bool {
+	for _, candidate := range secureHealthProbeServices {
+		if candidate == service {
+			return true
+		}
+	}
+	return false
+}
+
+// applyConstrainedPreset dials down the resource settings of 'execEnv' for small hosts, keeping all settings that were
+// given explicitly
+func applyConstrainedPreset(execEnv *handlers.ExecutionEnvironment) {
+	if execEnv.GoMaxProcs == 0 {
+		execEnv.GoMaxProcs = ConstrainedGoMaxProcs
+	}
+	if execEnv.KubeAPIMinRequestTimeout == 0 {
+		execEnv.KubeAPIMinRequestTimeout = ConstrainedMinRequestTimeout
+	}
+	if execEnv.KubeAPIMaxRequestsInflight == 0 {
+		execEnv.KubeAPIMaxRequestsInflight = ConstrainedMaxRequestsInflight
+	}
+	if execEnv.KubeAPIMaxMutatingRequestsInflight == 0 {
+		execEnv.KubeAPIMaxMutatingRequestsInflight = ConstrainedMaxMutatingRequestsInflight
+	}
+}
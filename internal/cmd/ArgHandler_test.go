@@ -19,8 +19,10 @@ package cmd
 import (
 	"flag"
 	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
 	"net"
 	"testing"
+	"time"
 )
 
 // TestBasicArgParse checks whether 'normal' arg parsing works successfully
@@ -45,6 +47,9 @@ func TestBasicArgParse(t *testing.T) {
 	assert.Equal(t, true, gs.verbose, "Unexpected verbosity value")
 	assert.Equal(t, net.IPv4(10, 233, 43, 2), execEnv.DNSAddress, "Unexpected dns address")
 	assert.Equal(t, net.IPv4(10, 233, 43, 1), execEnv.ServiceAddress, "Unexpected service address")
+	assert.Equal(t, "10.233.42.0/24", uut.ProxyClusterCIDR.String(), "Unexpected proxy cluster CIDR")
+	assert.Equal(t, []string{"bridge", "host-local", "loopback"}, uut.CNIPlugins, "Unexpected CNI plugins")
+	assert.Equal(t, DefaultPortBase+2, execEnv.KubeApiPort, "Unexpected apiserver port")
 }
 
 // TestAllArgParse checks whether arg parsing with all arguments works successfully
@@ -61,6 +66,16 @@ func TestAllArgParse(t *testing.T) {
 		"192.168.10.1/24",
 		"-service-range",
 		"192.168.11.1/24",
+		"-service-timeout",
+		"etcd=1m",
+		"-apiserver-goaway-chance",
+		"0.01",
+		"-cni-plugins",
+		"bridge, portmap,,loopback",
+		"-container-runtime-endpoint",
+		"/run/containerd/containerd.sock",
+		"-port-base",
+		"8000",
 		"-verbose",
 		"1",
 	}
@@ -72,4 +87,27 @@ func TestAllArgParse(t *testing.T) {
 	assert.Equal(t, true, gs.verbose, "Unexpected verbosity value")
 	assert.Equal(t, net.IPv4(192, 168, 11, 2), execEnv.DNSAddress, "Unexpected dns address")
 	assert.Equal(t, net.IPv4(192, 168, 11, 1), execEnv.ServiceAddress, "Unexpected service address")
+	assert.Equal(t, map[string]time.Duration{"etcd": time.Minute}, uut.ServiceTimeouts, "Unexpected service timeouts")
+	assert.Equal(t, DefaultServiceTimeout, uut.ServiceTimeout, "Unexpected default service timeout")
+	assert.Equal(t, 0.01, execEnv.KubeAPIGoawayChance, "Unexpected GOAWAY chance")
+	assert.Equal(t, []string{"bridge", "portmap", "loopback"}, uut.CNIPlugins, "Unexpected CNI plugins")
+	assert.Equal(t, "unix:///run/containerd/containerd.sock", execEnv.KubeletContainerRuntimeEndpoint,
+		"Unexpected container runtime endpoint")
+	assert.Equal(t, 8000, execEnv.EtcdClientPort, "Unexpected etcd port")
+	assert.Equal(t, 8002, execEnv.KubeApiPort, "Unexpected apiserver port")
+}
+
+// TestConstrainedPreset checks whether the constrained preset only fills in settings that weren't given explicitly
+func TestConstrainedPreset(t *testing.T) {
+	execEnv := handlers.ExecutionEnvironment{
+		GoMaxProcs: 1,
+	}
+	applyConstrainedPreset(&execEnv)
+	assert.Equal(t, 1, execEnv.GoMaxProcs, "Explicit GOMAXPROCS overridden")
+	assert.Equal(t, ConstrainedMinRequestTimeout, execEnv.KubeAPIMinRequestTimeout, "Unexpected min request timeout")
+	assert.Equal(t, ConstrainedMaxRequestsInflight, execEnv.KubeAPIMaxRequestsInflight,
+		"Unexpected max requests inflight")
+	assert.Equal(t, ConstrainedMaxMutatingRequestsInflight, execEnv.KubeAPIMaxMutatingRequestsInflight,
+		"Unexpected max mutating requests inflight")
+	assert.Equal(t, []string{"GOMAXPROCS=1"}, execEnv.GoRuntimeEnv(), "Unexpected runtime environment")
 }
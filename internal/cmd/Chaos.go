@@ -0,0 +1,117 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// Fault injection actions
+const (
+	// ChaosActionRestart kills a service, which is started again right away
+	ChaosActionRestart = "restart"
+	// ChaosActionLatency delays all traffic between the apiserver and etcd
+	ChaosActionLatency = "latency"
+	// ChaosActionDisconnect drops all connections between the apiserver and etcd, which makes pending requests fail
+	ChaosActionDisconnect = "disconnect"
+	// ChaosActionErrors makes all requests of the apiserver to etcd fail for one interval, alternating with one interval
+	// of normal operation. The connections are refused, as the proxy can't tamper with the TLS traffic.
+	ChaosActionErrors = "errors"
+)
+
+// chaosActionServices lists the services each action can be applied to. Kubelet and kube-proxy aren't restartable as
+// they run via the sudo method, which doesn't pass on signals.
+var chaosActionServices = map[string][]string{
+	ChaosActionRestart:    {"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler"},
+	ChaosActionLatency:    {"etcd"},
+	ChaosActionDisconnect: {"etcd"},
+	ChaosActionErrors:     {"etcd"},
+}
+
+// ChaosPolicy describes a fault to inject into a service
+type ChaosPolicy struct {
+	// Service to inject the fault into (e.g. 'etcd')
+	Service string
+	// Fault to inject, one of the ChaosAction* constants
+	Action string
+	// Time between two faults, or for ChaosActionLatency, the delay to add, or for ChaosActionErrors, the time to fail
+	// requests for
+	Interval time.Duration
+}
+
+// String returns the policy as 'service:action:interval'
+func (p ChaosPolicy) String() string {
+	return p.Service + ":" + p.Action + ":" + p.Interval.String()
+}
+
+// ParseChaosPolicy parses a policy of the form 'service:action:interval' (e.g. 'kube-scheduler:restart:5m')
+func ParseChaosPolicy(value string) (ChaosPolicy, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return ChaosPolicy{}, errors.New("expected service:action:interval, got '" + value + "'")
+	}
+	policy := ChaosPolicy{
+		Service: parts[0],
+		Action:  parts[1],
+	}
+	services, ok := chaosActionServices[policy.Action]
+	if !ok {
+		return ChaosPolicy{}, errors.New("unknown action '" + policy.Action + "'")
+	}
+	supported := false
+	for _, service := range services {
+		if service == policy.Service {
+			supported = true
+		}
+	}
+	if !supported {
+		return ChaosPolicy{}, errors.Errorf("action '%s' only supports %s", policy.Action, strings.Join(services, ", "))
+	}
+	var err error
+	policy.Interval, err = time.ParseDuration(parts[2])
+	if err != nil {
+		return ChaosPolicy{}, errors.Wrap(err, "invalid interval")
+	}
+	if policy.Interval <= 0 {
+		return ChaosPolicy{}, errors.New("interval must be positive")
+	}
+	return policy, nil
+}
+
+// chaosPolicyValue is a repeatable command line argument of the form 'service:action:interval'
+type chaosPolicyValue []ChaosPolicy
+
+// String returns the current value as comma-separated list, see flag.Value
+func (v *chaosPolicyValue) String() string {
+	var items []string
+	for _, policy := range *v {
+		items = append(items, policy.String())
+	}
+	return strings.Join(items, ",")
+}
+
+// Set parses and adds a single policy, see flag.Value
+func (v *chaosPolicyValue) Set(value string) error {
+	policy, err := ParseChaosPolicy(value)
+	if err != nil {
+		return err
+	}
+	*v = append(*v, policy)
+	return nil
+}
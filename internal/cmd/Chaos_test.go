@@ -0,0 +1,46 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestChaosPolicyValue checks whether repeated 'service:action:interval' arguments are parsed and validated
+func TestChaosPolicyValue(t *testing.T) {
+	uut := chaosPolicyValue(nil)
+	assert.NoError(t, uut.Set("kube-scheduler:restart:5m"), "unexpected error")
+	assert.NoError(t, uut.Set("etcd:latency:200ms"), "unexpected error")
+	assert.NoError(t, uut.Set("etcd:errors:30s"), "unexpected error")
+	assert.Equal(t, chaosPolicyValue{
+		{Service: "kube-scheduler", Action: ChaosActionRestart, Interval: 5 * time.Minute},
+		{Service: "etcd", Action: ChaosActionLatency, Interval: 200 * time.Millisecond},
+		{Service: "etcd", Action: ChaosActionErrors, Interval: 30 * time.Second},
+	}, uut, "unexpected value")
+	assert.Equal(t, "kube-scheduler:restart:5m0s,etcd:latency:200ms,etcd:errors:30s", uut.String(),
+		"unexpected string representation")
+
+	assert.Error(t, uut.Set("etcd:restart"), "missing error for missing interval")
+	assert.Error(t, uut.Set("etcd:explode:1m"), "missing error for unknown action")
+	assert.Error(t, uut.Set("kubelet:restart:1m"), "missing error for unsupported service")
+	assert.Error(t, uut.Set("kube-apiserver:latency:1s"), "missing error for unsupported service")
+	assert.Error(t, uut.Set("kube-scheduler:errors:1s"), "missing error for unsupported service")
+	assert.Error(t, uut.Set("etcd:disconnect:often"), "missing error for invalid interval")
+	assert.Error(t, uut.Set("etcd:disconnect:0s"), "missing error for zero interval")
+}
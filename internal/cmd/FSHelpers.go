@@ -18,11 +18,16 @@
 package cmd
 
 import (
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"path"
 )
 
+// linkFile creates a hard link, replaceable for tests
+var linkFile = os.Link
+
 // EnsureDir ensures that root/subdirectory exists, is a directory and has permissions 'permissions'
 func EnsureDir(root, subdirectory string, permissions os.FileMode) error {
 	dir := path.Join(root, subdirectory)
@@ -44,3 +49,47 @@ func EnsureDir(root, subdirectory string, permissions os.FileMode) error {
 	}
 	return nil
 }
+
+// LinkOrCopyExecutable makes the executable 'src' available at 'dest' by hard linking it, or by copying it if that
+// isn't possible, e.g. because both are on different file systems. 'dest' is executable in either case.
+func LinkOrCopyExecutable(src, dest string) error {
+	err := linkFile(src, dest)
+	if err == nil {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"src":  src,
+		"dest": dest,
+	}).WithError(err).Info("Couldn't link file, trying to copy")
+
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "couldn't open source file")
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "couldn't stat source file")
+	}
+	// Delete the destination, just in case a previous attempt left a partial file behind
+	os.Remove(dest)
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return errors.Wrap(err, "couldn't open destination file")
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return errors.Wrap(err, "couldn't copy file")
+	}
+	if n != info.Size() {
+		out.Close()
+		return errors.Errorf("copied %d of %d bytes", n, info.Size())
+	}
+	err = out.Close()
+	if err != nil {
+		return errors.Wrap(err, "couldn't write destination file")
+	}
+	// The mode passed to OpenFile is subject to the umask
+	return os.Chmod(dest, 0755)
+}
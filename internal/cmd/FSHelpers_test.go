@@ -22,6 +22,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -60,3 +61,59 @@ func TestEnsureDir(t *testing.T) {
 		t.Fatalf("Unexpected error: %s", err)
 	}
 }
+
+// TestLinkOrCopyExecutable checks whether executables are copied if they can't be linked, e.g. across file systems
+func TestLinkOrCopyExecutable(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	tempDir, err := ioutil.TempDir("", "TestLinkOrCopyExecutable")
+	if err != nil {
+		t.Fatal("tempDir creation failed", err)
+	}
+	defer os.RemoveAll(tempDir)
+	src := path.Join(tempDir, "bridge")
+	content := []byte("#!/bin/sh\necho fake CNI plugin\n")
+	err = ioutil.WriteFile(src, content, 0700)
+	if err != nil {
+		t.Fatal("source creation failed", err)
+	}
+
+	// Simulate source and destination being on different file systems
+	defer func() {
+		linkFile = os.Link
+	}()
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	for _, dest := range []string{path.Join(tempDir, "copied"), path.Join(tempDir, "existing")} {
+		if strings.HasSuffix(dest, "existing") {
+			// A partial copy left behind earlier is replaced
+			err = ioutil.WriteFile(dest, []byte("partial"), 0600)
+			if err != nil {
+				t.Fatal("destination creation failed", err)
+			}
+		}
+		err = LinkOrCopyExecutable(src, dest)
+		if err != nil {
+			t.Fatal("copy failed", err)
+		}
+		copied, err := ioutil.ReadFile(dest)
+		if err != nil {
+			t.Fatal("copy missing", err)
+		}
+		if string(copied) != string(content) {
+			t.Fatalf("Unexpected content of copy: '%s'", copied)
+		}
+		info, err := os.Stat(dest)
+		if err != nil {
+			t.Fatal("copy missing", err)
+		}
+		if info.Mode().Perm() != 0755 {
+			t.Fatalf("Unexpected mode of copy: %s", info.Mode())
+		}
+	}
+
+	err = LinkOrCopyExecutable(path.Join(tempDir, "missing"), path.Join(tempDir, "dest"))
+	if err == nil {
+		t.Fatal("Expected error missing")
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// splitKeyValue splits 'value' at the first '=' into a non-empty key and a value
+func splitKeyValue(value string) (string, string, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.New("expected key=value, got '" + value + "'")
+	}
+	return parts[0], parts[1], nil
+}
+
+// stringMapValue is a repeatable command line argument of the form 'key=value'
+type stringMapValue map[string]string
+
+// String returns the current value as comma-separated list, see flag.Value
+func (v *stringMapValue) String() string {
+	var items []string
+	for key, value := range *v {
+		items = append(items, key+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Set parses and adds a single 'key=value' item, see flag.Value
+func (v *stringMapValue) Set(value string) error {
+	key, item, err := splitKeyValue(value)
+	if err != nil {
+		return err
+	}
+	if *v == nil {
+		*v = make(stringMapValue)
+	}
+	(*v)[key] = item
+	return nil
+}
+
+// durationMapValue is a repeatable command line argument of the form 'service=duration', only accepting known services
+// (see serviceNames) and positive durations
+type durationMapValue map[string]time.Duration
+
+// String returns the current value as comma-separated list, see flag.Value
+func (v *durationMapValue) String() string {
+	var items []string
+	for key, value := range *v {
+		items = append(items, key+"="+value.String())
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Set parses and adds a single 'service=duration' item, see flag.Value
+func (v *durationMapValue) Set(value string) error {
+	key, durationStr, err := splitKeyValue(value)
+	if err != nil {
+		return err
+	}
+	known := false
+	for _, service := range serviceNames {
+		if service == key {
+			known = true
+		}
+	}
+	if !known {
+		return errors.Errorf("unknown service '%s', available: %s", key, strings.Join(serviceNames, ", "))
+	}
+	duration, err := time.ParseDuration(durationStr)
+	if err != nil {
+		return errors.Wrap(err, "invalid duration for '"+key+"'")
+	}
+	if duration <= 0 {
+		return errors.Errorf("duration for '%s' must be positive", key)
+	}
+	if *v == nil {
+		*v = make(durationMapValue)
+	}
+	(*v)[key] = duration
+	return nil
+}
+
+// boolMapValue is a repeatable command line argument of the form 'key=bool[,key=bool...]', like kubernetes'
+// --feature-gates
+type boolMapValue map[string]bool
+
+// String returns the current value as comma-separated list, see flag.Value
+func (v *boolMapValue) String() string {
+	var items []string
+	for key, value := range *v {
+		items = append(items, key+"="+strconv.FormatBool(value))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// Set parses and adds a comma-separated list of 'key=bool' items, see flag.Value
+func (v *boolMapValue) Set(value string) error {
+	for _, itemStr := range strings.Split(value, ",") {
+		key, boolStr, err := splitKeyValue(itemStr)
+		if err != nil {
+			return err
+		}
+		item, err := strconv.ParseBool(boolStr)
+		if err != nil {
+			return errors.Wrap(err, "invalid bool for '"+key+"'")
+		}
+		if *v == nil {
+			*v = make(boolMapValue)
+		}
+		(*v)[key] = item
+	}
+	return nil
+}
+
+// optionalBoolValue is a boolean command line argument that remembers whether it was given at all, so that a default
+// of the services can be kept otherwise
+type optionalBoolValue struct {
+	// The value given, nil if none was
+	value *bool
+}
+
+// String returns the current value or an empty string if none was given, see flag.Value
+func (v *optionalBoolValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.value)
+}
+
+// Set parses the value, see flag.Value
+func (v *optionalBoolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	v.value = &parsed
+	return nil
+}
+
+// IsBoolFlag allows giving the argument without value, see package flag
+func (v *optionalBoolValue) IsBoolFlag() bool {
+	return true
+}
+
+// stringSliceValue is a repeatable command line argument collecting all values given
+type stringSliceValue []string
+
+// String returns the current value as comma-separated list, see flag.Value
+func (v *stringSliceValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+// Set adds a single non-empty value, see flag.Value
+func (v *stringSliceValue) Set(value string) error {
+	if value == "" {
+		return errors.New("expected a non-empty value")
+	}
+	*v = append(*v, value)
+	return nil
+}
+
+// commaListValue is a repeatable command line argument of the form 'item[,item...]' collecting all items given
+type commaListValue []string
+
+// String returns the current value as comma-separated list, see flag.Value
+func (v *commaListValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+// Set adds all non-empty items of a comma-separated list, see flag.Value
+func (v *commaListValue) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+// byteSizeUnits are the binary suffixes parseByteSize understands
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+}
+
+// parseByteSize parses a number of bytes, optionally with a binary suffix like kubernetes quantities (e.g. '512Mi')
+func parseByteSize(value string) (int64, error) {
+	factor := int64(1)
+	number := value
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			factor = unit.factor
+			number = strings.TrimSuffix(value, unit.suffix)
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.Errorf("expected a size like '8Gi', got '%s'", value)
+	}
+	if size > math.MaxInt64/factor {
+		return 0, errors.Errorf("size '%s' is too large", value)
+	}
+	return size * factor, nil
+}
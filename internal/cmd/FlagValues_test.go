@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestDurationMapValue checks whether repeated 'service=duration' arguments are parsed and validated
+func TestDurationMapValue(t *testing.T) {
+	uut := durationMapValue(nil)
+	assert.NoError(t, uut.Set("etcd=1m"), "unexpected error")
+	assert.NoError(t, uut.Set("kubelet=90s"), "unexpected error")
+	assert.NoError(t, uut.Set("etcd=2m"), "unexpected error")
+	assert.Equal(t, durationMapValue{
+		"etcd":    2 * time.Minute,
+		"kubelet": 90 * time.Second,
+	}, uut, "unexpected value")
+	assert.Equal(t, "etcd=2m0s,kubelet=1m30s", uut.String(), "unexpected string representation")
+
+	assert.Error(t, uut.Set("etcd"), "missing error for value without '='")
+	assert.Error(t, uut.Set("=1m"), "missing error for empty key")
+	assert.Error(t, uut.Set("etcd=soon"), "missing error for invalid duration")
+	assert.Error(t, uut.Set("etcd=0s"), "missing error for zero duration")
+	assert.Error(t, uut.Set("etcd=-1m"), "missing error for negative duration")
+	assert.Error(t, uut.Set("etcdd=1m"), "missing error for unknown service")
+	assert.Equal(t, "etcd=2m0s,kubelet=1m30s", uut.String(), "invalid value changed arguments")
+}
+
+// TestBoolMapValue checks whether repeated 'key=bool' lists are parsed correctly
+func TestBoolMapValue(t *testing.T) {
+	uut := boolMapValue(nil)
+	assert.NoError(t, uut.Set("Foo=true,Bar=false"), "unexpected error")
+	assert.NoError(t, uut.Set("Baz=1"), "unexpected error")
+	assert.NoError(t, uut.Set("Foo=false"), "unexpected error")
+	assert.Equal(t, boolMapValue{
+		"Foo": false,
+		"Bar": false,
+		"Baz": true,
+	}, uut, "unexpected value")
+	assert.Equal(t, "Bar=false,Baz=true,Foo=false", uut.String(), "unexpected string representation")
+
+	assert.Error(t, uut.Set("Foo"), "missing error for value without '='")
+	assert.Error(t, uut.Set("Foo=true,"), "missing error for empty item")
+	assert.Error(t, uut.Set("Foo=maybe"), "missing error for invalid bool")
+}
+
+// TestOptionalBoolValue checks whether a boolean argument remembers if it was given
+func TestOptionalBoolValue(t *testing.T) {
+	uut := optionalBoolValue{}
+	assert.Nil(t, uut.value, "value set without being given")
+	assert.Equal(t, "", uut.String(), "unexpected string representation")
+	assert.True(t, uut.IsBoolFlag(), "argument requires a value")
+	assert.NoError(t, uut.Set("true"), "unexpected error")
+	assert.Equal(t, "true", uut.String(), "unexpected string representation")
+	assert.NoError(t, uut.Set("false"), "unexpected error")
+	if assert.NotNil(t, uut.value, "value not set") {
+		assert.False(t, *uut.value, "unexpected value")
+	}
+	assert.Error(t, uut.Set("maybe"), "missing error for invalid bool")
+}
+
+// TestStringMapValue checks whether repeated 'key=value' arguments are parsed correctly
+func TestStringMapValue(t *testing.T) {
+	uut := stringMapValue(nil)
+	assert.NoError(t, uut.Set("etcd=nobody"), "unexpected error")
+	assert.NoError(t, uut.Set("kube-apiserver=kube=api"), "unexpected error")
+	assert.Equal(t, stringMapValue{
+		"etcd":           "nobody",
+		"kube-apiserver": "kube=api",
+	}, uut, "unexpected value")
+	assert.Equal(t, "etcd=nobody,kube-apiserver=kube=api", uut.String(), "unexpected string representation")
+
+	assert.Error(t, uut.Set("etcd"), "missing error for value without '='")
+}
+
+// TestStringSliceValue checks whether repeated arguments are collected
+func TestStringSliceValue(t *testing.T) {
+	uut := stringSliceValue(nil)
+	assert.NoError(t, uut.Set("10.0.0.1"), "unexpected error")
+	assert.NoError(t, uut.Set("etcd.example.com"), "unexpected error")
+	assert.Equal(t, stringSliceValue{"10.0.0.1", "etcd.example.com"}, uut, "unexpected value")
+	assert.Equal(t, "10.0.0.1,etcd.example.com", uut.String(), "unexpected string representation")
+
+	assert.Error(t, uut.Set(""), "missing error for empty value")
+}
+
+// TestCommaListValue checks whether comma-separated items of repeated arguments are collected
+func TestCommaListValue(t *testing.T) {
+	uut := commaListValue(nil)
+	assert.NoError(t, uut.Set("NodeRestriction, PodSecurity"), "unexpected error")
+	assert.NoError(t, uut.Set("ServiceAccount,"), "unexpected error")
+	assert.Equal(t, commaListValue{"NodeRestriction", "PodSecurity", "ServiceAccount"}, uut, "unexpected value")
+	assert.Equal(t, "NodeRestriction,PodSecurity,ServiceAccount", uut.String(), "unexpected string representation")
+}
+
+// TestParseByteSize checks whether sizes with and without suffix are parsed
+func TestParseByteSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"0":         0,
+		"1024":      1024,
+		"512Mi":     512 * 1024 * 1024,
+		"8Gi":       8 * 1024 * 1024 * 1024,
+		"8388607Ti": 8388607 << 40,
+	} {
+		size, err := parseByteSize(value)
+		assert.NoError(t, err, "unexpected error for '%s'", value)
+		assert.Equal(t, expected, size, "unexpected size for '%s'", value)
+	}
+	for _, value := range []string{"", "8G", "-1", "Gi", "9999999Ti", "9223372036854775808"} {
+		_, err := parseByteSize(value)
+		assert.Error(t, err, "missing error for '%s'", value)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FindMountsBelow parses a mount table in the format of /proc/self/mountinfo and returns all mount points that are
+// located below 'root' (not including 'root' itself), deepest first so that they can be unmounted in order
+func FindMountsBelow(mountinfo io.Reader, root string) ([]string, error) {
+	root = path.Clean(root)
+	var mounts []string
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		// Format: ID parentID major:minor root mountpoint options ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountpoint := unescapeMountPath(fields[4])
+		if strings.HasPrefix(mountpoint, root+"/") {
+			mounts = append(mounts, mountpoint)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "couldn't read mount table")
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.Count(mounts[i], "/") > strings.Count(mounts[j], "/")
+	})
+	return mounts, nil
+}
+
+// unescapeMountPath reverses the octal escaping (e.g. '\040' for space) the kernel applies to paths in mountinfo
+func unescapeMountPath(escaped string) string {
+	var result strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '\\' && i+3 < len(escaped) {
+			if value, err := strconv.ParseUint(escaped[i+1:i+4], 8, 8); err == nil {
+				result.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		result.WriteByte(escaped[i])
+	}
+	return result.String()
+}
+
+// CleanupStaleMounts unmounts everything that is still mounted below 'root', e.g. volumes left behind by a kubelet
+// that was killed. Unmounting requires root, therefore 'sudoMethod' is used to invoke umount.
+func CleanupStaleMounts(root, sudoMethod string) error {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return errors.Wrap(err, "couldn't open mount table")
+	}
+	defer file.Close()
+	mounts, err := FindMountsBelow(file, root)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, mount := range mounts {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "prep",
+			"mount":     mount,
+		}).Warn("Unmounting stale mount from previous run")
+		if Unmount(mount, sudoMethod) != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " stale mounts couldn't be unmounted")
+	}
+	return nil
+}
+
+// Unmount unmounts 'mount' using 'sudoMethod', falling back to a lazy unmount if it's still busy
+func Unmount(mount, sudoMethod string) error {
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "prep",
+		"mount":     mount,
+	})
+	output, err := exec.Command(sudoMethod, "umount", mount).CombinedOutput()
+	if err != nil {
+		// Something still holds on to it, detach it lazily
+		logCtx.WithError(err).WithField("output", string(output)).Debug("umount failed, trying lazy unmount")
+		output, err = exec.Command(sudoMethod, "umount", "-l", mount).CombinedOutput()
+	}
+	if err != nil {
+		logCtx.WithError(err).WithField("output", string(output)).Warn("Couldn't unmount")
+		return errors.Wrap(err, "umount failed")
+	}
+	return nil
+}
+
+// FindFilesystemType parses a mount table in the format of /proc/self/mountinfo and returns the filesystem type of
+// the filesystem mounted at 'mountpoint', or an empty string if nothing is mounted there
+func FindFilesystemType(mountinfo io.Reader, mountpoint string) (string, error) {
+	mountpoint = path.Clean(mountpoint)
+	fstype := ""
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		// The optional fields are terminated by a single '-', followed by the filesystem type
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || unescapeMountPath(fields[4]) != mountpoint {
+			continue
+		}
+		for idx, field := range fields[5:] {
+			if field == "-" && 5+idx+1 < len(fields) {
+				// Later entries shadow earlier ones
+				fstype = fields[5+idx+1]
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrap(err, "couldn't read mount table")
+	}
+	return fstype, nil
+}
+
+// EnsureTmpfs makes sure that a tmpfs owned by the current user is mounted at 'dir', using 'sudoMethod' to mount it.
+// The first return value indicates whether a new tmpfs was mounted (and should therefore be unmounted on exit).
+func EnsureTmpfs(dir, sudoMethod string) (bool, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't open mount table")
+	}
+	fstype, err := FindFilesystemType(file, dir)
+	file.Close()
+	if err != nil {
+		return false, err
+	}
+	if fstype == "tmpfs" {
+		return false, nil
+	}
+	options := "mode=0770,uid=" + strconv.Itoa(os.Getuid()) + ",gid=" + strconv.Itoa(os.Getgid())
+	output, err := exec.Command(sudoMethod, "mount", "-t", "tmpfs", "-o", options, "tmpfs", dir).CombinedOutput()
+	if err != nil {
+		return false, errors.Wrap(err, "mount failed: "+strings.TrimSpace(string(output)))
+	}
+	return true, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// testMountinfo contains a mount table with leftovers from a kubelet below /home/user/.mukube/kube/kubelet
+const testMountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+25 22 0:5 / /dev rw,nosuid shared:2 - devtmpfs udev rw
+310 22 0:51 / /home/user/.mukube/kube/kubelet/pods/1234/volumes/kubernetes.io~secret/token rw,relatime shared:160 - tmpfs tmpfs rw
+311 22 8:1 /var/lib/docker /home/user/.mukube/kube/kubelet/pods/1234/volumes rw,relatime shared:1 - ext4 /dev/sda1 rw
+312 22 0:52 / /home/user/.mukube/kube/kubelet/pods/5678/volumes/my\040volume rw,relatime shared:161 - tmpfs tmpfs rw
+313 22 0:53 / /home/user/.mukube/kube/kubelet2/pods/9/volumes rw,relatime shared:162 - tmpfs tmpfs rw
+314 22 0:54 / /home/user/.mukube/kube/kubelet rw,relatime shared:163 - tmpfs tmpfs rw
+`
+
+// TestFindMountsBelow checks whether stale mounts are found and ordered correctly
+func TestFindMountsBelow(t *testing.T) {
+	mounts, err := FindMountsBelow(strings.NewReader(testMountinfo), "/home/user/.mukube/kube/kubelet/")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, []string{
+		"/home/user/.mukube/kube/kubelet/pods/1234/volumes/kubernetes.io~secret/token",
+		"/home/user/.mukube/kube/kubelet/pods/5678/volumes/my volume",
+		"/home/user/.mukube/kube/kubelet/pods/1234/volumes",
+	}, mounts, "unexpected mounts")
+
+	mounts, err = FindMountsBelow(strings.NewReader(testMountinfo), "/tmp")
+	assert.NoError(t, err, "unexpected error")
+	assert.Empty(t, mounts, "unexpected mounts")
+}
+
+// TestFindFilesystemType checks whether filesystem types are extracted correctly
+func TestFindFilesystemType(t *testing.T) {
+	fstype, err := FindFilesystemType(strings.NewReader(testMountinfo), "/home/user/.mukube/kube/kubelet/pods/1234/volumes")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "ext4", fstype, "unexpected filesystem type")
+
+	fstype, err = FindFilesystemType(strings.NewReader(testMountinfo), "/home/user/.mukube/kube/kubelet/")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "tmpfs", fstype, "unexpected filesystem type")
+
+	fstype, err = FindFilesystemType(strings.NewReader(testMountinfo), "/home/user/.mukube/etcddata")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "", fstype, "unexpected filesystem type")
+}
@@ -17,6 +17,7 @@
 package cmd
 
 import (
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"net"
 )
@@ -79,6 +80,26 @@ func CalculateIPRanges(podRange, serviceRange string) (pod, service, cluster *ne
 	return podRangeNet, serviceRangeNet, clusterIPRange, bindAddr, serviceRangeIP, nil
 }
 
+// DNSAddress returns the service IP of the cluster DNS, given the first IP of the service range
+func DNSAddress(firstSVC net.IP) net.IP {
+	dnsIP := net.IPv4(0, 0, 0, 0)
+	copy(dnsIP, firstSVC)
+	dnsIP[15]++
+	return dnsIP
+}
+
+// ParseBindAddress parses the per-service bind address 'address', returning nil if it is empty
+func ParseBindAddress(address string) (net.IP, error) {
+	if address == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, errors.Errorf("'%s' is not an IP address", address)
+	}
+	return ip, nil
+}
+
 // FindBindAddress tries to find a private IPv4 address from some local interface that can be used to bind services to it
 func FindBindAddress() net.IP {
 	ifaces, err := net.Interfaces()
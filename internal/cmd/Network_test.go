@@ -158,3 +158,31 @@ func TestIPParseError(t *testing.T) {
 		t.Fatalf("Unexpected error: %s", err)
 	}
 }
+
+// TestDNSAddress checks whether the cluster DNS gets the second IP of the service range
+func TestDNSAddress(t *testing.T) {
+	first := net.ParseIP("10.233.43.1")
+	dns := DNSAddress(first)
+	if dns.String() != "10.233.43.2" {
+		t.Fatalf("Unexpected DNS address '%s'", dns)
+	}
+	if first.String() != "10.233.43.1" {
+		t.Fatal("First service IP was modified")
+	}
+}
+
+// TestParseBindAddress checks whether an empty bind address falls back to nil and invalid ones are rejected
+func TestParseBindAddress(t *testing.T) {
+	ip, err := ParseBindAddress("")
+	if err != nil || ip != nil {
+		t.Fatalf("Unexpected result for empty address: '%s', '%s'", ip, err)
+	}
+	ip, err = ParseBindAddress("0.0.0.0")
+	if err != nil || !ip.IsUnspecified() {
+		t.Fatalf("Unexpected result for 0.0.0.0: '%s', '%s'", ip, err)
+	}
+	_, err = ParseBindAddress("localhost")
+	if err == nil {
+		t.Fatal("Expected error missing")
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portBaseStep is how far FindPortBase moves the port base if a port is occupied
+const portBaseStep = 100
+
+// maxPortBaseAttempts is the number of port bases FindPortBase tries before giving up
+const maxPortBaseAttempts = 10
+
+// OccupiedPorts returns the ports of the services laid out from 'base' by InitPorts that can't be listened on
+func OccupiedPorts(base int) []int {
+	execEnv := handlers.ExecutionEnvironment{}
+	execEnv.InitPorts(base)
+	var occupied []int
+	for _, port := range execEnv.Ports() {
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			occupied = append(occupied, port)
+			continue
+		}
+		listener.Close()
+	}
+	return occupied
+}
+
+// FindPortBase returns 'base' if all ports laid out from it are free. Otherwise, it returns the next base whose ports
+// are all free if 'bump' is set, or an error listing the occupied ports if not.
+func FindPortBase(base int, bump bool) (int, error) {
+	for i := 0; i < maxPortBaseAttempts; i++ {
+		candidate := base + i*portBaseStep
+		if candidate+handlers.PortCount-1 > 65535 {
+			break
+		}
+		occupied := OccupiedPorts(candidate)
+		if len(occupied) == 0 {
+			return candidate, nil
+		}
+		if !bump {
+			var ports []string
+			for _, port := range occupied {
+				ports = append(ports, strconv.Itoa(port))
+			}
+			return 0, errors.Errorf("port(s) %s already in use, is another microkube instance running? Choose "+
+				"other ports with -port-base or -auto-port-base", strings.Join(ports, ", "))
+		}
+	}
+	return 0, errors.Errorf("no free port base found from %d on", base)
+}
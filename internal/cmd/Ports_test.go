@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+// TestFindPortBase checks whether occupied ports are detected and skipped
+func TestFindPortBase(t *testing.T) {
+	// Occupy the third port of a base picked by the OS
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: '%s'", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	base := port - 2
+	if base+portBaseStep*maxPortBaseAttempts > 65535 {
+		t.Skip("Port picked by the OS is too high")
+	}
+
+	occupied := OccupiedPorts(base)
+	found := false
+	for _, candidate := range occupied {
+		found = found || candidate == port
+	}
+	if !found {
+		t.Fatalf("Port %d not detected as occupied", port)
+	}
+	_, err = FindPortBase(base, false)
+	if err == nil {
+		t.Fatal("Occupied port not reported")
+	}
+	newBase, err := FindPortBase(base, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: '%s'", err)
+	}
+	if newBase <= base || (newBase-base)%portBaseStep != 0 {
+		t.Fatalf("Unexpected port base %d, started from %d", newBase, base)
+	}
+	if len(OccupiedPorts(newBase)) != 0 {
+		t.Fatalf("Port base %d has occupied ports", newBase)
+	}
+}
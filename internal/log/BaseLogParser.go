@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/log"
 	"strings"
 	"sync"
 )
@@ -48,13 +49,10 @@ func GetLoggerFor(name string) *logrus.Logger {
 // LineHandlerFunc describes a function that is able to consume a log line
 type LineHandlerFunc func(string) error
 
-// Parser is the interface type of all log-parsing classes in this package.
-// A Parser is used to handle the output of child processes and re-log it using the logger of the main process,
-// unifying all logs into a single log with a coherent structure
-type Parser interface {
-	// HandleData takes all necessary actions with the provided buffer
-	HandleData(data []byte) error
-}
+// Parser is the interface type of all log-parsing classes in this package. The parsers register themselves with
+// the registry in pkg/log, see log.RegisterParser. Most implementations embed BaseLogParser, which reassembles lines
+// and hands them to a LineHandlerFunc.
+type Parser = log.Parser
 
 // BaseLogParser provides utilities often needed to implement log parsers, that is line reassembly functionality
 type BaseLogParser struct {
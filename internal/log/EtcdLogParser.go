@@ -17,7 +17,10 @@
 package log
 
 import (
+	"encoding/json"
 	"github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/handlers/etcd"
+	"github.com/vs-eth/microkube/pkg/log"
 	"strings"
 )
 
@@ -27,6 +30,34 @@ type ETCDLogParser struct {
 	BaseLogParser
 }
 
+// etcdJSONLogLine is a log line of etcd's structured (zap) logger, used by default since etcd 3.5
+type etcdJSONLogLine struct {
+	Level string `json:"level"`
+	// Either a formatted time or seconds since the epoch, depending on etcd's configuration. Unused, since the entry is
+	// logged again anyway.
+	Time    json.RawMessage `json:"ts"`
+	Message string          `json:"msg"`
+	// Source location, e.g. 'etcdserver/server.go:2036'
+	Caller string `json:"caller"`
+}
+
+// etcdJSONLogKeys are the keys of etcdJSONLogLine, all other keys of a JSON log line are logged as fields
+var etcdJSONLogKeys = map[string]bool{
+	"level":  true,
+	"ts":     true,
+	"msg":    true,
+	"caller": true,
+}
+
+// ETCDLogParserName is the name ETCDLogParser is registered as, see log.RegisterParser
+const ETCDLogParserName = "etcd"
+
+func init() {
+	log.MustRegisterParser(ETCDLogParserName, func(app string) Parser {
+		return NewETCDLogParser()
+	})
+}
+
 // NewETCDLogParser creates a ETCDLogParser
 func NewETCDLogParser() *ETCDLogParser {
 	obj := ETCDLogParser{}
@@ -36,6 +67,9 @@ func NewETCDLogParser() *ETCDLogParser {
 
 // handleLine handles a single line of log output
 func (h *ETCDLogParser) handleLine(lineStr string) error {
+	if strings.HasPrefix(lineStr, "{") && h.handleJSONLine(lineStr) {
+		return nil
+	}
 	line := ETCDLogLine{}
 	ok, _ := line.Extract(lineStr) // With the current format, this function will never return an error
 	if !ok {
@@ -66,6 +100,11 @@ func (h *ETCDLogParser) handleLine(lineStr string) error {
 		return nil
 	}
 
+	if isNoSpaceAlarm(line.Message) {
+		logNoSpaceAlarm(entry, line.Message)
+		return nil
+	}
+
 	switch line.Severity {
 	case "I":
 		entry.Info(line.Message)
@@ -90,3 +129,77 @@ func (h *ETCDLogParser) handleLine(lineStr string) error {
 
 	return nil
 }
+
+// handleJSONLine handles a single line of structured log output. If the line isn't a JSON log line, false is returned
+// and nothing is logged.
+func (h *ETCDLogParser) handleJSONLine(lineStr string) bool {
+	line := etcdJSONLogLine{}
+	fields := map[string]interface{}{}
+	if json.Unmarshal([]byte(lineStr), &line) != nil || json.Unmarshal([]byte(lineStr), &fields) != nil ||
+		line.Level == "" {
+		return false
+	}
+
+	// Same as above: Drop health checks of the apiserver that don't complete the TLS handshake
+	if line.Message == "rejected connection" && fields["error"] == "EOF" {
+		if addr, ok := fields["remote-addr"].(string); ok && strings.HasPrefix(addr, "127.0.0.1:") {
+			return true
+		}
+	}
+	if line.Message == "forgot to set Type=notify in systemd service file?" {
+		return true
+	}
+
+	entry := h.log.WithFields(logrus.Fields{
+		"app":       "etcd",
+		"component": strings.SplitN(line.Caller, "/", 2)[0],
+		"caller":    line.Caller,
+	})
+	extraFields := logrus.Fields{}
+	for key, value := range fields {
+		if !etcdJSONLogKeys[key] {
+			extraFields[key] = value
+		}
+	}
+	entry = entry.WithFields(extraFields)
+
+	if isNoSpaceAlarm(line.Message) || fields["alarm"] == "NOSPACE" {
+		logNoSpaceAlarm(entry, line.Message)
+		return true
+	}
+
+	switch line.Level {
+	case "debug":
+		entry.Debug(line.Message)
+	case "info":
+		entry.Info(line.Message)
+	case "warn":
+		entry.Warning(line.Message)
+	case "error":
+		entry.Error(line.Message)
+	case "dpanic", "panic", "fatal": // etcd exits on its own
+		entry.Error(line.Message)
+	default:
+		h.log.WithFields(logrus.Fields{
+			"component": "EtcdLogParser",
+			"app":       "microkube",
+			"level":     line.Level,
+		}).Warn("Unknown severity level in etcd log parser")
+		entry.Warn(line.Message)
+	}
+	return true
+}
+
+// isNoSpaceAlarm returns whether 'message' reports that etcd ran out of space
+func isNoSpaceAlarm(message string) bool {
+	return strings.Contains(message, "database space exceeded") || strings.Contains(message, "alarm NOSPACE")
+}
+
+// logNoSpaceAlarm logs 'message' reporting that etcd ran out of space. Running out of space turns etcd read-only,
+// which will wedge the whole cluster. Make sure this doesn't get lost.
+func logNoSpaceAlarm(entry *logrus.Entry, message string) {
+	entry.WithFields(logrus.Fields{
+		"alarm":       "NOSPACE",
+		"remediation": etcd.NoSpaceRemediation,
+	}).Error(message)
+}
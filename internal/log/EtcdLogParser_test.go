@@ -18,7 +18,10 @@ package log
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/handlers/etcd"
+	"reflect"
 	"testing"
 )
 
@@ -194,3 +197,63 @@ func TestInfoMessageSplitMultiline(t *testing.T) {
 		t.Fatalf("Unexpected output: %s", result)
 	}
 }
+
+// TestETCDNoSpaceAlarm tests whether the NOSPACE alarm is surfaced as a distinct event
+func TestETCDNoSpaceAlarm(t *testing.T) {
+	var buffer bytes.Buffer
+	testStr := "2018-08-12 14:13:48.437712 W | etcdserver: alarm NOSPACE raised by peer 8e9e05c52164694d\n"
+	uut := NewETCDLogParser()
+	uut.log.SetLevel(logrus.DebugLevel)
+	uut.log.SetOutput(&buffer)
+	uut.log.Formatter = &logrus.JSONFormatter{
+		DisableTimestamp: true,
+	}
+	err := uut.HandleData([]byte(testStr))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	result := map[string]string{}
+	err = json.Unmarshal(buffer.Bytes(), &result)
+	if err != nil {
+		t.Fatalf("Unexpected output: %s", buffer.String())
+	}
+	expected := map[string]string{
+		"alarm":       "NOSPACE",
+		"app":         "etcd",
+		"component":   "etcdserver",
+		"level":       "error",
+		"msg":         "alarm NOSPACE raised by peer 8e9e05c52164694d",
+		"remediation": etcd.NoSpaceRemediation,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Unexpected output: %s", buffer.String())
+	}
+}
+
+// TestETCDJSONFallback tests whether lines that only look like structured log lines are handled like classic ones
+func TestETCDJSONFallback(t *testing.T) {
+	var buffer bytes.Buffer
+	testStr := `{"level":"info","ts":1614936245.3,"caller":"raft/raft.go:765","msg":"8e9e05c52164694d became leader at term 2"}
+{"level":"info", truncated
+{"msg":"no level"}
+2018-08-12 14:13:48.437712 I | etcdserver: setting up the initial cluster version to 3.3
+`
+	uut := NewETCDLogParser()
+	uut.log.SetLevel(logrus.DebugLevel)
+	uut.log.SetOutput(&buffer)
+	uut.log.Formatter = &logrus.JSONFormatter{
+		DisableTimestamp: true,
+	}
+	err := uut.HandleData([]byte(testStr))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	result := buffer.String()
+	if result != `{"app":"etcd","caller":"raft/raft.go:765","component":"raft","level":"info","msg":"8e9e05c52164694d became leader at term 2"}
+{"app":"etcd","component":"EtcdLogParser","level":"warning","msg":"{\"level\":\"info\", truncated"}
+{"app":"etcd","component":"EtcdLogParser","level":"warning","msg":"{\"msg\":\"no level\"}"}
+{"app":"etcd","component":"etcdserver","level":"info","msg":"setting up the initial cluster version to 3.3"}
+` {
+		t.Fatalf("Unexpected output: %s", result)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"flag"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// updateGolden rewrites the expected parser output from the current parser behaviour. Use it after adding a fixture
+// (recorded with 'microkubed -capture-output <dir>') and check the result carefully.
+var updateGolden = flag.Bool("update", false, "update golden files of parser fixtures")
+
+// fixtureChunkSize mimics the buffer size used by CmdHandler, so that lines are split like in reality
+const fixtureChunkSize = 256
+
+// TestParserFixtures replays recorded process output into the parsers and compares the result with golden files
+func TestParserFixtures(t *testing.T) {
+	testCases := []struct {
+		fixture string
+		parser  func() (Parser, *logrus.Logger)
+	}{
+		{
+			fixture: "etcd.log",
+			parser: func() (Parser, *logrus.Logger) {
+				uut := NewETCDLogParser()
+				return uut, uut.log
+			},
+		},
+		{
+			fixture: "etcd-json.log",
+			parser: func() (Parser, *logrus.Logger) {
+				uut := NewETCDLogParser()
+				return uut, uut.log
+			},
+		},
+		{
+			fixture: "kube-apiserver.log",
+			parser: func() (Parser, *logrus.Logger) {
+				uut := NewKubeLogParser("kube-apiserver")
+				return uut, uut.log
+			},
+		},
+		{
+			fixture: "kubelet.log",
+			parser: func() (Parser, *logrus.Logger) {
+				uut := NewKubeLogParser("kubelet")
+				return uut, uut.log
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.fixture, func(t *testing.T) {
+			input, err := ioutil.ReadFile(path.Join("testdata", testCase.fixture))
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			var buffer bytes.Buffer
+			uut, logger := testCase.parser()
+			logger.SetLevel(logrus.DebugLevel)
+			logger.SetOutput(&buffer)
+			logger.Formatter = &logrus.JSONFormatter{
+				DisableTimestamp: true,
+			}
+			for pos := 0; pos < len(input); pos += fixtureChunkSize {
+				end := pos + fixtureChunkSize
+				if end > len(input) {
+					end = len(input)
+				}
+				err = uut.HandleData(input[pos:end])
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			}
+
+			goldenFile := path.Join("testdata", testCase.fixture+".golden")
+			if *updateGolden {
+				err = ioutil.WriteFile(goldenFile, buffer.Bytes(), 0644)
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+			}
+			expected, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if buffer.String() != string(expected) {
+				t.Fatalf("Unexpected output:\n%s\nExpected:\n%s", buffer.String(), string(expected))
+			}
+		})
+	}
+}
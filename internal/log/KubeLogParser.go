@@ -20,7 +20,9 @@ package log
 
 import (
 	"github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/log"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -33,80 +35,211 @@ type KubeLogParser struct {
 	app string
 	// Regex used to unindent logs
 	regexpInstance *regexp.Regexp
+	// Regex a klog header (everything before the first '] ') has to match after unindenting
+	headerRegexp *regexp.Regexp
+	// Regex matching the last key/value pair of a structured klog message, e.g. ' pod="kube-system/kube-dns"'
+	structuredRegexp *regexp.Regexp
+	// Regex matching the first line of a panic or goroutine dump
+	traceStartRegexp *regexp.Regexp
+	// Adjusts the level of parsed entries, nil if not needed
+	classifier entryClassifier
+
+	// Level and location of the last entry logged. Lines following it that can't be parsed (e.g. stack traces) are
+	// continuations of it and logged with the same level and location.
+	lastLevel    logrus.Level
+	lastLocation string
+	// Whether an entry was logged yet, that is, whether lastLevel and lastLocation are valid
+	hasLast bool
+}
+
+// klogSeverities maps the severity IDs of klog lines to the level they are logged at
+var klogSeverities = map[byte]logrus.Level{
+	'I': logrus.InfoLevel,
+	'E': logrus.ErrorLevel,
+	'W': logrus.WarnLevel,
+	'D': logrus.DebugLevel,
+	'N': logrus.InfoLevel,  // Notice is handled as info
+	'S': logrus.ErrorLevel, // Severe is handled as error
+	'F': logrus.ErrorLevel, // Fatal is handled as error, since the component will exit on it's own
+}
+
+// entryClassifier adjusts the level of a parsed entry with message 'message', which is logged at 'level' by default.
+// It may add fields to 'fields'.
+type entryClassifier func(message string, level logrus.Level, fields logrus.Fields) logrus.Level
+
+// KubeLogParserName is the name KubeLogParser is registered as, see log.RegisterParser
+const KubeLogParserName = "kube"
+
+func init() {
+	log.MustRegisterParser(KubeLogParserName, func(app string) Parser {
+		return NewKubeLogParser(app)
+	})
 }
 
 // NewKubeLogParser creates a KubeLogParser for the application named by 'app'
 func NewKubeLogParser(app string) *KubeLogParser {
 	obj := KubeLogParser{
-		app:            app,
-		regexpInstance: regexp.MustCompile("[ ]+"),
+		app:              app,
+		regexpInstance:   regexp.MustCompile("[ ]+"),
+		headerRegexp:     regexp.MustCompile(`^[A-Z][0-9]{4} [0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)? [0-9]+ [^ ]+$`),
+		structuredRegexp: regexp.MustCompile(`\s([A-Za-z_][A-Za-z0-9_./-]*)=("(?:[^"\\]|\\.)*"|[^\s"]*)$`),
+		traceStartRegexp: regexp.MustCompile(`^(panic: |fatal error: |goroutine [0-9]+ \[)`),
 	}
 	obj.BaseLogParser = *NewBaseLogParser(obj.handleLine, "kube")
 	return &obj
 }
 
-// handleLine handles a single line of log output
+// handleLine handles a single line of log output. Lines that can't be parsed are logged verbatim.
 func (h *KubeLogParser) handleLine(lineStr string) error {
-	if strings.HasPrefix(lineStr, "[restful]") {
+	if !strings.HasSuffix(lineStr, "\n") || strings.Count(lineStr, "\n") > 1 {
+		// The parsers below stop at the first line feed, which has to be the end of the line to not lose anything
+		h.passThrough(lineStr)
+	} else if strings.HasPrefix(lineStr, "[restful]") {
 		// Ugh. [restful] means that this line is actually a different format
 		line := KubeLogLineRestful{}
 		ok, _ := line.Extract(lineStr) // With the current format, this function will never return an error
-		if !ok {
+		if !ok || line.Location == "" || strings.Contains(line.Location, " ") {
 			// Whelp. Normal format didn't work out, assume this line is simply unformatted...
-			h.log.WithFields(logrus.Fields{
-				"app": h.app,
-			}).Warn(strings.Trim(lineStr, "\n"))
+			h.passThrough(lineStr)
 			return nil
 		}
-		h.log.WithFields(logrus.Fields{
+		h.logEntry(h.log.WithFields(logrus.Fields{
 			"component": "restful",
 			"location":  line.Location,
 			"app":       h.app,
-		}).Info(line.Message)
+		}), logrus.InfoLevel, line.Location, line.Message)
 	} else {
 		// Hopefully this is a normal log line
-		line := KubeLogLine{}
-		// Fix multi-whitespaces as kube logs are intended for consoles...
-		lineStr = h.regexpInstance.ReplaceAllString(lineStr, " ")
-
-		ok, _ := line.Extract(lineStr) // With the current format, this function will never return an error
+		line, ok := h.parseKlogLine(lineStr)
 		if ok {
 			// Yay, this is a normal log entry!
-			entry := h.log.WithFields(logrus.Fields{
-				"app":      h.app,
-				"location": line.Location,
-			})
-
-			switch line.SeverityID[0] {
-			case 'I':
-				entry.Info(line.Message)
-			case 'E':
-				entry.Error(line.Message)
-			case 'W':
-				entry.Warning(line.Message)
-			case 'D':
-				entry.Debug(line.Message)
-			case 'N': // Notice is handled as info
-				entry.Info(line.Message)
-			case 'S': // Severe is handled as error
-				entry.Error(line.Message)
-			default:
+			level, ok := klogSeverities[line.SeverityID[0]]
+			if !ok {
 				h.log.WithFields(logrus.Fields{
 					"component": "KubeLogParser",
 					"app":       "microkube",
 					"level":     line.SeverityID[0],
 				}).Warn("Unknown severity level in kube log parser")
-				h.log.WithFields(logrus.Fields{
-					"app": h.app,
-				}).Warn(lineStr)
+				h.passThrough(lineStr)
+				return nil
 			}
+			message, fields := h.splitStructuredMessage(line.Message)
+			if fields == nil {
+				fields = logrus.Fields{}
+			}
+			if h.classifier != nil {
+				level = h.classifier(message, level, fields)
+			}
+			entry := h.log.WithFields(fields).WithFields(logrus.Fields{
+				"app":      h.app,
+				"location": line.Location,
+			})
+			h.logEntry(entry, level, line.Location, message)
 		} else {
 			// Whelp. Normal format didn't work out, assume this line is simply unformatted...
-			h.log.WithFields(logrus.Fields{
-				"app": h.app,
-			}).Warn(strings.Trim(lineStr, "\n"))
+			h.handleUnformattedLine(lineStr)
 		}
 	}
 
 	return nil
 }
+
+// handleUnformattedLine handles a single line that isn't a log entry on its own. Panics and goroutine dumps are logged
+// as errors, other lines are assumed to continue the previous entry (e.g. its stack trace).
+func (h *KubeLogParser) handleUnformattedLine(lineStr string) {
+	message := strings.TrimSuffix(lineStr, "\n")
+	if h.traceStartRegexp.MatchString(message) {
+		h.logEntry(h.log.WithFields(logrus.Fields{
+			"app": h.app,
+		}), logrus.ErrorLevel, "", message)
+		return
+	}
+	if !h.hasLast {
+		h.passThrough(lineStr)
+		return
+	}
+	fields := logrus.Fields{
+		"app":          h.app,
+		"continuation": true,
+	}
+	if h.lastLocation != "" {
+		fields["location"] = h.lastLocation
+	}
+	h.logEntry(h.log.WithFields(fields), h.lastLevel, h.lastLocation, message)
+}
+
+// logEntry logs 'message' at 'level' and remembers level and location for continuation lines
+func (h *KubeLogParser) logEntry(entry *logrus.Entry, level logrus.Level, location, message string) {
+	h.lastLevel = level
+	h.lastLocation = location
+	h.hasLast = true
+	switch level {
+	case logrus.DebugLevel:
+		entry.Debug(message)
+	case logrus.InfoLevel:
+		entry.Info(message)
+	case logrus.WarnLevel:
+		entry.Warning(message)
+	default:
+		entry.Error(message)
+	}
+}
+
+// parseKlogLine parses a klog line ('Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg'). The header is padded for
+// consoles, which is removed before parsing, while the message is kept as-is. The line is only considered valid if the
+// header is well-formed.
+func (h *KubeLogParser) parseKlogLine(lineStr string) (*KubeLogLine, bool) {
+	end := strings.Index(lineStr, "] ")
+	if end < 0 {
+		return nil, false
+	}
+	header := h.regexpInstance.ReplaceAllString(lineStr[:end], " ")
+	if !h.headerRegexp.MatchString(header) {
+		return nil, false
+	}
+	line := KubeLogLine{}
+	ok, _ := line.Extract(header + lineStr[end:]) // With the current format, this function will never return an error
+	return &line, ok
+}
+
+// splitStructuredMessage splits a structured klog message ('"msg" key="value" key2=value2', as written by InfoS and
+// ErrorS) into the message and its key/value pairs. Other messages are returned unchanged.
+func (h *KubeLogParser) splitStructuredMessage(message string) (string, logrus.Fields) {
+	fields := logrus.Fields{}
+	head := message
+	for {
+		match := h.structuredRegexp.FindStringSubmatchIndex(head)
+		if match == nil {
+			break
+		}
+		key := head[match[2]:match[3]]
+		value := head[match[4]:match[5]]
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err == nil {
+				value = unquoted
+			}
+		}
+		// Pairs are split off from the end, so for duplicate keys the last one wins
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+		head = head[:match[0]]
+	}
+	// Messages of structured lines are always quoted, this avoids splitting plain messages ending in 'key=value'
+	if !strings.HasPrefix(head, `"`) {
+		return message, nil
+	}
+	unquoted, err := strconv.Unquote(head)
+	if err != nil {
+		return message, nil
+	}
+	return unquoted, fields
+}
+
+// passThrough logs a line that couldn't be parsed as a warning without modifying it
+func (h *KubeLogParser) passThrough(lineStr string) {
+	h.log.WithFields(logrus.Fields{
+		"app": h.app,
+	}).Warn(strings.TrimSuffix(lineStr, "\n"))
+}
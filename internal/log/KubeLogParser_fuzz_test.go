@@ -0,0 +1,89 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzKubeLogParser checks that arbitrary lines are either parsed or passed through verbatim. Run it with
+// 'go test -fuzz FuzzKubeLogParser ./internal/log', the corpus in testdata is used as seed.
+func FuzzKubeLogParser(f *testing.F) {
+	for _, line := range readKubeLogCorpus(f) {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		// The JSON formatter replaces invalid UTF-8, which would make the output differ from the input
+		if !utf8.ValidString(line) {
+			t.Skip()
+		}
+		checkKubeLogLine(t, line)
+	})
+}
+
+// FuzzKubeLogMessage checks that the message of a well-formed klog line is never modified, unless it is a structured
+// message (starting with a quote)
+func FuzzKubeLogMessage(f *testing.F) {
+	f.Add("Version: v1.11.2   (aligned    columns)")
+	f.Add("Trace[1298498081]: [1.000012345s] [1.000000s] END")
+	f.Add("] ")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, message string) {
+		if !utf8.ValidString(message) || strings.Contains(message, "\n") || strings.HasPrefix(message, `"`) {
+			t.Skip()
+		}
+		var buffer bytes.Buffer
+		uut := NewKubeLogParser("testkubeapp")
+		uut.log.SetLevel(logrus.DebugLevel)
+		uut.log.SetOutput(&buffer)
+		uut.log.Formatter = &logrus.JSONFormatter{
+			DisableTimestamp: true,
+		}
+		fields := logrus.Fields{}
+		uut.log.AddHook(&captureHook{fields: fields})
+		err := uut.handleLine("E0812 17:00:08.194751   25997 genericapiserver.go:319] " + message + "\n")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if fields["msg"] != message || fields["location"] != "genericapiserver.go:319" || fields["level"] != "error" {
+			t.Fatalf("Unexpected result for '%q': %v", message, fields)
+		}
+	})
+}
+
+// captureHook records the message, level and location of the last log entry
+type captureHook struct {
+	fields logrus.Fields
+}
+
+func (h *captureHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *captureHook) Fire(entry *logrus.Entry) error {
+	h.fields["msg"] = entry.Message
+	h.fields["level"] = entry.Level.String()
+	h.fields["location"] = entry.Data["location"]
+	return nil
+}
@@ -17,8 +17,13 @@
 package log
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -110,7 +115,7 @@ func TestInvalidKubeMessageType(t *testing.T) {
 	}
 	result := buffer.String()
 	if result != `{"app":"microkube","component":"KubeLogParser","fields.level":88,"level":"warning","msg":"Unknown severity level in kube log parser"}
-{"app":"testkubeapp","level":"warning","msg":"X0812 17:00:08.194751 25997 genericapiserver.go:319] Skipping API scheduling.k8s.io/v1alpha1 because it has no resources.\n"}
+{"app":"testkubeapp","level":"warning","msg":"X0812 17:00:08.194751   25997 genericapiserver.go:319] Skipping API scheduling.k8s.io/v1alpha1 because it has no resources."}
 ` {
 		t.Fatalf("Unexpected output: %s", result)
 	}
@@ -155,3 +160,200 @@ func TestIncompleteKubeMessag(t *testing.T) {
 		t.Fatalf("Unexpected output: %s", result)
 	}
 }
+
+// TestAwkwardKubeMessages tests messages that are easy to mis-split
+func TestAwkwardKubeMessages(t *testing.T) {
+	testCases := map[string]string{
+		"I0812 17:00:13.000000   26001 server.go:129] Version: v1.11.2   (aligned    columns)\n": `{"app":"testkubeapp","level":"info","location":"server.go:129","msg":"Version: v1.11.2   (aligned    columns)"}`,
+		"I0812 17:00:11.123500   25999 trace.go:76] Trace[1298498081]: [1.000012345s] END\n":     `{"app":"testkubeapp","level":"info","location":"trace.go:76","msg":"Trace[1298498081]: [1.000012345s] END"}`,
+		"F0812 17:00:14.000000   26002 server.go:155] unable to load client CA file\n":           `{"app":"testkubeapp","level":"error","location":"server.go:155","msg":"unable to load client CA file"}`,
+		"  I0812 17:00:18.000000   26005 indented.go:1] leading whitespace\n":                    `{"app":"testkubeapp","level":"warning","msg":"  I0812 17:00:18.000000   26005 indented.go:1] leading whitespace"}`,
+		"W0812 17:00:16.000000   26003 ] empty location\n":                                       `{"app":"testkubeapp","level":"warning","msg":"W0812 17:00:16.000000   26003 ] empty location"}`,
+		"I0812 17:00:21.000000   26008 x.go:1] \n":                                               `{"app":"testkubeapp","level":"info","location":"x.go:1","msg":""}`,
+		"goroutine 1 [running]:\n":                                                               `{"app":"testkubeapp","level":"error","msg":"goroutine 1 [running]:"}`,
+	}
+	for testStr, expected := range testCases {
+		var buffer bytes.Buffer
+		uut := NewKubeLogParser("testkubeapp")
+		uut.log.SetLevel(logrus.DebugLevel)
+		uut.log.SetOutput(&buffer)
+		uut.log.Formatter = &logrus.JSONFormatter{
+			DisableTimestamp: true,
+		}
+		err := uut.HandleData([]byte(testStr))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if buffer.String() != expected+"\n" {
+			t.Fatalf("Unexpected output for '%s': %s", testStr, buffer.String())
+		}
+	}
+}
+
+// TestStructuredKubeMessages tests whether the key/value pairs of structured messages are logged as fields
+func TestStructuredKubeMessages(t *testing.T) {
+	testCases := map[string]string{
+		"I0812 17:00:22.000000   26009 controller.go:42] \"Starting controller\" name=\"node ipam\" workers=5\n":                                                                    `{"app":"testkubeapp","level":"info","location":"controller.go:42","msg":"Starting controller","name":"node ipam","workers":"5"}`,
+		"E0812 17:00:23.000000   26010 pod_workers.go:190] \"Error syncing pod, skipping\" err=\"failed to \\\"StartContainer\\\" for \\\"dns\\\"\" pod=\"kube-system/kube-dns\"\n": `{"app":"testkubeapp","err":"failed to \"StartContainer\" for \"dns\"","level":"error","location":"pod_workers.go:190","msg":"Error syncing pod, skipping","pod":"kube-system/kube-dns"}`,
+		"I0812 17:00:24.000000   26011 server.go:50] \"Quoted message without pairs\"\n":                                                                                            `{"app":"testkubeapp","level":"info","location":"server.go:50","msg":"Quoted message without pairs"}`,
+		"I0812 17:00:25.000000   26012 server.go:51] Listening on port=8080\n":                                                                                                      `{"app":"testkubeapp","level":"info","location":"server.go:51","msg":"Listening on port=8080"}`,
+		"I0812 17:00:26.000000   26013 server.go:52] \"Unterminated message key=value\n":                                                                                            `{"app":"testkubeapp","level":"info","location":"server.go:52","msg":"\"Unterminated message key=value"}`,
+	}
+	for testStr, expected := range testCases {
+		var buffer bytes.Buffer
+		uut := NewKubeLogParser("testkubeapp")
+		uut.log.SetLevel(logrus.DebugLevel)
+		uut.log.SetOutput(&buffer)
+		uut.log.Formatter = &logrus.JSONFormatter{
+			DisableTimestamp: true,
+		}
+		err := uut.HandleData([]byte(testStr))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if buffer.String() != expected+"\n" {
+			t.Fatalf("Unexpected output for '%s': %s", testStr, buffer.String())
+		}
+	}
+}
+
+// TestKubeStackTrace tests whether the lines of a goroutine dump are associated with the entry they belong to
+func TestKubeStackTrace(t *testing.T) {
+	var buffer bytes.Buffer
+	testStr := `I0812 17:00:14.000000   26002 server.go:150] Loading client CA file
+F0812 17:00:14.000000   26002 server.go:155] unable to load client CA file
+goroutine 1 [running]:
+k8s.io/kubernetes/vendor/github.com/golang/glog.stacks(0xc420388000, 0xc4203fe000, 0x83, 0xd1)
+	/workspace/vendor/github.com/golang/glog/glog.go:769 +0xcf
+panic: runtime error: invalid memory address or nil pointer dereference
+	/workspace/cmd/kube-apiserver/app/server.go:160 +0x4a
+`
+	uut := NewKubeLogParser("testkubeapp")
+	uut.log.SetLevel(logrus.DebugLevel)
+	uut.log.SetOutput(&buffer)
+	uut.log.Formatter = &logrus.JSONFormatter{
+		DisableTimestamp: true,
+	}
+	err := uut.HandleData([]byte(testStr))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	result := buffer.String()
+	if result != `{"app":"testkubeapp","level":"info","location":"server.go:150","msg":"Loading client CA file"}
+{"app":"testkubeapp","level":"error","location":"server.go:155","msg":"unable to load client CA file"}
+{"app":"testkubeapp","level":"error","msg":"goroutine 1 [running]:"}
+{"app":"testkubeapp","continuation":true,"level":"error","msg":"k8s.io/kubernetes/vendor/github.com/golang/glog.stacks(0xc420388000, 0xc4203fe000, 0x83, 0xd1)"}
+{"app":"testkubeapp","continuation":true,"level":"error","msg":"\t/workspace/vendor/github.com/golang/glog/glog.go:769 +0xcf"}
+{"app":"testkubeapp","level":"error","msg":"panic: runtime error: invalid memory address or nil pointer dereference"}
+{"app":"testkubeapp","continuation":true,"level":"error","msg":"\t/workspace/cmd/kube-apiserver/app/server.go:160 +0x4a"}
+` {
+		t.Fatalf("Unexpected output: %s", result)
+	}
+}
+
+// TestKubeContinuationLine tests whether lines continuing an entry are logged with its level and location
+func TestKubeContinuationLine(t *testing.T) {
+	var buffer bytes.Buffer
+	testStr := "Flag --cni-bin-dir has been deprecated\n" +
+		"I0812 17:00:15.000000   26003 server.go:408] Version: v1.11.2\n" +
+		"  with some details on the next line\n"
+	uut := NewKubeLogParser("testkubeapp")
+	uut.log.SetLevel(logrus.DebugLevel)
+	uut.log.SetOutput(&buffer)
+	uut.log.Formatter = &logrus.JSONFormatter{
+		DisableTimestamp: true,
+	}
+	err := uut.HandleData([]byte(testStr))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	result := buffer.String()
+	if result != `{"app":"testkubeapp","level":"warning","msg":"Flag --cni-bin-dir has been deprecated"}
+{"app":"testkubeapp","level":"info","location":"server.go:408","msg":"Version: v1.11.2"}
+{"app":"testkubeapp","continuation":true,"level":"info","location":"server.go:408","msg":"  with some details on the next line"}
+` {
+		t.Fatalf("Unexpected output: %s", result)
+	}
+}
+
+// TestKubeLogParserCorpus checks that no line of the corpus of awkward lines is corrupted
+func TestKubeLogParserCorpus(t *testing.T) {
+	for _, line := range readKubeLogCorpus(t) {
+		checkKubeLogLine(t, line)
+	}
+}
+
+// readKubeLogCorpus returns all lines of the corpus of awkward kube log lines, including the line feed
+func readKubeLogCorpus(t testing.TB) []string {
+	file, err := os.Open(path.Join("testdata", "klog-awkward.log"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer file.Close()
+	lines := []string{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return lines
+}
+
+// checkKubeLogLine feeds 'line' into a KubeLogParser and checks that it is either parsed or passed through verbatim,
+// that is, the message logged last is either the complete line or the part following the header, without the key/value
+// pairs of structured lines
+func checkKubeLogLine(t *testing.T, line string) {
+	var buffer bytes.Buffer
+	uut := NewKubeLogParser("testkubeapp")
+	uut.log.SetLevel(logrus.DebugLevel)
+	uut.log.SetOutput(&buffer)
+	uut.log.Formatter = &logrus.JSONFormatter{
+		DisableTimestamp: true,
+	}
+	err := uut.handleLine(line)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	type logEntry struct {
+		Level    string `json:"level"`
+		Location string `json:"location"`
+		Message  string `json:"msg"`
+	}
+	entry := logEntry{}
+	decoder := json.NewDecoder(&buffer)
+	entries := 0
+	for decoder.More() {
+		err = decoder.Decode(&entry)
+		if err != nil {
+			t.Fatalf("Couldn't decode output for '%q': %s", line, err)
+		}
+		entries++
+	}
+	if entries == 0 {
+		t.Fatalf("Line '%q' was dropped", line)
+	}
+
+	trimmed := strings.TrimSuffix(line, "\n")
+	if entry.Message == trimmed {
+		return
+	}
+	header := ""
+	if strings.HasSuffix(trimmed, entry.Message) {
+		header = trimmed[:len(trimmed)-len(entry.Message)]
+	}
+	if end := strings.Index(trimmed, "] "); !strings.HasSuffix(header, "] ") && end >= 0 {
+		message, fields := uut.splitStructuredMessage(trimmed[end+2:])
+		if message == entry.Message && fields != nil {
+			header = trimmed[:end+2]
+		}
+	}
+	if !strings.HasSuffix(header, "] ") || entry.Location == "" || !strings.Contains(header, entry.Location) {
+		t.Fatalf("Line '%q' was corrupted: location '%s', message '%q'", line, entry.Location, entry.Message)
+	}
+}
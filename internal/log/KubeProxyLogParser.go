@@ -0,0 +1,80 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// KubeProxyLogParserName is the name of the parser for kube-proxy's output, see log.RegisterParser
+const KubeProxyLogParserName = "kube-proxy"
+
+// kubeProxySyncDurationRegexp matches the sync latency messages of the iptables proxier, e.g. 'syncProxyRules took
+// 25.3ms'
+var kubeProxySyncDurationRegexp = regexp.MustCompile(`^syncProxyRules took ([0-9.]+[a-zµ]+)$`)
+
+// kubeProxyRoutineMessages are logged by kube-proxy on every sync of its rules
+var kubeProxyRoutineMessages = []string{
+	"Syncing iptables rules",
+	"SyncProxyRules complete",
+	"syncProxyRules took ",
+}
+
+func init() {
+	log.MustRegisterParser(KubeProxyLogParserName, func(app string) Parser {
+		return NewKubeProxyLogParser(app)
+	})
+}
+
+// NewKubeProxyLogParser creates a KubeLogParser for kube-proxy named by 'app'. Routine syncs of the iptables rules
+// are logged at debug level, including their duration in field 'durationSeconds', while failed syncs are surfaced as
+// warnings.
+func NewKubeProxyLogParser(app string) *KubeLogParser {
+	obj := NewKubeLogParser(app)
+	obj.classifier = classifyKubeProxyEntry
+	return obj
+}
+
+// classifyKubeProxyEntry is the entryClassifier of kube-proxy, see NewKubeProxyLogParser
+func classifyKubeProxyEntry(message string, level logrus.Level, fields logrus.Fields) logrus.Level {
+	lower := strings.ToLower(message)
+	if lower == "sync failed" || (strings.Contains(lower, "iptables") &&
+		(strings.Contains(lower, "fail") || strings.Contains(lower, "error"))) {
+		// kube-proxy retries failed syncs, so this isn't fatal, but rules might be stale until then
+		return logrus.WarnLevel
+	}
+	for _, routine := range kubeProxyRoutineMessages {
+		if !strings.HasPrefix(message, routine) {
+			continue
+		}
+		duration := ""
+		if match := kubeProxySyncDurationRegexp.FindStringSubmatch(message); match != nil {
+			duration = match[1]
+		} else if elapsed, ok := fields["elapsed"].(string); ok {
+			duration = elapsed
+		}
+		if parsed, err := time.ParseDuration(duration); err == nil {
+			fields["durationSeconds"] = parsed.Seconds()
+		}
+		return logrus.DebugLevel
+	}
+	return level
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+// TestKubeProxyMessages tests whether routine syncs are quietened and failed syncs are surfaced
+func TestKubeProxyMessages(t *testing.T) {
+	testCases := map[string]string{
+		"I0812 17:00:08.194751   25997 proxier.go:624] Syncing iptables rules\n":                                                               `{"app":"kube-proxy","level":"debug","location":"proxier.go:624","msg":"Syncing iptables rules"}`,
+		"I0812 17:00:08.219992   25997 proxier.go:1412] syncProxyRules took 25.3ms\n":                                                          `{"app":"kube-proxy","durationSeconds":0.0253,"level":"debug","location":"proxier.go:1412","msg":"syncProxyRules took 25.3ms"}`,
+		"I0812 17:00:08.219992   25997 proxier.go:820] \"SyncProxyRules complete\" elapsed=\"1.5s\"\n":                                         `{"app":"kube-proxy","durationSeconds":1.5,"elapsed":"1.5s","level":"debug","location":"proxier.go:820","msg":"SyncProxyRules complete"}`,
+		"E0812 17:00:09.001234   25997 proxier.go:1402] Failed to execute iptables-restore: exit status 1 (iptables-restore: line 7 failed)\n": `{"app":"kube-proxy","level":"warning","location":"proxier.go:1402","msg":"Failed to execute iptables-restore: exit status 1 (iptables-restore: line 7 failed)"}`,
+		"E0812 17:00:09.001234   25997 proxier.go:860] \"Sync failed\" retryingTime=\"30s\"\n":                                                 `{"app":"kube-proxy","level":"warning","location":"proxier.go:860","msg":"Sync failed","retryingTime":"30s"}`,
+		"I0812 17:00:07.000000   25997 server.go:444] Version: v1.11.2\n":                                                                      `{"app":"kube-proxy","level":"info","location":"server.go:444","msg":"Version: v1.11.2"}`,
+	}
+	for testStr, expected := range testCases {
+		var buffer bytes.Buffer
+		uut := NewKubeProxyLogParser("kube-proxy")
+		uut.log.SetLevel(logrus.DebugLevel)
+		uut.log.SetOutput(&buffer)
+		uut.log.Formatter = &logrus.JSONFormatter{
+			DisableTimestamp: true,
+		}
+		err := uut.HandleData([]byte(testStr))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if buffer.String() != expected+"\n" {
+			t.Fatalf("Unexpected output for '%s': %s", testStr, buffer.String())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"github.com/vs-eth/microkube/pkg/log"
+	"testing"
+)
+
+// TestBuiltinParsers tests whether the built-in parsers are registered and can be created by name
+func TestBuiltinParsers(t *testing.T) {
+	parser, err := log.NewParser(KubeLogParserName, "kube-api")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	kubeParser, ok := parser.(*KubeLogParser)
+	if !ok || kubeParser.app != "kube-api" {
+		t.Fatalf("Unexpected parser: %#v", parser)
+	}
+	parser, err = log.NewParser(ETCDLogParserName, "etcd")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, ok := parser.(*ETCDLogParser); !ok {
+		t.Fatalf("Unexpected parser: %#v", parser)
+	}
+	parser, err = log.NewParser(KubeProxyLogParserName, "kube-proxy")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if kubeParser, ok := parser.(*KubeLogParser); !ok || kubeParser.classifier == nil {
+		t.Fatalf("Unexpected parser: %#v", parser)
+	}
+
+	names := log.ParserNames()
+	if len(names) != 3 || names[0] != "etcd" || names[1] != "kube" || names[2] != "kube-proxy" {
+		t.Fatalf("Unexpected parser names: %v", names)
+	}
+}
@@ -1,5 +1,8 @@
 //go:generate go run ../../cmd/codegen/Manifest.go -name DNS -src ../../manifests/coredns.yml -dest DNS.go -package manifests
 //go:generate go run ../../cmd/codegen/Manifest.go -name KubeDash -src ../../manifests/kubernetes-dashboard.yaml -dest KubeDash.go -package manifests
+//go:generate go run ../../cmd/codegen/Manifest.go -name Konnectivity -src ../../manifests/konnectivity.yml -dest Konnectivity.go -package manifests
+//go:generate go run ../../cmd/codegen/Manifest.go -name ReadOnlyRBAC -src ../../manifests/readonly-rbac.yml -dest ReadOnlyRBAC.go -package manifests
+//go:generate go run ../../cmd/codegen/Manifest.go -name MetricsServerBase -src ../../manifests/metrics-server.yml -dest MetricsServerBase.go -package manifests
 
 /*
  * Copyright 2018 The microkube authors
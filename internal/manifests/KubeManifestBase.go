@@ -18,24 +18,85 @@ package manifests
 
 import (
 	"bytes"
+	"context"
+	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/kube"
+	"github.com/vs-eth/microkube/pkg/pki"
 	"io/ioutil"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
 	cmd2 "k8s.io/kubernetes/pkg/kubectl/cmd"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 )
 
+// spreadAnnotation marks deployments whose replica count and pod anti-affinity are derived from the runtime info, see
+// KubeManifestRuntimeInfo.EffectiveReplicas
+const spreadAnnotation = "microkube.vs-eth.github.io/spread"
+
+// injectCAAnnotation marks webhook configurations whose webhooks without a caBundle trust microkube's kubernetes CA,
+// see KubeManifestBase.injectCABundle
+const injectCAAnnotation = "microkube.vs-eth.github.io/inject-ca-bundle"
+
+// validationTimeout is the time validating all objects of a manifest may take
+const validationTimeout = 30 * time.Second
+
+// applyTimeout is the time applying all objects of a manifest may take
+const applyTimeout = 60 * time.Second
+
+// applyFieldManager is the field manager recorded for all fields set by microkube's manifests
+const applyFieldManager = "microkube"
+
+// errServerSideApplyUnsupported is returned by applyWith if the apiserver doesn't support server-side apply
+var errServerSideApplyUnsupported = errors.New("server-side apply unsupported")
+
 // KubeManifestRuntimeInfo contains all runtime information about the current environment (e.g. pod IP range...)
 type KubeManifestRuntimeInfo struct {
+	// Settings shared by all services, e.g. ExecEnv.DNSAddress is the cluster IP of the DNS service
 	ExecEnv handlers.ExecutionEnvironment
+	// Network range used for pods
+	PodRange *net.IPNet
+	// Network range used for services
+	ServiceRange *net.IPNet
+	// Base directory of microkube, which contains all state (e.g. certificates)
+	BaseDir string
+	// Number of nodes in the cluster
+	NodeCount int
+	// Replica count of deployments marked with spreadAnnotation, 0 to derive it from NodeCount
+	Replicas int32
+	// Credentials of the cluster, used for injecting the CA into webhook configurations marked with
+	// injectCAAnnotation. May be nil.
+	Credentials *pki.MicrokubeCredentials
+}
+
+// EffectiveReplicas returns the number of replicas for deployments marked with spreadAnnotation: two if there are
+// multiple nodes, one otherwise, unless explicitly specified
+func (r KubeManifestRuntimeInfo) EffectiveReplicas() int32 {
+	if r.Replicas > 0 {
+		return r.Replicas
+	}
+	if r.NodeCount > 1 {
+		return 2
+	}
+	return 1
 }
 
 // KubeManifestBase is the base type for all autogenerated manifests, bundling common functionality
@@ -50,12 +111,19 @@ type KubeManifestBase struct {
 	healthObjParsed runtime.Object
 	// Name of this service
 	name string
+	// Runtime information used to adjust objects on registration
+	runtimeInfo KubeManifestRuntimeInfo
 }
 
 // KubeManifest is implemented by all types that can be applied to a kube cluster as supported by KubeManifestBase
 type KubeManifest interface {
 	// ApplyToCluster applies this manifest to the kubernetes cluster specified in 'kubeconfig'
 	ApplyToCluster(kubeconfig string) error
+	// RemoveFromCluster deletes all objects of this manifest from the kubernetes cluster specified in 'kubeconfig'
+	RemoveFromCluster(kubeconfig string) error
+	// Validate checks all objects of this manifest against the apiserver of the kubernetes cluster specified in
+	// 'kubeconfig' without applying anything
+	Validate(kubeconfig string) error
 	// IsHealthy checks whether the resources this manifest describes can be considered 'healthy'
 	// You'll need to run InitHealthCheck first.
 	IsHealthy() (bool, error)
@@ -67,10 +135,171 @@ type KubeManifest interface {
 
 type KubeManifestConstructor func(KubeManifestRuntimeInfo) (KubeManifest, error)
 
+// ManifestValidator validates objects against the apiserver without persisting them, see kube.KubeClient.DryRunApply
+type ManifestValidator interface {
+	DryRunApply(ctx context.Context, obj runtime.Object) error
+}
+
+// ManifestApplier creates or updates objects, see kube.KubeClient.ForceServerSideApply
+type ManifestApplier interface {
+	ForceServerSideApply(ctx context.Context, obj runtime.Object, fieldManager string) error
+}
+
+// ManifestDeleter deletes objects, see kube.KubeClient.DeleteObject
+type ManifestDeleter interface {
+	DeleteObject(ctx context.Context, obj runtime.Object) error
+}
+
+// SetRuntimeInfo sets the runtime information used when registering manifests. It is supposed to be only used by
+// derived types!
+func (m *KubeManifestBase) SetRuntimeInfo(runtimeInfo KubeManifestRuntimeInfo) {
+	m.runtimeInfo = runtimeInfo
+}
+
 // Register registers the manifest 'manifest' (JSON string) with this instance of KubeManifestBase. It is supposed to
-// be only used by derived types!
+// be only used by derived types! Deployments marked with spreadAnnotation and webhook configurations marked with
+// injectCAAnnotation are adjusted to the runtime information.
 func (m *KubeManifestBase) Register(manifest string) {
-	m.objects = append(m.objects, manifest)
+	m.objects = append(m.objects, m.injectCABundle(m.applySpread(manifest)))
+}
+
+// RegisterTemplate renders the manifest template 'manifest' (JSON string) with the runtime information, e.g.
+// '{{ .ExecEnv.DNSAddress }}' or '{{ .ServiceRange }}', and registers the result like Register. It is supposed to be
+// only used by derived types!
+func (m *KubeManifestBase) RegisterTemplate(manifest string) error {
+	tmpl, err := template.New(m.name).Option("missingkey=error").Parse(manifest)
+	if err != nil {
+		return errors.Wrap(err, "couldn't parse manifest template")
+	}
+	buf := bytes.Buffer{}
+	err = tmpl.Execute(&buf, m.runtimeInfo)
+	if err != nil {
+		return errors.Wrap(err, "couldn't render manifest template")
+	}
+	m.Register(buf.String())
+	return nil
+}
+
+// applySpread sets replica count and anti-affinity of 'manifest' if it is a deployment marked with spreadAnnotation.
+// Anything else is returned unchanged.
+func (m *KubeManifestBase) applySpread(manifest string) string {
+	obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(manifest), nil, nil)
+	if err != nil {
+		return manifest
+	}
+	replicas := m.runtimeInfo.EffectiveReplicas()
+	switch deployment := obj.(type) {
+	case *appsv1.Deployment:
+		if deployment.Annotations[spreadAnnotation] != "true" {
+			return manifest
+		}
+		deployment.Spec.Replicas = &replicas
+		m.spreadPodTemplate(&deployment.Spec.Template, replicas)
+	case *extensionsv1beta1.Deployment:
+		if deployment.Annotations[spreadAnnotation] != "true" {
+			return manifest
+		}
+		deployment.Spec.Replicas = &replicas
+		m.spreadPodTemplate(&deployment.Spec.Template, replicas)
+	default:
+		return manifest
+	}
+	return m.encodeAdjusted(obj, gvk, manifest)
+}
+
+// injectCABundle sets the caBundle of all webhooks without one to microkube's kubernetes CA if 'manifest' is a webhook
+// configuration marked with injectCAAnnotation. This allows webhooks to serve certificates signed by that CA. Anything
+// else is returned unchanged.
+func (m *KubeManifestBase) injectCABundle(manifest string) string {
+	obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(manifest), nil, nil)
+	if err != nil {
+		return manifest
+	}
+	var webhooks []admissionv1beta1.Webhook
+	switch config := obj.(type) {
+	case *admissionv1beta1.ValidatingWebhookConfiguration:
+		if config.Annotations[injectCAAnnotation] != "true" {
+			return manifest
+		}
+		webhooks = config.Webhooks
+	case *admissionv1beta1.MutatingWebhookConfiguration:
+		if config.Annotations[injectCAAnnotation] != "true" {
+			return manifest
+		}
+		webhooks = config.Webhooks
+	default:
+		return manifest
+	}
+
+	logCtx := log.WithFields(log.Fields{
+		"component": "services",
+		"service":   m.name,
+	})
+	if m.runtimeInfo.Credentials == nil || m.runtimeInfo.Credentials.KubeCA == nil {
+		logCtx.Warn("No CA available, using webhook configuration unchanged")
+		return manifest
+	}
+	caBundle, err := ioutil.ReadFile(m.runtimeInfo.Credentials.KubeCA.CertPath)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't read CA, using webhook configuration unchanged")
+		return manifest
+	}
+	// 'webhooks' shares its backing array with the configuration
+	for i := range webhooks {
+		if len(webhooks[i].ClientConfig.CABundle) == 0 {
+			webhooks[i].ClientConfig.CABundle = caBundle
+		}
+	}
+	return m.encodeAdjusted(obj, gvk, manifest)
+}
+
+// encodeAdjusted encodes 'obj', which was decoded from 'manifest' and then adjusted, as JSON. If this fails, 'manifest'
+// is returned unchanged.
+func (m *KubeManifestBase) encodeAdjusted(obj runtime.Object, gvk *schema.GroupVersionKind, manifest string) string {
+	buf := bytes.Buffer{}
+	encoder := scheme.Codecs.EncoderForVersion(&json.Serializer{}, gvk.GroupVersion())
+	err := encoder.Encode(obj, &buf)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"component": "services",
+			"service":   m.name,
+			"kind":      gvk.Kind,
+		}).WithError(err).Warn("Couldn't encode adjusted object, using it unchanged")
+		return manifest
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// spreadPodTemplate adds pod anti-affinity to 'template' so that 'replicas' pods are spread across nodes. The
+// anti-affinity is only required if there are enough nodes, otherwise replicas would stay pending forever.
+func (m *KubeManifestBase) spreadPodTemplate(template *corev1.PodTemplateSpec, replicas int32) {
+	if template.Spec.Affinity == nil {
+		template.Spec.Affinity = &corev1.Affinity{}
+	}
+	if replicas <= 1 {
+		template.Spec.Affinity.PodAntiAffinity = nil
+		return
+	}
+	term := corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: template.Labels,
+		},
+		TopologyKey: "kubernetes.io/hostname",
+	}
+	if int(replicas) <= m.runtimeInfo.NodeCount {
+		template.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+		}
+	} else {
+		template.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight:          100,
+					PodAffinityTerm: term,
+				},
+			},
+		}
+	}
 }
 
 // RegisterHO registers the manifest 'manifest' (JSON string) as something to watch for a healthcheck with this instance
@@ -90,8 +319,25 @@ func (m *KubeManifestBase) SetName(name string) {
 	m.name = name
 }
 
-// ApplyToCluster applies this manifest to the kubernetes cluster specified in 'kubeconfig'
+// ApplyToCluster applies this manifest to the kubernetes cluster specified in 'kubeconfig'. Objects are created or
+// updated using server-side apply, so applying an unchanged manifest again is a no-op. Objects whose immutable fields
+// changed are skipped with a warning. If the apiserver doesn't support server-side apply, kubectl apply is used.
 func (m *KubeManifestBase) ApplyToCluster(kubeconfig string) error {
+	client, err := kube.NewKubeClient(kubeconfig)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
+	defer cancel()
+	err = m.applyWith(ctx, client)
+	if err != errServerSideApplyUnsupported {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"component": "services",
+		"service":   m.name,
+	}).Debug("Server-side apply unsupported, using kubectl apply")
 	str, err := m.dumpToFile()
 	if err != nil {
 		return err
@@ -99,6 +345,151 @@ func (m *KubeManifestBase) ApplyToCluster(kubeconfig string) error {
 	return m.runApply(kubeconfig, str)
 }
 
+// applyWith applies all objects using 'applier', skipping objects that can't be updated because immutable fields
+// changed. The returned error lists every other object that couldn't be applied and why.
+func (m *KubeManifestBase) applyWith(ctx context.Context, applier ManifestApplier) error {
+	var problems []string
+	for idx, manifest := range m.objects {
+		obj, err := parseObject(manifest)
+		if err != nil {
+			problems = append(problems, "object "+strconv.Itoa(idx)+": "+err.Error())
+			continue
+		}
+		err = applier.ForceServerSideApply(ctx, obj, applyFieldManager)
+		if err == nil {
+			continue
+		}
+		cause := errors.Cause(err)
+		if apierrors.IsUnsupportedMediaType(cause) {
+			// Fails for the first object already, so nothing was applied yet
+			return errServerSideApplyUnsupported
+		}
+		logCtx := log.WithFields(log.Fields{
+			"component": "services",
+			"service":   m.name,
+			"object":    objectID(obj),
+		})
+		if isImmutableFieldError(cause) {
+			logCtx.WithError(err).Warn("Object can't be updated since immutable fields changed, skipping it. " +
+				"Delete it to have it recreated.")
+			continue
+		}
+		logCtx.WithError(err).Debug("Couldn't apply object")
+		problems = append(problems, objectID(obj)+": "+err.Error())
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("%d object(s) not applied: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RemoveFromCluster deletes all objects of this manifest from the kubernetes cluster specified in 'kubeconfig', which
+// undoes ApplyToCluster. Objects that don't exist are ignored.
+func (m *KubeManifestBase) RemoveFromCluster(kubeconfig string) error {
+	client, err := kube.NewKubeClient(kubeconfig)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
+	defer cancel()
+	return m.removeWith(ctx, client)
+}
+
+// removeWith deletes all objects using 'deleter' in reverse order, so that e.g. a deployment is deleted before the
+// service account it uses. The returned error lists every object that couldn't be deleted and why.
+func (m *KubeManifestBase) removeWith(ctx context.Context, deleter ManifestDeleter) error {
+	var problems []string
+	for idx := len(m.objects) - 1; idx >= 0; idx-- {
+		obj, err := parseObject(m.objects[idx])
+		if err != nil {
+			problems = append(problems, "object "+strconv.Itoa(idx)+": "+err.Error())
+			continue
+		}
+		err = deleter.DeleteObject(ctx, obj)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"component": "services",
+				"service":   m.name,
+				"object":    objectID(obj),
+			}).WithError(err).Debug("Couldn't delete object")
+			problems = append(problems, objectID(obj)+": "+err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("%d object(s) not deleted: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isImmutableFieldError returns whether 'err' was returned by the apiserver because an update changed a field that
+// can't be changed after creation, e.g. the cluster IP of a service or the selector of a deployment
+func isImmutableFieldError(err error) bool {
+	return apierrors.IsInvalid(err) && strings.Contains(err.Error(), "field is immutable")
+}
+
+// objectID returns a human-readable identifier of 'obj', e.g. 'Deployment kube-system/coredns'
+func objectID(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() != "" {
+		return obj.GetKind() + " " + obj.GetNamespace() + "/" + obj.GetName()
+	}
+	return obj.GetKind() + " " + obj.GetName()
+}
+
+// Validate checks all objects of this manifest against the apiserver of the kubernetes cluster specified in
+// 'kubeconfig' using a server-side dry run. Nothing is applied, so a manifest that fails validation can be skipped
+// as a whole instead of being applied partially.
+func (m *KubeManifestBase) Validate(kubeconfig string) error {
+	client, err := kube.NewKubeClient(kubeconfig)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+	return m.validateWith(ctx, client)
+}
+
+// validateWith validates all objects using 'validator'. The returned error lists every invalid object and why it is
+// invalid.
+func (m *KubeManifestBase) validateWith(ctx context.Context, validator ManifestValidator) error {
+	var problems []string
+	for idx, manifest := range m.objects {
+		obj, err := parseObject(manifest)
+		if err != nil {
+			problems = append(problems, "object "+strconv.Itoa(idx)+": "+err.Error())
+			continue
+		}
+		err = validator.DryRunApply(ctx, obj)
+		if err != nil {
+			id := objectID(obj)
+			log.WithFields(log.Fields{
+				"component": "services",
+				"service":   m.name,
+				"object":    id,
+			}).WithError(err).Debug("Object is invalid")
+			problems = append(problems, id+": "+err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("%d invalid object(s): %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// parseObject parses 'manifest' (YAML or JSON) into an unstructured object, so that kinds unknown to this binary
+// (e.g. custom resources) can be validated as well
+func parseObject(manifest string) (*unstructured.Unstructured, error) {
+	data, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse object")
+	}
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse object")
+	}
+	return obj, nil
+}
+
 // dumpToFile writes a manifest file suitable for kubectl apply
 func (m *KubeManifestBase) dumpToFile() (string, error) {
 	file, err := ioutil.TempFile("", "kube-apply-manifest")
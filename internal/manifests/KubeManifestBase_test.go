@@ -17,9 +17,28 @@
 package manifests
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"net"
+	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -50,3 +69,270 @@ func TestBaseFunctions(t *testing.T) {
 	}
 	assert.Equal(t, false, health, "unexpected health")
 }
+
+// TestSpread checks whether replica count and anti-affinity of marked deployments follow the node count
+func TestSpread(t *testing.T) {
+	spreadDeployment := strings.Replace(testDeployment, "  name: kubernetes-dashboard\n",
+		"  name: kubernetes-dashboard\n  annotations:\n    "+spreadAnnotation+": \"true\"\n", 1)
+
+	// Single node: one replica, no anti-affinity
+	uut := KubeManifestBase{}
+	uut.SetRuntimeInfo(KubeManifestRuntimeInfo{NodeCount: 1})
+	uut.Register(spreadDeployment)
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(uut.objects[0]), nil, nil)
+	assert.NoError(t, err, "unexpected error")
+	deployment := obj.(*appsv1.Deployment)
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas, "unexpected replica count")
+	assert.Nil(t, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity, "unexpected anti-affinity")
+
+	// Multiple nodes: two replicas that must not share a node
+	uut = KubeManifestBase{}
+	uut.SetRuntimeInfo(KubeManifestRuntimeInfo{NodeCount: 3})
+	uut.Register(spreadDeployment)
+	obj, _, err = scheme.Codecs.UniversalDeserializer().Decode([]byte(uut.objects[0]), nil, nil)
+	assert.NoError(t, err, "unexpected error")
+	deployment = obj.(*appsv1.Deployment)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas, "unexpected replica count")
+	if assert.NotNil(t, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity, "anti-affinity missing") {
+		assert.Len(t, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity.
+			RequiredDuringSchedulingIgnoredDuringExecution, 1, "unexpected anti-affinity")
+	}
+
+	// More replicas than nodes: anti-affinity is only preferred
+	uut = KubeManifestBase{}
+	uut.SetRuntimeInfo(KubeManifestRuntimeInfo{NodeCount: 1, Replicas: 3})
+	uut.Register(spreadDeployment)
+	obj, _, err = scheme.Codecs.UniversalDeserializer().Decode([]byte(uut.objects[0]), nil, nil)
+	assert.NoError(t, err, "unexpected error")
+	deployment = obj.(*appsv1.Deployment)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas, "unexpected replica count")
+	if assert.NotNil(t, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity, "anti-affinity missing") {
+		assert.Len(t, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity.
+			PreferredDuringSchedulingIgnoredDuringExecution, 1, "unexpected anti-affinity")
+	}
+
+	// Unmarked deployments stay untouched
+	uut = KubeManifestBase{}
+	uut.SetRuntimeInfo(KubeManifestRuntimeInfo{NodeCount: 3})
+	uut.Register(testDeployment)
+	assert.Equal(t, testDeployment, uut.objects[0], "unmarked deployment was changed")
+}
+
+// testWebhookConfig is a webhook configuration marked for CA injection, with one webhook that already has a caBundle
+const testWebhookConfig = `kind: ValidatingWebhookConfiguration
+apiVersion: admissionregistration.k8s.io/v1beta1
+metadata:
+  name: test-webhook
+  annotations:
+    ` + injectCAAnnotation + `: "true"
+webhooks:
+- name: inject.example.com
+  clientConfig:
+    service:
+      namespace: default
+      name: test-webhook
+- name: keep.example.com
+  clientConfig:
+    service:
+      namespace: default
+      name: test-webhook
+    caBundle: a2VlcA==
+`
+
+// TestInjectCABundle checks whether microkube's CA is injected into marked webhook configurations
+func TestInjectCABundle(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-manifests")
+	if err != nil {
+		t.Fatalf("Couldn't create tempdir: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	caFile := path.Join(tmpdir, "ca.pem")
+	err = ioutil.WriteFile(caFile, []byte("ca"), 0600)
+	if err != nil {
+		t.Fatalf("Couldn't write CA: '%s'", err)
+	}
+	runtimeInfo := KubeManifestRuntimeInfo{
+		Credentials: &pki.MicrokubeCredentials{
+			KubeCA: &pki.RSACertificate{CertPath: caFile},
+		},
+	}
+
+	uut := KubeManifestBase{}
+	uut.SetRuntimeInfo(runtimeInfo)
+	uut.Register(testWebhookConfig)
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(uut.objects[0]), nil, nil)
+	assert.NoError(t, err, "unexpected error")
+	config := obj.(*admissionv1beta1.ValidatingWebhookConfiguration)
+	if assert.Len(t, config.Webhooks, 2, "unexpected number of webhooks") {
+		assert.Equal(t, []byte("ca"), config.Webhooks[0].ClientConfig.CABundle, "CA not injected")
+		assert.Equal(t, []byte("keep"), config.Webhooks[1].ClientConfig.CABundle, "existing caBundle overwritten")
+	}
+
+	// Unmarked configurations stay untouched
+	unmarked := strings.Replace(testWebhookConfig, injectCAAnnotation, "example.com/other", 1)
+	uut = KubeManifestBase{}
+	uut.SetRuntimeInfo(runtimeInfo)
+	uut.Register(unmarked)
+	assert.Equal(t, unmarked, uut.objects[0], "unmarked webhook configuration was changed")
+
+	// Without credentials, there is nothing to inject
+	uut = KubeManifestBase{}
+	uut.Register(testWebhookConfig)
+	assert.Equal(t, testWebhookConfig, uut.objects[0], "webhook configuration changed without CA")
+}
+
+// rejectingValidator rejects all objects with a given name
+type rejectingValidator struct {
+	reject string
+	// Kinds of all objects validated
+	validated []string
+}
+
+func (v *rejectingValidator) DryRunApply(ctx context.Context, obj runtime.Object) error {
+	u := obj.(*unstructured.Unstructured)
+	v.validated = append(v.validated, u.GetKind())
+	if u.GetName() == v.reject {
+		return errors.New("spec.replicas: Invalid value")
+	}
+	return nil
+}
+
+// TestValidate checks whether all objects are validated and every invalid object is reported
+func TestValidate(t *testing.T) {
+	uut := KubeManifestBase{}
+	uut.Register(testDeployment)
+	uut.Register(testWebhookConfig)
+
+	validator := &rejectingValidator{}
+	assert.NoError(t, uut.validateWith(context.Background(), validator), "unexpected error")
+	assert.Equal(t, []string{"Deployment", "ValidatingWebhookConfiguration"}, validator.validated,
+		"not all objects validated")
+
+	uut.Register("kind: [")
+	validator = &rejectingValidator{reject: "kubernetes-dashboard"}
+	err := uut.validateWith(context.Background(), validator)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "2 invalid object(s)", "wrong number of invalid objects")
+		assert.Contains(t, err.Error(), "Deployment kube-system/kubernetes-dashboard: spec.replicas: Invalid value",
+			"rejected object missing")
+		assert.Contains(t, err.Error(), "object 2: couldn't parse object", "unparseable object missing")
+	}
+}
+
+// TestRegisterTemplate checks whether the CoreDNS manifest is rendered with the configured DNS address and templates
+// referring to unknown runtime information are rejected
+func TestRegisterTemplate(t *testing.T) {
+	codegen := NewManifestCodegen("../../manifests/coredns.yml", "manifests", "DNS", "", "", "")
+	assert.NoError(t, codegen.ParseFile(), "couldn't parse CoreDNS manifest")
+
+	_, serviceRange, _ := net.ParseCIDR("10.96.0.0/16")
+	uut := KubeManifestBase{}
+	uut.SetName("DNS")
+	uut.SetRuntimeInfo(KubeManifestRuntimeInfo{
+		ExecEnv: handlers.ExecutionEnvironment{
+			DNSAddress: net.ParseIP("10.96.0.10"),
+		},
+		ServiceRange: serviceRange,
+	})
+	for _, entry := range codegen.entries {
+		if strings.HasSuffix(entry.name, "HO") {
+			continue
+		}
+		buf := bytes.Buffer{}
+		encoder := scheme.Codecs.EncoderForVersion(&json.Serializer{}, entry.gv)
+		assert.NoError(t, encoder.Encode(entry.obj, &buf), "couldn't encode object")
+		assert.NoError(t, uut.RegisterTemplate(buf.String()), "couldn't render object")
+	}
+	found := false
+	for _, manifest := range uut.objects {
+		obj, err := parseObject(manifest)
+		assert.NoError(t, err, "rendered object is invalid")
+		if obj.GetKind() == "Service" {
+			clusterIP, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterIP")
+			assert.Equal(t, "10.96.0.10", clusterIP, "DNS address not rendered")
+			found = true
+		}
+	}
+	assert.True(t, found, "DNS service missing")
+
+	assert.NoError(t, uut.RegisterTemplate(`{"range":"{{ .ServiceRange }}"}`), "couldn't render service range")
+	assert.Equal(t, `{"range":"10.96.0.0/16"}`, uut.objects[len(uut.objects)-1], "service range not rendered")
+	assert.Error(t, uut.RegisterTemplate(`{"ip":"{{ .ExecEnv.NoSuchAddress }}"}`), "unknown field accepted")
+}
+
+// fakeApplier fails to apply objects depending on their name
+type fakeApplier struct {
+	// Error to return per object name
+	errors map[string]error
+	// Names of all objects applied
+	applied []string
+}
+
+func (a *fakeApplier) ForceServerSideApply(ctx context.Context, obj runtime.Object, fieldManager string) error {
+	u := obj.(*unstructured.Unstructured)
+	a.applied = append(a.applied, u.GetName())
+	return a.errors[u.GetName()]
+}
+
+// TestApply checks whether objects with changed immutable fields are skipped, other failures are reported and a
+// missing server-side apply is detected
+func TestApply(t *testing.T) {
+	uut := KubeManifestBase{}
+	for _, name := range []string{"immutable", "broken", "fine"} {
+		uut.Register(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"` + name + `","namespace":"test"}}`)
+	}
+
+	applier := &fakeApplier{}
+	assert.NoError(t, uut.applyWith(context.Background(), applier), "unexpected error")
+	assert.Equal(t, []string{"immutable", "broken", "fine"}, applier.applied, "not all objects applied")
+
+	applier = &fakeApplier{errors: map[string]error{
+		"immutable": apierrors.NewInvalid(schema.GroupKind{Kind: "Service"}, "immutable", field.ErrorList{
+			field.Invalid(field.NewPath("spec", "clusterIP"), "10.0.0.1", "field is immutable"),
+		}),
+		"broken": errors.New("connection refused"),
+	}}
+	err := uut.applyWith(context.Background(), applier)
+	assert.Equal(t, []string{"immutable", "broken", "fine"}, applier.applied, "apply stopped early")
+	if assert.Error(t, err) {
+		assert.Equal(t, "1 object(s) not applied: Service test/broken: connection refused", err.Error(),
+			"wrong error returned")
+	}
+
+	applier = &fakeApplier{errors: map[string]error{
+		"immutable": &apierrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonUnsupportedMediaType}},
+	}}
+	assert.Equal(t, errServerSideApplyUnsupported, uut.applyWith(context.Background(), applier),
+		"missing server-side apply not detected")
+}
+
+// recordingDeleter records the names of all objects deleted and fails for a given name
+type recordingDeleter struct {
+	reject  string
+	deleted []string
+}
+
+func (d *recordingDeleter) DeleteObject(ctx context.Context, obj runtime.Object) error {
+	u := obj.(*unstructured.Unstructured)
+	d.deleted = append(d.deleted, u.GetName())
+	if u.GetName() == d.reject {
+		return errors.New("forbidden")
+	}
+	return nil
+}
+
+// TestRemove checks whether all objects are deleted in reverse order and failures are reported
+func TestRemove(t *testing.T) {
+	uut := KubeManifestBase{}
+	for _, name := range []string{"account", "deployment"} {
+		uut.Register(`{"apiVersion":"v1","kind":"ServiceAccount","metadata":{"name":"` + name + `"}}`)
+	}
+
+	deleter := &recordingDeleter{reject: "account"}
+	err := uut.removeWith(context.Background(), deleter)
+	assert.Equal(t, []string{"deployment", "account"}, deleter.deleted, "objects not deleted in reverse order")
+	if assert.Error(t, err) {
+		assert.Equal(t, "1 object(s) not deleted: ServiceAccount account: forbidden", err.Error(),
+			"wrong error returned")
+	}
+}
@@ -190,6 +190,8 @@ import (
 	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 	"github.com/vs-eth/microkube/internal/cmd"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"path"
 	"`)
 	bufWriter.WriteString(m.mainPkgBase + "/" + m.pkg)
 	if m.hasHealthCheck {
@@ -201,21 +203,42 @@ import (
 
 func main() {
 	kubeconfig := flag.String("kubeconfig", "~/.mukube/kube/kubeconfig", "Path to Kubeconfig")
+	root := flag.String("root", "~/.mukube", "Microkube root directory, containing the CA to inject into "+
+		"webhook configurations")
+	validate := flag.Bool("validate-manifests", false, "Validate all objects against the apiserver before "+
+		"applying any of them")
 	arg := cmd.ArgHandler{}
 	kmri := manifests.KubeManifestRuntimeInfo{
 		ExecEnv: *arg.HandleArgs(),
 	}
+	kmri.PodRange = arg.PodRangeNet
+	kmri.ServiceRange = arg.ServiceRangeNet
 	var err error
 	*kubeconfig, err = homedir.Expand(*kubeconfig)
 	if err != nil {
 		log.WithError(err).WithField("root", *kubeconfig).Fatal("Couldn't expand kubeconfig")
 	}
+	kmri.BaseDir, err = homedir.Expand(*root)
+	if err != nil {
+		log.WithError(err).WithField("root", *root).Fatal("Couldn't expand root directory")
+	}
+	kmri.Credentials = &pki.MicrokubeCredentials{
+		KubeCA: &pki.RSACertificate{
+			CertPath: path.Join(kmri.BaseDir, "kubetls", "ca.pem"),
+		},
+	}
 	obj, err := `)
 	bufWriter.WriteString(m.pkg + ".New" + m.name)
 	bufWriter.WriteString(`(kmri)
 	if err != nil {
 		log.WithError(err).WithField("root", *kubeconfig).Fatal("Couldn't init object")
 	}
+	if *validate {
+		err = obj.Validate(*kubeconfig)
+		if err != nil {
+			log.WithError(err).WithField("root", *kubeconfig).Fatal("Manifest is invalid")
+		}
+	}
 	err = obj.ApplyToCluster(*kubeconfig)
 	if err != nil {
 		log.WithError(err).WithField("root", *kubeconfig).Fatal("Couldn't apply object to cluster")
@@ -258,18 +281,14 @@ func (m *ManifestCodegen) writeFile() error {
 
 `)
 	bufWriter.WriteString("package " + m.pkg)
-	bufWriter.WriteString(`
-
-import (
-	"bytes"
-	"text/template"
-`)
+	bufWriter.WriteString("\n\n")
 	if m.mainPkgBase+"/"+m.pkg != "github.com/vs-eth/microkube/internal/manifests" {
-		bufWriter.WriteString(`
+		bufWriter.WriteString(`import (
 	"github.com/vs-eth/microkube/internal/manifests"
+)
+
 `)
 	}
-	bufWriter.WriteString(")\n\n")
 
 	serializer := json.Serializer{}
 	for _, entry := range m.entries {
@@ -311,22 +330,18 @@ func New` + m.name + `(rtEnv `)
 	bufWriter.WriteString(`KubeManifestRuntimeInfo) (KubeManifest, error) {
 	obj := &` + m.name + `{}
 	obj.SetName("` + m.name + `")
+	obj.SetRuntimeInfo(rtEnv)
 	var err error
-	var buf *bytes.Buffer
-	var tmpl *template.Template
 
 `)
 
 	for _, entry := range m.entries {
 		// 'HO' means health object, those have to be registered differently
 		if !strings.HasSuffix(entry.name, "HO") {
-			bufWriter.WriteString(`	tmpl, err = template.New("` + entry.name + `").Parse(` + entry.name + `)
+			bufWriter.WriteString(`	err = obj.RegisterTemplate(` + entry.name + `)
 	if err != nil {
 		return nil, err
 	}
-	buf = bytes.NewBufferString("")
-	tmpl.Execute(buf, rtEnv)
-	obj.Register(buf.String())
 `)
 		} else {
 			bufWriter.WriteString(`	obj.RegisterHO(` + entry.name + ")\n")
@@ -0,0 +1,186 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifests
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// metricsAPIServiceName is the name of the APIService registering metrics-server as the resource metrics API
+const metricsAPIServiceName = "v1beta1.metrics.k8s.io"
+
+// metricsAPIServicePath is the path of the metrics APIService in the apiserver
+const metricsAPIServicePath = "/apis/apiregistration.k8s.io/v1beta1/apiservices/" + metricsAPIServiceName
+
+// apiServiceStatus mirrors the status of apiregistration.k8s.io/v1beta1 APIService, since the aggregator client isn't
+// vendored
+type apiServiceStatus struct {
+	Status struct {
+		Conditions []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// MetricsServer is the metrics-server cluster addon, which serves the resource metrics API (e.g. for 'kubectl top')
+// through the aggregation layer. It is healthy once its deployment is ready and the apiserver reports the APIService
+// as available.
+type MetricsServer struct {
+	*MetricsServerBase
+}
+
+// NewMetricsServer creates the metrics-server cluster addon. Its serving certificate is
+// MicrokubeCredentials.KubeMetricsServer, so runtimeInfo.Credentials is required.
+func NewMetricsServer(runtimeInfo KubeManifestRuntimeInfo) (KubeManifest, error) {
+	creds := runtimeInfo.Credentials
+	if creds == nil || creds.KubeCA == nil || creds.KubeMetricsServer == nil {
+		return nil, errors.New("metrics-server requires its certificate, see MicrokubeCredentials.MetricsServer")
+	}
+	manifest, err := NewMetricsServerBase(runtimeInfo)
+	if err != nil {
+		return nil, err
+	}
+	base := manifest.(*MetricsServerBase)
+	base.SetName("MetricsServer")
+
+	caBundle, err := ioutil.ReadFile(creds.KubeCA.CertPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read CA")
+	}
+	cert, err := ioutil.ReadFile(creds.KubeMetricsServer.CertPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read metrics-server certificate")
+	}
+	key, err := ioutil.ReadFile(creds.KubeMetricsServer.KeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read metrics-server key")
+	}
+	secret, err := metricsServerSecret(caBundle, cert, key)
+	if err != nil {
+		return nil, err
+	}
+	base.Register(secret)
+	apiService, err := metricsAPIService(caBundle)
+	if err != nil {
+		return nil, err
+	}
+	base.Register(apiService)
+	return &MetricsServer{base}, nil
+}
+
+// metricsServerSecret returns the secret metrics-server mounts its serving certificate 'cert' and key 'key' from as
+// JSON, along with the CA kubelet certificates are verified with
+func metricsServerSecret(caBundle, cert, key []byte) (string, error) {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "metrics-server-tls",
+			Namespace: "kube-system",
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"ca.crt":                caBundle,
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+		},
+	}
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't encode metrics-server secret")
+	}
+	return string(data), nil
+}
+
+// metricsAPIService returns the APIService registering the metrics-server service as the resource metrics API as
+// JSON. The apiserver verifies metrics-server's certificate with 'caBundle'.
+func metricsAPIService(caBundle []byte) (string, error) {
+	// The aggregator types aren't vendored and the scheme doesn't know APIService, so this isn't part of the
+	// generated manifest
+	apiService := map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1beta1",
+		"kind":       "APIService",
+		"metadata": map[string]interface{}{
+			"name": metricsAPIServiceName,
+		},
+		"spec": map[string]interface{}{
+			"service": map[string]interface{}{
+				"name":      "metrics-server",
+				"namespace": "kube-system",
+			},
+			"group":                "metrics.k8s.io",
+			"version":              "v1beta1",
+			"caBundle":             caBundle,
+			"groupPriorityMinimum": 100,
+			"versionPriority":      100,
+		},
+	}
+	data, err := json.Marshal(apiService)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't encode metrics APIService")
+	}
+	return string(data), nil
+}
+
+// IsHealthy checks whether the metrics-server deployment is ready and the apiserver reports the resource metrics API
+// as available. You'll need to run InitHealthCheck first.
+func (m *MetricsServer) IsHealthy() (bool, error) {
+	ok, err := m.MetricsServerBase.IsHealthy()
+	if !ok || err != nil {
+		return ok, err
+	}
+	data, err := m.client.CoreV1().RESTClient().Get().AbsPath(metricsAPIServicePath).DoRaw()
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't query metrics APIService")
+	}
+	return apiServiceAvailable(data)
+}
+
+// apiServiceAvailable returns whether the APIService 'data' (JSON) has an 'Available' condition that is true. If it
+// is false, the reason is returned as error.
+func apiServiceAvailable(data []byte) (bool, error) {
+	var apiService apiServiceStatus
+	err := json.Unmarshal(data, &apiService)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't decode APIService")
+	}
+	for _, condition := range apiService.Status.Conditions {
+		if condition.Type != "Available" {
+			continue
+		}
+		if condition.Status == "True" {
+			return true, nil
+		}
+		log.WithFields(log.Fields{
+			"component": "services",
+			"service":   "MetricsServer",
+			"reason":    condition.Reason,
+		}).Debug("APIService unavailable")
+		return false, errors.Errorf("APIService unavailable: %s", condition.Message)
+	}
+	return false, nil
+}
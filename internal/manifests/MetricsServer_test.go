@@ -0,0 +1,53 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifests
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestMetricsAPIService checks whether the APIService points to the metrics-server service and trusts the given CA
+func TestMetricsAPIService(t *testing.T) {
+	apiService, err := metricsAPIService([]byte("ca"))
+	assert.NoError(t, err, "couldn't create APIService")
+	obj, err := parseObject(apiService)
+	assert.NoError(t, err, "couldn't parse APIService")
+	assert.Equal(t, "APIService", obj.GetKind(), "unexpected kind")
+	assert.Equal(t, metricsAPIServiceName, obj.GetName(), "unexpected name")
+	assert.Contains(t, apiService, `"caBundle":"`+base64.StdEncoding.EncodeToString([]byte("ca"))+`"`,
+		"CA missing")
+	assert.Contains(t, apiService, `"service":{"name":"metrics-server","namespace":"kube-system"}`,
+		"unexpected service")
+}
+
+// TestAPIServiceAvailable checks whether APIServices are only considered available given the 'Available' condition
+func TestAPIServiceAvailable(t *testing.T) {
+	ok, err := apiServiceAvailable([]byte(`{"status":{"conditions":[{"type":"Available","status":"True"}]}}`))
+	assert.NoError(t, err, "available APIService reported as error")
+	assert.True(t, ok, "available APIService not recognized")
+
+	ok, err = apiServiceAvailable([]byte(`{"status":{"conditions":[{"type":"Available","status":"False",` +
+		`"reason":"FailedDiscoveryCheck","message":"no response"}]}}`))
+	assert.Error(t, err, "reason missing")
+	assert.False(t, ok, "unavailable APIService considered available")
+
+	ok, err = apiServiceAvailable([]byte(`{"status":{}}`))
+	assert.NoError(t, err, "unexpected error without conditions")
+	assert.False(t, ok, "APIService without conditions considered available")
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifests
+
+import (
+	"bytes"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// userManifestExtensions are the file extensions of manifest files, see ManifestFiles
+var userManifestExtensions = map[string]bool{
+	".yaml": true,
+	".yml":  true,
+	".json": true,
+}
+
+// documentSeparator separates the documents of a multi-document YAML file
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// ManifestFiles returns the paths of all manifest files (*.yaml, *.yml and *.json) in 'dir', sorted by name so that
+// the order they are applied in can be controlled by prefixing them with numbers. Subdirectories are ignored.
+func ManifestFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list manifest directory")
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !userManifestExtensions[strings.ToLower(path.Ext(entry.Name()))] {
+			continue
+		}
+		files = append(files, path.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// NewUserManifest reads the manifest file 'file' supplied by the user, which may contain multiple YAML documents or a
+// JSON object, and registers each object in it. The manifest is named after the file. It has no health check.
+func NewUserManifest(file string, runtimeInfo KubeManifestRuntimeInfo) (KubeManifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read manifest")
+	}
+	obj := &KubeManifestBase{}
+	obj.SetName(path.Base(file))
+	obj.SetRuntimeInfo(runtimeInfo)
+	for idx, doc := range documentSeparator.Split(string(data), -1) {
+		// Objects are concatenated when applying them, which only works for JSON
+		jsonDoc, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse document "+strconv.Itoa(idx+1))
+		}
+		jsonDoc = bytes.TrimSpace(jsonDoc)
+		if string(jsonDoc) == "null" {
+			// Empty document, e.g. only comments or a leading separator
+			continue
+		}
+		obj.Register(string(jsonDoc))
+	}
+	if len(obj.objects) == 0 {
+		return nil, errors.New("no objects found")
+	}
+	return obj, nil
+}
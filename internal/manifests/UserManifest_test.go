@@ -0,0 +1,68 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manifests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestUserManifests checks whether manifest files are found in order and multi-document files are split into objects
+func TestUserManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-manifests")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"20-config.yaml": "---\n# Leading separator and comment\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n" +
+			"---\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+		"10-namespace.json": `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "test"}}`,
+		"30-empty.yml":      "# Nothing here\n",
+		"README.md":         "Not a manifest",
+	}
+	for name, content := range files {
+		assert.NoError(t, ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644), "couldn't write file")
+	}
+	assert.NoError(t, os.Mkdir(path.Join(dir, "40-subdir.yaml"), 0755), "couldn't create directory")
+
+	found, err := ManifestFiles(dir)
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, []string{
+		path.Join(dir, "10-namespace.json"),
+		path.Join(dir, "20-config.yaml"),
+		path.Join(dir, "30-empty.yml"),
+	}, found, "unexpected manifest files")
+
+	manifest, err := NewUserManifest(found[1], KubeManifestRuntimeInfo{})
+	if assert.NoError(t, err, "unexpected error") {
+		assert.Equal(t, "20-config.yaml", manifest.Name(), "unexpected name")
+		assert.Equal(t, []string{
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"a"}}`,
+			`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"b"}}`,
+		}, manifest.(*KubeManifestBase).objects, "unexpected objects")
+	}
+	manifest, err = NewUserManifest(found[0], KubeManifestRuntimeInfo{})
+	if assert.NoError(t, err, "unexpected error") {
+		assert.Len(t, manifest.(*KubeManifestBase).objects, 1, "unexpected number of objects")
+	}
+	_, err = NewUserManifest(found[2], KubeManifestRuntimeInfo{})
+	assert.Error(t, err, "empty manifest accepted")
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"bufio"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"time"
+)
+
+// dnsPodSelector selects the pods of the CoreDNS cluster addon in namespace kube-system
+const dnsPodSelector = "k8s-app=kube-dns"
+
+// addonLogLines is how many lines of an addon's pod logs are logged once it becomes unhealthy
+const addonLogLines = 20
+
+// addonLogTimeout is how long reading an addon's pod logs may take
+const addonLogTimeout = 10 * time.Second
+
+// logAddonPods logs the last lines of the logs of all pods in kube-system matching 'labelSelector' to 'logCtx', to
+// help finding out why an addon is unhealthy
+func (c *Cluster) logAddonPods(logCtx *log.Entry, labelSelector string) {
+	ctx, cancel := context.WithTimeout(context.Background(), addonLogTimeout)
+	defer cancel()
+	stream, err := c.kCl.PodLogs(ctx, "kube-system", labelSelector, false)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't read pod logs")
+		return
+	}
+	defer stream.Close()
+	lines, err := tailLines(stream, addonLogLines)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't read pod logs")
+	}
+	for _, line := range lines {
+		logCtx.Info("Pod log: " + line)
+	}
+}
+
+// tailLines returns the last 'n' lines read from 'in', as far as 'in' could be read
+func tailLines(in io.Reader, n int) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
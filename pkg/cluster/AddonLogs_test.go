@@ -0,0 +1,34 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// TestTailLines checks whether only the last lines are kept
+func TestTailLines(t *testing.T) {
+	lines, err := tailLines(strings.NewReader("1\n2\n3\n4"), 2)
+	assert.NoError(t, err, "couldn't read lines")
+	assert.Equal(t, []string{"3", "4"}, lines, "unexpected lines")
+
+	lines, err = tailLines(strings.NewReader("1\n"), 2)
+	assert.NoError(t, err, "couldn't read lines")
+	assert.Equal(t, []string{"1"}, lines, "unexpected lines")
+}
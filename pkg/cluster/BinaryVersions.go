@@ -0,0 +1,83 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/helpers"
+)
+
+// SupportedEtcdVersions is the range of etcd versions that accept the flags microkube starts etcd with
+var SupportedEtcdVersions = helpers.VersionRange{
+	Min: helpers.BinaryVersion{Major: 3, Minor: 2},
+	Max: helpers.BinaryVersion{Major: 3, Minor: 6},
+}
+
+// SupportedKubeVersions is the range of kubernetes versions that accept the flags and configs microkube starts the
+// kubernetes components with, whether they come as hyperkube or as standalone binaries. Later releases changed more
+// than that, e.g. the apiserver requires service account token issuing to be configured since 1.20.
+var SupportedKubeVersions = helpers.VersionRange{
+	Min: helpers.BinaryVersion{Major: 1, Minor: 11},
+	Max: helpers.BinaryVersion{Major: 1, Minor: 19},
+}
+
+// RootlessKubeVersion is the first kubernetes release whose kubelet can run in a user namespace, using the
+// 'KubeletInUserNamespace' feature gate. Rootless mode is only available once SupportedKubeVersions includes it.
+var RootlessKubeVersion = helpers.BinaryVersion{Major: 1, Minor: 22}
+
+// checkRootlessSupported returns an error if rootless mode is requested, but none of the supported kubernetes releases
+// can run it. Otherwise, the kubelet would only fail once it is started, e.g. with an unknown feature gate.
+func (c *Cluster) checkRootlessSupported() error {
+	if c.baseExecEnv.Rootless && !RootlessKubeVersion.Less(SupportedKubeVersions.Max) {
+		return errors.Errorf("rootless mode needs Kubernetes %s or later, which isn't supported yet (supported are %s)",
+			RootlessKubeVersion, SupportedKubeVersions)
+	}
+	return nil
+}
+
+// checkBinaryVersion checks whether 'binary' (run with 'args' to report it's version) is in 'supported' and returns
+// the version, which is zero if it can't be determined. A version outside of the range or one that can't be determined
+// is only reported, unless strictVersion is set.
+func (c *Cluster) checkBinaryVersion(name, binary string, supported helpers.VersionRange,
+	args ...string) (helpers.BinaryVersion, error) {
+
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "prep",
+		"binary":    binary,
+		"supported": supported.String(),
+	})
+	version, err := helpers.QueryBinaryVersion(binary, args...)
+	if err != nil {
+		if c.strictVersion {
+			return helpers.BinaryVersion{}, errors.Wrapf(err, "couldn't determine %s version", name)
+		}
+		logCtx.WithError(err).Warnf("Couldn't determine %s version, it might not be supported", name)
+		return helpers.BinaryVersion{}, nil
+	}
+	logCtx = logCtx.WithField("version", version.String())
+	if !supported.Contains(version) {
+		if c.strictVersion {
+			return version, errors.Errorf("unsupported %s version %s, supported are %s", name, version, supported)
+		}
+		logCtx.Warnf("Unsupported %s version, services might fail to start with unknown flags", name)
+		return version, nil
+	}
+	logCtx.Debugf("Found %s", name)
+	return version, nil
+}
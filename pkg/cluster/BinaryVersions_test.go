@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestCheckBinaryVersion checks whether unsupported versions only fail startup in strict mode
+func TestCheckBinaryVersion(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	// echo reports the version it is given
+	uut := Cluster{}
+	version, err := uut.checkBinaryVersion("hyperkube", "/bin/echo", SupportedKubeVersions, "Kubernetes", "v1.11.2")
+	assert.NoError(t, err, "supported version rejected")
+	assert.Equal(t, helpers.BinaryVersion{Major: 1, Minor: 11, Patch: 2}, version, "wrong version")
+	_, err = uut.checkBinaryVersion("hyperkube", "/bin/echo", SupportedKubeVersions, "Kubernetes", "v1.25.0")
+	assert.NoError(t, err, "unsupported version rejected without strict mode")
+	version, err = uut.checkBinaryVersion("etcd", "/bin/false", SupportedEtcdVersions)
+	assert.NoError(t, err, "unknown version rejected without strict mode")
+	assert.Equal(t, helpers.BinaryVersion{}, version, "unknown version not zero")
+
+	uut.strictVersion = true
+	_, err = uut.checkBinaryVersion("etcd", "/bin/echo", SupportedEtcdVersions, "etcd Version: 3.3.9")
+	assert.NoError(t, err, "supported version rejected in strict mode")
+	_, err = uut.checkBinaryVersion("hyperkube", "/bin/echo", SupportedKubeVersions, "Kubernetes", "v1.25.0")
+	assert.Error(t, err, "unsupported version accepted in strict mode")
+	_, err = uut.checkBinaryVersion("etcd", "/bin/false", SupportedEtcdVersions)
+	assert.Error(t, err, "unknown version accepted in strict mode")
+}
+
+// TestFindBinaryOverride checks whether an explicitly given binary is used instead of searching it, if it's executable
+func TestFindBinaryOverride(t *testing.T) {
+	uut := Cluster{}
+	binary, err := uut.findBinary("hyperkube", "/bin/sh")
+	assert.NoError(t, err, "executable override rejected")
+	assert.Equal(t, "/bin/sh", binary, "override not used")
+
+	_, err = uut.findBinary("hyperkube", "/nonexistent/hyperkube")
+	assert.Error(t, err, "missing override accepted")
+	file, err := ioutil.TempFile("", "microkube-unittests-hyperkube")
+	if !assert.NoError(t, err, "file creation failed") {
+		return
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	_, err = uut.findBinary("hyperkube", file.Name())
+	assert.Error(t, err, "non-executable override accepted")
+}
+
+// TestCheckRootlessSupported checks whether rootless mode is rejected as long as no supported release can run it
+func TestCheckRootlessSupported(t *testing.T) {
+	uut := Cluster{}
+	assert.NoError(t, uut.checkRootlessSupported(), "rootless mode required")
+
+	uut.baseExecEnv = handlers.ExecutionEnvironment{Rootless: true}
+	supported := SupportedKubeVersions
+	defer func() {
+		SupportedKubeVersions = supported
+	}()
+	SupportedKubeVersions.Max = RootlessKubeVersion
+	assert.Error(t, uut.checkRootlessSupported(), "rootless mode accepted without a supported release")
+	SupportedKubeVersions.Max = helpers.BinaryVersion{Major: 1, Minor: 23}
+	assert.NoError(t, uut.checkRootlessSupported(), "rootless mode rejected with a supported release")
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/cmd"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"strconv"
+	"time"
+)
+
+// chaosServiceNames maps service names as used on the command line to the names services are registered as, if they
+// differ
+var chaosServiceNames = map[string]string{
+	"kube-apiserver": "kube-api",
+}
+
+// needsEtcdProxy returns whether any chaos policy injects faults into the connection between the apiserver and etcd
+func (c *Cluster) needsEtcdProxy() bool {
+	for _, policy := range c.chaosPolicies {
+		if policy.Action == cmd.ChaosActionLatency || policy.Action == cmd.ChaosActionDisconnect ||
+			policy.Action == cmd.ChaosActionErrors {
+			return true
+		}
+	}
+	return false
+}
+
+// setupEtcdProxy puts a fault proxy between the apiserver and etcd if required by the chaos policies. This has to
+// happen before the apiserver is started. Until startChaos is called, the proxy doesn't inject any faults.
+func (c *Cluster) setupEtcdProxy() error {
+	if !c.needsEtcdProxy() {
+		return nil
+	}
+	proxy, err := helpers.NewFaultProxy("127.0.0.1:0", "127.0.0.1:"+strconv.Itoa(c.baseExecEnv.EtcdClientPort))
+	if err != nil {
+		return errors.Wrap(err, "couldn't create etcd proxy")
+	}
+	c.etcdProxy = proxy
+	c.baseExecEnv.KubeAPIEtcdAddress = proxy.Addr()
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "chaos",
+		"address":   proxy.Addr(),
+	}).Info("Routing apiserver-etcd traffic through fault proxy")
+	return nil
+}
+
+// findRestartableService returns the handler of service 'name' (as used on the command line) if it supports restarts
+func (c *Cluster) findRestartableService(name string) (handlers.RestartableHandler, bool) {
+	if registered, ok := chaosServiceNames[name]; ok {
+		name = registered
+	}
+	for _, service := range c.services() {
+		if service.name == name {
+			handler, ok := service.handler.(handlers.RestartableHandler)
+			return handler, ok
+		}
+	}
+	return nil, false
+}
+
+// startChaos starts injecting the faults described by the chaos policies. Call the function returned to stop.
+func (c *Cluster) startChaos() context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	// closeEtcdProxy resets the field, the goroutines below keep using the proxy until they notice the cancellation
+	proxy := c.etcdProxy
+	for _, policy := range c.chaosPolicies {
+		logCtx := log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "chaos",
+			"service":   policy.Service,
+			"action":    policy.Action,
+			"interval":  policy.Interval,
+		})
+		var inject func()
+		switch policy.Action {
+		case cmd.ChaosActionLatency:
+			logCtx.Warn("Delaying apiserver-etcd traffic")
+			proxy.SetLatency(policy.Interval)
+			continue
+		case cmd.ChaosActionDisconnect:
+			inject = proxy.DisconnectAll
+		case cmd.ChaosActionErrors:
+			// Only called from the goroutine below
+			refusing := false
+			inject = func() {
+				refusing = !refusing
+				proxy.SetRefusing(refusing)
+			}
+		case cmd.ChaosActionRestart:
+			handler, ok := c.findRestartableService(policy.Service)
+			if !ok {
+				logCtx.Warn("Service not found or not restartable, ignoring policy")
+				continue
+			}
+			inject = handler.Restart
+		}
+		go func(inject func(), logCtx *log.Entry, interval time.Duration) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					logCtx.Warn("Injecting fault")
+					inject()
+				}
+			}
+		}(inject, logCtx, policy.Interval)
+	}
+	return func() {
+		cancel()
+		if proxy != nil {
+			proxy.SetLatency(0)
+			proxy.SetRefusing(false)
+		}
+	}
+}
+
+// closeEtcdProxy closes the fault proxy between the apiserver and etcd, if any. The apiserver needs etcd until it is
+// stopped, so this has to happen after the services were stopped and not when the faults are stopped.
+func (c *Cluster) closeEtcdProxy() {
+	if c.etcdProxy == nil {
+		return
+	}
+	err := c.etcdProxy.Close()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "chaos",
+		}).WithError(err).Warn("Couldn't close etcd proxy")
+	}
+	c.etcdProxy = nil
+}
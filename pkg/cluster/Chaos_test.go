@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/vs-eth/microkube/internal/cmd"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// restartableFakeServiceHandler is a fakeServiceHandler that additionally counts restarts
+type restartableFakeServiceHandler struct {
+	fakeServiceHandler
+	restarted *int32
+}
+
+func (f restartableFakeServiceHandler) Restart() {
+	atomic.AddInt32(f.restarted, 1)
+}
+
+// TestChaosRestart checks whether restart policies restart the right service until chaos is stopped
+func TestChaosRestart(t *testing.T) {
+	stopped, restarted := int32(0), int32(0)
+	obj := Cluster{
+		chaosPolicies: []cmd.ChaosPolicy{
+			{Service: "kube-apiserver", Action: cmd.ChaosActionRestart, Interval: 10 * time.Millisecond},
+			// Not restartable, ignored
+			{Service: "etcd", Action: cmd.ChaosActionRestart, Interval: 10 * time.Millisecond},
+		},
+	}
+	obj.registerService(serviceEntry{
+		handler: restartableFakeServiceHandler{
+			fakeServiceHandler: fakeServiceHandler{stopped: &stopped},
+			restarted:          &restarted,
+		},
+		name: "kube-api",
+	})
+	obj.registerService(serviceEntry{
+		handler: fakeServiceHandler{stopped: &stopped},
+		name:    "etcd",
+	})
+
+	stopChaos := obj.startChaos()
+	time.Sleep(55 * time.Millisecond)
+	stopChaos()
+	count := atomic.LoadInt32(&restarted)
+	if count < 2 {
+		t.Fatalf("Expected multiple restarts, got %d", count)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&restarted) != count {
+		t.Fatal("Restarts continued after chaos was stopped")
+	}
+	if atomic.LoadInt32(&stopped) != 0 {
+		t.Fatal("Service was stopped instead of restarted")
+	}
+}
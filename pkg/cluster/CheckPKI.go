@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"fmt"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io"
+	"strings"
+	"time"
+)
+
+// CheckPKI loads the credentials from the base directory and writes a report on each certificate to 'out' without
+// starting the cluster. Returns whether all checks passed.
+func (c *Cluster) CheckPKI(out io.Writer) bool {
+	creds := pki.MicrokubeCredentials{
+		ClockSkewTolerance: c.clockSkewTolerance,
+	}
+	err := creds.LoadCertificates(c.baseDir)
+	if err != nil {
+		fmt.Fprintf(out, "Couldn't load credentials from '%s': %s\n", c.baseDir, err)
+		return false
+	}
+
+	ok := true
+	for _, report := range creds.Inspect(time.Now()) {
+		ok = ok && report.OK()
+		writeCertReport(out, &report)
+	}
+	if ok {
+		fmt.Fprintln(out, "All certificates OK")
+	} else {
+		fmt.Fprintln(out, "Problems found, see above")
+	}
+	return ok
+}
+
+// writeCertReport writes a human-readable version of 'report' to 'out'
+func writeCertReport(out io.Writer, report *pki.CertReport) {
+	status := "OK"
+	if !report.OK() {
+		status = "FAILED"
+	}
+	fmt.Fprintf(out, "%s: %s\n", report.Name, status)
+	fmt.Fprintf(out, "  certificate: %s\n", report.CertPath)
+	fmt.Fprintf(out, "  key:         %s\n", report.KeyPath)
+	if report.Cert != nil {
+		fmt.Fprintf(out, "  subject:     %s\n", report.Cert.Subject)
+		fmt.Fprintf(out, "  issuer:      %s\n", report.Cert.Issuer)
+		fmt.Fprintf(out, "  SANs:        %s\n", strings.Join(report.SANs(), ", "))
+		fmt.Fprintf(out, "  valid:       %s - %s\n", report.Cert.NotBefore.Format(time.RFC3339),
+			report.Cert.NotAfter.Format(time.RFC3339))
+	}
+	checks := []struct {
+		name string
+		err  error
+	}{
+		{"load", report.LoadErr},
+		{"validity", report.ValidityErr},
+		{"chain", report.ChainErr},
+		{"key match", report.KeyErr},
+	}
+	for _, check := range checks {
+		if check.err != nil {
+			fmt.Fprintf(out, "  %s: %s\n", check.name, check.err)
+		}
+	}
+}
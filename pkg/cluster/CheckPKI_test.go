@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCheckPKI checks the check-pki report for a missing and a freshly created PKI
+func TestCheckPKI(t *testing.T) {
+	rootdir, err := ioutil.TempDir("", "microkube-unittests-checkpki")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(rootdir)
+	obj := Cluster{baseDir: rootdir, clockSkewTolerance: pki.DefaultClockSkewTolerance}
+
+	out := bytes.Buffer{}
+	if obj.CheckPKI(&out) {
+		t.Fatal("Missing credentials not reported!")
+	}
+	if !strings.Contains(out.String(), "Couldn't load credentials") {
+		t.Fatalf("Unexpected report: %s", out.String())
+	}
+
+	creds := pki.MicrokubeCredentials{}
+	err = creds.CreateOrLoadCertificates(rootdir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Credential creation failed: '%s'", err)
+	}
+	out.Reset()
+	if !obj.CheckPKI(&out) {
+		t.Fatalf("Unexpected problems: %s", out.String())
+	}
+	for _, expected := range []string{"kube server: OK", "127.1.1.1", "All certificates OK"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("'%s' missing from report: %s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,1916 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cluster runs a single node kubernetes cluster. It contains the implementation of the microkubed command
+// and can be used to embed a cluster in other programs, e.g. integration tests.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/cmd"
+	log2 "github.com/vs-eth/microkube/internal/log"
+	"github.com/vs-eth/microkube/internal/manifests"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/handlers/etcd"
+	"github.com/vs-eth/microkube/pkg/handlers/kube"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	kube2 "github.com/vs-eth/microkube/pkg/kube"
+	log3 "github.com/vs-eth/microkube/pkg/log"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io"
+	av1 "k8s.io/api/core/v1"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certExpiryWarning is how long before certificates expire microkubed starts warning about it
+const certExpiryWarning = 7 * 24 * time.Hour
+
+// certExpiryCheckInterval is the time between two checks for expiring certificates
+const certExpiryCheckInterval = 24 * time.Hour
+
+// addonEndpointTimeout is how long startup waits for the services of the cluster addons to get ready endpoints
+const addonEndpointTimeout = 2 * time.Minute
+
+// serviceConstructor describes a function that can create a service, given the I/O handlers
+type serviceConstructor func(handlers.OutputHandler, handlers.ExitHandler) (handlers.ServiceHandler, error)
+
+// serviceEntry describes all information about a running service needed to check it's health and stop it
+type serviceEntry struct {
+	exitChan chan bool
+	handler  handlers.ServiceHandler
+	name     string
+}
+
+// Cluster is a single node kubernetes cluster run by microkube, or a worker node joining such a cluster
+type Cluster struct {
+	// Are we in 'graceful termination mode', that is, everything has started successfully and in order to stop we
+	// should first stop all pods?
+	gracefulTerminationMode bool
+	// List of service handlers in the order they were started, only 'handler' and 'name' are set. This is used to
+	// handle shutdown on fatal errors
+	serviceHandlers []serviceEntry
+	// Base directory of microkube, which is where all state will be stored
+	baseDir string
+	// Extra binary dir which is added to the binary search path
+	extraBinDir string
+	// CNI plugins to copy to the kubelet's CNI directory
+	cniPlugins []string
+
+	// CIDR of pod IPs (commandline argument)
+	podRangeNet *net.IPNet
+	// CIDR of service IPs (commandline argument)
+	serviceRangeNet *net.IPNet
+	// CIDR of pod and service network combined
+	clusterIPRange *net.IPNet
+	// CIDR kube-proxy considers cluster-internal (pod network unless overridden)
+	proxyClusterCIDR *net.IPNet
+
+	// Struct with all credentials needed for any given service
+	cred *pki.MicrokubeCredentials
+	// Template struct with information needed for execution of programs
+	baseExecEnv handlers.ExecutionEnvironment
+	// Host and port of the apiserver to join as worker node, empty if running the control plane
+	joinHost string
+	joinPort int
+
+	// Path to etcd server binary
+	etcdBin string
+	// Path to etcdctl binary, only needed for taking and restoring snapshots
+	etcdctlBin string
+	// Path to hyperkube binary, empty if standalone component binaries are used
+	hyperkubeBin string
+	// Paths to the standalone binaries of the kubernetes components, indexed by component name (e.g.
+	// 'kube-apiserver'). Only used if there is no hyperkube binary.
+	componentBins map[string]string
+	// Version of the kubernetes components, zero if it couldn't be determined
+	kubeVersion helpers.BinaryVersion
+
+	// A list of running services
+	serviceList []serviceEntry
+	// Result of the last health check of each service, indexed by service name
+	serviceHealth map[string]handlers.HealthMessage
+	// Whether the node became ready during startup, which means it has to be drained when stopping
+	nodeReady bool
+	// Cancels the startup sequence, nil if not starting
+	cancelStartup context.CancelFunc
+	// Reason the startup sequence was aborted, nil if it wasn't
+	startupErr error
+	// Manifests applied to the cluster, in the order they were applied in
+	appliedManifests []manifests.KubeManifest
+	// Names of the addons whose health is monitored already
+	monitoredManifests map[string]bool
+	// Guards serviceList, serviceHealth, serviceHandlers, gracefulTerminationMode, nodeReady, cancelStartup,
+	// startupErr, appliedManifests and monitoredManifests. These are modified by concurrently starting services while
+	// health checks, exit handlers and Stop read them.
+	serviceLock sync.Mutex
+	// Maximum number of services to start at the same time
+	maxStartupParallelism int
+	// Whether to deploy the kubernetes dashboard cluster addon
+	enableKubeDash bool
+	// Namespace and name of the service account whose token is printed to sign in to the dashboard
+	dashboardNamespace      string
+	dashboardServiceAccount string
+	// Whether to deploy the CoreDNS cluster addon
+	enableDns bool
+	// Whether to deploy the konnectivity cluster addon
+	enableKonnectivity bool
+	// Whether to deploy the metrics-server cluster addon
+	enableMetricsServer bool
+	// Whether the apiserver writes an audit log
+	enableAudit bool
+	// Whether to only run the control plane, without kubelet and kube-proxy
+	noKubelet bool
+	// Whether to fail if the version of etcd or hyperkube is unsupported, instead of only warning
+	strictVersion bool
+	// Paths of the etcd and hyperkube binaries to use instead of searching them, empty to search them
+	etcdBinOverride      string
+	hyperkubeBinOverride string
+	// Whether to validate addon manifests against the apiserver before applying them
+	validateManifests bool
+	// Directory of manifests to apply after the cluster addons, empty if none
+	manifestDir string
+	// Number of CoreDNS replicas, 0 to derive it from the number of nodes
+	dnsReplicas int
+	// Directory to record raw service output to, empty if disabled
+	captureOutputDir string
+	// Whether to record raw service output to '<baseDir>/logs' as well
+	rawLogs bool
+	// Files service output is recorded to, closed by Stop. Guarded by recordersLock, as services start concurrently.
+	recorders     []io.Closer
+	recordersLock sync.Mutex
+	// Kubernetes client used for checking node status and service information
+	kCl *kube2.KubeClient
+	// Time a service may take to become healthy during startup, unless overridden in serviceTimeouts
+	serviceTimeout time.Duration
+	// Per-service startup timeouts, indexed by service name
+	serviceTimeouts map[string]time.Duration
+	// Maximum time to wait for all services to exit when stopping, 0 to not wait
+	shutdownTimeout time.Duration
+	// Maximum time to wait for all pods to be evicted from the node when stopping, 0 for no limit
+	drainTimeout time.Duration
+	// Backoff between health checks of a service during startup
+	startupBackoff helpers.Backoff
+	// Whether to remove the kubelet's pod state before starting
+	fresh bool
+	// Whether to keep etcd's data on a tmpfs
+	etcdTmpfs bool
+	// Whether we mounted the etcd tmpfs ourselves (and therefore have to unmount it)
+	etcdTmpfsMounted bool
+	// Whether to save a snapshot of etcd when stopping
+	snapshotOnExit bool
+	// Whether to delete all applied manifests when stopping
+	teardown bool
+	// Snapshot to restore etcd's data from before starting it, empty if none
+	restoreFrom string
+	// Whether to remove existing etcd data in order to restore restoreFrom
+	forceRestore bool
+	// Whether to only print the command line of each service instead of starting it
+	dryRun bool
+	// Whether to move all service ports to the next free range if one of them is in use
+	autoPortBase bool
+	// Interval in which to log the resource usage of all services, 0 if disabled
+	resourceReportInterval time.Duration
+	// Namespace to create during startup
+	defaultNamespace string
+	// Resource quota to apply to defaultNamespace, nil if none
+	defaultQuota av1.ResourceList
+	// Default container limits to apply to defaultNamespace, nil if none
+	defaultLimitRange av1.ResourceList
+	// How far the host clock may be off when checking the validity of existing certificates
+	clockSkewTolerance time.Duration
+	// Additional IPs or DNS names to include in the etcd server certificate
+	etcdSANs []string
+	// Additional IPs or DNS names to include in the kube-apiserver serving certificate
+	kubeSANs []string
+	// OpenID Connect authentication settings of the apiserver, disabled if empty
+	oidc kube.OIDCConfig
+	// Paths of an existing CA certificate and it's key to use as kubernetes CA, empty to use a self-signed one
+	externalKubeCACert string
+	externalKubeCAKey  string
+	// File to write the etcd and kubernetes CA certificates to, empty if disabled
+	caBundle string
+	// Whether to create a read-only kubeconfig in addition to the admin one
+	readOnlyKubeconfig bool
+	// Type of keys to create for new certificates
+	keyAlgorithm pki.KeyAlgorithm
+	// How long new certificates are valid
+	certValidity time.Duration
+	// Users to run services as, indexed by service name
+	runAsUsers map[string]string
+	// Log parsers overriding the default, indexed by service name
+	logParsers map[string]string
+	// Services probed over HTTPS with a client certificate, indexed by service name
+	secureHealthProbes map[string]bool
+	// Faults to inject after startup
+	chaosPolicies []cmd.ChaosPolicy
+	// Proxy between the apiserver and etcd to inject faults with, nil if not needed by chaosPolicies
+	etcdProxy *helpers.FaultProxy
+	// Address to serve the status page on, empty if disabled
+	statusListen string
+	// Serves the status of all services on the status socket, nil if not serving
+	statusSocket net.Listener
+	// Serves the status page on statusListen, nil if not serving
+	statusUI net.Listener
+	// Address to serve metrics on, empty if disabled
+	metricsListen string
+	// Serves metrics on metricsListen, nil if not serving
+	metricsListener net.Listener
+	// Restarts, health checks and uptime of all services, nil if metrics aren't served
+	metrics *helpers.Metrics
+	// Maximum number of health probes to run at the same time
+	healthCheckWorkers int
+	// Number of consecutive failed health probes after which the cluster fails, 0 to only warn
+	healthFailureThreshold int
+	// Probes the health of all services once the node is ready, nil before
+	healthScheduler *handlers.HealthScheduler
+	// Receives startup progress events, nil if disabled
+	progress *helpers.ProgressEmitter
+	// Receives the results of all health probes after startup, nil if disabled
+	healthReporter *handlers.HealthReporter
+	// Stops injecting faults, nil if chaos policies aren't active
+	stopChaos context.CancelFunc
+	// Receives an error if the cluster fails after startup, see Failed
+	failed chan error
+	// Makes sure that Stop only runs once
+	stopOnce sync.Once
+	// Closed by Stop to end background work such as periodic checks and addon health monitoring. Nil for clusters
+	// not created by New, which don't run any.
+	stopping chan struct{}
+	// Serializes Reload and makes Stop wait for a running one, guards stopped
+	reloadLock sync.Mutex
+	// Whether Stop was called, in which case Reload doesn't do anything
+	stopped bool
+}
+
+// New creates a cluster with the settings in 'config'. The cluster is started by Start.
+func New(config Config) (*Cluster, error) {
+	c := &Cluster{
+		baseDir:                 config.BaseDir,
+		extraBinDir:             config.ExtraBinDir,
+		cniPlugins:              config.CNIPlugins,
+		podRangeNet:             config.PodRangeNet,
+		serviceRangeNet:         config.ServiceRangeNet,
+		clusterIPRange:          config.ClusterIPRange,
+		proxyClusterCIDR:        config.ProxyClusterCIDR,
+		baseExecEnv:             config.ExecEnv,
+		enableKubeDash:          config.EnableKubeDash,
+		dashboardNamespace:      config.DashboardNamespace,
+		dashboardServiceAccount: config.DashboardServiceAccount,
+		enableDns:               config.EnableDNS,
+		enableKonnectivity:      config.EnableKonnectivity,
+		enableMetricsServer:     config.EnableMetricsServer,
+		enableAudit:             config.EnableAudit,
+		noKubelet:               config.NoKubelet,
+		strictVersion:           config.StrictVersion,
+		etcdBinOverride:         config.EtcdBinary,
+		hyperkubeBinOverride:    config.HyperkubeBinary,
+		validateManifests:       config.ValidateManifests,
+		manifestDir:             config.ManifestDir,
+		dnsReplicas:             config.DNSReplicas,
+		captureOutputDir:        config.CaptureOutputDir,
+		rawLogs:                 config.RawLogs,
+		serviceTimeout:          config.ServiceTimeout,
+		serviceTimeouts:         config.ServiceTimeouts,
+		shutdownTimeout:         config.ShutdownTimeout,
+		drainTimeout:            config.DrainTimeout,
+		startupBackoff:          config.StartupBackoff,
+		maxStartupParallelism:   config.MaxStartupParallelism,
+		runAsUsers:              config.RunAsUsers,
+		logParsers:              config.LogParsers,
+		secureHealthProbes:      config.SecureHealthProbes,
+		chaosPolicies:           config.ChaosPolicies,
+		fresh:                   config.Fresh,
+		etcdTmpfs:               config.EtcdTmpfs,
+		snapshotOnExit:          config.SnapshotOnExit,
+		teardown:                config.Teardown,
+		restoreFrom:             config.RestoreFrom,
+		forceRestore:            config.ForceRestore,
+		dryRun:                  config.DryRun,
+		autoPortBase:            config.AutoPortBase,
+		resourceReportInterval:  config.ResourceReportInterval,
+		defaultNamespace:        config.DefaultNamespace,
+		clockSkewTolerance:      config.ClockSkewTolerance,
+		keyAlgorithm:            config.KeyAlgorithm,
+		certValidity:            config.CertValidity,
+		etcdSANs:                config.EtcdSANs,
+		kubeSANs:                config.KubeSANs,
+		oidc:                    config.OIDC,
+		externalKubeCACert:      config.ExternalKubeCACert,
+		externalKubeCAKey:       config.ExternalKubeCAKey,
+		caBundle:                config.CABundle,
+		statusListen:            config.StatusListen,
+		metricsListen:           config.MetricsListen,
+		healthCheckWorkers:      config.HealthCheckWorkers,
+		healthFailureThreshold:  config.HealthFailureThreshold,
+		failed:                  make(chan error, 1),
+		stopping:                make(chan struct{}),
+	}
+	if c.baseDir == "" {
+		return nil, errors.New("base directory missing")
+	}
+	if c.podRangeNet == nil || c.serviceRangeNet == nil || c.clusterIPRange == nil {
+		return nil, errors.New("pod, service and cluster IP range are required")
+	}
+	if c.proxyClusterCIDR == nil {
+		c.proxyClusterCIDR = c.podRangeNet
+	}
+	if c.maxStartupParallelism < 1 {
+		return nil, errors.Errorf("startup parallelism must be at least 1, got %d", c.maxStartupParallelism)
+	}
+	if c.healthCheckWorkers < 1 {
+		return nil, errors.Errorf("health check workers must be at least 1, got %d", c.healthCheckWorkers)
+	}
+	err := c.checkRootlessSupported()
+	if err != nil {
+		return nil, err
+	}
+	if c.metricsListen != "" {
+		c.metrics = helpers.NewMetrics()
+	}
+	for service, parser := range c.logParsers {
+		if _, err := log3.NewParser(parser, service); err != nil {
+			return nil, errors.Wrapf(err, "invalid log parser for %s", service)
+		}
+	}
+	err = c.oidc.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = c.setExtraKubeconfigs(config.ExtraKubeconfigs)
+	if err != nil {
+		return nil, err
+	}
+	if config.JoinAPIServer != "" {
+		if c.noKubelet {
+			return nil, errors.New("a worker node only runs kubelet and kube-proxy, it can't run without kubelet")
+		}
+		err = c.setJoinAPIServer(config.JoinAPIServer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.DefaultQuota != "" {
+		c.defaultQuota, err = kube2.ParseResourceList(config.DefaultQuota)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse default quota")
+		}
+	}
+	if config.DefaultLimitRange != "" {
+		c.defaultLimitRange, err = kube2.ParseResourceList(config.DefaultLimitRange)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse default limit range")
+		}
+	}
+	err = c.openProgress(config.ProgressFile, config.ProgressSocket)
+	if err != nil {
+		return nil, err
+	}
+	err = c.openHealthReporter(config.HealthEvents)
+	if err != nil {
+		c.progress.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// setJoinAPIServer makes the cluster join the apiserver at 'address' (host:port) as worker node
+func (c *Cluster) setJoinAPIServer(address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid apiserver address '%s'", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid apiserver port in '%s'", address)
+	}
+	if c.externalKubeCACert == "" || c.externalKubeCAKey == "" {
+		// The apiserver only talks to kubelets whose serving certificate is signed by it's kubernetes CA
+		return errors.New("joining a cluster requires the certificate and key of its kubernetes CA")
+	}
+	if c.needsEtcdProxy() {
+		return errors.New("chaos policies affecting etcd can't be used when joining a cluster")
+	}
+	if c.snapshotOnExit || c.restoreFrom != "" {
+		return errors.New("etcd snapshots can't be taken or restored when joining a cluster")
+	}
+	if c.readOnlyKubeconfig {
+		return errors.New("extra kubeconfigs are created by the cluster to join")
+	}
+	c.joinHost = host
+	c.joinPort = port
+	return nil
+}
+
+// isWorker returns whether this is a worker node joining another cluster, see setJoinAPIServer
+func (c *Cluster) isWorker() bool {
+	return c.joinHost != ""
+}
+
+// Create directories and copy CNI plugins if appropriate
+func (c *Cluster) createDirectories() error {
+	cmd.EnsureDir(c.baseDir, "", 0770)
+	cmd.EnsureDir(c.baseDir, "kube", 0770)
+	cmd.EnsureDir(c.baseDir, "etcdtls", 0770)
+	cmd.EnsureDir(c.baseDir, "kubesched", 0770)
+	cmd.EnsureDir(c.baseDir, "kubetls", 0770)
+	cmd.EnsureDir(c.baseDir, "kubectls", 0770)
+	cmd.EnsureDir(c.baseDir, "kubestls", 0770)
+	cmd.EnsureDir(c.baseDir, "etcddata", 0770)
+	if c.rawLogs || c.enableAudit {
+		cmd.EnsureDir(c.baseDir, "logs", 0770)
+	}
+	if c.etcdTmpfs && !c.dryRun && !c.isWorker() {
+		c.setupEtcdTmpfs()
+	}
+
+	// Special case: in case the extra binaries directory contains CNI plugins, copy them to the right location
+	cmd.EnsureDir(c.baseDir, path.Join("kube", "kubelet"), 0755)
+	cmd.EnsureDir(c.baseDir, path.Join("kube", "kubelet", "cni"), 0755)
+	for _, plugin := range c.cniPlugins {
+		pluginPath, err := helpers.FindBinary(plugin, c.baseDir, c.extraBinDir)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"plugin":    plugin,
+				"app":       "microkube",
+				"component": "prep",
+			}).Warn("CNI plugin not found, skipping it")
+			continue
+		}
+		destPath := path.Join(c.baseDir, "kube", "kubelet", "cni", plugin)
+		_, err = os.Stat(destPath)
+		if err != nil {
+			err = cmd.LinkOrCopyExecutable(pluginPath, destPath)
+			if err != nil {
+				return errors.Wrapf(err, "couldn't install CNI plugin %s to %s", pluginPath, destPath)
+			}
+		}
+	}
+	return nil
+}
+
+// Unmount anything a previous kubelet left behind in its root directory and, if requested, remove its pod state
+func (c *Cluster) cleanupKubeletDir() error {
+	kubeletDir := path.Join(c.baseDir, "kube", "kubelet")
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "prep",
+		"dir":       kubeletDir,
+	})
+	if c.baseExecEnv.Rootless {
+		// Mounts of a rootless kubelet vanish along with it's mount namespace, and it's state belongs to the current
+		// user
+		if c.fresh {
+			logCtx.Info("Removing pod state of previous runs")
+			err := os.RemoveAll(path.Join(kubeletDir, "pods"))
+			if err == nil {
+				err = os.RemoveAll(path.Join(kubeletDir, "cpu_manager_state"))
+			}
+			if err != nil {
+				return errors.Wrap(err, "couldn't remove pod state")
+			}
+		}
+		return nil
+	}
+	err := cmd.CleanupStaleMounts(kubeletDir, c.baseExecEnv.SudoMethod)
+	if err != nil {
+		if c.fresh {
+			// Removing the directory while something is still mounted below it would delete the mount's contents
+			return errors.Wrap(err, "couldn't unmount stale mounts, refusing to remove pod state")
+		}
+		logCtx.WithError(err).Warn("Couldn't clean up stale mounts, kubelet might fail to start")
+		return nil
+	}
+	if c.fresh {
+		logCtx.Info("Removing pod state of previous runs")
+		// The kubelet runs as root, therefore its state is owned by root as well
+		// The CPU manager checkpoint is removed as well, the kubelet refuses to start if it was written by another
+		// CPU manager policy
+		output, err := exec.Command(c.baseExecEnv.SudoMethod, "rm", "-rf", path.Join(kubeletDir, "pods"),
+			path.Join(kubeletDir, "cpu_manager_state")).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "couldn't remove pod state: %s", strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// Mount a tmpfs for etcd's data directory, falling back to the on-disk directory if this fails
+func (c *Cluster) setupEtcdTmpfs() {
+	etcdDir := path.Join(c.baseDir, "etcddata")
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "prep",
+		"dir":       etcdDir,
+	})
+	var err error
+	c.etcdTmpfsMounted, err = cmd.EnsureTmpfs(etcdDir, c.baseExecEnv.SudoMethod)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't mount tmpfs for etcd, using disk instead")
+		return
+	}
+	logCtx.Info("etcd data is kept in memory and will be lost on exit")
+}
+
+// Unmount etcd's tmpfs if we mounted it
+func (c *Cluster) cleanupEtcdTmpfs() {
+	if c.etcdTmpfsMounted {
+		cmd.Unmount(path.Join(c.baseDir, "etcddata"), c.baseExecEnv.SudoMethod)
+		c.etcdTmpfsMounted = false
+	}
+}
+
+// Generate the egress selector config for the konnectivity addon, unless the user supplied one
+func (c *Cluster) setupEgressSelector() error {
+	if !c.enableKonnectivity || c.baseExecEnv.KubeAPIEgressSelectorConfig != "" {
+		return nil
+	}
+	config := path.Join(c.baseDir, "kube", "egress-selector.yaml")
+	err := kube.CreateKonnectivityEgressConfig(config, c.cred, c.baseExecEnv, c.kubeVersion)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create egress selector config")
+	}
+	c.baseExecEnv.KubeAPIEgressSelectorConfig = config
+	return nil
+}
+
+// Set up the apiserver's audit log, generating the default policy unless the user supplied one
+func (c *Cluster) setupAudit() error {
+	if !c.enableAudit {
+		return nil
+	}
+	if c.baseExecEnv.KubeAPIAuditLog == "" {
+		c.baseExecEnv.KubeAPIAuditLog = path.Join(c.baseDir, "logs", "audit.log")
+	}
+	if c.baseExecEnv.KubeAPIAuditPolicy == "" {
+		policy := path.Join(c.baseDir, "kube", "audit-policy.yaml")
+		err := kube.CreateDefaultAuditPolicy(policy)
+		if err != nil {
+			return errors.Wrap(err, "couldn't create audit policy")
+		}
+		c.baseExecEnv.KubeAPIAuditPolicy = policy
+	}
+	return nil
+}
+
+// Find binaries
+func (c *Cluster) findBinaries() error {
+	var err error
+	if !c.isWorker() {
+		c.etcdBin, err = c.findBinary("etcd", c.etcdBinOverride)
+		if err != nil {
+			return err
+		}
+		_, err = c.checkBinaryVersion("etcd", c.etcdBin, SupportedEtcdVersions)
+		if err != nil {
+			return err
+		}
+	}
+	if c.snapshotOnExit || c.restoreFrom != "" {
+		c.etcdctlBin, err = helpers.FindBinary("etcdctl", c.baseDir, c.extraBinDir)
+		if err != nil {
+			return errors.Wrap(err, "couldn't find etcdctl binary, which is required for snapshots")
+		}
+	}
+	err = c.findKubeBinaries()
+	if err != nil {
+		return err
+	}
+	if c.baseExecEnv.Rootless && !c.noKubelet {
+		c.baseExecEnv.RootlessKitBinary, err = helpers.FindBinary("rootlesskit", c.baseDir, c.extraBinDir)
+		if err != nil {
+			return errors.Wrap(err, "couldn't find rootlesskit binary, which is required for rootless mode")
+		}
+	}
+	return nil
+}
+
+// findBinary returns 'override' if it is set and executable, otherwise it searches binary 'name'
+func (c *Cluster) findBinary(name, override string) (string, error) {
+	if override != "" {
+		err := helpers.CheckExecutable(override)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid %s binary", name)
+		}
+		return override, nil
+	}
+	binary, err := helpers.FindBinary(name, c.baseDir, c.extraBinDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't find %s binary", name)
+	}
+	return binary, nil
+}
+
+// Start etcd
+func (c *Cluster) startEtcd(ctx context.Context) error {
+	etcdHandler, etcdChan, err := c.startService(ctx, "etcd", func(etcdOutputHandler handlers.OutputHandler,
+		etcdExitHandler handlers.ExitHandler) (handlers.ServiceHandler, error) {
+
+		execEnv := handlers.ExecutionEnvironment{
+			Binary:        c.etcdBin,
+			ExitHandler:   etcdExitHandler,
+			OutputHandler: etcdOutputHandler,
+			Workdir:       path.Join(c.baseDir, "etcddata"),
+			RunAsUser:     c.runAsUsers["etcd"],
+		}
+		execEnv.CopyInformationFromBase(&c.baseExecEnv)
+		handler := etcd.NewEtcdHandler(execEnv, c.cred)
+		handler.SetEtcdctl(c.etcdctlBin)
+		if c.restoreFrom != "" {
+			err := c.restoreEtcd(handler, execEnv.Workdir)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return handler, nil
+	}, "etcd", log2.ETCDLogParserName)
+	if err != nil {
+		return err
+	}
+	log.Info("ETCD ready")
+
+	c.registerService(serviceEntry{
+		handler:  etcdHandler,
+		exitChan: etcdChan,
+		name:     "etcd",
+	})
+	return nil
+}
+
+// Start Kube APIServer
+func (c *Cluster) startKubeAPIServer(ctx context.Context) error {
+	log.Info("Starting kube api server...")
+	kubeAPIHandler, kubeAPIChan, err := c.startService(ctx, "kube-apiserver",
+		func(kubeAPIOutputHandler handlers.OutputHandler,
+			kubeAPIExitHandler handlers.ExitHandler) (handlers.ServiceHandler, error) {
+
+			execEnv := handlers.ExecutionEnvironment{
+				ExitHandler:   kubeAPIExitHandler,
+				OutputHandler: kubeAPIOutputHandler,
+				RunAsUser:     c.runAsUsers["kube-apiserver"],
+			}
+			execEnv.CopyInformationFromBase(&c.baseExecEnv)
+			c.setKubeBinary(&execEnv, "kube-apiserver")
+			return kube.NewKubeAPIServerHandler(execEnv, c.cred, c.serviceRangeNet.String(), c.oidc)
+		}, "kube-api", log2.KubeLogParserName)
+	if err != nil {
+		return err
+	}
+	log.Info("Kube api server ready")
+
+	apiHost := c.baseExecEnv.ConnectAddress(c.baseExecEnv.KubeAPIBindAddress).String()
+	err = c.setupKubeconfig(apiHost, c.baseExecEnv.KubeApiPort)
+	if err != nil {
+		return err
+	}
+	err = c.setupExtraKubeconfigs(apiHost, c.baseExecEnv.KubeApiPort)
+	if err != nil {
+		return err
+	}
+
+	c.registerService(serviceEntry{
+		handler:  kubeAPIHandler,
+		exitChan: kubeAPIChan,
+		name:     "kube-api",
+	})
+	return nil
+}
+
+// Generate the kubeconfig for kubelet, kube-proxy and kubectl, connecting to the apiserver at 'host':'port'
+func (c *Cluster) setupKubeconfig(host string, port int) error {
+	log.Info("Generating kubeconfig...")
+	kubeconfig := path.Join(c.baseDir, "kube/", "kubeconfig")
+	execEnv := c.baseExecEnv
+	execEnv.KubeApiPort = port
+	_, err := os.Stat(kubeconfig)
+	create := err != nil
+	if !create {
+		// Certificates might have been regenerated and the apiserver might have moved since the kubeconfig was created
+		matches, err := kube.ClientKubeconfigMatches(c.cred, kubeconfig, "https://"+host+":"+strconv.Itoa(port))
+		if !matches {
+			log.WithError(err).WithField("kubeconfig", kubeconfig).Info("Kubeconfig doesn't match current " +
+				"certificates or apiserver address, recreating it")
+			create = true
+		}
+	}
+	if create {
+		log.Debug("Creating kubeconfig")
+		err = kube.CreateClientKubeconfig(execEnv, c.cred, kubeconfig, host)
+		if err != nil {
+			return errors.Wrap(err, "couldn't create kubeconfig")
+		}
+	}
+	c.cred.Kubeconfig = kubeconfig
+	return nil
+}
+
+// Start controller-manager
+func (c *Cluster) startKubeControllerManager(ctx context.Context) error {
+	log.Info("Starting controller-manager...")
+	kubeCtrlMgrHandler, kubeCtrlMgrChan, err := c.startService(ctx, "kube-controller-manager",
+		func(kubeCtrlMgrOutputHandler handlers.OutputHandler,
+			kubeCtrlMgrExitHandler handlers.ExitHandler) (handlers.ServiceHandler, error) {
+
+			execEnv := handlers.ExecutionEnvironment{
+				ExitHandler:   kubeCtrlMgrExitHandler,
+				OutputHandler: kubeCtrlMgrOutputHandler,
+				RunAsUser:     c.runAsUsers["kube-controller-manager"],
+			}
+			execEnv.CopyInformationFromBase(&c.baseExecEnv)
+			c.setKubeBinary(&execEnv, "kube-controller-manager")
+			return kube.NewControllerManagerHandler(execEnv, c.cred, c.podRangeNet.String()), nil
+		}, "kube-controller-manager", log2.KubeLogParserName)
+	if err != nil {
+		return err
+	}
+	log.Info("Kube controller-manager ready")
+
+	c.registerService(serviceEntry{
+		handler:  kubeCtrlMgrHandler,
+		exitChan: kubeCtrlMgrChan,
+		name:     "kube-controller-manager",
+	})
+	return nil
+}
+
+// Start scheduler
+func (c *Cluster) startKubeScheduler(ctx context.Context) error {
+	log.Info("Starting kube-scheduler...")
+	kubeSchedHandler, kubeSchedChan, err := c.startService(ctx, "kube-scheduler",
+		func(kubeSchedOutputHandler handlers.OutputHandler,
+			kubeSchedExitHandler handlers.ExitHandler) (handlers.ServiceHandler, error) {
+
+			execEnv := handlers.ExecutionEnvironment{
+				Workdir:           path.Join(c.baseDir, "kubesched"),
+				ExitHandler:       kubeSchedExitHandler,
+				OutputHandler:     kubeSchedOutputHandler,
+				RunAsUser:         c.runAsUsers["kube-scheduler"],
+				SecureHealthProbe: c.secureHealthProbes["kube-scheduler"],
+			}
+			execEnv.CopyInformationFromBase(&c.baseExecEnv)
+			c.setKubeBinary(&execEnv, "kube-scheduler")
+			return kube.NewKubeSchedulerHandler(execEnv, c.cred)
+		}, "kube-scheduler", log2.KubeLogParserName)
+	if err != nil {
+		return err
+	}
+	log.Info("Kube-scheduler ready")
+
+	c.registerService(serviceEntry{
+		handler:  kubeSchedHandler,
+		exitChan: kubeSchedChan,
+		name:     "kube-scheduler",
+	})
+	return nil
+}
+
+// Start kubelet
+func (c *Cluster) startKubelet(ctx context.Context) error {
+	log.Info("Starting kubelet...")
+	kubeletHandler, kubeletChan, err := c.startService(ctx, "kubelet",
+		func(kubeletOutputHandler handlers.OutputHandler,
+			kubeletExitHandler handlers.ExitHandler) (handlers.ServiceHandler, error) {
+
+			execEnv := handlers.ExecutionEnvironment{
+				Workdir:       path.Join(c.baseDir, "kube"),
+				ExitHandler:   kubeletExitHandler,
+				OutputHandler: kubeletOutputHandler,
+			}
+			execEnv.CopyInformationFromBase(&c.baseExecEnv)
+			c.setKubeBinary(&execEnv, "kubelet")
+			return kube.NewKubeletHandler(execEnv, c.cred)
+		}, "kubelet", log2.KubeLogParserName)
+	if err != nil {
+		return err
+	}
+	log.Info("Kubelet ready")
+
+	c.registerService(serviceEntry{
+		handler:  kubeletHandler,
+		exitChan: kubeletChan,
+		name:     "kubelet",
+	})
+	return nil
+}
+
+// Start kube-proxy
+func (c *Cluster) startKubeProxy(ctx context.Context) error {
+	log.Info("Starting kube-proxy...")
+	kubeProxyHandler, kubeProxyChan, err := c.startService(ctx, "kube-proxy",
+		func(output handlers.OutputHandler, exit handlers.ExitHandler) (handlers.ServiceHandler, error) {
+
+			execEnv := handlers.ExecutionEnvironment{
+				Workdir:       path.Join(c.baseDir, "kube"),
+				ExitHandler:   exit,
+				OutputHandler: output,
+			}
+			execEnv.CopyInformationFromBase(&c.baseExecEnv)
+			c.setKubeBinary(&execEnv, "kube-proxy")
+			return kube.NewKubeProxyHandler(execEnv, c.cred, c.proxyClusterCIDR.String())
+		}, "kube-proxy", log2.KubeProxyLogParserName)
+	if err != nil {
+		return err
+	}
+	log.Info("kube-proxy ready")
+
+	c.registerService(serviceEntry{
+		handler:  kubeProxyHandler,
+		exitChan: kubeProxyChan,
+		name:     "kube-proxy",
+	})
+	return nil
+}
+
+// registerService adds a started service to the list of services whose health is monitored. This is safe to call from
+// multiple goroutines.
+func (c *Cluster) registerService(entry serviceEntry) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	c.serviceList = append(c.serviceList, entry)
+	// Services are only registered once they are healthy
+	if c.serviceHealth == nil {
+		c.serviceHealth = make(map[string]handlers.HealthMessage)
+	}
+	c.serviceHealth[entry.name] = handlers.HealthMessage{IsHealthy: true}
+}
+
+// addServiceHandler adds the handler of service 'name' to the list of handlers to stop on exit. This is safe to call
+// from multiple goroutines.
+func (c *Cluster) addServiceHandler(name string, handler handlers.ServiceHandler) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	c.serviceHandlers = append(c.serviceHandlers, serviceEntry{handler: handler, name: name})
+}
+
+// services returns a snapshot of all registered services
+func (c *Cluster) services() []serviceEntry {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	return append([]serviceEntry{}, c.serviceList...)
+}
+
+// setServiceHealth records the result of the last health check of service 'name'
+func (c *Cluster) setServiceHealth(name string, msg handlers.HealthMessage) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	c.serviceHealth[name] = msg
+}
+
+// serviceHealthMessage returns the result of the last health check of service 'name'
+func (c *Cluster) serviceHealthMessage(name string) handlers.HealthMessage {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	return c.serviceHealth[name]
+}
+
+// isServiceHealthy returns whether the last health check of service 'name' succeeded
+func (c *Cluster) isServiceHealthy(name string) bool {
+	return c.serviceHealthMessage(name).IsHealthy
+}
+
+// setGracefulTerminationMode sets whether we're in graceful termination mode, see gracefulTerminationMode
+func (c *Cluster) setGracefulTerminationMode(enabled bool) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	c.gracefulTerminationMode = enabled
+}
+
+// isGracefulTerminationMode returns whether we're in graceful termination mode, see gracefulTerminationMode
+func (c *Cluster) isGracefulTerminationMode() bool {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	return c.gracefulTerminationMode
+}
+
+// handleHealthResult handles the result of a health probe of 'service'. 'unhealthyCounts' contains the number of
+// consecutive failed probes of each service. Once it reaches healthFailureThreshold, the cluster fails.
+func (c *Cluster) handleHealthResult(service serviceEntry, msg handlers.HealthMessage,
+	unhealthyCounts map[string]int) {
+	// Exits are checked along with the health, which also keeps exit handlers from blocking on a full channel
+	select {
+	case <-service.exitChan:
+		if !c.isGracefulTerminationMode() {
+			c.fail(errors.Errorf("service %s exitted", service.name))
+		}
+	default:
+	}
+	c.setServiceHealth(service.name, msg)
+	c.healthReporter.Report(service.name, msg)
+	c.recordHealthCheck(service.name, msg.IsHealthy)
+	if !msg.IsHealthy && msg.Probe == handlers.HealthProbeReadiness {
+		// The process is alive, it's most likely waiting for a dependency and recovers on it's own
+		log.WithField("app", service.name).WithError(msg.Error).Warn("not ready")
+		unhealthyCounts[service.name] = 0
+	} else if !msg.IsHealthy {
+		log.WithFields(log.Fields{
+			"app":   service.name,
+			"count": unhealthyCounts[service.name],
+		}).WithError(msg.Error).Warn("unhealthy!")
+		unhealthyCounts[service.name]++
+		if c.healthFailureThreshold > 0 && unhealthyCounts[service.name] == c.healthFailureThreshold {
+			c.fail(errors.Errorf("service %s failed %d health checks in a row", service.name,
+				unhealthyCounts[service.name]))
+		}
+	} else {
+		log.WithField("app", service.name).Debug("healthy")
+		unhealthyCounts[service.name] = 0
+	}
+}
+
+// fail reports that the cluster failed after startup, see Failed. Only the first failure is reported.
+func (c *Cluster) fail(err error) {
+	select {
+	case c.failed <- err:
+	default:
+	}
+}
+
+// Failed returns a channel that receives an error if the cluster fails after Start returned, that is, a service exits
+// unexpectedly or fails too many health checks in a row. The cluster keeps running until Stop is called.
+func (c *Cluster) Failed() <-chan error {
+	return c.failed
+}
+
+// Start periodic health checks of all services. Probes of all services share a single ticker and at most
+// healthCheckWorkers probes run at the same time.
+func (c *Cluster) enableHealthChecks() {
+	services := make(map[string]serviceEntry)
+	unhealthyCounts := make(map[string]int)
+	c.healthScheduler = handlers.NewHealthScheduler(handlers.DefaultHealthCheckInterval, c.healthCheckWorkers,
+		func(name string, msg handlers.HealthMessage) {
+			c.handleHealthResult(services[name], msg, unhealthyCounts)
+		})
+	for _, service := range c.services() {
+		log.WithField("app", service.name).Debug("Enabling health check...")
+		services[service.name] = service
+		c.healthScheduler.Add(service.name, service.handler)
+	}
+	c.healthScheduler.Start()
+
+	// Expired certificates make health checks fail with TLS errors that don't point at the actual problem
+	c.checkCertExpiry()
+	go func() {
+		ticker := time.NewTicker(certExpiryCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopping:
+				return
+			case <-ticker.C:
+				c.checkCertExpiry()
+			}
+		}
+	}()
+}
+
+// checkCertExpiry warns about certificates that expire within certExpiryWarning
+func (c *Cluster) checkCertExpiry() {
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "pki",
+	})
+	expiring, err := c.cred.CheckExpiry(certExpiryWarning)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't check certificate expiry")
+	}
+	if len(expiring) > 0 {
+		logCtx.WithField("certs", strings.Join(expiring, ", ")).Warn("Certificates expire within a week! Remove " +
+			"the TLS directories (etcdtls, kubetls, kubectls, kubestls) in the root directory and restart microkube " +
+			"to regenerate them")
+	}
+}
+
+// Periodically log CPU and memory usage of all services backed by a local process until the cluster is stopped
+func (c *Cluster) reportResourceUsage() {
+	lastCPUTime := make(map[string]time.Duration)
+	ticker := time.NewTicker(c.resourceReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopping:
+			return
+		case <-ticker.C:
+		}
+		for _, service := range c.services() {
+			logCtx := log.WithFields(log.Fields{
+				"app":       "microkube",
+				"component": "resources",
+				"service":   service.name,
+			})
+			processHandler, ok := service.handler.(handlers.ProcessHandler)
+			if !ok || processHandler.Pid() == 0 {
+				continue
+			}
+			stats, err := helpers.ReadProcessTreeStats(processHandler.Pid())
+			if err != nil {
+				logCtx.WithError(err).Debug("Couldn't read resource usage")
+				continue
+			}
+			// The first sample only establishes a baseline
+			if last, ok := lastCPUTime[service.name]; ok && stats.CPUTime >= last {
+				logCtx.WithFields(log.Fields{
+					"cpuPercent": fmt.Sprintf("%.1f", 100*float64(stats.CPUTime-last)/float64(c.resourceReportInterval)),
+					"rssMiB":     stats.RSS / (1024 * 1024),
+				}).Info("Resource usage")
+			}
+			lastCPUTime[service.name] = stats.CPUTime
+		}
+		c.reportNodeUsage()
+	}
+}
+
+// Log the node's resource usage as seen by the cluster. This requires metrics-server, so failures are only logged in
+// debug mode.
+func (c *Cluster) reportNodeUsage() {
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "resources",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	usage, err := c.kCl.TopNode(ctx)
+	if err != nil {
+		logCtx.WithError(err).Debug("Couldn't read node resource usage")
+		return
+	}
+	logCtx.WithFields(log.Fields{
+		"node":      usage.Name,
+		"cpuMillis": usage.CPU.MilliValue(),
+		"memoryMiB": usage.Memory.Value() / (1024 * 1024),
+	}).Info("Node resource usage")
+}
+
+// Wait until node is ready or 'ctx' is cancelled
+func (c *Cluster) waitUntilNodeReady(ctx context.Context) error {
+	var err error
+	c.kCl, err = kube2.NewKubeClientWithRetry(ctx, path.Join(c.baseDir, "kube/", "kubeconfig"))
+	if err != nil {
+		return errors.Wrap(err, "couldn't init kube client")
+	}
+	// Worker nodes might join the cluster, so only look at our own node
+	nodeName, err := kube2.LocalNodeName()
+	if err != nil {
+		return err
+	}
+	c.kCl.SetNode(nodeName)
+	return c.waitForNode(ctx)
+}
+
+// waitUntilAPIServerReady waits until the apiserver accepts requests or 'ctx' is cancelled. This replaces
+// waitUntilNodeReady if there is no kubelet.
+func (c *Cluster) waitUntilAPIServerReady(ctx context.Context) error {
+	var err error
+	c.kCl, err = kube2.NewKubeClientWithRetry(ctx, path.Join(c.baseDir, "kube/", "kubeconfig"))
+	if err != nil {
+		return errors.Wrap(err, "couldn't init kube client")
+	}
+	c.setGracefulTerminationMode(true)
+	return nil
+}
+
+// waitForNode waits until the node is ready, see waitUntilNodeReady. If 'ctx' is cancelled before, the node isn't
+// drained when stopping, as it might not even be registered.
+func (c *Cluster) waitForNode(ctx context.Context) error {
+	log.Info("Waiting for node...")
+	c.progress.Emit("node", helpers.ProgressStarting, nil)
+	err := c.kCl.WaitForNode(ctx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.progress.Emit("node", helpers.ProgressFailed, err)
+		return errors.Wrap(err, "node didn't become ready")
+	}
+	c.serviceLock.Lock()
+	c.nodeReady = true
+	c.serviceLock.Unlock()
+
+	checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err = c.kCl.CheckAPIServerEndpoints(checkCtx)
+	cancel()
+	if err != nil {
+		c.progress.Emit("node", helpers.ProgressFailed, err)
+		return errors.Wrap(err, "in-cluster clients can't reach the apiserver through the 'kubernetes' service")
+	}
+	c.progress.Emit("node", helpers.ProgressReady, nil)
+	c.setGracefulTerminationMode(true)
+	return nil
+}
+
+// drainContext returns the context to drain the node in, limited by the drain timeout. If the kubelet's graceful node
+// shutdown is enabled, draining is also limited to the time the kubelet grants regular pods, so that a drain during a
+// system shutdown doesn't eat into the time reserved for critical pods. If all of it is reserved for critical pods,
+// only the drain timeout applies.
+func (c *Cluster) drainContext() (context.Context, context.CancelFunc) {
+	timeout := c.drainTimeout
+	gracePeriod := c.baseExecEnv.KubeletShutdownGracePeriod - c.baseExecEnv.KubeletShutdownGracePeriodCriticalPods
+	if gracePeriod > 0 && (timeout <= 0 || gracePeriod < timeout) {
+		timeout = gracePeriod
+	}
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// setupDefaultNamespace creates the default namespace and applies the default quota and limit range to it
+func (c *Cluster) setupDefaultNamespace() {
+	if c.defaultNamespace == "" {
+		return
+	}
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "services",
+		"namespace": c.defaultNamespace,
+	})
+	err := c.kCl.EnsureNamespace(c.defaultNamespace)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't create default namespace")
+		return
+	}
+	if c.defaultQuota != nil {
+		err = c.kCl.ApplyResourceQuota(c.defaultNamespace, c.defaultQuota)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't apply default quota")
+		}
+	}
+	if c.defaultLimitRange != nil {
+		err = c.kCl.ApplyLimitRange(c.defaultNamespace, c.defaultLimitRange)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't apply default limit range")
+		}
+	}
+}
+
+// manifestRuntimeInfo returns the information about the cluster manifests are adjusted to
+func (c *Cluster) manifestRuntimeInfo() manifests.KubeManifestRuntimeInfo {
+	nodeCount, err := c.kCl.NodeCount()
+	if err != nil {
+		log.WithError(err).Warn("Couldn't count nodes, assuming a single node")
+		nodeCount = 1
+	}
+	return manifests.KubeManifestRuntimeInfo{
+		ExecEnv:      c.baseExecEnv,
+		PodRange:     c.podRangeNet,
+		ServiceRange: c.serviceRangeNet,
+		BaseDir:      c.baseDir,
+		NodeCount:    nodeCount,
+		Replicas:     int32(c.dnsReplicas),
+		Credentials:  c.cred,
+	}
+}
+
+// startServices deploys certain manifests into the cluster and waits until the services of the addons have ready
+// endpoints, at most for addonEndpointTimeout or until 'ctx' expires
+func (c *Cluster) startServices(ctx context.Context) {
+	if c.noKubelet {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "services",
+		}).Info("No kubelet to run pods, skipping cluster addons")
+		return
+	}
+	// Label selector of the pods of each addon in kube-system whose logs to show once it becomes unhealthy, and the
+	// service in kube-system to wait for, both empty if none
+	type addon struct {
+		construct manifests.KubeManifestConstructor
+		// Name of the manifest, for logging before it was constructed
+		name        string
+		podSelector string
+		service     string
+	}
+	services := []addon{}
+	if c.enableKubeDash {
+		services = append(services, addon{construct: manifests.NewKubeDash, name: "KubeDash",
+			service: "kubernetes-dashboard"})
+	}
+	if c.enableDns {
+		services = append(services, addon{construct: manifests.NewDNS, name: "DNS", podSelector: dnsPodSelector,
+			service: "kube-dns"})
+	}
+	if c.enableKonnectivity {
+		services = append(services, addon{construct: manifests.NewKonnectivity, name: "Konnectivity"})
+	}
+	if c.enableMetricsServer {
+		services = append(services, addon{construct: manifests.NewMetricsServer, name: "MetricsServer"})
+	}
+	kmri := c.manifestRuntimeInfo()
+
+	var waitFor []string
+	for _, service := range services {
+		logCtx := log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "services",
+			"service":   service.name,
+		})
+		// Constructors return no manifest on errors
+		manifest, err := service.construct(kmri)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't init service!")
+			continue
+		}
+
+		if c.validateManifests {
+			err = manifest.Validate(c.cred.Kubeconfig)
+			if err != nil {
+				logCtx.WithError(err).Warn("Service manifest is invalid, not applying it!")
+				continue
+			}
+		}
+		c.recordManifest(manifest)
+		err = manifest.ApplyToCluster(c.cred.Kubeconfig)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't apply service to cluster!")
+			continue
+		}
+		if service.service != "" {
+			waitFor = append(waitFor, service.service)
+		}
+		err = manifest.InitHealthCheck(c.cred.Kubeconfig)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't initialize health check!")
+			continue
+		}
+
+		if !c.monitorManifest(manifest.Name()) {
+			// Re-applied by Reload, the health check started before is still running
+			continue
+		}
+		go func(podSelector string) {
+			// Delay first report since the service needs some time to start
+			delay := 30 * time.Second
+			healthy := true
+			for {
+				select {
+				case <-c.stopping:
+					return
+				case <-time.After(delay):
+				}
+				ok, err := manifest.IsHealthy()
+				if !ok {
+					logCtx.WithError(err).Warn("Service is unhealthy!")
+					if healthy && podSelector != "" {
+						// Only once per failure, the logs don't change much while it lasts
+						c.logAddonPods(logCtx, podSelector)
+					}
+				} else {
+					logCtx.Debug("Service is healthy")
+				}
+				healthy = ok
+				delay = 10 * time.Second
+			}
+		}(service.podSelector)
+	}
+	c.waitForAddonEndpoints(ctx, waitFor)
+}
+
+// waitForAddonEndpoints waits until all 'services' in kube-system have ready endpoints, so that they are reachable once
+// the info message advertises them. Services that don't get ready within addonEndpointTimeout are logged.
+func (c *Cluster) waitForAddonEndpoints(ctx context.Context, services []string) {
+	ctx, cancel := context.WithTimeout(ctx, addonEndpointTimeout)
+	defer cancel()
+	for _, service := range services {
+		err := c.kCl.WaitForServiceEndpoints(ctx, "kube-system", service)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"app":       "microkube",
+				"component": "services",
+				"service":   service,
+			}).WithError(err).Warn("Addon isn't reachable yet")
+		}
+	}
+}
+
+func printIndented(message string) {
+	msg := ""
+	if message == "" {
+		msg = strings.Repeat("#", 120)
+	} else {
+		pad := (120 - len(message) - 2) / 2
+		msg = msg + strings.Repeat("#", pad)
+		msg = msg + " " + message + " "
+		msg = msg + strings.Repeat("#", pad)
+	}
+	log.Info(msg)
+}
+
+func (c *Cluster) PrintInfoMessage() {
+	printIndented("")
+	printIndented("Microkube is up!")
+	printIndented("")
+	printIndented("Information")
+	log.Info("# To access the cluster, use the kubeconfig at '" + c.cred.Kubeconfig + "'")
+	log.Info("# Example:")
+	log.Info("# kubectl --kubeconfig " + c.cred.Kubeconfig + " get service --all-namespaces")
+	if c.readOnlyKubeconfig {
+		log.Info("# To share read-only access, hand out the kubeconfig at '" +
+			c.extraKubeconfigPath(ExtraKubeconfigReadOnly) + "'")
+	}
+	if c.statusListen != "" {
+		log.Info("# Status page at http://" + c.statusListen)
+	}
+	if c.noKubelet {
+		log.Info("# There is no kubelet, pods won't be scheduled and no cluster addons are available")
+		printIndented("")
+		return
+	}
+	if c.isWorker() {
+		log.Info("# This node joined the cluster at " + net.JoinHostPort(c.joinHost, strconv.Itoa(c.joinPort)) +
+			", cluster addons are managed there")
+		printIndented("")
+		return
+	}
+	log.Info("# The following 'Cluster Addons' are available:")
+
+	if c.enableKubeDash {
+		ip, port := c.kCl.FindService("kubernetes-dashboard")
+		secret, err := c.kCl.FindServiceAccountToken(c.dashboardNamespace, c.dashboardServiceAccount)
+		if err != nil {
+			log.WithError(err).Warn("Couldn't find token to sign in to the dashboard")
+		}
+		if ip != "" && port == 443 && secret != "" {
+			log.Info("# Kubernetes Dashboard at https://" + ip)
+			log.Info("# Sign in with Token: " + secret)
+			log.Info("# You might need to remove the line breaks first, depending on your terminal emulator :/")
+		}
+	}
+	if c.enableDns {
+		ip, port := c.kCl.FindService("kube-dns")
+		if ip != "" && port == 53 {
+			log.Info("# Core DNS at " + ip + "")
+		}
+	}
+	printIndented("")
+}
+
+// Start starts all services, waits until the node is ready and deploys the cluster addons. Cancelling 'ctx' aborts
+// startup, as does a service exitting before startup is complete. If Start fails, everything that was started so far
+// is stopped again.
+func (c *Cluster) Start(ctx context.Context) (err error) {
+	c.setGracefulTerminationMode(false)
+	ctx, cancel := c.startupContext(ctx)
+	defer cancel()
+	defer func() {
+		if err != nil {
+			// Report why startup was aborted instead of the cancellation it caused
+			err = c.startupError(err)
+			log.WithError(err).Warn("Startup failed, stopping services that were started already")
+			c.Stop()
+		}
+	}()
+
+	err = c.start(ctx)
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		return nil
+	}
+
+	if c.noKubelet {
+		err = c.waitUntilAPIServerReady(ctx)
+	} else {
+		err = c.waitUntilNodeReady(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	c.enableHealthChecks()
+	err = c.startStatusSocket()
+	if err != nil {
+		log.WithError(err).Warn("Couldn't serve service status, 'microkubed status' won't work")
+	}
+	if c.statusListen != "" {
+		err = c.startStatusUI()
+		if err != nil {
+			return errors.Wrap(err, "couldn't start status page")
+		}
+	}
+	if c.resourceReportInterval > 0 {
+		go c.reportResourceUsage()
+	}
+	// All good. Launch stuff
+	if !c.isWorker() {
+		// A worker node leaves namespaces and addons to the control plane
+		c.applyReadOnlyRBAC()
+		c.setupDefaultNamespace()
+		c.progress.Emit("addons", helpers.ProgressStarting, nil)
+		c.startServices(ctx)
+		c.applyUserManifests()
+		c.progress.Emit("addons", helpers.ProgressReady, nil)
+	}
+	// Print info message if allowed
+	c.PrintInfoMessage()
+	c.progress.Emit("cluster", helpers.ProgressReady, nil)
+	if len(c.chaosPolicies) > 0 {
+		c.stopChaos = c.startChaos()
+	}
+	return nil
+}
+
+// startupContext returns the context of the startup sequence, which is cancelled along with 'parent' or once
+// abortStartup is called
+func (c *Cluster) startupContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	c.cancelStartup = cancel
+	c.startupErr = nil
+	return ctx, cancel
+}
+
+// abortStartup cancels the startup sequence because of 'err'. Only the first error is kept.
+func (c *Cluster) abortStartup(err error) {
+	c.serviceLock.Lock()
+	if c.startupErr == nil {
+		c.startupErr = err
+	}
+	cancel := c.cancelStartup
+	c.serviceLock.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// startupError returns the error the startup sequence was aborted with, or 'err' if it wasn't aborted
+func (c *Cluster) startupError(err error) error {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	if c.startupErr != nil {
+		return c.startupErr
+	}
+	return err
+}
+
+// Stop drains the node if it became ready and stops all services. This is safe to call more than once, only the
+// first call has an effect.
+func (c *Cluster) Stop() {
+	c.stopOnce.Do(func() {
+		c.reloadLock.Lock()
+		c.stopped = true
+		c.reloadLock.Unlock()
+		// Services are about to be stopped on purpose
+		c.setGracefulTerminationMode(true)
+		if c.stopChaos != nil {
+			c.stopChaos()
+		}
+		if c.stopping != nil {
+			close(c.stopping)
+		}
+		if c.healthScheduler != nil {
+			c.healthScheduler.Stop()
+		}
+		c.stopStatusSocket()
+		c.stopStatusUI()
+		c.stopMetrics()
+		c.serviceLock.Lock()
+		drain := c.nodeReady
+		started := len(c.serviceHandlers) > 0
+		c.serviceLock.Unlock()
+		if c.snapshotOnExit && started {
+			// Before draining, so that the snapshot contains the pods
+			c.snapshotEtcd()
+		}
+		if c.teardown {
+			c.removeManifests()
+		}
+		if drain {
+			ctx, cancel := c.drainContext()
+			err := c.kCl.DrainNode(ctx)
+			cancel()
+			if err == context.DeadlineExceeded {
+				log.WithFields(log.Fields{
+					"app":       "microkube",
+					"component": "services",
+				}).Warn("Draining the node timed out, stopping anyway")
+			} else if err != nil {
+				log.WithFields(log.Fields{
+					"app":       "microkube",
+					"component": "services",
+				}).WithError(err).Warn("Couldn't drain the node, stopping anyway")
+			}
+		} else if started && !c.noKubelet {
+			log.Info("Node isn't ready, skipping drain")
+		}
+		deadline := time.Now().Add(c.shutdownTimeout)
+		c.stopServices(deadline)
+		c.progress.Close()
+		c.healthReporter.Close()
+
+		if started {
+			// If we exit before all services are down, systemd will simply kill them
+			c.waitForServicesExit(deadline)
+		}
+		c.closeEtcdProxy()
+		c.closeRecorders()
+		c.cleanupEtcdTmpfs()
+	})
+}
+
+// start starts all cluster services
+func (c *Cluster) start(ctx context.Context) error {
+	if c.dryRun {
+		// Print the command lines in a stable order
+		c.maxStartupParallelism = 1
+	} else {
+		err := c.checkPorts()
+		if err != nil {
+			return err
+		}
+		if !c.noKubelet {
+			err = c.cleanupKubeletDir()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := c.createDirectories()
+	if err != nil {
+		return err
+	}
+	c.cred = &pki.MicrokubeCredentials{
+		ClockSkewTolerance: c.clockSkewTolerance,
+		EtcdSANs:           c.etcdSANs,
+		KubeSANs:           c.serverSANs(),
+		ExternalKubeCACert: c.externalKubeCACert,
+		ExternalKubeCAKey:  c.externalKubeCAKey,
+		KeyAlgorithm:       c.keyAlgorithm,
+		CertValidity:       c.certValidity,
+		ReadOnlyClient:     c.readOnlyKubeconfig,
+		MetricsServer:      c.enableMetricsServer,
+	}
+	c.progress.Emit("certificates", helpers.ProgressStarting, nil)
+	err = c.cred.CreateOrLoadCertificates(c.baseDir, c.baseExecEnv.ListenAddress, c.baseExecEnv.ServiceAddress)
+	if err != nil {
+		c.progress.Emit("certificates", helpers.ProgressFailed, err)
+		return errors.Wrap(err, "couldn't init credentials")
+	}
+	c.progress.Emit("certificates", helpers.ProgressReady, nil)
+	if c.caBundle != "" {
+		err = c.cred.WriteCABundle(c.caBundle)
+		if err != nil {
+			log.WithError(err).WithField("caBundle", c.caBundle).Warn("Couldn't write CA bundle")
+		}
+	}
+
+	err = c.findBinaries()
+	if err != nil {
+		return err
+	}
+
+	// All other services only need the apiserver, be it our own or the one of the cluster to join, and may therefore
+	// start in parallel
+	var services []func() error
+	if !c.noKubelet {
+		services = append(services, func() error {
+			return c.startKubelet(ctx)
+		})
+	}
+	if !c.noKubelet && !c.baseExecEnv.Rootless {
+		// kube-proxy can't set up iptables rules without root, so service IPs don't work in rootless mode
+		services = append(services, func() error {
+			return c.startKubeProxy(ctx)
+		})
+	}
+	if c.isWorker() {
+		err = c.setupKubeconfig(c.joinHost, c.joinPort)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = c.startControlPlane(ctx)
+		if err != nil {
+			return err
+		}
+		services = append([]func() error{func() error {
+			return c.startKubeControllerManager(ctx)
+		}, func() error {
+			return c.startKubeScheduler(ctx)
+		}}, services...)
+	}
+	err = helpers.RunConcurrently(c.maxStartupParallelism, services...)
+	if err != nil {
+		return errors.Wrap(err, "couldn't start all services")
+	}
+	return nil
+}
+
+// serverSANs returns the additional IPs or DNS names to include in the kubernetes serving certificate, which are the
+// ones given by the user and the addresses the apiserver and controller-manager bind to if they are reached there
+func (c *Cluster) serverSANs() []string {
+	sans := append([]string{}, c.kubeSANs...)
+	for _, override := range []net.IP{c.baseExecEnv.KubeAPIBindAddress, c.baseExecEnv.KubeControllerManagerBindAddress} {
+		if override != nil && !override.IsUnspecified() {
+			sans = append(sans, override.String())
+		}
+	}
+	return sans
+}
+
+// checkPorts makes sure that all service ports are free, moving them to the next free range if autoPortBase is set
+func (c *Cluster) checkPorts() error {
+	// InitPorts lays out all ports starting with etcd's client port
+	base := c.baseExecEnv.EtcdClientPort
+	newBase, err := cmd.FindPortBase(base, c.autoPortBase)
+	if err != nil {
+		return errors.Wrap(err, "service ports unavailable")
+	}
+	if newBase != base {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "prep",
+			"portBase":  newBase,
+		}).Warn("Service ports in use, moving them")
+		c.baseExecEnv.InitPorts(newBase)
+	}
+	return nil
+}
+
+// startControlPlane starts etcd and the apiserver
+func (c *Cluster) startControlPlane(ctx context.Context) error {
+	err := c.setupEgressSelector()
+	if err != nil {
+		return err
+	}
+	err = c.setupAudit()
+	if err != nil {
+		return err
+	}
+
+	// Everything depends on the apiserver, which in turn depends on etcd
+	err = c.startEtcd(ctx)
+	if err != nil {
+		return errors.Wrap(err, "couldn't start etcd")
+	}
+	if !c.dryRun {
+		err = c.setupEtcdProxy()
+		if err != nil {
+			return errors.Wrap(err, "couldn't set up etcd proxy")
+		}
+	}
+	err = c.startKubeAPIServer(ctx)
+	if err != nil {
+		return errors.Wrap(err, "couldn't start kube api server")
+	}
+	return nil
+}
+
+// Starts a service and waits until it is healthy or 'ctx' is cancelled. This function takes care of setting up the
+// infrastructure required by a service constructor, the output of the service is logged as application 'app' using
+// the log parser selected for it or 'defaultParser'. A service that was started is stopped on exit, even if it never
+// became healthy.
+func (c *Cluster) startService(ctx context.Context, name string, constructor serviceConstructor, app,
+	defaultParser string) (_ handlers.ServiceHandler, _ chan bool, err error) {
+
+	c.progress.Emit(name, helpers.ProgressStarting, nil)
+	defer func() {
+		if err != nil {
+			c.progress.Emit(name, helpers.ProgressFailed, err)
+		} else {
+			c.progress.Emit(name, helpers.ProgressReady, nil)
+		}
+	}()
+
+	logParser, err := c.newLogParser(name, app, defaultParser)
+	if err != nil {
+		return nil, nil, err
+	}
+	var outputHandler handlers.OutputHandler = func(output []byte) {
+		err := logParser.HandleData(output)
+		if err != nil {
+			log.WithError(err).Warn("Couldn't parse log line!")
+		}
+	}
+	if c.captureOutputDir != "" {
+		recorder, closer, err := helpers.RecordOutput(path.Join(c.captureOutputDir, name+".log"), outputHandler)
+		if err != nil {
+			log.WithError(err).WithField("app", name).Warn("Couldn't record output")
+		} else {
+			c.addRecorder(closer)
+			outputHandler = recorder
+		}
+	}
+	// A dry run mustn't rotate away the output of the last real run
+	if c.rawLogs && !c.dryRun {
+		outputHandler = c.recordRawLog(name, outputHandler)
+	}
+	stateChan := make(chan bool, 2)
+	healthChan := make(chan handlers.HealthMessage, 2)
+	// Set before the service is started, so that the exit handler can use it
+	var serviceHandler handlers.ServiceHandler
+	exitHandler := func(success bool, exitError *exec.ExitError) {
+		log.WithFields(log.Fields{
+			"success": success,
+			"app":     name,
+		}).WithError(exitError).Error(name + " stopped!")
+		if !success && !c.isGracefulTerminationMode() {
+			logLastOutput(name, serviceHandler)
+		}
+		if !c.isGracefulTerminationMode() {
+			err := errors.Errorf("%s exitted during startup", name)
+			if exitError != nil {
+				err = errors.Wrapf(exitError, "%s exitted during startup", name)
+			}
+			c.abortStartup(err)
+		}
+		stateChan <- success
+	}
+
+	serviceHandler, err = constructor(outputHandler, exitHandler)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't create "+name+" handler")
+	}
+	if c.dryRun {
+		if builder, ok := serviceHandler.(handlers.CommandLineBuilder); ok {
+			fmt.Println(formatCommandLine(builder.BuildArgs()))
+		} else {
+			log.WithField("app", name).Warn("Service can't tell its command line")
+		}
+		return serviceHandler, stateChan, nil
+	}
+	err = serviceHandler.Start()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't start "+name)
+	}
+	c.addServiceHandler(name, serviceHandler)
+	c.recordServiceStarted(name, serviceHandler, time.Now())
+
+	msg := handlers.HealthMessage{
+		IsHealthy: false,
+	}
+	timeout := c.startupTimeout(name)
+	backoff := c.startupBackoff
+	if backoff.Initial <= 0 {
+		backoff = helpers.DefaultStartupBackoff
+	}
+	attempt := 0
+	for deadline := time.Now().Add(timeout); !msg.IsHealthy && time.Now().Before(deadline) &&
+		!backoff.Exhausted(attempt); attempt++ {
+		// Don't wait past the deadline, the last check would be pointless otherwise
+		delay := backoff.Delay(attempt)
+		if remaining := time.Until(deadline); delay > remaining {
+			delay = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, c.startupError(errors.Wrapf(ctx.Err(), "%s didn't become healthy", name))
+		case <-time.After(delay):
+		}
+		serviceHandler.EnableHealthChecks(healthChan, false)
+		msg = <-healthChan
+		c.recordHealthCheck(name, msg.IsHealthy)
+		log.WithFields(log.Fields{
+			"app":     name,
+			"health":  msg.IsHealthy,
+			"attempt": attempt + 1,
+			"delay":   delay,
+		}).Debug("Healthcheck")
+	}
+	if !msg.IsHealthy {
+		if msg.Error == nil {
+			return nil, nil, errors.Errorf("%s didn't become healthy within %s (%d checks)", name, timeout,
+				attempt)
+		}
+		return nil, nil, errors.Wrapf(msg.Error, "%s didn't become healthy within %s (%d checks)", name, timeout,
+			attempt)
+	}
+
+	return serviceHandler, stateChan, nil
+}
+
+// logLastOutput logs the last lines the process of service 'name' printed before it crashed, if 'handler' keeps them.
+// The exit error alone usually doesn't tell why a service crashed.
+func logLastOutput(name string, handler handlers.ServiceHandler) {
+	tailer, ok := handler.(handlers.OutputTailer)
+	if !ok {
+		return
+	}
+	logCtx := log.WithField("app", name)
+	for _, line := range tailer.LastOutput() {
+		logCtx.Error("Last output: " + line)
+	}
+}
+
+// formatCommandLine formats 'args' for printing, quoting arguments that a shell would split or interpret
+func formatCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+			return !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+.,:/@%", r)
+		}) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// recordRawLog returns an OutputHandler that writes the output of service 'name' to its raw log file before passing it
+// on to 'next'. The raw log of the previous run is kept as well.
+func (c *Cluster) recordRawLog(name string, next handlers.OutputHandler) handlers.OutputHandler {
+	file := path.Join(c.baseDir, "logs", name+".log")
+	logCtx := log.WithFields(log.Fields{
+		"app":  name,
+		"file": file,
+	})
+	err := helpers.RotateOutput(file)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't rotate raw log, appending to it")
+	}
+	recorder, closer, err := helpers.RecordOutput(file, next)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't record raw log")
+		return next
+	}
+	c.addRecorder(closer)
+	return recorder
+}
+
+// addRecorder adds a file service output is recorded to, which is closed by Stop
+func (c *Cluster) addRecorder(recorder io.Closer) {
+	c.recordersLock.Lock()
+	defer c.recordersLock.Unlock()
+	c.recorders = append(c.recorders, recorder)
+}
+
+// closeRecorders closes all files service output is recorded to. Output arriving later is only logged.
+func (c *Cluster) closeRecorders() {
+	c.recordersLock.Lock()
+	defer c.recordersLock.Unlock()
+	for _, recorder := range c.recorders {
+		recorder.Close()
+	}
+	c.recorders = nil
+}
+
+// openProgress opens the progress event file or socket, if enabled
+func (c *Cluster) openProgress(file, socket string) error {
+	if file != "" && socket != "" {
+		return errors.New("only one of progress file and progress socket may be given")
+	}
+	var err error
+	if file != "" {
+		c.progress, err = helpers.OpenProgressFile(file)
+	} else if socket != "" {
+		c.progress, err = helpers.DialProgressSocket(socket)
+	}
+	return errors.Wrap(err, "couldn't set up progress events")
+}
+
+// openHealthReporter connects to the socket or URL 'target' to publish health events to, if enabled
+func (c *Cluster) openHealthReporter(target string) error {
+	if target == "" {
+		return nil
+	}
+	var err error
+	c.healthReporter, err = handlers.OpenHealthReporter(target)
+	return errors.Wrap(err, "couldn't set up health events")
+}
+
+// newLogParser creates the log parser for service 'name' whose output is logged as application 'app', using the
+// parser selected in the configuration or 'defaultParser'
+func (c *Cluster) newLogParser(name, app, defaultParser string) (log3.Parser, error) {
+	parserName := defaultParser
+	if override, ok := c.logParsers[name]; ok {
+		parserName = override
+	}
+	parser, err := log3.NewParser(parserName, app)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't create log parser for %s", name)
+	}
+	return parser, nil
+}
+
+// startupTimeout returns the time service 'name' may take to become healthy
+func (c *Cluster) startupTimeout(name string) time.Duration {
+	if timeout, ok := c.serviceTimeouts[name]; ok {
+		return timeout
+	}
+	if c.serviceTimeout > 0 {
+		return c.serviceTimeout
+	}
+	return cmd.DefaultServiceTimeout
+}
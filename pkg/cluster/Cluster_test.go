@@ -0,0 +1,380 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/cmd"
+	log2 "github.com/vs-eth/microkube/internal/log"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	kube2 "github.com/vs-eth/microkube/pkg/kube"
+	"io/ioutil"
+	"k8s.io/client-go/kubernetes/fake"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServiceHandler is a ServiceHandler that only counts how often it was stopped
+type fakeServiceHandler struct {
+	stopped *int32
+}
+
+func (f fakeServiceHandler) Start() error {
+	return nil
+}
+
+func (f fakeServiceHandler) EnableHealthChecks(messages chan handlers.HealthMessage, forever bool) {
+	messages <- handlers.HealthMessage{IsHealthy: true}
+}
+
+func (f fakeServiceHandler) Stop() {
+	atomic.AddInt32(f.stopped, 1)
+}
+
+// TestConcurrentServiceRegistration registers and stops services from multiple goroutines while health checks read
+// the service list. Run with '-race' to detect unsynchronized access.
+func TestConcurrentServiceRegistration(t *testing.T) {
+	obj := Cluster{}
+	stopped := int32(0)
+	count := 16
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			handler := fakeServiceHandler{stopped: &stopped}
+			obj.addServiceHandler("service-"+strconv.Itoa(i), handler)
+			obj.registerService(serviceEntry{
+				handler:  handler,
+				exitChan: make(chan bool, 1),
+				name:     "service-" + strconv.Itoa(i),
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			for _, service := range obj.services() {
+				if service.handler == nil {
+					t.Errorf("Service %s without handler", service.name)
+				}
+			}
+			obj.isGracefulTerminationMode()
+		}()
+		go func() {
+			defer wg.Done()
+			obj.stopServices(time.Now().Add(time.Minute))
+		}()
+	}
+	obj.setGracefulTerminationMode(true)
+	wg.Wait()
+
+	if len(obj.services()) != count {
+		t.Fatalf("Unexpected number of services: %d", len(obj.services()))
+	}
+	before := atomic.LoadInt32(&stopped)
+	obj.stopServices(time.Now().Add(time.Minute))
+	if atomic.LoadInt32(&stopped)-before != int32(count) {
+		t.Fatalf("Unexpected number of stopped services: %d", atomic.LoadInt32(&stopped)-before)
+	}
+	if !obj.isGracefulTerminationMode() {
+		t.Fatal("Graceful termination mode not set")
+	}
+}
+
+// TestFormatCommandLine checks whether arguments a shell would split or interpret are quoted in dry-run output
+func TestFormatCommandLine(t *testing.T) {
+	result := formatCommandLine([]string{"/usr/bin/hyperkube", "kubelet", "--node-labels", "a=b,c=d",
+		"--eviction-hard", "memory.available<100Mi", "--pod-infra-container-image", "", "--name", "it's here"})
+	expected := "/usr/bin/hyperkube kubelet --node-labels a=b,c=d --eviction-hard 'memory.available<100Mi' " +
+		"--pod-infra-container-image '' --name 'it'\\''s here'"
+	if result != expected {
+		t.Fatalf("Unexpected command line '%s', expected '%s'", result, expected)
+	}
+}
+
+// TestHealthFailureThreshold checks whether failed health probes are counted and only abort microkube once the
+// threshold is reached. With a threshold of 0, microkube never aborts.
+func TestHealthFailureThreshold(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	obj := Cluster{
+		serviceHealth:          make(map[string]handlers.HealthMessage),
+		healthFailureThreshold: 0,
+	}
+	service := serviceEntry{
+		handler:  fakeServiceHandler{stopped: new(int32)},
+		exitChan: make(chan bool, 1),
+		name:     "etcd",
+	}
+	unhealthyCounts := make(map[string]int)
+	for i := 0; i < 3*cmd.DefaultHealthFailureThreshold; i++ {
+		obj.handleHealthResult(service, handlers.HealthMessage{IsHealthy: false}, unhealthyCounts)
+	}
+	if unhealthyCounts["etcd"] != 3*cmd.DefaultHealthFailureThreshold {
+		t.Fatalf("Unexpected number of failed probes: %d", unhealthyCounts["etcd"])
+	}
+	if obj.isServiceHealthy("etcd") {
+		t.Fatal("Service reported healthy")
+	}
+
+	// Consecutive failures below the threshold don't abort, a successful probe resets the count
+	obj.healthFailureThreshold = 2
+	unhealthyCounts["etcd"] = 0
+	obj.handleHealthResult(service, handlers.HealthMessage{IsHealthy: false}, unhealthyCounts)
+	obj.handleHealthResult(service, handlers.HealthMessage{IsHealthy: true}, unhealthyCounts)
+	obj.handleHealthResult(service, handlers.HealthMessage{IsHealthy: false}, unhealthyCounts)
+	if unhealthyCounts["etcd"] != 1 {
+		t.Fatalf("Unexpected number of failed probes: %d", unhealthyCounts["etcd"])
+	}
+
+	// Reaching the threshold fails the cluster
+	obj.failed = make(chan error, 1)
+	obj.handleHealthResult(service, handlers.HealthMessage{IsHealthy: false}, unhealthyCounts)
+	select {
+	case err := <-obj.Failed():
+		if err == nil {
+			t.Fatal("Failure without error")
+		}
+	default:
+		t.Fatal("Failure not reported")
+	}
+}
+
+// TestShutdownBeforeNodeReady cancels startup while waiting for the node to register and checks whether it stops
+// waiting without trying to drain the node
+func TestShutdownBeforeNodeReady(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	obj := Cluster{
+		kCl: kube2.NewKubeClientForInterface(fake.NewSimpleClientset()),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan error, 1)
+	go func() {
+		results <- obj.waitForNode(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-results:
+		if err == nil {
+			t.Fatal("Node reported ready")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Still waiting for node after cancellation")
+	}
+	// Draining would fail, as there's no node
+	obj.Stop()
+	if !obj.isGracefulTerminationMode() {
+		t.Fatal("Graceful termination mode not set, stopping services would be fatal")
+	}
+}
+
+// TestStopEndsBackgroundWork checks whether Stop ends periodic reports and closes the status page, so that another
+// cluster in the same process can reuse its address
+func TestStopEndsBackgroundWork(t *testing.T) {
+	obj := Cluster{
+		statusListen:           "127.0.0.1:0",
+		resourceReportInterval: time.Hour,
+		stopping:               make(chan struct{}),
+	}
+	if err := obj.startStatusUI(); err != nil {
+		t.Fatalf("Couldn't start status page: %s", err)
+	}
+	address := obj.statusUI.Addr().String()
+	returned := make(chan struct{})
+	go func() {
+		obj.reportResourceUsage()
+		close(returned)
+	}()
+
+	obj.Stop()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Still reporting resource usage after stopping")
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Status page address still in use after stopping: %s", err)
+	}
+	listener.Close()
+}
+
+// crashingServiceHandler is a ServiceHandler that exits right after it was started and never becomes healthy
+type crashingServiceHandler struct {
+	exit handlers.ExitHandler
+}
+
+func (f crashingServiceHandler) Start() error {
+	go f.exit(false, nil)
+	return nil
+}
+
+func (f crashingServiceHandler) EnableHealthChecks(messages chan handlers.HealthMessage, forever bool) {
+	messages <- handlers.HealthMessage{IsHealthy: false}
+}
+
+func (f crashingServiceHandler) Stop() {
+}
+
+// TestServiceExitDuringStartup checks whether a service exitting during startup aborts startup with an error instead
+// of waiting until its startup timeout runs out
+func TestServiceExitDuringStartup(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+	obj := Cluster{
+		serviceTimeout: time.Minute,
+		startupBackoff: helpers.Backoff{Initial: 50 * time.Millisecond, Max: 50 * time.Millisecond},
+	}
+	ctx, cancel := obj.startupContext(context.Background())
+	defer cancel()
+
+	begin := time.Now()
+	_, _, err := obj.startService(ctx, "etcd", func(output handlers.OutputHandler,
+		exit handlers.ExitHandler) (handlers.ServiceHandler, error) {
+		return crashingServiceHandler{exit: exit}, nil
+	}, "etcd", log2.ETCDLogParserName)
+	if err == nil || !strings.Contains(err.Error(), "etcd exitted during startup") {
+		t.Fatalf("Unexpected error: '%v'", err)
+	}
+	if time.Since(begin) > 10*time.Second {
+		t.Fatal("Startup wasn't aborted")
+	}
+	// The service is stopped along with all others
+	obj.serviceLock.Lock()
+	defer obj.serviceLock.Unlock()
+	if len(obj.serviceHandlers) != 1 {
+		t.Fatalf("Unexpected number of services to stop: %d", len(obj.serviceHandlers))
+	}
+}
+
+// TestNew checks whether invalid configurations are rejected
+func TestNew(t *testing.T) {
+	config, err := DefaultConfig("/tmp/microkube-unittests-new")
+	if err != nil {
+		t.Fatalf("default config failed: '%s'", err)
+	}
+	obj, err := New(*config)
+	if err != nil {
+		t.Fatalf("default config rejected: '%s'", err)
+	}
+	if obj.startupTimeout("etcd") != cmd.DefaultServiceTimeout {
+		t.Fatalf("Unexpected startup timeout %s", obj.startupTimeout("etcd"))
+	}
+
+	for name, modify := range map[string]func(*Config){
+		"base dir":     func(c *Config) { c.BaseDir = "" },
+		"pod range":    func(c *Config) { c.PodRangeNet = nil },
+		"parallelism":  func(c *Config) { c.MaxStartupParallelism = 0 },
+		"log parser":   func(c *Config) { c.LogParsers = map[string]string{"etcd": "invalid"} },
+		"quota":        func(c *Config) { c.DefaultQuota = "cpu" },
+		"progress out": func(c *Config) { c.ProgressFile, c.ProgressSocket = "a", "b" },
+		"join address": func(c *Config) { c.JoinAPIServer = "10.0.0.1" },
+		"join CA":      func(c *Config) { c.JoinAPIServer = "10.0.0.1:7002" },
+		"join snapshot": func(c *Config) {
+			c.JoinAPIServer, c.ExternalKubeCACert, c.ExternalKubeCAKey = "10.0.0.1:7002", "/tmp/ca.pem", "/tmp/ca.key"
+			c.SnapshotOnExit = true
+		},
+		"extra kubeconfig": func(c *Config) { c.ExtraKubeconfigs = []string{"admin"} },
+		"oidc issuer":      func(c *Config) { c.OIDC.IssuerURL, c.OIDC.ClientID = "http://example.com", "microkube" },
+		"join without kubelet": func(c *Config) {
+			c.JoinAPIServer, c.ExternalKubeCACert, c.ExternalKubeCAKey = "10.0.0.1:7002", "/tmp/ca.pem", "/tmp/ca.key"
+			c.NoKubelet = true
+		},
+		"join kubeconfig": func(c *Config) {
+			c.JoinAPIServer, c.ExternalKubeCACert, c.ExternalKubeCAKey = "10.0.0.1:7002", "/tmp/ca.pem", "/tmp/ca.key"
+			c.ExtraKubeconfigs = []string{ExtraKubeconfigReadOnly}
+		},
+	} {
+		invalid := *config
+		modify(&invalid)
+		if _, err := New(invalid); err == nil {
+			t.Fatalf("Invalid %s accepted", name)
+		}
+	}
+
+	readOnly := *config
+	readOnly.ExtraKubeconfigs = []string{ExtraKubeconfigReadOnly}
+	obj, err = New(readOnly)
+	if err != nil || !obj.readOnlyKubeconfig {
+		t.Fatalf("read-only kubeconfig not enabled: '%v'", err)
+	}
+
+	worker := *config
+	worker.JoinAPIServer = "10.0.0.1:7002"
+	worker.ExternalKubeCACert, worker.ExternalKubeCAKey = "/tmp/ca.pem", "/tmp/ca.key"
+	obj, err = New(worker)
+	if err != nil {
+		t.Fatalf("worker config rejected: '%s'", err)
+	}
+	if !obj.isWorker() || obj.joinHost != "10.0.0.1" || obj.joinPort != 7002 {
+		t.Fatalf("Unexpected apiserver to join %s:%d", obj.joinHost, obj.joinPort)
+	}
+}
+
+// Test9IntegrationMicrokubed runs a full integration test, that is, it bootstraps a full cluster and waits until it
+// is healthy. This requires:
+//   - passwordless sudo
+//   - iptables rules that do not restrict the pod/service networks
+//   - access to the docker socket
+//   - Linux
+func Test9IntegrationMicrokubed(t *testing.T) {
+	logrus.SetLevel(logrus.WarnLevel)
+	rootdir, err := ioutil.TempDir("", "microkube-integration-test")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	config, err := DefaultConfig(rootdir)
+	if err != nil {
+		t.Fatalf("default config failed: '%s'", err)
+	}
+	config.PodRangeNet, config.ServiceRangeNet, config.ClusterIPRange, config.ExecEnv.ListenAddress,
+		config.ExecEnv.ServiceAddress, err = cmd.CalculateIPRanges("192.168.250.1/24", "192.168.251.1/24")
+	if err != nil {
+		t.Fatalf("ipcalc failed: '%s'", err)
+	}
+	config.ProxyClusterCIDR = config.PodRangeNet
+	config.ExecEnv.DNSAddress = cmd.DNSAddress(config.ExecEnv.ServiceAddress)
+	config.ExecEnv.SudoMethod = "/usr/bin/sudo"
+
+	obj, err := New(*config)
+	if err != nil {
+		t.Fatalf("cluster creation failed: '%s'", err)
+	}
+	defer obj.Stop()
+	err = obj.Start(context.Background())
+	if err != nil {
+		t.Fatalf("cluster startup failed: '%s'", err)
+	}
+	// This should make all health checks execute once since they're on a ten second timer
+	time.Sleep(15 * time.Second)
+	select {
+	case err := <-obj.Failed():
+		t.Fatalf("cluster failed: '%s'", err)
+	default:
+	}
+	// Cluster is running, node is healthy, we're done here
+	fmt.Println("All fine")
+}
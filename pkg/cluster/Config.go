@@ -0,0 +1,217 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vs-eth/microkube/internal/cmd"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/handlers/kube"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"net"
+	"strings"
+	"time"
+)
+
+// Config contains all settings of a Cluster. The microkubed command fills it from its command line arguments, other
+// users should start from DefaultConfig.
+type Config struct {
+	// Directory to store all state in
+	BaseDir string
+	// Additional directory to search for binaries
+	ExtraBinDir string
+	// Paths of the etcd and hyperkube binaries to use instead of searching them, empty to search them
+	EtcdBinary      string
+	HyperkubeBinary string
+	// Whether to fail if the version of etcd or hyperkube is outside of SupportedEtcdVersions or
+	// SupportedKubeVersions, instead of only warning
+	StrictVersion bool
+	// CNI plugins to copy from ExtraBinDir to the kubelet's CNI directory
+	CNIPlugins []string
+
+	// Network range to use for pods
+	PodRangeNet *net.IPNet
+	// Network range to use for services
+	ServiceRangeNet *net.IPNet
+	// Network range that contains both pod and service range
+	ClusterIPRange *net.IPNet
+	// Network range kube-proxy considers cluster-internal, usually PodRangeNet
+	ProxyClusterCIDR *net.IPNet
+	// Settings shared by all services, including addresses, ports and the sudo method
+	ExecEnv handlers.ExecutionEnvironment
+	// Address (host:port) of the apiserver of an existing microkube cluster to join as worker node, empty to run the
+	// control plane. A worker node only runs kubelet and kube-proxy, ExternalKubeCACert and ExternalKubeCAKey have to
+	// be the kubernetes CA of the cluster to join. Pod and service range have to match the ones of that cluster.
+	JoinAPIServer string
+	// Whether to only run the control plane, without kubelet and kube-proxy. Startup waits for the apiserver instead
+	// of the node, and neither the node is drained nor are the cluster addons deployed, as no pods can run.
+	NoKubelet bool
+	// Whether to move all ports in ExecEnv to the next free range if one of them is in use on startup, instead of
+	// failing
+	AutoPortBase bool
+
+	// Whether to deploy the kubernetes dashboard cluster addon
+	EnableKubeDash bool
+	// Namespace and name of the service account whose token is printed to sign in to the dashboard, these have to
+	// match the dashboard addon
+	DashboardNamespace      string
+	DashboardServiceAccount string
+	// Whether to deploy the CoreDNS cluster addon
+	EnableDNS bool
+	// Whether to deploy the konnectivity cluster addon and route apiserver-to-cluster traffic through it
+	EnableKonnectivity bool
+	// Whether to deploy the metrics-server cluster addon and enable the apiserver's aggregation layer for it
+	EnableMetricsServer bool
+	// Whether the apiserver writes an audit log. Unless set in ExecEnv, the log is written to
+	// '<BaseDir>/logs/audit.log' using a default policy stored in '<BaseDir>/kube/audit-policy.yaml'.
+	EnableAudit bool
+	// Whether to validate addon manifests against the apiserver before applying them
+	ValidateManifests bool
+	// Directory of YAML or JSON manifests to apply after the cluster addons, in order of their file names, empty if
+	// none. These are validated as well if ValidateManifests is set.
+	ManifestDir string
+	// Number of CoreDNS replicas, 0 to derive it from the number of nodes
+	DNSReplicas int
+
+	// Directory to record the raw output of all services to, empty if disabled
+	CaptureOutputDir string
+	// Whether to record the raw output of each service to '<BaseDir>/logs/<service>.log'
+	RawLogs bool
+	// Time a service may take to become healthy during startup, unless overridden in ServiceTimeouts
+	ServiceTimeout time.Duration
+	// Per-service startup timeouts, indexed by service name (e.g. 'etcd')
+	ServiceTimeouts map[string]time.Duration
+	// Maximum time to wait for all services to exit when stopping, 0 to not wait
+	ShutdownTimeout time.Duration
+	// Maximum time to wait for all pods to be evicted from the node when stopping, 0 for no limit. If draining takes
+	// longer, the services are stopped anyway.
+	DrainTimeout time.Duration
+	// Backoff between health checks of a service during startup, bounded by its startup timeout
+	StartupBackoff helpers.Backoff
+	// Maximum number of services that depend only on the apiserver to start at the same time
+	MaxStartupParallelism int
+	// Per-service users to run as, indexed by service name (e.g. 'etcd')
+	RunAsUsers map[string]string
+	// Per-service log parsers overriding the default, indexed by service name (e.g. 'kube-apiserver'). Parsers are
+	// selected by the name they are registered as, see RegisterParser in pkg/log
+	LogParsers map[string]string
+	// Services whose health and metrics endpoints are served over HTTPS requiring a client certificate, indexed by
+	// service name. Only 'kube-scheduler' supports this, which requires kubernetes 1.12 or later.
+	SecureHealthProbes map[string]bool
+	// Faults to inject into services after startup, empty if disabled
+	ChaosPolicies []cmd.ChaosPolicy
+	// Whether to wipe the kubelet's pod state before starting
+	Fresh bool
+	// Whether to keep etcd's data in memory (tmpfs)
+	EtcdTmpfs bool
+	// Whether to save a snapshot of etcd to '<BaseDir>/etcdbackups' when stopping. This requires etcdctl.
+	SnapshotOnExit bool
+	// Whether to delete all addons and manifests of ManifestDir from the cluster when stopping. This happens after
+	// taking the snapshot.
+	Teardown bool
+	// Snapshot to restore etcd's data from before starting it, empty if none. This requires etcdctl and fails if
+	// etcd already has data, unless ForceRestore is set.
+	RestoreFrom string
+	// Whether to remove existing etcd data in order to restore RestoreFrom
+	ForceRestore bool
+	// Whether to only print the command lines of all services instead of starting them
+	DryRun bool
+	// Interval in which to log the resource usage of all services, 0 if disabled
+	ResourceReportInterval time.Duration
+
+	// Namespace to create during startup, empty if none
+	DefaultNamespace string
+	// Resource quota to apply to DefaultNamespace (e.g. 'cpu=2,pods=10'), empty if none
+	DefaultQuota string
+	// Default container limits to apply to DefaultNamespace (e.g. 'cpu=500m,memory=512Mi'), empty if none
+	DefaultLimitRange string
+
+	// How far the host clock may be off when checking the validity of existing certificates
+	ClockSkewTolerance time.Duration
+	// Type of keys to create for new certificates
+	KeyAlgorithm pki.KeyAlgorithm
+	// How long new certificates are valid
+	CertValidity time.Duration
+	// Additional IPs or DNS names to include in the etcd server certificate
+	EtcdSANs []string
+	// Additional IPs or DNS names to include in the kube-apiserver serving certificate
+	KubeSANs []string
+	// OpenID Connect authentication settings of the apiserver, disabled if empty
+	OIDC kube.OIDCConfig
+	// Paths of an existing CA certificate and it's key to use as kubernetes CA, empty to use a self-signed one
+	ExternalKubeCACert string
+	ExternalKubeCAKey  string
+	// File to write the etcd and kubernetes CA certificates to, empty if disabled
+	CABundle string
+	// Kinds of kubeconfigs to create in '<BaseDir>/kube' in addition to the admin one, e.g. ExtraKubeconfigReadOnly
+	ExtraKubeconfigs []string
+
+	// Address to serve the status page on, empty if disabled
+	StatusListen string
+	// Address to serve Prometheus metrics about all services on, empty if disabled. See Cluster.StartMetrics.
+	MetricsListen string
+	// Maximum number of health probes to run at the same time
+	HealthCheckWorkers int
+	// Number of consecutive failed health probes of a service after which the cluster fails, 0 to only warn
+	HealthFailureThreshold int
+	// File to append startup progress events to, empty if disabled
+	ProgressFile string
+	// Unix socket to send startup progress events to, empty if disabled
+	ProgressSocket string
+	// Unix socket or HTTP(S) URL to publish health probe results to, empty if disabled
+	HealthEvents string
+}
+
+// DefaultConfig returns the settings microkubed uses if no arguments are given, storing all state in 'baseDir'
+func DefaultConfig(baseDir string) (*Config, error) {
+	config := &Config{
+		BaseDir:                 baseDir,
+		EnableKubeDash:          true,
+		DashboardNamespace:      cmd.DefaultDashboardNamespace,
+		DashboardServiceAccount: cmd.DefaultDashboardServiceAccount,
+		EnableDNS:               true,
+		ServiceTimeout:          cmd.DefaultServiceTimeout,
+		ShutdownTimeout:         cmd.DefaultShutdownTimeout,
+		DrainTimeout:            cmd.DefaultDrainTimeout,
+		StartupBackoff:          helpers.DefaultStartupBackoff,
+		MaxStartupParallelism:   cmd.DefaultMaxStartupParallelism,
+		ClockSkewTolerance:      pki.DefaultClockSkewTolerance,
+		KeyAlgorithm:            pki.KeyAlgorithmRSA,
+		CertValidity:            pki.DefaultCertValidity,
+		HealthCheckWorkers:      cmd.DefaultHealthCheckWorkers,
+		HealthFailureThreshold:  cmd.DefaultHealthFailureThreshold,
+	}
+	config.CNIPlugins = strings.Split(cmd.DefaultCNIPlugins, ",")
+
+	var err error
+	var firstSVC net.IP
+	config.PodRangeNet, config.ServiceRangeNet, config.ClusterIPRange, config.ExecEnv.ListenAddress, firstSVC, err =
+		cmd.CalculateIPRanges(cmd.DefaultPodRange, cmd.DefaultServiceRange)
+	if err != nil {
+		return nil, errors.Wrap(err, "IP range calculation failed")
+	}
+	config.ProxyClusterCIDR = config.PodRangeNet
+	config.ExecEnv.ServiceAddress = firstSVC
+	config.ExecEnv.DNSAddress = cmd.DNSAddress(firstSVC)
+	config.ExecEnv.SudoMethod = cmd.DefaultSudoMethod
+	config.ExecEnv.EtcdQuotaBytes = cmd.DefaultEtcdQuotaBytes
+	config.ExecEnv.EtcdAutoCompactionRetention = cmd.DefaultEtcdAutoCompactionRetention
+	config.ExecEnv.OutputTailLines = helpers.DefaultOutputTailLines
+	config.ExecEnv.InitPorts(cmd.DefaultPortBase)
+	return config, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/manifests"
+	"github.com/vs-eth/microkube/pkg/handlers/kube"
+	"path"
+)
+
+// ExtraKubeconfigReadOnly is the kind of extra kubeconfig whose user may view most objects (except for secrets), but
+// can't modify anything. It is bound to the 'view' cluster role through the group pki.ReadOnlyGroup.
+const ExtraKubeconfigReadOnly = "readonly"
+
+// setExtraKubeconfigs sets the kinds of kubeconfigs to create in addition to the admin one
+func (c *Cluster) setExtraKubeconfigs(kinds []string) error {
+	for _, kind := range kinds {
+		if kind != ExtraKubeconfigReadOnly {
+			return errors.Errorf("unknown extra kubeconfig '%s', supported: %s", kind, ExtraKubeconfigReadOnly)
+		}
+		c.readOnlyKubeconfig = true
+	}
+	return nil
+}
+
+// extraKubeconfigPath returns the path of the extra kubeconfig of kind 'kind'
+func (c *Cluster) extraKubeconfigPath(kind string) string {
+	return path.Join(c.baseDir, "kube", "kubeconfig-"+kind)
+}
+
+// setupExtraKubeconfigs creates the extra kubeconfigs, connecting to the apiserver at 'host':'port'. They are
+// recreated on each start, so they always embed the current certificates and address.
+func (c *Cluster) setupExtraKubeconfigs(host string, port int) error {
+	if !c.readOnlyKubeconfig {
+		return nil
+	}
+	execEnv := c.baseExecEnv
+	execEnv.KubeApiPort = port
+	err := kube.CreateReadOnlyKubeconfig(execEnv, c.cred, c.extraKubeconfigPath(ExtraKubeconfigReadOnly), host)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create read-only kubeconfig")
+	}
+	return nil
+}
+
+// applyReadOnlyRBAC binds the group of the read-only kubeconfig to the 'view' cluster role
+func (c *Cluster) applyReadOnlyRBAC() {
+	if !c.readOnlyKubeconfig {
+		return
+	}
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "services",
+		"service":   "readonly-rbac",
+	})
+	manifest, err := manifests.NewReadOnlyRBAC(manifests.KubeManifestRuntimeInfo{
+		ExecEnv:     c.baseExecEnv,
+		BaseDir:     c.baseDir,
+		Credentials: c.cred,
+	})
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't init read-only RBAC binding, the read-only kubeconfig can't read anything")
+		return
+	}
+	err = manifest.ApplyToCluster(c.cred.Kubeconfig)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't apply read-only RBAC binding, the read-only kubeconfig can't read " +
+			"anything")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/handlers"
+)
+
+// kubeComponents returns the names of the kubernetes components this cluster runs
+func (c *Cluster) kubeComponents() []string {
+	var components []string
+	if !c.isWorker() {
+		components = append(components, "kube-apiserver", "kube-controller-manager", "kube-scheduler")
+	}
+	if !c.noKubelet {
+		components = append(components, "kubelet")
+		if !c.baseExecEnv.Rootless {
+			components = append(components, "kube-proxy")
+		}
+	}
+	return components
+}
+
+// findKubeBinaries finds the binaries of the kubernetes components. This is hyperkube if it exists, otherwise the
+// standalone binary of each component. Either way, they have to be in SupportedKubeVersions, which doesn't include the
+// releases without hyperkube yet (1.19 and later). A hyperkube binary given explicitly has to exist.
+func (c *Cluster) findKubeBinaries() error {
+	var err error
+	c.hyperkubeBin, err = c.findBinary("hyperkube", c.hyperkubeBinOverride)
+	if err == nil {
+		// hyperkube itself doesn't report a version, all components share the one of the kubelet
+		c.kubeVersion, err = c.checkBinaryVersion("hyperkube", c.hyperkubeBin, SupportedKubeVersions, "kubelet")
+		return err
+	}
+	if c.hyperkubeBinOverride != "" {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "prep",
+	}).WithError(err).Info("No hyperkube binary, using standalone component binaries")
+
+	components := c.kubeComponents()
+	c.componentBins = make(map[string]string)
+	for _, component := range components {
+		c.componentBins[component], err = c.findBinary(component, "")
+		if err != nil {
+			return errors.Wrap(err, "neither hyperkube nor standalone binaries found")
+		}
+	}
+	if len(components) == 0 {
+		return nil
+	}
+	// All components are expected to be of the same release, so checking one is enough
+	c.kubeVersion, err = c.checkBinaryVersion(components[0], c.componentBins[components[0]], SupportedKubeVersions)
+	return err
+}
+
+// setKubeBinary sets the binary to run kubernetes component 'component' with in 'execEnv'
+func (c *Cluster) setKubeBinary(execEnv *handlers.ExecutionEnvironment, component string) {
+	if c.hyperkubeBin != "" {
+		execEnv.Binary = c.hyperkubeBin
+		execEnv.StandaloneBinary = false
+		return
+	}
+	execEnv.Binary = c.componentBins[component]
+	execEnv.StandaloneBinary = true
+}
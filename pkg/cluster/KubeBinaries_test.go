@@ -0,0 +1,52 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"testing"
+)
+
+// TestKubeComponents checks whether only the components that run in each mode are searched
+func TestKubeComponents(t *testing.T) {
+	uut := Cluster{}
+	assert.Equal(t, []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler", "kubelet", "kube-proxy"},
+		uut.kubeComponents(), "wrong components of a full cluster")
+	uut.baseExecEnv.Rootless = true
+	assert.Equal(t, []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler", "kubelet"},
+		uut.kubeComponents(), "wrong components in rootless mode")
+	uut.noKubelet = true
+	assert.Equal(t, []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}, uut.kubeComponents(),
+		"wrong components without kubelet")
+	uut = Cluster{joinHost: "10.0.0.1"}
+	assert.Equal(t, []string{"kubelet", "kube-proxy"}, uut.kubeComponents(), "wrong components of a worker")
+}
+
+// TestSetKubeBinary checks whether components run hyperkube if available and their standalone binary otherwise
+func TestSetKubeBinary(t *testing.T) {
+	uut := Cluster{hyperkubeBin: "/usr/bin/hyperkube"}
+	execEnv := handlers.ExecutionEnvironment{}
+	uut.setKubeBinary(&execEnv, "kubelet")
+	assert.Equal(t, "/usr/bin/hyperkube", execEnv.Binary, "hyperkube not used")
+	assert.False(t, execEnv.StandaloneBinary, "hyperkube run as standalone binary")
+
+	uut = Cluster{componentBins: map[string]string{"kubelet": "/usr/local/bin/kubelet"}}
+	uut.setKubeBinary(&execEnv, "kubelet")
+	assert.Equal(t, "/usr/local/bin/kubelet", execEnv.Binary, "standalone binary not used")
+	assert.True(t, execEnv.StandaloneBinary, "standalone binary run as hyperkube")
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	restartsMetric     = "microkube_service_restarts_total"
+	healthChecksMetric = "microkube_service_health_checks_total"
+	uptimeMetric       = "microkube_service_uptime_seconds"
+)
+
+// recordServiceStarted records that service 'name' handled by 'handler' was started at 'started'. Its restarts and
+// uptime are read whenever the metrics are served.
+func (c *Cluster) recordServiceStarted(name string, handler handlers.ServiceHandler, started time.Time) {
+	if c.metrics == nil {
+		return
+	}
+	labels := map[string]string{"service": name}
+	// Show both results right away instead of once the first one occurred
+	c.recordHealthCheckResult(name, "passed", 0)
+	c.recordHealthCheckResult(name, "failed", 0)
+	c.metrics.AddCollector(func(m *helpers.Metrics) {
+		restarts := 0
+		if counter, ok := handler.(handlers.RestartCounter); ok {
+			restarts = counter.Restarts()
+		}
+		m.SetCounter(restartsMetric, "Number of times a service was restarted after exiting unexpectedly.", labels,
+			float64(restarts))
+		m.Set(uptimeMetric, "Time since microkube started a service.", labels, time.Since(started).Seconds())
+	})
+}
+
+// recordHealthCheck records the result of a health check of service 'name'
+func (c *Cluster) recordHealthCheck(name string, healthy bool) {
+	if healthy {
+		c.recordHealthCheckResult(name, "passed", 1)
+	} else {
+		c.recordHealthCheckResult(name, "failed", 1)
+	}
+}
+
+// recordHealthCheckResult adds 'delta' to the number of health checks of service 'name' with result 'result'
+func (c *Cluster) recordHealthCheckResult(name, result string, delta float64) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.Add(healthChecksMetric, "Number of health checks of a service by result.", map[string]string{
+		"service": name,
+		"result":  result,
+	}, delta)
+}
+
+// StartMetrics serves the metrics of all services at '/metrics' on the metrics address in the background, if one is
+// configured. Depending on the Accept header, they are served in the Prometheus text format or in the OpenMetrics
+// format. This doesn't need the cluster to be started, so that failed startups show up as well.
+func (c *Cluster) StartMetrics() error {
+	if c.metricsListen == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", c.metricsListen)
+	if err != nil {
+		return errors.Wrap(err, "couldn't listen on "+c.metricsListen)
+	}
+	c.metricsListener = listener
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "metrics",
+		"address":   listener.Addr().String(),
+	}).Info("Serving metrics")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.metrics)
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// stopMetrics stops serving metrics, if they are served
+func (c *Cluster) stopMetrics() {
+	if c.metricsListener != nil {
+		c.metricsListener.Close()
+		c.metricsListener = nil
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// restartingFakeServiceHandler is a fakeServiceHandler whose service was restarted a few times
+type restartingFakeServiceHandler struct {
+	fakeServiceHandler
+	restarts int
+}
+
+func (f restartingFakeServiceHandler) Restarts() int {
+	return f.restarts
+}
+
+// TestMetrics checks the metrics of services in both exposition formats
+func TestMetrics(t *testing.T) {
+	obj := Cluster{metrics: helpers.NewMetrics()}
+	stopped := int32(0)
+	obj.recordServiceStarted("etcd", restartingFakeServiceHandler{
+		fakeServiceHandler: fakeServiceHandler{stopped: &stopped},
+		restarts:           2,
+	}, time.Now().Add(-90*time.Second))
+	obj.recordHealthCheck("etcd", false)
+	obj.recordHealthCheck("etcd", true)
+	obj.recordHealthCheck("etcd", true)
+	obj.recordServiceStarted("kube-scheduler", fakeServiceHandler{stopped: &stopped}, time.Now())
+	obj.recordHealthCheck("kube-scheduler", false)
+
+	cases := []struct {
+		accept      string
+		contentType string
+		typeLine    string
+	}{
+		{
+			accept:      "",
+			contentType: helpers.PrometheusContentType,
+			typeLine:    "# TYPE microkube_service_restarts_total counter",
+		},
+		{
+			accept:      "application/openmetrics-text",
+			contentType: helpers.OpenMetricsContentType,
+			typeLine:    "# TYPE microkube_service_restarts counter",
+		},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", c.accept)
+		recorder := httptest.NewRecorder()
+		obj.metrics.ServeHTTP(recorder, req)
+		assert.Equal(t, c.contentType, recorder.Header().Get("Content-Type"), "unexpected content type")
+		body := recorder.Body.String()
+		assert.Contains(t, body, c.typeLine, "restarts not typed as counter")
+		for _, line := range []string{
+			`microkube_service_restarts_total{service="etcd"} 2 `,
+			`microkube_service_restarts_total{service="kube-scheduler"} 0 `,
+			`microkube_service_health_checks_total{result="passed",service="etcd"} 2 `,
+			`microkube_service_health_checks_total{result="failed",service="etcd"} 1 `,
+			`microkube_service_health_checks_total{result="passed",service="kube-scheduler"} 0 `,
+			`microkube_service_health_checks_total{result="failed",service="kube-scheduler"} 1 `,
+		} {
+			assert.Contains(t, body, line, "metric missing")
+		}
+
+		uptime := regexp.MustCompile(`microkube_service_uptime_seconds{service="etcd"} ([0-9.e+]+) `).
+			FindStringSubmatch(body)
+		if assert.NotNil(t, uptime, "uptime missing") {
+			seconds, err := strconv.ParseFloat(uptime[1], 64)
+			assert.NoError(t, err, "invalid uptime")
+			assert.InDelta(t, 90, seconds, 5, "unexpected uptime")
+		}
+	}
+}
+
+// TestMetricsDisabled checks that recording metrics is a no-op if they aren't served
+func TestMetricsDisabled(t *testing.T) {
+	obj := Cluster{}
+	stopped := int32(0)
+	obj.recordServiceStarted("etcd", fakeServiceHandler{stopped: &stopped}, time.Now())
+	obj.recordHealthCheck("etcd", true)
+	assert.NoError(t, obj.StartMetrics(), "starting disabled metrics failed")
+	assert.Nil(t, obj.metricsListener, "metrics served although disabled")
+}
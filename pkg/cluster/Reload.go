@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reload re-applies the cluster addons and the manifests of the manifest directory to the running cluster, e.g. after
+// they were edited. Concurrent calls are serialized, and once Stop was called, Reload doesn't do anything.
+func (c *Cluster) Reload() {
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "services",
+	})
+	if c.stopped {
+		logCtx.Info("Cluster is stopping, not reloading manifests")
+		return
+	}
+	if c.isWorker() {
+		// A worker node leaves addons to the control plane
+		logCtx.Info("Worker node, nothing to reload")
+		return
+	}
+	logCtx.Info("Reloading manifests")
+	c.startServices(context.Background())
+	c.applyUserManifests()
+	logCtx.Info("Manifests reloaded")
+}
+
+// monitorManifest returns whether the health of the addon 'name' still has to be monitored, marking it as monitored
+func (c *Cluster) monitorManifest(name string) bool {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	if c.monitoredManifests == nil {
+		c.monitoredManifests = make(map[string]bool)
+	}
+	if c.monitoredManifests[name] {
+		return false
+	}
+	c.monitoredManifests[name] = true
+	return true
+}
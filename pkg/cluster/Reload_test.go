@@ -0,0 +1,37 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestReloadAfterStop checks whether Reload doesn't touch a cluster that is stopping
+func TestReloadAfterStop(t *testing.T) {
+	uut := Cluster{stopped: true, manifestDir: "/nonexistent"}
+	uut.Reload()
+	assert.Empty(t, uut.appliedManifests, "manifests applied after stop")
+}
+
+// TestMonitorManifest checks whether the health of each addon is only monitored once across reloads
+func TestMonitorManifest(t *testing.T) {
+	uut := Cluster{}
+	assert.True(t, uut.monitorManifest("DNS"), "new addon not monitored")
+	assert.True(t, uut.monitorManifest("KubeDash"), "new addon not monitored")
+	assert.False(t, uut.monitorManifest("DNS"), "addon monitored twice")
+}
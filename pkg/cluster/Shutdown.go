@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownPollInterval is the interval in which waitForServicesExit checks whether services are still running
+const shutdownPollInterval = 100 * time.Millisecond
+
+// controlPlaneServices are the services all others depend on, in the order they are started
+var controlPlaneServices = []string{"etcd", "kube-apiserver"}
+
+// stopServices stops all services that were started so far, in reverse start order: first all services depending on
+// the control plane at the same time, then the apiserver and finally etcd. Each of these stages is waited for until
+// 'deadline', once it passed the remaining stages are stopped right away.
+func (c *Cluster) stopServices(deadline time.Time) {
+	c.serviceLock.Lock()
+	services := append([]serviceEntry{}, c.serviceHandlers...)
+	c.serviceLock.Unlock()
+	// Stage 0 contains the services depending on the control plane, the control plane follows in reverse order
+	stages := make([][]handlers.ServiceHandler, len(controlPlaneServices)+1)
+	for i := len(services) - 1; i >= 0; i-- {
+		stage := 0
+		for j, name := range controlPlaneServices {
+			if services[i].name == name {
+				stage = len(controlPlaneServices) - j
+			}
+		}
+		stages[stage] = append(stages[stage], services[i].handler)
+	}
+	for _, stage := range stages {
+		stopConcurrently(stage, deadline)
+	}
+}
+
+// stopConcurrently stops all of 'services' at the same time and waits until they are stopped, but not beyond
+// 'deadline'
+func stopConcurrently(services []handlers.ServiceHandler, deadline time.Time) {
+	stopped := make(chan struct{})
+	go func() {
+		wg := sync.WaitGroup{}
+		for _, service := range services {
+			wg.Add(1)
+			go func(service handlers.ServiceHandler) {
+				defer wg.Done()
+				service.Stop()
+			}(service)
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+	}
+}
+
+// waitForServicesExit waits until the processes of all services exited, but at most until 'deadline'. Services that
+// aren't backed by a local process are considered stopped.
+func (c *Cluster) waitForServicesExit(deadline time.Time) {
+	running := c.runningServices()
+	for len(running) > 0 && time.Now().Before(deadline) {
+		time.Sleep(shutdownPollInterval)
+		running = c.runningServices()
+	}
+	if len(running) > 0 {
+		log.WithFields(log.Fields{
+			"app":      "microkube",
+			"services": running,
+			"timeout":  c.shutdownTimeout,
+		}).Warn("Services didn't exit in time")
+		return
+	}
+	log.WithField("app", "microkube").Debug("All services exited")
+}
+
+// runningServices returns the names of all services whose process is still running
+func (c *Cluster) runningServices() []string {
+	var running []string
+	for _, service := range c.services() {
+		processHandler, ok := service.handler.(handlers.ProcessHandler)
+		if ok && processAlive(processHandler.Pid()) {
+			running = append(running, service.name)
+		}
+	}
+	return running
+}
+
+// processAlive returns whether the process 'pid' exists. The handler of a process keeps reporting it's ID after it
+// exited, but the process disappears once the handler reaped it.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// Signal 0 only checks whether the process exists. Processes of other users (see RunAsUsers) can't be signalled,
+	// but exist nonetheless.
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
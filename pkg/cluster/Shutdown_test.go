@@ -0,0 +1,148 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderedStopServiceHandler records when it is stopped and takes 'delay' to stop
+type orderedStopServiceHandler struct {
+	fakeServiceHandler
+	name  string
+	delay time.Duration
+	lock  *sync.Mutex
+	order *[]string
+}
+
+func (o orderedStopServiceHandler) Stop() {
+	time.Sleep(o.delay)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	*o.order = append(*o.order, o.name)
+}
+
+// TestStopServices checks whether services depending on the control plane are stopped at the same time before the
+// apiserver and etcd, and whether the deadline bounds waiting for a stage
+func TestStopServices(t *testing.T) {
+	lock := &sync.Mutex{}
+	var order []string
+	obj := Cluster{}
+	for _, name := range []string{"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler", "kubelet"} {
+		obj.addServiceHandler(name, orderedStopServiceHandler{name: name, delay: 200 * time.Millisecond, lock: lock,
+			order: &order})
+	}
+	start := time.Now()
+	obj.stopServices(start.Add(time.Minute))
+	assert.True(t, time.Since(start) < 2*time.Second, "services depending on the control plane stopped one by one")
+	if assert.Len(t, order, 5, "not all services stopped") {
+		assert.ElementsMatch(t, []string{"kube-controller-manager", "kube-scheduler", "kubelet"}, order[:3],
+			"control plane stopped before the services depending on it")
+		assert.Equal(t, []string{"kube-apiserver", "etcd"}, order[3:], "wrong control plane stop order")
+	}
+
+	obj = Cluster{}
+	obj.addServiceHandler("etcd", orderedStopServiceHandler{name: "etcd", delay: time.Minute, lock: lock,
+		order: &order})
+	start = time.Now()
+	obj.stopServices(start.Add(200 * time.Millisecond))
+	assert.True(t, time.Since(start) < 30*time.Second, "waited beyond the deadline")
+}
+
+// TestWaitForServicesExit checks whether stopping waits until all processes exited, but not longer than the timeout
+func TestWaitForServicesExit(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("couldn't start process: '%s'", err)
+	}
+	stopped := int32(0)
+	obj := Cluster{}
+	obj.registerService(serviceEntry{
+		handler: processFakeServiceHandler{
+			fakeServiceHandler: fakeServiceHandler{stopped: &stopped},
+			pid:                cmd.Process.Pid,
+		},
+		exitChan: make(chan bool, 1),
+		name:     "etcd",
+	})
+	assert.Equal(t, []string{"etcd"}, obj.runningServices(), "running process not detected")
+
+	start := time.Now()
+	obj.waitForServicesExit(start.Add(200 * time.Millisecond))
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "didn't wait for running process")
+
+	// Returns as soon as the process exited
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	start = time.Now()
+	obj.waitForServicesExit(start.Add(time.Minute))
+	assert.True(t, time.Since(start) < 30*time.Second, "waited for exited process")
+	assert.Empty(t, obj.runningServices(), "exited process considered running")
+}
+
+// TestDrainContext checks how the drain timeout and the kubelet's shutdown grace periods bound draining
+func TestDrainContext(t *testing.T) {
+	cases := []struct {
+		name                    string
+		drainTimeout            time.Duration
+		gracePeriod             time.Duration
+		gracePeriodCriticalPods time.Duration
+		// Expected timeout, 0 for none
+		expected time.Duration
+	}{
+		{name: "unbounded", expected: 0},
+		{name: "drain timeout", drainTimeout: time.Minute, expected: time.Minute},
+		{name: "grace period shorter", drainTimeout: time.Minute, gracePeriod: 40 * time.Second,
+			gracePeriodCriticalPods: 10 * time.Second, expected: 30 * time.Second},
+		{name: "grace period longer", drainTimeout: time.Minute, gracePeriod: 2 * time.Minute,
+			gracePeriodCriticalPods: 10 * time.Second, expected: time.Minute},
+		{name: "grace period without drain timeout", gracePeriod: 30 * time.Second, expected: 30 * time.Second},
+		{name: "grace period reserved for critical pods", drainTimeout: time.Minute, gracePeriod: 30 * time.Second,
+			gracePeriodCriticalPods: 30 * time.Second, expected: time.Minute},
+		{name: "grace period reserved for critical pods without drain timeout", gracePeriod: 30 * time.Second,
+			gracePeriodCriticalPods: 30 * time.Second, expected: 0},
+	}
+	for _, c := range cases {
+		obj := Cluster{
+			drainTimeout: c.drainTimeout,
+			baseExecEnv: handlers.ExecutionEnvironment{
+				KubeletShutdownGracePeriod:             c.gracePeriod,
+				KubeletShutdownGracePeriodCriticalPods: c.gracePeriodCriticalPods,
+			},
+		}
+		start := time.Now()
+		ctx, cancel := obj.drainContext()
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if c.expected == 0 {
+			assert.False(t, ok, "unexpected deadline for '%s'", c.name)
+			continue
+		}
+		if assert.True(t, ok, "deadline missing for '%s'", c.name) {
+			assert.WithinDuration(t, start.Add(c.expected), deadline, time.Second, "unexpected deadline for '%s'",
+				c.name)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/cmd"
+	"github.com/vs-eth/microkube/pkg/handlers/etcd"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// etcdBackupDir is the directory in the base directory etcd snapshots are saved to. This is not inside of etcd's data
+// directory, which might be a tmpfs.
+const etcdBackupDir = "etcdbackups"
+
+// snapshotEtcd saves a snapshot of etcd to the backup directory, if etcd is running
+func (c *Cluster) snapshotEtcd() {
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "etcd-backup",
+	})
+	var handler *etcd.EtcdHandler
+	for _, service := range c.services() {
+		if etcdHandler, ok := service.handler.(*etcd.EtcdHandler); ok {
+			handler = etcdHandler
+		}
+	}
+	if handler == nil {
+		logCtx.Info("etcd isn't running, skipping snapshot")
+		return
+	}
+	cmd.EnsureDir(c.baseDir, etcdBackupDir, 0770)
+	file := path.Join(c.baseDir, etcdBackupDir, "snapshot-"+time.Now().Format("20060102-150405")+".db")
+	logCtx = logCtx.WithField("file", file)
+	logCtx.Info("Saving etcd snapshot...")
+	err := handler.Snapshot(file)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't save etcd snapshot")
+		return
+	}
+	logCtx.Info("etcd snapshot saved")
+}
+
+// restoreEtcd restores the snapshot given in the configuration into etcd's data directory 'dataDir' before etcd is
+// started by 'handler'. Existing data is only removed if forced.
+func (c *Cluster) restoreEtcd(handler *etcd.EtcdHandler, dataDir string) error {
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "etcd-backup",
+		"file":      c.restoreFrom,
+	})
+	if c.dryRun {
+		logCtx.Info("Dry run, not restoring etcd snapshot")
+		return nil
+	}
+	if c.forceRestore {
+		logCtx.Warn("Removing existing etcd data")
+		err := removeDirContents(dataDir)
+		if err != nil {
+			return errors.Wrap(err, "couldn't remove existing etcd data")
+		}
+	}
+	logCtx.Info("Restoring etcd snapshot...")
+	err := handler.RestoreSnapshot(c.restoreFrom, dataDir)
+	if err != nil {
+		return errors.Wrap(err, "couldn't restore etcd snapshot")
+	}
+	logCtx.Info("etcd snapshot restored")
+	return nil
+}
+
+// removeDirContents removes everything inside of 'dir', but not 'dir' itself as it might be a mount point
+func removeDirContents(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = os.RemoveAll(path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// statusSocketName is the name of the unix socket in the base directory the status of all services is served on
+const statusSocketName = "microkubed.sock"
+
+// statusQueryTimeout is how long QueryStatus waits for a response
+const statusQueryTimeout = 5 * time.Second
+
+// ServiceStatus describes the state of a service started by microkube
+type ServiceStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Reason of the last failed health check, empty if healthy or unknown
+	Error string `json:"error,omitempty"`
+	// Process ID of the service, 0 if it isn't backed by a local process or isn't running
+	Pid int `json:"pid"`
+}
+
+// StatusSocket returns the path of the socket the status of the cluster in 'baseDir' is served on
+func StatusSocket(baseDir string) string {
+	return path.Join(baseDir, statusSocketName)
+}
+
+// ServiceStatus returns the state of all services, in the order they were started in
+func (c *Cluster) ServiceStatus() []ServiceStatus {
+	var result []ServiceStatus
+	for _, service := range c.services() {
+		msg := c.serviceHealthMessage(service.name)
+		status := ServiceStatus{
+			Name:    service.name,
+			Healthy: msg.IsHealthy,
+		}
+		if !msg.IsHealthy && msg.Error != nil {
+			status.Error = msg.Error.Error()
+		}
+		if processHandler, ok := service.handler.(handlers.ProcessHandler); ok {
+			status.Pid = processHandler.Pid()
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// startStatusSocket serves the status of all services as JSON on the status socket in the background
+func (c *Cluster) startStatusSocket() error {
+	socket := StatusSocket(c.baseDir)
+	// A socket left behind by a previous run would make listening fail
+	err := os.Remove(socket)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "couldn't remove stale status socket")
+	}
+	c.statusSocket, err = net.Listen("unix", socket)
+	if err != nil {
+		return errors.Wrap(err, "couldn't listen on "+socket)
+	}
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "status",
+		"socket":    socket,
+	}).Debug("Serving service status")
+	go http.Serve(c.statusSocket, http.HandlerFunc(c.serveServiceStatus))
+	return nil
+}
+
+// stopStatusSocket stops serving the status socket, if it is served
+func (c *Cluster) stopStatusSocket() {
+	if c.statusSocket != nil {
+		// Closing a unix listener removes the socket file
+		c.statusSocket.Close()
+		c.statusSocket = nil
+	}
+}
+
+// serveServiceStatus responds with the state of all services
+func (c *Cluster) serveServiceStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/services" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(c.ServiceStatus())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "status",
+		}).WithError(err).Warn("Couldn't send service status")
+	}
+}
+
+// QueryStatus asks the cluster running in 'baseDir' for the state of its services
+func QueryStatus(baseDir string) ([]ServiceStatus, error) {
+	socket := StatusSocket(baseDir)
+	client := http.Client{
+		Timeout: statusQueryTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	// The host is ignored, all requests go to the socket
+	resp, err := client.Get("http://microkubed/services")
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't query "+socket+", is microkube running?")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	var result []ServiceStatus
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't decode service status")
+	}
+	return result, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// processFakeServiceHandler is a fakeServiceHandler backed by a (pretend) local process
+type processFakeServiceHandler struct {
+	fakeServiceHandler
+	pid int
+}
+
+func (f processFakeServiceHandler) Pid() int {
+	return f.pid
+}
+
+// TestStatusSocket queries the status of all services through the status socket
+func TestStatusSocket(t *testing.T) {
+	rootdir, err := ioutil.TempDir("", "microkube-unittests-status")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(rootdir)
+	obj := Cluster{baseDir: rootdir}
+	stopped := int32(0)
+	obj.registerService(serviceEntry{
+		handler:  processFakeServiceHandler{fakeServiceHandler: fakeServiceHandler{stopped: &stopped}, pid: 1234},
+		exitChan: make(chan bool, 1),
+		name:     "etcd",
+	})
+	obj.registerService(serviceEntry{
+		handler:  fakeServiceHandler{stopped: &stopped},
+		exitChan: make(chan bool, 1),
+		name:     "kube-scheduler",
+	})
+	obj.setServiceHealth("kube-scheduler", handlers.HealthMessage{IsHealthy: false, Error: errors.New("timeout")})
+
+	_, err = QueryStatus(rootdir)
+	assert.Error(t, err, "status returned without microkube running")
+
+	// A socket left behind by a previous run is replaced
+	assert.NoError(t, ioutil.WriteFile(StatusSocket(rootdir), nil, 0600), "couldn't create stale socket")
+	assert.NoError(t, obj.startStatusSocket(), "couldn't serve status")
+	defer obj.stopStatusSocket()
+	status, err := QueryStatus(rootdir)
+	assert.NoError(t, err, "couldn't query status")
+	assert.Equal(t, []ServiceStatus{
+		{Name: "etcd", Healthy: true, Pid: 1234},
+		{Name: "kube-scheduler", Healthy: false, Error: "timeout"},
+	}, status, "unexpected status")
+}
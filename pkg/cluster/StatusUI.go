@@ -0,0 +1,151 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	kube2 "github.com/vs-eth/microkube/pkg/kube"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+)
+
+// statusPageTemplate renders the status page. It reloads itself every few seconds.
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>Microkube status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: .3em .8em; text-align: left; }
+.ok { color: #080; }
+.bad { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Microkube status</h1>
+<p>As of {{ .Time.Format "2006-01-02 15:04:05" }}</p>
+<h2>Components</h2>
+<table>
+<tr><th>Service</th><th>Health</th></tr>
+{{- range .Components }}
+<tr><td>{{ .Name }}</td>{{ if .Healthy }}<td class="ok">healthy</td>{{ else }}<td class="bad">unhealthy</td>{{ end }}</tr>
+{{- end }}
+</table>
+{{- if .Error }}
+<p class="bad">Couldn't query the cluster: {{ .Error }}</p>
+{{- else }}
+<h2>Nodes</h2>
+<table>
+<tr><th>Name</th><th>Status</th></tr>
+{{- range .Cluster.Nodes }}
+<tr><td>{{ .Name }}</td>{{ if .Ready }}<td class="ok">Ready{{ else }}<td class="bad">NotReady{{ end }}{{ if .Unschedulable }}, SchedulingDisabled{{ end }}</td></tr>
+{{- end }}
+</table>
+<h2>Pods</h2>
+<table>
+<tr><th>Namespace</th><th>Name</th><th>Ready</th><th>Phase</th><th>Restarts</th></tr>
+{{- range .Cluster.Pods }}
+<tr><td>{{ .Namespace }}</td><td>{{ .Name }}</td><td>{{ .ReadyContainers }}/{{ .Containers }}</td><td>{{ .Phase }}</td><td>{{ .Restarts }}</td></tr>
+{{- end }}
+</table>
+<h2>Recent events</h2>
+<table>
+<tr><th>Time</th><th>Type</th><th>Namespace</th><th>Object</th><th>Reason</th><th>Message</th></tr>
+{{- range .Cluster.Events }}
+<tr><td>{{ .Time.Format "15:04:05" }}</td><td{{ if eq .Type "Warning" }} class="bad"{{ end }}>{{ .Type }}</td><td>{{ .Namespace }}</td><td>{{ .Object }}</td><td>{{ .Reason }}</td><td>{{ .Message }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`))
+
+// componentStatus describes the health of a service started by microkube
+type componentStatus struct {
+	Name    string
+	Healthy bool
+}
+
+// statusPageData contains the data used when rendering the status page
+type statusPageData struct {
+	Time       time.Time
+	Components []componentStatus
+	// Nil if the cluster couldn't be queried, see Error
+	Cluster *kube2.ClusterStatus
+	Error   string
+}
+
+// startStatusUI serves the read-only status page on statusListen in the background
+func (c *Cluster) startStatusUI() error {
+	listener, err := net.Listen("tcp", c.statusListen)
+	if err != nil {
+		return errors.Wrap(err, "couldn't listen on "+c.statusListen)
+	}
+	c.statusUI = listener
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "status",
+		"address":   listener.Addr().String(),
+	}).Info("Serving status page")
+	go http.Serve(listener, http.HandlerFunc(c.serveStatus))
+	return nil
+}
+
+// stopStatusUI stops serving the status page, if it is served
+func (c *Cluster) stopStatusUI() {
+	if c.statusUI != nil {
+		c.statusUI.Close()
+		c.statusUI = nil
+	}
+}
+
+// serveStatus renders the status page
+func (c *Cluster) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := statusPageData{
+		Time: time.Now(),
+	}
+	for _, service := range c.services() {
+		data.Components = append(data.Components, componentStatus{
+			Name:    service.name,
+			Healthy: c.isServiceHealthy(service.name),
+		})
+	}
+	cluster, err := c.kCl.Status()
+	if err != nil {
+		data.Error = err.Error()
+	} else {
+		data.Cluster = cluster
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = statusPageTemplate.Execute(w, data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "status",
+		}).WithError(err).Warn("Couldn't render status page")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	kube2 "github.com/vs-eth/microkube/pkg/kube"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestStatusUI renders the status page and checks whether component health, pods and events are shown
+func TestStatusUI(t *testing.T) {
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-dns-1234", Namespace: "kube-system"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	event := v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "kube-dns-1234.1", Namespace: "kube-system"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "kube-dns-1234"},
+		Type:           v1.EventTypeWarning,
+		Reason:         "BackOff",
+		Message:        "Back-off restarting <failed> container",
+	}
+	obj := Cluster{
+		kCl: kube2.NewKubeClientForInterface(fake.NewSimpleClientset(&pod, &event)),
+	}
+	stopped := int32(0)
+	for _, name := range []string{"etcd", "kube-scheduler"} {
+		obj.registerService(serviceEntry{
+			handler:  fakeServiceHandler{stopped: &stopped},
+			exitChan: make(chan bool, 1),
+			name:     name,
+		})
+	}
+	obj.setServiceHealth("kube-scheduler", handlers.HealthMessage{IsHealthy: false})
+
+	recorder := httptest.NewRecorder()
+	obj.serveStatus(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "unexpected status code")
+	body := recorder.Body.String()
+	assert.Contains(t, body, "<td>etcd</td><td class=\"ok\">healthy</td>", "etcd health missing")
+	assert.Contains(t, body, "<td>kube-scheduler</td><td class=\"bad\">unhealthy</td>", "scheduler health missing")
+	assert.Contains(t, body, "kube-dns-1234", "pod missing")
+	assert.Contains(t, body, "BackOff", "event missing")
+	assert.Contains(t, body, "&lt;failed&gt;", "event message not escaped")
+
+	recorder = httptest.NewRecorder()
+	obj.serveStatus(recorder, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "unexpected status code for unknown path")
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/manifests"
+)
+
+// recordManifest remembers that 'manifest' is applied to the cluster, so that it can be removed on teardown. A
+// manifest re-applied by Reload replaces the one recorded before under the same name.
+func (c *Cluster) recordManifest(manifest manifests.KubeManifest) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	for idx, applied := range c.appliedManifests {
+		if applied.Name() == manifest.Name() {
+			c.appliedManifests[idx] = manifest
+			return
+		}
+	}
+	c.appliedManifests = append(c.appliedManifests, manifest)
+}
+
+// removeManifests deletes all manifests applied to the cluster in reverse order, so that user manifests are removed
+// before the addons they might depend on. Failures are reported, but don't stop the removal of other manifests.
+func (c *Cluster) removeManifests() {
+	c.serviceLock.Lock()
+	applied := c.appliedManifests
+	c.appliedManifests = nil
+	c.serviceLock.Unlock()
+
+	for idx := len(applied) - 1; idx >= 0; idx-- {
+		logCtx := log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "services",
+			"service":   applied[idx].Name(),
+		})
+		err := applied[idx].RemoveFromCluster(c.cred.Kubeconfig)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't remove manifest from cluster")
+			continue
+		}
+		logCtx.Info("Manifest removed from cluster")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"testing"
+)
+
+// fakeManifest is a manifest recording its removal
+type fakeManifest struct {
+	name string
+	// Names of all manifests removed so far, shared between manifests
+	removed *[]string
+	// Error to fail removal with, nil to succeed
+	err error
+}
+
+func (f fakeManifest) ApplyToCluster(kubeconfig string) error {
+	return nil
+}
+
+func (f fakeManifest) RemoveFromCluster(kubeconfig string) error {
+	*f.removed = append(*f.removed, f.name)
+	return f.err
+}
+
+func (f fakeManifest) Validate(kubeconfig string) error {
+	return nil
+}
+
+func (f fakeManifest) IsHealthy() (bool, error) {
+	return true, nil
+}
+
+func (f fakeManifest) InitHealthCheck(kubeconfig string) error {
+	return nil
+}
+
+func (f fakeManifest) Name() string {
+	return f.name
+}
+
+// TestRemoveManifests checks whether all applied manifests are removed in reverse order, even if one of them fails
+func TestRemoveManifests(t *testing.T) {
+	var removed []string
+	uut := Cluster{cred: &pki.MicrokubeCredentials{}}
+	uut.recordManifest(fakeManifest{name: "DNS", removed: &removed})
+	uut.recordManifest(fakeManifest{name: "MetricsServer", removed: &removed, err: errors.New("forbidden")})
+	uut.recordManifest(fakeManifest{name: "app.yaml", removed: &removed})
+
+	uut.removeManifests()
+	assert.Equal(t, []string{"app.yaml", "MetricsServer", "DNS"}, removed, "manifests not removed in reverse order")
+
+	// Manifests are only removed once
+	uut.removeManifests()
+	assert.Len(t, removed, 3, "manifests removed twice")
+}
+
+// TestRecordManifestReplaces checks whether a re-applied manifest is only removed once, in it's original position
+func TestRecordManifestReplaces(t *testing.T) {
+	var removed []string
+	uut := Cluster{cred: &pki.MicrokubeCredentials{}}
+	uut.recordManifest(fakeManifest{name: "DNS", removed: &removed})
+	uut.recordManifest(fakeManifest{name: "app.yaml", removed: &removed})
+	uut.recordManifest(fakeManifest{name: "DNS", removed: &removed})
+
+	uut.removeManifests()
+	assert.Equal(t, []string{"app.yaml", "DNS"}, removed, "re-applied manifest not replaced")
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/internal/manifests"
+)
+
+// applyUserManifests applies all manifest files in manifestDir in order of their names. A file that can't be read,
+// is invalid or can't be applied is reported and skipped, the remaining files are still applied.
+func (c *Cluster) applyUserManifests() {
+	if c.manifestDir == "" {
+		return
+	}
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "services",
+		"dir":       c.manifestDir,
+	})
+	files, err := manifests.ManifestFiles(c.manifestDir)
+	if err != nil {
+		logCtx.WithError(err).Warn("Couldn't find user manifests")
+		return
+	}
+	kmri := c.manifestRuntimeInfo()
+	applied := 0
+	for _, file := range files {
+		fileLogCtx := logCtx.WithField("file", file)
+		manifest, err := manifests.NewUserManifest(file, kmri)
+		if err != nil {
+			fileLogCtx.WithError(err).Warn("Couldn't load manifest, skipping it")
+			continue
+		}
+		if c.validateManifests {
+			err = manifest.Validate(c.cred.Kubeconfig)
+			if err != nil {
+				fileLogCtx.WithError(err).Warn("Manifest is invalid, not applying it")
+				continue
+			}
+		}
+		c.recordManifest(manifest)
+		err = manifest.ApplyToCluster(c.cred.Kubeconfig)
+		if err != nil {
+			fileLogCtx.WithError(err).Warn("Couldn't apply manifest")
+			continue
+		}
+		fileLogCtx.Debug("Manifest applied")
+		applied++
+	}
+	logCtx.WithFields(log.Fields{
+		"applied": applied,
+		"total":   len(files),
+	}).Info("User manifests applied")
+}
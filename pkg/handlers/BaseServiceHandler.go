@@ -28,6 +28,7 @@ import (
 	"net/http"
 	"net/url"
 	"os/exec"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -42,6 +43,12 @@ type StartHandler func() error
 // result
 type HealthCheckValidatorFunction func(result *io.ReadCloser) error
 
+// DefaultRestartBackoff is the delay before restarting a service that exited unexpectedly, doubled after each restart
+const DefaultRestartBackoff = time.Second
+
+// maxRestartBackoff is the maximum delay before restarting a service that exited unexpectedly
+const maxRestartBackoff = 30 * time.Second
+
 // BaseServiceHandler serves as a base type for all handlers in github.com/vs-eth/microkube/pkg/handlers,
 // bundling common functions
 type BaseServiceHandler struct {
@@ -51,6 +58,15 @@ type BaseServiceHandler struct {
 	healthCheck chan bool
 	// Number of service restart retires left
 	retriesLeft int
+	// Number of times the service was restarted after exiting unexpectedly. Accessed atomically
+	restarts int32
+	// Delay before the first restart after an unexpected exit, doubled after each restart
+	restartBackoff time.Duration
+	// Was Stop() called, that is, is an exit expected? Accessed atomically
+	stopped int32
+	// Is a restart requested by Restart() pending, that is, should the next exit start the service again? Accessed
+	// atomically
+	restartPending int32
 	// Exit handler, that is a function to be called after the final (retriesLeft == 0) exit
 	exit ExitHandler
 	// Health check 'validator' function. This should be implemented inside the other handlers. It gets called with the
@@ -58,6 +74,11 @@ type BaseServiceHandler struct {
 	healthCheckValidator HealthCheckValidatorFunction
 	// URL to send health checks to
 	healthCheckEndpoint string
+	// URLs of separate liveness and readiness probes, empty if healthCheckEndpoint is the only health check
+	livenessEndpoint, readinessEndpoint string
+	// Whether the service turned out not to serve the liveness and readiness probes, so that healthCheckEndpoint is
+	// used instead. Accessed atomically
+	splitProbesMissing int32
 	// Handler to be called if the user invokes Stop(). It is expected that other handlers use this pointer to provide
 	// a function that stops the actual process
 	stopHandler StopHandler
@@ -66,6 +87,8 @@ type BaseServiceHandler struct {
 	startHandler StartHandler
 	// CA and client certificate for health checks. Can be nil to disable TLS
 	ca, client *pki.RSACertificate
+	// Maximum time a single health check request may take
+	healthCheckTimeout time.Duration
 }
 
 // NewHandler creates a new helper handler. For detailed field descriptions, refer to the struct docs.
@@ -75,6 +98,8 @@ func NewHandler(exit ExitHandler, healthCheckValidator HealthCheckValidatorFunct
 		healthCheckRunning:   0,
 		healthCheck:          make(chan bool, 2),
 		retriesLeft:          1,
+		restartBackoff:       DefaultRestartBackoff,
+		healthCheckTimeout:   DefaultHealthCheckTimeout,
 		exit:                 exit,
 		healthCheckValidator: healthCheckValidator,
 		stopHandler:          stopHandler,
@@ -85,42 +110,100 @@ func NewHandler(exit ExitHandler, healthCheckValidator HealthCheckValidatorFunct
 	}
 }
 
-// healthCheckFun is the actual health check implementation. This function performs a single request against the
-// configured health check endpoint, passing the results to the healthCheckValidator
-func (handler *BaseServiceHandler) healthCheckFun() error {
-	var httpClient *http.Client
-	if handler.ca != nil {
-		caCert, err := ioutil.ReadFile(handler.ca.CertPath)
+// healthClients caches the HTTP clients used for health checks, indexed by the paths of CA and client certificate and
+// the timeout. Sharing them between services and probes allows reusing connections.
+var healthClients = make(map[string]*http.Client)
+
+// healthClientsLock guards healthClients
+var healthClientsLock sync.Mutex
+
+// healthClient returns the HTTP client for health checks using 'ca' and 'client', which can be nil to disable TLS
+// client authentication. Requests including reading the response are aborted after 'timeout'.
+func healthClient(ca, client *pki.RSACertificate, timeout time.Duration) (*http.Client, error) {
+	key := timeout.String()
+	if ca != nil {
+		key += ":" + ca.CertPath + ":" + client.CertPath
+	}
+	healthClientsLock.Lock()
+	defer healthClientsLock.Unlock()
+	if httpClient, ok := healthClients[key]; ok {
+		return httpClient, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if ca != nil {
+		caCert, err := ioutil.ReadFile(ca.CertPath)
 		if err != nil {
-			return errors.Wrap(err, "CA load from file failed")
+			return nil, errors.Wrap(err, "CA load from file failed")
 		}
-		clientCert, err := tls.LoadX509KeyPair(handler.client.CertPath, handler.client.KeyPath)
+		clientCert, err := tls.LoadX509KeyPair(client.CertPath, client.KeyPath)
 		if err != nil {
-			return errors.Wrap(err, "client cert load from file failed")
+			return nil, errors.Wrap(err, "client cert load from file failed")
 		}
 		caPool := x509.NewCertPool()
 		if !caPool.AppendCertsFromPEM(caCert) {
-			return errors.Wrap(err, "CA append to pool failed")
+			return nil, errors.New("CA append to pool failed")
 		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+		tlsConfig.RootCAs = caPool
+	}
+	httpClient := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			IdleConnTimeout: 2 * DefaultHealthCheckInterval,
+		},
+	}
+	healthClients[key] = httpClient
+	return httpClient, nil
+}
 
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				DisableKeepAlives: true,
-				TLSClientConfig: &tls.Config{
-					Certificates: []tls.Certificate{clientCert},
-					RootCAs:      caPool,
-				},
-			},
-		}
-	} else {
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				DisableKeepAlives: true,
-				TLSClientConfig:   &tls.Config{},
-			},
+// errProbeNotFound is returned by healthCheckFun if the service doesn't serve the probed endpoint
+var errProbeNotFound = errors.New("health probe endpoint not found")
+
+// SetProbeEndpoints lets the service be probed for liveness at 'liveness' and, only if that succeeds, for readiness at
+// 'readiness', instead of a single check of the health check endpoint. Results report which probe produced them. If
+// the service doesn't serve these endpoints (HTTP 404), e.g. because it's an older release, the health check endpoint
+// is used after all.
+func (handler *BaseServiceHandler) SetProbeEndpoints(liveness, readiness string) {
+	handler.livenessEndpoint = liveness
+	handler.readinessEndpoint = readiness
+}
+
+// ProbeHealth performs a single health probe, see interface HealthProber
+func (handler *BaseServiceHandler) ProbeHealth() HealthMessage {
+	if handler.livenessEndpoint == "" || atomic.LoadInt32(&handler.splitProbesMissing) == 1 {
+		err := handler.healthCheckFun(handler.healthCheckEndpoint)
+		return HealthMessage{
+			IsHealthy: err == nil,
+			Error:     err,
 		}
 	}
-	responseHTTP, err := httpClient.Get(handler.healthCheckEndpoint)
+	probe := HealthProbeLiveness
+	err := handler.healthCheckFun(handler.livenessEndpoint)
+	if err == nil {
+		probe = HealthProbeReadiness
+		err = handler.healthCheckFun(handler.readinessEndpoint)
+	}
+	if err == errProbeNotFound {
+		atomic.StoreInt32(&handler.splitProbesMissing, 1)
+		return handler.ProbeHealth()
+	}
+	return HealthMessage{
+		IsHealthy: err == nil,
+		Error:     err,
+		Probe:     probe,
+	}
+}
+
+// healthCheckFun is the actual health check implementation. This function performs a single request against
+// 'endpoint', passing the results to the healthCheckValidator
+func (handler *BaseServiceHandler) healthCheckFun(endpoint string) error {
+	httpClient, err := healthClient(handler.ca, handler.client, handler.healthCheckTimeout)
+	if err != nil {
+		return err
+	}
+	responseHTTP, err := httpClient.Get(endpoint)
 	// Backoff is doubled starting at .1 seconds until the limit of 7 seconds is exceeded
 	waitTime := 100 * time.Millisecond
 	for err != nil {
@@ -132,22 +215,50 @@ func (handler *BaseServiceHandler) healthCheckFun() error {
 						return errors.New("Timeout waiting for service to come up")
 					}
 					time.Sleep(waitTime)
-					responseHTTP, err = httpClient.Get(handler.healthCheckEndpoint)
+					responseHTTP, err = httpClient.Get(endpoint)
 					waitTime = 2 * waitTime
 					continue
 				}
 			}
+			// The service accepted the connection, but didn't respond in time
+			if uerr.Timeout() {
+				return errors.Errorf("Health check timed out after %s", handler.healthCheckTimeout)
+			}
 		}
 		return errors.Wrap(err, "Health check failed")
 	}
 	responseBin := responseHTTP.Body
 	defer responseBin.Close()
+	if responseHTTP.StatusCode == http.StatusNotFound {
+		return errProbeNotFound
+	}
 
 	return handler.healthCheckValidator(&responseBin)
 }
 
+// SetMaxRestarts lets the service be restarted up to 'maxRestarts' times when it exits unexpectedly, with an
+// exponential backoff starting at DefaultRestartBackoff. The exit handler is only called once no restarts are left.
+func (handler *BaseServiceHandler) SetMaxRestarts(maxRestarts int) {
+	handler.retriesLeft = maxRestarts + 1
+}
+
+// SetHealthCheckTimeout aborts health check requests that take longer than 'timeout', counting them as failed. 0 keeps
+// DefaultHealthCheckTimeout.
+func (handler *BaseServiceHandler) SetHealthCheckTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		handler.healthCheckTimeout = timeout
+	}
+}
+
+// Restarts returns the number of times the service was restarted after exiting unexpectedly, see interface
+// RestartCounter
+func (handler *BaseServiceHandler) Restarts() int {
+	return int(atomic.LoadInt32(&handler.restarts))
+}
+
 // Stop stops the service. See interface ServiceHandler.
 func (handler *BaseServiceHandler) Stop() {
+	atomic.StoreInt32(&handler.stopped, 1)
 	handler.stopHandler()
 	if atomic.LoadInt32(&handler.healthCheckRunning) == 1 {
 		// Notify goroutine of exit
@@ -155,17 +266,20 @@ func (handler *BaseServiceHandler) Stop() {
 	}
 }
 
+// Restart stops the service and starts it again once it has exited. The exit isn't passed to the exit handler and
+// doesn't count against the retries left.
+func (handler *BaseServiceHandler) Restart() {
+	atomic.StoreInt32(&handler.restartPending, 1)
+	handler.stopHandler()
+}
+
 // EnableHealthChecks enables health checks, see interface ServiceHandler.
 func (handler *BaseServiceHandler) EnableHealthChecks(messages chan HealthMessage, forever bool) {
 	if atomic.LoadInt32(&handler.healthCheckRunning) == 0 {
 		atomic.StoreInt32(&handler.healthCheckRunning, 1)
 		go func() {
 			for {
-				val := handler.healthCheckFun()
-				messages <- HealthMessage{
-					IsHealthy: val == nil,
-					Error:     val,
-				}
+				messages <- handler.ProbeHealth()
 				if !forever {
 					atomic.StoreInt32(&handler.healthCheckRunning, 0)
 					break
@@ -173,7 +287,7 @@ func (handler *BaseServiceHandler) EnableHealthChecks(messages chan HealthMessag
 				select {
 				case <-handler.healthCheck:
 					return
-				case <-time.After(10 * time.Second):
+				case <-time.After(DefaultHealthCheckInterval):
 					continue
 				}
 			}
@@ -183,10 +297,35 @@ func (handler *BaseServiceHandler) EnableHealthChecks(messages chan HealthMessag
 
 // HandleExit handles a process exit. Other handlers are expected to call this method on process exit
 func (handler *BaseServiceHandler) HandleExit(success bool, exitError *exec.ExitError) {
+	if atomic.CompareAndSwapInt32(&handler.restartPending, 1, 0) {
+		if handler.startHandler() != nil {
+			handler.exit(false, nil)
+		}
+		return
+	}
 	handler.retriesLeft--
-	if handler.retriesLeft > 0 {
-		handler.startHandler()
-	} else {
-		handler.exit(success, exitError)
+	if handler.retriesLeft > 0 && atomic.LoadInt32(&handler.stopped) == 0 {
+		time.Sleep(handler.restartDelay())
+		atomic.AddInt32(&handler.restarts, 1)
+		// The service might have been stopped while waiting
+		if atomic.LoadInt32(&handler.stopped) == 0 {
+			if handler.startHandler() != nil {
+				handler.exit(false, nil)
+			}
+			return
+		}
+	}
+	handler.exit(success, exitError)
+}
+
+// restartDelay returns the time to wait before the next restart after an unexpected exit
+func (handler *BaseServiceHandler) restartDelay() time.Duration {
+	delay := handler.restartBackoff
+	for i := int32(0); i < atomic.LoadInt32(&handler.restarts) && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartBackoff {
+		delay = maxRestartBackoff
 	}
+	return delay
 }
@@ -0,0 +1,329 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRestart checks whether a restart starts the service again without notifying the exit handler
+func TestRestart(t *testing.T) {
+	starts, exits := 0, 0
+	var handler *BaseServiceHandler
+	handler = NewHandler(func(success bool, exitError *exec.ExitError) {
+		exits++
+	}, nil, "", func() {
+		// Stopping the process makes it exit
+		handler.HandleExit(false, nil)
+	}, func() error {
+		starts++
+		return nil
+	}, nil, nil)
+
+	handler.Restart()
+	if starts != 1 || exits != 0 {
+		t.Fatalf("Unexpected restart behaviour: %d starts, %d exits", starts, exits)
+	}
+
+	// Further exits are handled as usual
+	handler.Stop()
+	if starts != 1 || exits != 1 {
+		t.Fatalf("Unexpected stop behaviour: %d starts, %d exits", starts, exits)
+	}
+}
+
+// flakyBinary is the fake binary used by TestRestartOnCrash. It counts its runs in the file passed to it and fails the
+// number of times given, then keeps running.
+const flakyBinary = `#!/bin/sh
+echo run >> "$1"
+if [ "$(wc -l < "$1")" -gt "$2" ]; then
+	exec sleep 30
+fi
+exit 1
+`
+
+// TestRestartOnCrash checks whether a crashing service is restarted until no restarts are left
+func TestRestartOnCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-restart")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	binary := path.Join(dir, "flaky")
+	err = ioutil.WriteFile(binary, []byte(flakyBinary), 0755)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name        string
+		failures    int
+		maxRestarts int
+		// Whether the exit handler should be called before the service is stopped
+		gaveUp bool
+	}{
+		{name: "recovers", failures: 2, maxRestarts: 2, gaveUp: false},
+		{name: "gives-up", failures: 3, maxRestarts: 2, gaveUp: true},
+		{name: "disabled", failures: 1, maxRestarts: 0, gaveUp: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			runsFile := path.Join(dir, testCase.name+".runs")
+			exits := make(chan bool, 10)
+			var handler *BaseServiceHandler
+			var cmd *exec.Cmd
+			lock := sync.Mutex{}
+			start := func() error {
+				lock.Lock()
+				defer lock.Unlock()
+				cmd = exec.Command(binary, runsFile, strconv.Itoa(testCase.failures))
+				err := cmd.Start()
+				if err != nil {
+					return err
+				}
+				go func(cmd *exec.Cmd) {
+					err := cmd.Wait()
+					exitError, _ := err.(*exec.ExitError)
+					handler.HandleExit(err == nil, exitError)
+				}(cmd)
+				return nil
+			}
+			handler = NewHandler(func(success bool, exitError *exec.ExitError) {
+				exits <- success
+			}, nil, "", func() {
+				lock.Lock()
+				defer lock.Unlock()
+				cmd.Process.Kill()
+			}, start, nil, nil)
+			handler.SetMaxRestarts(testCase.maxRestarts)
+			handler.restartBackoff = 10 * time.Millisecond
+			err := start()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			select {
+			case <-exits:
+				if !testCase.gaveUp {
+					t.Fatal("Service wasn't restarted")
+				}
+			case <-time.After(2 * time.Second):
+				if testCase.gaveUp {
+					t.Fatal("Exit handler wasn't called")
+				}
+			}
+			runs, err := ioutil.ReadFile(runsFile)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if count := strings.Count(string(runs), "\n"); count != testCase.maxRestarts+1 {
+				t.Fatalf("Unexpected number of runs: %d", count)
+			}
+			if restarts := handler.Restarts(); restarts != testCase.maxRestarts {
+				t.Fatalf("Unexpected number of restarts: %d", restarts)
+			}
+
+			if !testCase.gaveUp {
+				// Stopping the service isn't a crash
+				handler.Stop()
+				select {
+				case <-exits:
+				case <-time.After(2 * time.Second):
+					t.Fatal("Exit handler wasn't called")
+				}
+			}
+		})
+	}
+}
+
+// TestProbeEndpoints checks whether readiness is only probed once the service is live and whether the health check
+// endpoint is used if the service doesn't serve separate probes
+func TestProbeEndpoints(t *testing.T) {
+	responses := map[string]string{}
+	lock := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	setResponses := func(newResponses map[string]string) {
+		lock.Lock()
+		defer lock.Unlock()
+		responses = newResponses
+	}
+	validator := func(result *io.ReadCloser) error {
+		body, err := ioutil.ReadAll(*result)
+		if err != nil {
+			return err
+		}
+		if string(body) != "ok" {
+			return errors.New("not ok: " + string(body))
+		}
+		return nil
+	}
+
+	handler := NewHandler(nil, validator, server.URL+"/healthz", nil, nil, nil, nil)
+	handler.SetProbeEndpoints(server.URL+"/livez", server.URL+"/readyz")
+	testCases := []struct {
+		name      string
+		responses map[string]string
+		expected  HealthMessage
+	}{
+		{"ready", map[string]string{"/livez": "ok", "/readyz": "ok"},
+			HealthMessage{IsHealthy: true, Probe: HealthProbeReadiness}},
+		{"not ready", map[string]string{"/livez": "ok", "/readyz": "etcd failed"},
+			HealthMessage{IsHealthy: false, Probe: HealthProbeReadiness}},
+		{"not live", map[string]string{"/livez": "ping failed", "/readyz": "ok"},
+			HealthMessage{IsHealthy: false, Probe: HealthProbeLiveness}},
+		{"no probes", map[string]string{"/healthz": "ok"}, HealthMessage{IsHealthy: true}},
+		// Once a service turned out not to serve the probes, they aren't tried again
+		{"no probes anymore", map[string]string{"/livez": "ok", "/readyz": "ok", "/healthz": "not ok"},
+			HealthMessage{IsHealthy: false}},
+	}
+	for _, testCase := range testCases {
+		setResponses(testCase.responses)
+		msg := handler.ProbeHealth()
+		if msg.IsHealthy != testCase.expected.IsHealthy || msg.Probe != testCase.expected.Probe {
+			t.Fatalf("Unexpected result for case '%s': %+v", testCase.name, msg)
+		}
+		if !msg.IsHealthy && msg.Error == nil {
+			t.Fatalf("Error missing for case '%s'", testCase.name)
+		}
+	}
+}
+
+// TestHealthCheckTimeout checks whether a service that accepts connections but never responds is reported unhealthy
+func TestHealthCheckTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: '%s'", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Keep the connection open without ever responding
+			defer conn.Close()
+		}
+	}()
+
+	handler := NewHandler(nil, func(result *io.ReadCloser) error {
+		return nil
+	}, "http://"+listener.Addr().String()+"/healthz", nil, nil, nil, nil)
+	handler.SetHealthCheckTimeout(100 * time.Millisecond)
+	messages := make(chan HealthMessage, 1)
+	go func() {
+		messages <- handler.ProbeHealth()
+	}()
+	select {
+	case msg := <-messages:
+		if msg.IsHealthy || msg.Error == nil {
+			t.Fatalf("Unexpected result %+v, expected timeout", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Health check didn't time out")
+	}
+}
+
+// TestHealthCheckClientCert checks whether health checks authenticate with the client certificate against a service
+// that requires one
+func TestHealthCheckClientCert(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-health")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// ECDSA keys are fast enough to create for every test run
+	manager := pki.NewManagerWithKeyType(tmpdir, pki.KeyAlgorithmECDSAP256)
+	newCert := func(name string, isServer bool, ca *pki.RSACertificate) *pki.RSACertificate {
+		var cert *pki.RSACertificate
+		if ca == nil {
+			cert, err = manager.NewSelfSignedCACert(name, pkix.Name{CommonName: name}, 1, 0)
+		} else {
+			cert, err = manager.NewCert(name, pkix.Name{CommonName: name}, 2, isServer, !isServer,
+				[]string{"127.0.0.1"}, ca, 0)
+		}
+		if err != nil {
+			t.Fatalf("creation of certificate '%s' failed: '%s'", name, err)
+		}
+		return cert
+	}
+	ca := newCert("ca", false, nil)
+	server := newCert("server", true, ca)
+	client := newCert("client", false, ca)
+	otherCA := newCert("otherca", false, nil)
+	otherClient := newCert("otherclient", false, otherCA)
+
+	caPEM, err := ioutil.ReadFile(ca.CertPath)
+	if err != nil {
+		t.Fatalf("couldn't read CA: '%s'", err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(caPEM)
+	serverCert, err := tls.LoadX509KeyPair(server.CertPath, server.KeyPath)
+	if err != nil {
+		t.Fatalf("couldn't load server certificate: '%s'", err)
+	}
+	uut := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	uut.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	uut.StartTLS()
+	defer uut.Close()
+
+	validator := func(result *io.ReadCloser) error {
+		return nil
+	}
+	handler := NewHandler(nil, validator, uut.URL+"/healthz", nil, nil, ca, client)
+	if msg := handler.ProbeHealth(); !msg.IsHealthy {
+		t.Fatalf("Health check with client certificate failed: '%s'", msg.Error)
+	}
+	handler = NewHandler(nil, validator, uut.URL+"/healthz", nil, nil, ca, otherClient)
+	if msg := handler.ProbeHealth(); msg.IsHealthy {
+		t.Fatal("Health check with client certificate of another CA succeeded")
+	}
+}
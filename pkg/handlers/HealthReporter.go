@@ -0,0 +1,186 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthReportQueueLength is the number of health events that may wait for delivery before new ones are dropped
+const healthReportQueueLength = 100
+
+// healthReportCloseTimeout is the time Close waits for queued events to be delivered
+const healthReportCloseTimeout = 5 * time.Second
+
+// HealthEvent is the machine-readable result of a single health probe
+type HealthEvent struct {
+	// Name of the service, e.g. 'etcd'
+	Service string `json:"service"`
+	// Time the result was reported
+	Time time.Time `json:"time"`
+	// Whether the service is healthy
+	Healthy bool `json:"healthy"`
+	// Reason why the service is unhealthy, if known
+	Error string `json:"error,omitempty"`
+	// Probe that produced the result, empty if the service only has a single health check
+	Probe HealthProbe `json:"probe,omitempty"`
+}
+
+// HealthReporter publishes health probe results as JSON, e.g. for an external dashboard. Events are delivered in the
+// background so that a slow consumer doesn't delay health checks, events that don't fit into the queue are dropped.
+// A nil HealthReporter discards all events.
+type HealthReporter struct {
+	// Delivers a single JSON-encoded event
+	send func(event []byte) error
+	// Releases the resources used by 'send'
+	close func() error
+	// Events waiting for delivery, closed on Close
+	events chan []byte
+	// Closed once all queued events were delivered
+	done chan struct{}
+	// Guards 'closed'
+	lock   sync.Mutex
+	closed bool
+}
+
+// newHealthReporter creates a HealthReporter delivering events with 'send' and starts delivering
+func newHealthReporter(send func(event []byte) error, close func() error) *HealthReporter {
+	r := &HealthReporter{
+		send:   send,
+		close:  close,
+		events: make(chan []byte, healthReportQueueLength),
+		done:   make(chan struct{}),
+	}
+	go r.deliver()
+	return r
+}
+
+// NewStreamHealthReporter creates a HealthReporter writing events as JSON lines to 'out'
+func NewStreamHealthReporter(out io.WriteCloser) *HealthReporter {
+	return newHealthReporter(func(event []byte) error {
+		_, err := out.Write(append(event, '\n'))
+		return err
+	}, out.Close)
+}
+
+// NewHTTPHealthReporter creates a HealthReporter posting each event to 'url'
+func NewHTTPHealthReporter(url string) *HealthReporter {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return newHealthReporter(func(event []byte) error {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(event))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return errors.Errorf("unexpected status '%s'", resp.Status)
+		}
+		return nil
+	}, func() error {
+		return nil
+	})
+}
+
+// OpenHealthReporter creates a HealthReporter for 'target', which is either an HTTP(S) URL to post events to or a unix
+// socket to write JSON lines to. The consumer has to listen on the socket already.
+func OpenHealthReporter(target string) (*HealthReporter, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return NewHTTPHealthReporter(target), nil
+	}
+	conn, err := net.Dial("unix", target)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't connect to health event socket")
+	}
+	return NewStreamHealthReporter(conn), nil
+}
+
+// Report queues the result 'msg' of a health probe of 'service' for delivery
+func (r *HealthReporter) Report(service string, msg HealthMessage) {
+	if r == nil {
+		return
+	}
+	event := HealthEvent{
+		Service: service,
+		Time:    time.Now(),
+		Healthy: msg.IsHealthy,
+		Probe:   msg.Probe,
+	}
+	if msg.Error != nil {
+		event.Error = msg.Error.Error()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.events <- data:
+	default:
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "health",
+			"service":   service,
+		}).Debug("Health event queue full, dropping event")
+	}
+}
+
+// deliver sends queued events until the queue is closed
+func (r *HealthReporter) deliver() {
+	defer close(r.done)
+	for event := range r.events {
+		err := r.send(event)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"app":       "microkube",
+				"component": "health",
+			}).WithError(err).Debug("Couldn't deliver health event")
+		}
+	}
+}
+
+// Close delivers queued events, waiting at most healthReportCloseTimeout, and releases the socket, if any
+func (r *HealthReporter) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.events)
+	r.lock.Unlock()
+	select {
+	case <-r.done:
+	case <-time.After(healthReportCloseTimeout):
+	}
+	return r.close()
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// TestHealthReporterSocket checks whether health events are written to a unix socket as JSON lines
+func TestHealthReporterSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-health")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "health.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	uut, err := OpenHealthReporter(socket)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+	uut.Report("etcd", HealthMessage{IsHealthy: true})
+	uut.Report("kube-apiserver", HealthMessage{IsHealthy: false, Error: errors.New("connection refused")})
+	uut.Close()
+	// Reporting after closing is ignored
+	uut.Report("etcd", HealthMessage{IsHealthy: true})
+
+	var events []HealthEvent
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		event := HealthEvent{}
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatalf("Invalid event '%s': %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Unexpected number of events: %d", len(events))
+	}
+	if events[0].Service != "etcd" || !events[0].Healthy || events[0].Error != "" {
+		t.Fatalf("Unexpected event: %+v", events[0])
+	}
+	if events[1].Service != "kube-apiserver" || events[1].Healthy || events[1].Error != "connection refused" {
+		t.Fatalf("Unexpected event: %+v", events[1])
+	}
+	if time.Since(events[0].Time) > time.Minute {
+		t.Fatalf("Unexpected event time: %s", events[0].Time)
+	}
+
+	_, err = OpenHealthReporter(path.Join(dir, "missing.sock"))
+	if err == nil {
+		t.Fatal("Expected error missing for socket without listener")
+	}
+	// A nil reporter discards events
+	var disabled *HealthReporter
+	disabled.Report("etcd", HealthMessage{IsHealthy: true})
+	disabled.Close()
+}
+
+// TestHealthReporterHTTP checks whether health events are posted to an HTTP endpoint
+func TestHealthReporterHTTP(t *testing.T) {
+	events := make(chan HealthEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := HealthEvent{}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&event) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- event
+	}))
+	defer server.Close()
+
+	uut, err := OpenHealthReporter(server.URL + "/health")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	uut.Report("kubelet", HealthMessage{IsHealthy: true})
+	uut.Close()
+	select {
+	case event := <-events:
+		if event.Service != "kubelet" || !event.Healthy {
+			t.Fatalf("Unexpected event: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Event missing")
+	}
+}
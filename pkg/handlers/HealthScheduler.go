@@ -0,0 +1,152 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckInterval is the time between two health probes of a service
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// DefaultHealthCheckTimeout is the maximum time a single health probe request may take
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// HealthProber is implemented by service handlers that can probe their health synchronously. The HealthScheduler
+// uses this to run the probe on one of its workers instead of starting a goroutine for each probe.
+type HealthProber interface {
+	// ProbeHealth performs a single health probe
+	ProbeHealth() HealthMessage
+}
+
+// HealthResultHandler is called with the result of a health probe of service 'name'
+type HealthResultHandler func(name string, msg HealthMessage)
+
+// scheduledService is a service whose health is probed by a HealthScheduler
+type scheduledService struct {
+	name    string
+	handler ServiceHandler
+}
+
+// HealthScheduler probes the health of all services added to it on a shared ticker, using a fixed number of workers
+// for each round of probes. Results of a round of probes are passed to the result handler one after another once all
+// probes of that round are done, so the result handler doesn't need to be safe for concurrent use.
+type HealthScheduler struct {
+	// Time between two rounds of probes
+	interval time.Duration
+	// Maximum number of probes to run at the same time
+	workers int
+	// Called with the result of each probe
+	results HealthResultHandler
+	// Services to probe, guarded by 'lock'
+	services []scheduledService
+	lock     sync.Mutex
+	// Closed to stop probing
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHealthScheduler creates a HealthScheduler probing every 'interval' with at most 'workers' concurrent probes
+func NewHealthScheduler(interval time.Duration, workers int, results HealthResultHandler) *HealthScheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &HealthScheduler{
+		interval: interval,
+		workers:  workers,
+		results:  results,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Add adds service 'name' to the services probed in each round
+func (s *HealthScheduler) Add(name string, handler ServiceHandler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.services = append(s.services, scheduledService{
+		name:    name,
+		handler: handler,
+	})
+}
+
+// Start starts probing in the background. The first round of probes happens after one interval.
+func (s *HealthScheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.probeAll()
+			}
+		}
+	}()
+}
+
+// Stop stops probing. Results of a round that is already running may still be reported.
+func (s *HealthScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// probeAll runs one round of probes and reports the results
+func (s *HealthScheduler) probeAll() {
+	s.lock.Lock()
+	services := append([]scheduledService{}, s.services...)
+	s.lock.Unlock()
+
+	messages := make([]HealthMessage, len(services))
+	// A fixed number of workers takes the services to probe from a queue
+	queue := make(chan int, len(services))
+	for i := range services {
+		queue <- i
+	}
+	close(queue)
+	workers := s.workers
+	if workers > len(services) {
+		workers = len(services)
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				messages[i] = probe(services[i].handler)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, service := range services {
+		s.results(service.name, messages[i])
+	}
+}
+
+// probe performs a single health probe of 'handler'
+func probe(handler ServiceHandler) HealthMessage {
+	if prober, ok := handler.(HealthProber); ok {
+		return prober.ProbeHealth()
+	}
+	messages := make(chan HealthMessage, 1)
+	handler.EnableHealthChecks(messages, false)
+	return <-messages
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probeCountingHandler is a ServiceHandler whose probes take a while and track how many run at the same time
+type probeCountingHandler struct {
+	healthy bool
+	// Number of probes running right now and maximum seen so far, shared between handlers
+	running, maxRunning *int32
+}
+
+func (h probeCountingHandler) Start() error {
+	return nil
+}
+
+func (h probeCountingHandler) EnableHealthChecks(messages chan HealthMessage, forever bool) {
+	messages <- h.ProbeHealth()
+}
+
+func (h probeCountingHandler) Stop() {
+}
+
+func (h probeCountingHandler) ProbeHealth() HealthMessage {
+	running := atomic.AddInt32(h.running, 1)
+	defer atomic.AddInt32(h.running, -1)
+	for {
+		max := atomic.LoadInt32(h.maxRunning)
+		if running <= max || atomic.CompareAndSwapInt32(h.maxRunning, max, running) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !h.healthy {
+		return HealthMessage{Error: errors.New("unhealthy")}
+	}
+	return HealthMessage{IsHealthy: true}
+}
+
+// TestHealthScheduler checks whether all services are probed each round with a bounded number of concurrent probes
+func TestHealthScheduler(t *testing.T) {
+	running, maxRunning := int32(0), int32(0)
+	results := make(chan string, 100)
+	uut := NewHealthScheduler(50*time.Millisecond, 2, func(name string, msg HealthMessage) {
+		results <- name + ":" + strconv.FormatBool(msg.IsHealthy)
+	})
+	for i := 0; i < 5; i++ {
+		uut.Add("service-"+strconv.Itoa(i), probeCountingHandler{
+			healthy:    i != 3,
+			running:    &running,
+			maxRunning: &maxRunning,
+		})
+	}
+	uut.Start()
+	defer uut.Stop()
+
+	// Results of a round are reported in the order the services were added
+	for i := 0; i < 5; i++ {
+		expected := "service-" + strconv.Itoa(i) + ":" + strconv.FormatBool(i != 3)
+		select {
+		case result := <-results:
+			if result != expected {
+				t.Fatalf("Unexpected result '%s', expected '%s'", result, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Result missing")
+		}
+	}
+	if max := atomic.LoadInt32(&maxRunning); max > 2 || max < 1 {
+		t.Fatalf("Unexpected number of concurrent probes: %d", max)
+	}
+}
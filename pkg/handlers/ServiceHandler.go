@@ -19,6 +19,8 @@ package handlers
 import (
 	"net"
 	"os/exec"
+	"strconv"
+	"time"
 )
 
 // ExitHandler describes a function that is called when a process exits.
@@ -33,8 +35,21 @@ type HealthMessage struct {
 	IsHealthy bool
 	// If the service isn't healthy, is there a specific reason as to why?
 	Error error
+	// Probe that produced this result, empty if the service only has a single health check
+	Probe HealthProbe
 }
 
+// HealthProbe is the kind of health probe that produced a HealthMessage
+type HealthProbe string
+
+const (
+	// HealthProbeLiveness checks whether the process is up. If it fails, the service is broken.
+	HealthProbeLiveness HealthProbe = "liveness"
+	// HealthProbeReadiness checks whether the service can handle requests. It's only probed once the liveness probe
+	// succeeds, a failure usually means that the service is waiting for a dependency.
+	HealthProbeReadiness HealthProbe = "readiness"
+)
+
 // ServiceHandler handle some kind of running service. This interface is implemented by all service handlers below this
 // package
 type ServiceHandler interface {
@@ -48,16 +63,67 @@ type ServiceHandler interface {
 	Stop()
 }
 
+// ProcessHandler is implemented by service handlers that are backed by a local process
+type ProcessHandler interface {
+	// Pid returns the process ID of the running process, or 0 if it isn't running
+	Pid() int
+}
+
+// RestartableHandler is implemented by service handlers that can restart their service without stopping microkube
+type RestartableHandler interface {
+	// Restart stops the service and starts it again
+	Restart()
+}
+
+// OutputTailer is implemented by service handlers that keep the last lines their process printed
+type OutputTailer interface {
+	// LastOutput returns the most recent lines the process printed, oldest first
+	LastOutput() []string
+}
+
+// RestartCounter is implemented by service handlers that restart their service after unexpected exits
+type RestartCounter interface {
+	// Restarts returns the number of times the service was restarted after exiting unexpectedly
+	Restarts() int
+}
+
+// CommandLineBuilder is implemented by service handlers that can tell which command line they start their process with
+type CommandLineBuilder interface {
+	// BuildArgs returns the full command line (program first) the service would be started with
+	BuildArgs() []string
+}
+
 // ExecutionEnvironment describes the environment to execute something in
 type ExecutionEnvironment struct {
 	// Binary contains the full path to the program to run
 	Binary string
+	// Whether Binary is the standalone binary of a kubernetes component (e.g. kube-apiserver) instead of hyperkube,
+	// in which case it is run without hyperkube's subcommand. This is specific to a single service and therefore not
+	// copied by CopyInformationFromBase.
+	StandaloneBinary bool
 	// SudoMethod contains the binary to execute when running programs as root (sudo, pkexec, ...)
 	SudoMethod string
+	// Whether to run the kubelet in a user namespace instead of as root (experimental). SudoMethod isn't used then,
+	// and kube-proxy isn't started, as it can't set up iptables rules without root.
+	Rootless bool
+	// Path to rootlesskit, which runs the kubelet in a user namespace with slirp4netns networking if Rootless is set
+	RootlessKitBinary string
+	// RunAsUser is the user to run a program that doesn't need root as, empty for the current user. This is specific
+	// to a single service and therefore not copied by CopyInformationFromBase.
+	RunAsUser string
+	// Whether the service serves its health and metrics endpoints over HTTPS, refusing clients without a certificate
+	// of the kubernetes CA, so that they are probed using the KubeClient certificate instead of plain HTTP. This is
+	// specific to a single service and therefore not copied by CopyInformationFromBase.
+	SecureHealthProbe bool
 	// Workdir contains a path where an application may store it's data
 	Workdir string
 	// ListenAddress is the address to bind exposed services to
 	ListenAddress net.IP
+	// Address the apiserver binds to (e.g. 0.0.0.0 for remote kubectl), nil to use ListenAddress. It still advertises
+	// ListenAddress to the cluster.
+	KubeAPIBindAddress net.IP
+	// Address the controller-manager binds to, nil to use ListenAddress
+	KubeControllerManagerBindAddress net.IP
 	// ServiceAddress is the first address in the k8s service network, reserved for K8S API
 	ServiceAddress net.IP
 	// DNSAddress is the second address in the k8s service network, reserved for DNS
@@ -87,6 +153,81 @@ type ExecutionEnvironment struct {
 	KubeSchedulerHealthPort int
 	// Kube-scheduler metrics endpoint port
 	KubeSchedulerMetricsPort int
+
+	// Whether the kubelet should apply the RuntimeDefault seccomp profile to all pods that don't specify one
+	KubeletSeccompDefault bool
+	// Time the kubelet delays a node shutdown to terminate pods, 0 disables graceful node shutdown
+	KubeletShutdownGracePeriod time.Duration
+	// Part of KubeletShutdownGracePeriod that is reserved for terminating critical pods
+	KubeletShutdownGracePeriodCriticalPods time.Duration
+	// CPU manager policy of the kubelet ('none' or 'static'), empty for the kubelet default
+	KubeletCPUManagerPolicy string
+	// Topology manager policy of the kubelet (e.g. 'single-numa-node'), empty for the kubelet default
+	KubeletTopologyManagerPolicy string
+	// Resources the kubelet reserves for system daemons, as 'resource=quantity' list (e.g. 'cpu=500m,memory=512Mi')
+	KubeletSystemReserved string
+	// Resources the kubelet reserves for kubernetes components, in the same format as KubeletSystemReserved
+	KubeletKubeReserved string
+	// CRI endpoint of the container runtime the kubelet uses (e.g. 'unix:///run/containerd/containerd.sock'), empty
+	// to use docker
+	KubeletContainerRuntimeEndpoint string
+	// Whether to compact and defragment etcd automatically when it runs out of space
+	EtcdAutoDefrag bool
+	// Size of etcd's backend database at which it raises a space alarm and refuses writes, 0 for etcd's default (2 GB)
+	EtcdQuotaBytes int64
+	// Age of the revisions etcd compacts periodically, 0 to disable automatic compaction
+	EtcdAutoCompactionRetention time.Duration
+	// Path to the egress selector config of the apiserver, empty if apiserver-to-cluster traffic isn't proxied
+	KubeAPIEgressSelectorConfig string
+	// Path of the audit log the apiserver writes, empty if auditing is disabled
+	KubeAPIAuditLog string
+	// Path to the audit policy deciding which requests the apiserver records in KubeAPIAuditLog
+	KubeAPIAuditPolicy string
+	// Admission plugins to enable and disable in addition to the apiserver's defaults, empty to keep them
+	KubeAPIEnableAdmissionPlugins  []string
+	KubeAPIDisableAdmissionPlugins []string
+	// GOMAXPROCS to run etcd and the apiserver with, 0 to use the Go runtime's default (number of CPUs)
+	GoMaxProcs int
+	// Minimum timeout of long-running apiserver requests like watches, 0 for the apiserver's default
+	KubeAPIMinRequestTimeout time.Duration
+	// Maximum number of non-mutating requests the apiserver handles at once, 0 for the apiserver's default
+	KubeAPIMaxRequestsInflight int
+	// Maximum number of mutating requests the apiserver handles at once, 0 for the apiserver's default
+	KubeAPIMaxMutatingRequestsInflight int
+	// Probability with which the apiserver asks HTTP/2 clients to reconnect (GOAWAY), 0 to disable
+	KubeAPIGoawayChance float64
+	// Address (host:port) the apiserver connects to etcd at, empty for the etcd client port on localhost. This allows
+	// putting a proxy between the two.
+	KubeAPIEtcdAddress string
+	// Whether the apiserver, controller-manager, scheduler and kube-proxy serve pprof endpoints at /debug/pprof. If nil,
+	// the apiserver and controller-manager keep their default of serving them, the scheduler and kube-proxy don't.
+	Profiling *bool
+	// Whether the controller-manager and scheduler use leader election. A single node cluster only ever runs one
+	// instance of each, so this is off by default to save the time spent acquiring the lease.
+	EnableLeaderElection bool
+	// Feature gates to enable (true) or disable (false) in the apiserver, controller-manager, scheduler and kubelet,
+	// indexed by name (e.g. 'CSIBlockVolume')
+	FeatureGates map[string]bool
+	// Additional environment variables ('KEY=value') to pass to all services, e.g. 'ETCD_UNSUPPORTED_ARCH=arm64'.
+	// These take precedence over the ones derived from other settings, like GOMAXPROCS.
+	ExtraEnv []string
+	// Number of times a service is restarted after exiting unexpectedly before microkube gives up on it, 0 to never
+	// restart services
+	MaxRestarts int
+	// Maximum time a single health probe request may take, including reading the response, 0 for
+	// DefaultHealthCheckTimeout. A service that accepts the connection but doesn't respond in time is unhealthy.
+	HealthCheckTimeout time.Duration
+	// Number of most recent output lines each service keeps to log when it crashes, 0 to keep none. See
+	// helpers.DefaultOutputTailLines.
+	OutputTailLines int
+}
+
+// GoRuntimeEnv returns the environment variables to pass to Go-based services to apply the runtime settings of 'e'
+func (e *ExecutionEnvironment) GoRuntimeEnv() []string {
+	if e.GoMaxProcs > 0 {
+		return []string{"GOMAXPROCS=" + strconv.Itoa(e.GoMaxProcs)}
+	}
+	return nil
 }
 
 // InitPorts initializes the ports in 'e' starting from 'base'
@@ -103,7 +244,46 @@ func (e *ExecutionEnvironment) InitPorts(base int) {
 	e.KubeSchedulerMetricsPort = base + 9
 }
 
-// CopyInformationFromBase copies all ports, all addresses and the sudo method from 'o' to this structure
+// BindAddress returns the address a service with the per-service address 'override' (e.g. KubeAPIBindAddress) binds
+// to, which is ListenAddress unless 'override' is set
+func (e *ExecutionEnvironment) BindAddress(override net.IP) net.IP {
+	if override != nil {
+		return override
+	}
+	return e.ListenAddress
+}
+
+// ConnectAddress returns the address a service with the per-service address 'override' is reached at. This is its
+// bind address, or ListenAddress if it binds to all addresses.
+func (e *ExecutionEnvironment) ConnectAddress(override net.IP) net.IP {
+	address := e.BindAddress(override)
+	if address.IsUnspecified() {
+		return e.ListenAddress
+	}
+	return address
+}
+
+// PortCount is the number of consecutive ports InitPorts assigns
+const PortCount = 10
+
+// Ports returns all ports assigned to services in 'e', in the order InitPorts assigns them
+func (e *ExecutionEnvironment) Ports() []int {
+	return []int{
+		e.EtcdClientPort,
+		e.EtcdPeerPort,
+		e.KubeApiPort,
+		e.KubeNodeApiPort,
+		e.KubeControllerManagerPort,
+		e.KubeletHealthPort,
+		e.KubeProxyHealthPort,
+		e.KubeProxyMetricsPort,
+		e.KubeSchedulerHealthPort,
+		e.KubeSchedulerMetricsPort,
+	}
+}
+
+// CopyInformationFromBase copies all ports, all addresses, the sudo method, the rootless settings and the component
+// options from 'o' to this structure
 func (e *ExecutionEnvironment) CopyInformationFromBase(o *ExecutionEnvironment) {
 	// Ports
 	e.EtcdClientPort = o.EtcdClientPort
@@ -118,7 +298,41 @@ func (e *ExecutionEnvironment) CopyInformationFromBase(o *ExecutionEnvironment)
 	e.KubeSchedulerMetricsPort = o.KubeSchedulerMetricsPort
 
 	e.ListenAddress = o.ListenAddress
+	e.KubeAPIBindAddress = o.KubeAPIBindAddress
+	e.KubeControllerManagerBindAddress = o.KubeControllerManagerBindAddress
 	e.ServiceAddress = o.ServiceAddress
 	e.DNSAddress = o.DNSAddress
 	e.SudoMethod = o.SudoMethod
+	e.Rootless = o.Rootless
+	e.RootlessKitBinary = o.RootlessKitBinary
+
+	e.KubeletSeccompDefault = o.KubeletSeccompDefault
+	e.KubeletShutdownGracePeriod = o.KubeletShutdownGracePeriod
+	e.KubeletShutdownGracePeriodCriticalPods = o.KubeletShutdownGracePeriodCriticalPods
+	e.KubeletCPUManagerPolicy = o.KubeletCPUManagerPolicy
+	e.KubeletTopologyManagerPolicy = o.KubeletTopologyManagerPolicy
+	e.KubeletSystemReserved = o.KubeletSystemReserved
+	e.KubeletKubeReserved = o.KubeletKubeReserved
+	e.KubeletContainerRuntimeEndpoint = o.KubeletContainerRuntimeEndpoint
+	e.EtcdAutoDefrag = o.EtcdAutoDefrag
+	e.EtcdQuotaBytes = o.EtcdQuotaBytes
+	e.EtcdAutoCompactionRetention = o.EtcdAutoCompactionRetention
+	e.KubeAPIEgressSelectorConfig = o.KubeAPIEgressSelectorConfig
+	e.KubeAPIAuditLog = o.KubeAPIAuditLog
+	e.KubeAPIAuditPolicy = o.KubeAPIAuditPolicy
+	e.KubeAPIEnableAdmissionPlugins = o.KubeAPIEnableAdmissionPlugins
+	e.KubeAPIDisableAdmissionPlugins = o.KubeAPIDisableAdmissionPlugins
+	e.GoMaxProcs = o.GoMaxProcs
+	e.KubeAPIMinRequestTimeout = o.KubeAPIMinRequestTimeout
+	e.KubeAPIMaxRequestsInflight = o.KubeAPIMaxRequestsInflight
+	e.KubeAPIMaxMutatingRequestsInflight = o.KubeAPIMaxMutatingRequestsInflight
+	e.KubeAPIGoawayChance = o.KubeAPIGoawayChance
+	e.KubeAPIEtcdAddress = o.KubeAPIEtcdAddress
+	e.Profiling = o.Profiling
+	e.EnableLeaderElection = o.EnableLeaderElection
+	e.FeatureGates = o.FeatureGates
+	e.ExtraEnv = o.ExtraEnv
+	e.MaxRestarts = o.MaxRestarts
+	e.HealthCheckTimeout = o.HealthCheckTimeout
+	e.OutputTailLines = o.OutputTailLines
 }
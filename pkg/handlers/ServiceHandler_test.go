@@ -0,0 +1,52 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"net"
+	"testing"
+)
+
+// TestBindAddressFallback checks whether services bind to ListenAddress unless their address is overridden, and are
+// reached at ListenAddress if they bind to all addresses
+func TestBindAddressFallback(t *testing.T) {
+	base := ExecutionEnvironment{
+		ListenAddress:      net.ParseIP("10.0.0.5"),
+		KubeAPIBindAddress: net.ParseIP("0.0.0.0"),
+	}
+	base.KubeControllerManagerBindAddress = net.ParseIP("127.0.0.1")
+	uut := ExecutionEnvironment{}
+	uut.CopyInformationFromBase(&base)
+
+	for _, testCase := range []struct {
+		name             string
+		override         net.IP
+		bind, connection string
+	}{
+		{"default", nil, "10.0.0.5", "10.0.0.5"},
+		{"kube-apiserver", uut.KubeAPIBindAddress, "0.0.0.0", "10.0.0.5"},
+		{"kube-controller-manager", uut.KubeControllerManagerBindAddress, "127.0.0.1", "127.0.0.1"},
+	} {
+		if address := uut.BindAddress(testCase.override).String(); address != testCase.bind {
+			t.Fatalf("Unexpected bind address '%s' for %s, expected '%s'", address, testCase.name, testCase.bind)
+		}
+		if address := uut.ConnectAddress(testCase.override).String(); address != testCase.connection {
+			t.Fatalf("Unexpected connect address '%s' for %s, expected '%s'", address, testCase.name,
+				testCase.connection)
+		}
+	}
+}
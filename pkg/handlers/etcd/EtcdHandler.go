@@ -18,15 +18,27 @@
 package etcd
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"github.com/vs-eth/microkube/pkg/handlers"
 	"github.com/vs-eth/microkube/pkg/helpers"
 	"github.com/vs-eth/microkube/pkg/pki"
 	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// snapshotTimeout is how long taking or restoring a snapshot may take
+const snapshotTimeout = 2 * time.Minute
+
 // EtcdHandler takes care of running a single etcd listening on (hardcoded) localhost.
 type EtcdHandler struct {
 	// Base ref
@@ -38,6 +50,14 @@ type EtcdHandler struct {
 	datadir string
 	// etcd binary location
 	binary string
+	// etcdctl binary location, empty if unavailable
+	etcdctl string
+	// User to run the binary as, empty for the current user
+	runAsUser string
+	// Additional environment variables
+	env []string
+	// Number of output lines to keep for crash diagnostics, 0 to keep none
+	outputTailLines int
 	// Client port (currently hardcoded to 2379)
 	clientport int
 	// Peer port (currently hardcoded to 2380)
@@ -52,6 +72,18 @@ type EtcdHandler struct {
 	out handlers.OutputHandler
 	// Exit handler
 	exit handlers.ExitHandler
+	// CA and client certificate used for maintenance operations
+	ca, client *pki.RSACertificate
+	// Whether to automatically compact and defragment etcd if it runs out of space
+	autoDefrag bool
+	// Space quota of the backend database, 0 for etcd's default
+	quotaBytes int64
+	// Age of the revisions to compact periodically, 0 to disable automatic compaction
+	autoCompactionRetention time.Duration
+	// Maintenance client, created on first use
+	maintenance *MaintenanceClient
+	// Whether an automatic NOSPACE remediation is running, 1 if so. Accessed atomically
+	remediating int32
 }
 
 // NewEtcdHandler creates an EtcdHandler from the arguments provided
@@ -59,23 +91,57 @@ func NewEtcdHandler(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeC
 	obj := &EtcdHandler{
 		datadir:    execEnv.Workdir,
 		binary:     execEnv.Binary,
+		runAsUser:  execEnv.RunAsUser,
+		env:        append(execEnv.GoRuntimeEnv(), execEnv.ExtraEnv...),
 		clientport: execEnv.EtcdClientPort,
 		peerport:   execEnv.EtcdPeerPort,
 		servercert: creds.EtcdServer.CertPath,
 		serverkey:  creds.EtcdServer.KeyPath,
 		cacert:     creds.EtcdCA.CertPath,
-		cmd:        nil,
 		out:        execEnv.OutputHandler,
 		exit:       execEnv.ExitHandler,
+		ca:         creds.EtcdCA,
+		client:     creds.EtcdClient,
+		autoDefrag: execEnv.EtcdAutoDefrag,
+
+		quotaBytes:              execEnv.EtcdQuotaBytes,
+		autoCompactionRetention: execEnv.EtcdAutoCompactionRetention,
+		outputTailLines:         execEnv.OutputTailLines,
 	}
 	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun,
 		"https://localhost:"+strconv.Itoa(obj.clientport)+"/health", obj.stop, obj.Start, creds.EtcdCA, creds.EtcdClient)
+	obj.BaseServiceHandler.SetMaxRestarts(execEnv.MaxRestarts)
+	obj.BaseServiceHandler.SetHealthCheckTimeout(execEnv.HealthCheckTimeout)
+	// Created once, so that restarts only replace the process and never the handler other goroutines might be using
+	obj.cmd = helpers.NewCmdHandler(obj.binary, nil, obj.BaseServiceHandler.HandleExit, obj.out, obj.out)
+	obj.cmd.Env = obj.env
+	obj.cmd.OutputTailLines = obj.outputTailLines
 	return obj
 }
 
 // Start starts the process, see interface docs
 func (handler *EtcdHandler) Start() error {
-	handler.cmd = helpers.NewCmdHandler(handler.binary, []string{
+	handler.cmd.SetArgs(handler.args())
+	err := handler.cmd.RunAs(handler.runAsUser)
+	if err != nil {
+		return err
+	}
+	err = handler.cmd.CheckAccess([]string{handler.cacert, handler.servercert, handler.serverkey},
+		[]string{handler.datadir})
+	if err != nil {
+		return errors.Wrapf(err, "etcd can't run as '%s'", handler.runAsUser)
+	}
+	return handler.cmd.Start()
+}
+
+// BuildArgs returns the full command line etcd is started with, see interface handlers.CommandLineBuilder
+func (handler *EtcdHandler) BuildArgs() []string {
+	return append([]string{handler.binary}, handler.args()...)
+}
+
+// args returns the command line arguments of etcd
+func (handler *EtcdHandler) args() []string {
+	args := []string{
 		"--data-dir",
 		handler.datadir,
 		"--listen-peer-urls",
@@ -102,8 +168,112 @@ func (handler *EtcdHandler) Start() error {
 		handler.serverkey,
 		"--client-cert-auth",
 		"--peer-client-cert-auth",
-	}, handler.BaseServiceHandler.HandleExit, handler.out, handler.out)
-	return handler.cmd.Start()
+	}
+	if handler.quotaBytes > 0 {
+		args = append(args, "--quota-backend-bytes", strconv.FormatInt(handler.quotaBytes, 10))
+	}
+	if handler.autoCompactionRetention > 0 {
+		args = append(args, "--auto-compaction-retention", compactionRetention(handler.autoCompactionRetention))
+	}
+	return args
+}
+
+// compactionRetention formats 'retention' for --auto-compaction-retention. etcd 3.2 only understands whole hours,
+// which are therefore passed as number. Other durations require etcd 3.3 or newer.
+func compactionRetention(retention time.Duration) string {
+	if retention%time.Hour == 0 {
+		return strconv.Itoa(int(retention / time.Hour))
+	}
+	return retention.String()
+}
+
+// SetEtcdctl sets the path of the etcdctl binary used for taking and restoring snapshots
+func (handler *EtcdHandler) SetEtcdctl(etcdctl string) {
+	handler.etcdctl = etcdctl
+}
+
+// Snapshot saves a snapshot of the running etcd to 'snapshotPath' using etcdctl. This connects to the client port
+// using the etcd client certificate.
+func (handler *EtcdHandler) Snapshot(snapshotPath string) error {
+	return handler.runEtcdctl("save", handler.snapshotArgs(snapshotPath))
+}
+
+// RestoreSnapshot restores the snapshot at 'snapshotPath' into the empty data directory 'dataDir' using etcdctl. This
+// has to happen before etcd is started. The restored member is configured like the etcd started by this handler.
+func (handler *EtcdHandler) RestoreSnapshot(snapshotPath, dataDir string) error {
+	err := os.MkdirAll(dataDir, 0770)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create data directory")
+	}
+	entries, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read data directory")
+	}
+	if len(entries) > 0 {
+		return errors.Errorf("data directory %s isn't empty, refusing to overwrite it", dataDir)
+	}
+	// etcdctl refuses to restore into an existing directory, which the data directory might have to be (e.g. a
+	// tmpfs mount point), so the data is moved into place afterwards
+	restoreDir := path.Join(dataDir, "restore")
+	defer os.RemoveAll(restoreDir)
+	err = handler.runEtcdctl("restore", handler.restoreArgs(snapshotPath, restoreDir))
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.Rename(path.Join(restoreDir, "member"), path.Join(dataDir, "member")),
+		"couldn't move restored data into place")
+}
+
+// runEtcdctl runs etcdctl with 'args', 'action' describes what it does for error messages
+func (handler *EtcdHandler) runEtcdctl(action string, args []string) error {
+	if handler.etcdctl == "" {
+		return errors.New("etcdctl binary unknown")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, handler.etcdctl, args...)
+	// etcdctl defaults to the v2 API, which doesn't support snapshots
+	cmd.Env = append(os.Environ(), "ETCDCTL_API=3")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "etcdctl snapshot %s failed: %s", action, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// restoreArgs returns the etcdctl arguments to restore the snapshot 'snapshotPath' into 'dataDir'. The member name
+// and peer URLs have to match the ones etcd is started with.
+func (handler *EtcdHandler) restoreArgs(snapshotPath, dataDir string) []string {
+	return []string{
+		"snapshot",
+		"restore",
+		snapshotPath,
+		"--data-dir",
+		dataDir,
+		"--name",
+		"default",
+		"--initial-cluster",
+		"default=https://localhost:" + strconv.Itoa(handler.peerport),
+		"--initial-advertise-peer-urls",
+		"https://localhost:" + strconv.Itoa(handler.peerport),
+	}
+}
+
+// snapshotArgs returns the etcdctl arguments to save a snapshot to 'snapshotPath'
+func (handler *EtcdHandler) snapshotArgs(snapshotPath string) []string {
+	return []string{
+		"--endpoints",
+		"https://localhost:" + strconv.Itoa(handler.clientport),
+		"--cacert",
+		handler.ca.CertPath,
+		"--cert",
+		handler.client.CertPath,
+		"--key",
+		handler.client.KeyPath,
+		"snapshot",
+		"save",
+		snapshotPath,
+	}
 }
 
 // Stop the child process
@@ -113,6 +283,22 @@ func (handler *EtcdHandler) stop() {
 	}
 }
 
+// Pid returns the process ID of the child process, see interface handlers.ProcessHandler
+func (handler *EtcdHandler) Pid() int {
+	if handler.cmd == nil {
+		return 0
+	}
+	return handler.cmd.Pid()
+}
+
+// LastOutput returns the last lines the process printed, see interface handlers.OutputTailer
+func (handler *EtcdHandler) LastOutput() []string {
+	if handler.cmd == nil {
+		return nil
+	}
+	return handler.cmd.LastOutput()
+}
+
 // Handle result of a health probe
 func (handler *EtcdHandler) healthCheckFun(responseBin *io.ReadCloser) error {
 	type EtcdStatus struct {
@@ -124,11 +310,58 @@ func (handler *EtcdHandler) healthCheckFun(responseBin *io.ReadCloser) error {
 		return errors.Wrap(err, "JSON decode of response failed")
 	}
 	if response.Health != "true" {
-		return errors.Wrap(err, "etcd is unhealthy!")
+		// etcd also reports itself as unhealthy while an alarm is raised. NOSPACE is the most common one and
+		// deserves special treatment since etcd won't recover from it on it's own
+		if handler.checkNoSpace() {
+			return ErrNoSpace
+		}
+		return errors.New("etcd is unhealthy!")
 	}
 	return nil
 }
 
+// checkNoSpace checks whether the NOSPACE alarm is raised, logging how to resolve it or starting to resolve it in the
+// background if enabled. It returns whether the alarm is still active, which it is until the remediation is done.
+func (handler *EtcdHandler) checkNoSpace() bool {
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "etcd",
+		"alarm":     "NOSPACE",
+	})
+	if handler.maintenance == nil {
+		maintenance, err := NewMaintenanceClient("https://localhost:"+strconv.Itoa(handler.clientport),
+			handler.ca, handler.client)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't create etcd maintenance client")
+			return false
+		}
+		handler.maintenance = maintenance
+	}
+	active, err := handler.maintenance.NoSpaceAlarmActive()
+	if err != nil || !active {
+		return false
+	}
+	if !handler.autoDefrag {
+		logCtx.WithField("remediation", NoSpaceRemediation).Error("etcd ran out of space and is read-only!")
+		return true
+	}
+	// Compacting and defragmenting may take minutes, which mustn't hold up the health checks of the other services
+	if !atomic.CompareAndSwapInt32(&handler.remediating, 0, 1) {
+		return true
+	}
+	logCtx.Warn("etcd ran out of space, compacting and defragmenting...")
+	go func() {
+		defer atomic.StoreInt32(&handler.remediating, 0)
+		err := handler.maintenance.RemediateNoSpace()
+		if err != nil {
+			logCtx.WithError(err).WithField("remediation", NoSpaceRemediation).Error("Automatic remediation failed!")
+			return
+		}
+		logCtx.Info("etcd recovered from NOSPACE alarm")
+	}()
+	return true
+}
+
 // EtcdHandlerConstructor is supposed to be only used for testing
 func EtcdHandlerConstructor(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials) ([]handlers.ServiceHandler, error) {
 	handler := NewEtcdHandler(execEnv, creds)
@@ -18,8 +18,15 @@ package etcd
 
 import (
 	"github.com/vs-eth/microkube/pkg/helpers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Test whether etcd actually starts correctly
@@ -41,3 +48,81 @@ func TestEtcdStartup(t *testing.T) {
 		item.Stop()
 	}
 }
+
+// TestEtcdSnapshotArgs checks whether snapshots are taken from the client port using the client certificate
+func TestEtcdSnapshotArgs(t *testing.T) {
+	uut := EtcdHandler{
+		clientport: 7000,
+		ca:         &pki.RSACertificate{CertPath: "/tmp/etcdtls/ca.pem"},
+		client:     &pki.RSACertificate{CertPath: "/tmp/etcdtls/client.pem", KeyPath: "/tmp/etcdtls/client.key"},
+	}
+	expected := []string{"--endpoints", "https://localhost:7000", "--cacert", "/tmp/etcdtls/ca.pem", "--cert",
+		"/tmp/etcdtls/client.pem", "--key", "/tmp/etcdtls/client.key", "snapshot", "save", "/tmp/snapshot.db"}
+	if args := uut.snapshotArgs("/tmp/snapshot.db"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Unexpected arguments: %v", args)
+	}
+	if err := uut.Snapshot("/tmp/snapshot.db"); err == nil {
+		t.Fatal("Snapshot without etcdctl succeeded")
+	}
+}
+
+// TestEtcdRestoreSnapshot checks whether restores match etcd's configuration and never overwrite existing data
+func TestEtcdRestoreSnapshot(t *testing.T) {
+	uut := EtcdHandler{
+		peerport: 7001,
+		etcdctl:  "/bin/false",
+	}
+	expected := []string{"snapshot", "restore", "/tmp/snapshot.db", "--data-dir", "/tmp/etcddata/restore", "--name",
+		"default", "--initial-cluster", "default=https://localhost:7001", "--initial-advertise-peer-urls",
+		"https://localhost:7001"}
+	if args := uut.restoreArgs("/tmp/snapshot.db", "/tmp/etcddata/restore"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Unexpected arguments: %v", args)
+	}
+
+	dataDir, err := ioutil.TempDir("", "microkube-unittests-etcdrestore")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(dataDir)
+	err = uut.RestoreSnapshot("/tmp/snapshot.db", dataDir)
+	if err == nil {
+		t.Fatal("Failed etcdctl not reported")
+	}
+	if _, err := os.Stat(path.Join(dataDir, "restore")); !os.IsNotExist(err) {
+		t.Fatalf("Restore directory left behind: '%v'", err)
+	}
+	err = ioutil.WriteFile(path.Join(dataDir, "member"), nil, 0600)
+	if err != nil {
+		t.Fatalf("data creation failed: '%s'", err)
+	}
+	uut.etcdctl = "/bin/true"
+	err = uut.RestoreSnapshot("/tmp/snapshot.db", dataDir)
+	if err == nil {
+		t.Fatal("Existing data overwritten")
+	}
+}
+
+// TestEtcdSpaceArgs checks whether quota and compaction are only passed if set, with whole hours as plain number
+func TestEtcdSpaceArgs(t *testing.T) {
+	uut := EtcdHandler{}
+	args := strings.Join(uut.args(), " ")
+	if strings.Contains(args, "--quota-backend-bytes") || strings.Contains(args, "--auto-compaction-retention") {
+		t.Fatalf("Unexpected space settings: %s", args)
+	}
+
+	uut.quotaBytes = 8 * 1024 * 1024 * 1024
+	uut.autoCompactionRetention = 2 * time.Hour
+	args = strings.Join(uut.args(), " ")
+	if !strings.Contains(args, "--quota-backend-bytes 8589934592") {
+		t.Fatalf("Quota missing: %s", args)
+	}
+	if !strings.Contains(args, "--auto-compaction-retention 2") {
+		t.Fatalf("Compaction retention missing: %s", args)
+	}
+
+	uut.autoCompactionRetention = 30 * time.Minute
+	args = strings.Join(uut.args(), " ")
+	if !strings.Contains(args, "--auto-compaction-retention 30m0s") {
+		t.Fatalf("Compaction retention missing: %s", args)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NoSpaceRemediation describes how to recover from a NOSPACE alarm manually
+const NoSpaceRemediation = "compact the keyspace to the current revision, defragment and then disarm the alarm " +
+	"('etcdctl compact <rev>', 'etcdctl defrag', 'etcdctl alarm disarm') or restart with -etcd-auto-defrag"
+
+// ErrNoSpace is returned by health checks while etcd's NOSPACE alarm is active
+var ErrNoSpace = errors.New("etcd NOSPACE alarm active (mvcc: database space exceeded)")
+
+// gatewayPrefixes are the paths etcd's JSON gRPC gateway might be served at, newest first. etcd 3.2 only serves
+// '/v3alpha', 3.3 '/v3alpha' and '/v3beta', 3.4 '/v3beta' and '/v3' and 3.5 only '/v3'.
+var gatewayPrefixes = []string{"/v3", "/v3beta", "/v3alpha"}
+
+// MaintenanceClient performs maintenance operations against etcd using it's JSON gRPC gateway, which avoids pulling in
+// the full etcd client
+type MaintenanceClient struct {
+	// Base URL of etcd's client endpoint
+	endpoint string
+	// HTTP client configured for TLS client authentication
+	httpClient *http.Client
+
+	// Protects prefix
+	lock sync.Mutex
+	// Path of the gateway (one of gatewayPrefixes) once it is known, empty before
+	prefix string
+}
+
+// NewMaintenanceClient creates a MaintenanceClient for the etcd instance at 'endpoint' (e.g. https://localhost:2379),
+// authenticating using 'client' and validating the server against 'ca'
+func NewMaintenanceClient(endpoint string, ca, client *pki.RSACertificate) (*MaintenanceClient, error) {
+	caCert, err := ioutil.ReadFile(ca.CertPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "CA load from file failed")
+	}
+	clientCert, err := tls.LoadX509KeyPair(client.CertPath, client.KeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "client cert load from file failed")
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("CA append to pool failed")
+	}
+	return &MaintenanceClient{
+		endpoint: endpoint,
+		httpClient: &http.Client{
+			// Defragmentation blocks until it's done
+			Timeout: 2 * time.Minute,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates: []tls.Certificate{clientCert},
+					RootCAs:      caPool,
+				},
+			},
+		},
+	}, nil
+}
+
+// call POSTs 'request' as JSON to the gateway path 'method' and decodes the response into 'response' (if not nil).
+// Until the gateway's path is known, each of gatewayPrefixes is tried.
+func (c *MaintenanceClient) call(method string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "request encoding failed")
+	}
+	c.lock.Lock()
+	prefixes := gatewayPrefixes
+	if c.prefix != "" {
+		prefixes = []string{c.prefix}
+	}
+	c.lock.Unlock()
+	var resp *http.Response
+	for _, prefix := range prefixes {
+		resp, err = c.httpClient.Post(c.endpoint+prefix+"/"+method, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, method+" failed")
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			c.lock.Lock()
+			c.prefix = prefix
+			c.lock.Unlock()
+			break
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.New(method + " failed: no gateway found at " + c.endpoint)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(method + " failed: " + resp.Status + ": " + string(msg))
+	}
+	if response == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(response), "response decoding failed")
+}
+
+// alarmMember describes an active alarm as returned by the gateway
+type alarmMember struct {
+	// Encoded as string since it's a 64 bit integer
+	MemberID string `json:"memberID"`
+	Alarm    string `json:"alarm"`
+}
+
+// alarms returns all active alarms
+func (c *MaintenanceClient) alarms() ([]alarmMember, error) {
+	response := struct {
+		Alarms []alarmMember `json:"alarms"`
+	}{}
+	err := c.call("maintenance/alarm", map[string]string{"action": "GET"}, &response)
+	return response.Alarms, err
+}
+
+// NoSpaceAlarmActive checks whether etcd's NOSPACE alarm is currently raised
+func (c *MaintenanceClient) NoSpaceAlarmActive() (bool, error) {
+	alarms, err := c.alarms()
+	if err != nil {
+		return false, err
+	}
+	for _, alarm := range alarms {
+		if alarm.Alarm == "NOSPACE" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RemediateNoSpace recovers from a NOSPACE alarm by compacting all history, defragmenting the database and
+// disarming the alarm afterwards
+func (c *MaintenanceClient) RemediateNoSpace() error {
+	// Reading any key returns the current revision in the header
+	rangeResponse := struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+	}{}
+	err := c.call("kv/range", map[string]string{"key": "AA=="}, &rangeResponse)
+	if err != nil {
+		return err
+	}
+	revision, err := strconv.ParseInt(rangeResponse.Header.Revision, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid revision")
+	}
+	err = c.call("kv/compaction", map[string]interface{}{"revision": revision, "physical": true}, nil)
+	if err != nil {
+		return err
+	}
+	err = c.call("maintenance/defragment", map[string]string{}, nil)
+	if err != nil {
+		return err
+	}
+
+	alarms, err := c.alarms()
+	if err != nil {
+		return err
+	}
+	for _, alarm := range alarms {
+		if alarm.Alarm != "NOSPACE" {
+			continue
+		}
+		err = c.call("maintenance/alarm", map[string]string{
+			"action":   "DEACTIVATE",
+			"memberID": alarm.MemberID,
+			"alarm":    "NOSPACE",
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
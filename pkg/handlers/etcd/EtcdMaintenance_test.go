@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGateway serves the maintenance calls of etcd's gateway at 'prefix', recording the paths of all requests in
+// 'calls'. 'defragment' is called before a defragmentation returns.
+func fakeGateway(t *testing.T, prefix string, calls *[]string, defragment func()) *httptest.Server {
+	alarmActive := true
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&request)
+		*calls = append(*calls, r.URL.Path)
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, prefix) {
+		case "/kv/range":
+			w.Write([]byte(`{"header":{"revision":"42"}}`))
+			return
+		case "/kv/compaction":
+			if request["revision"] != float64(42) {
+				t.Errorf("Unexpected compaction revision: %v", request["revision"])
+			}
+		case "/maintenance/defragment":
+			defragment()
+		case "/maintenance/alarm":
+			if request["action"] == "DEACTIVATE" {
+				alarmActive = false
+			} else if alarmActive {
+				w.Write([]byte(`{"alarms":[{"memberID":"1234","alarm":"NOSPACE"}]}`))
+				return
+			}
+		}
+		w.Write([]byte("{}"))
+	}))
+}
+
+// TestRemediateNoSpace checks whether the NOSPACE remediation issues the expected gateway calls, whichever path the
+// gateway of the etcd release is served at
+func TestRemediateNoSpace(t *testing.T) {
+	for _, prefix := range gatewayPrefixes {
+		var calls []string
+		server := fakeGateway(t, prefix, &calls, func() {})
+		defer server.Close()
+
+		uut := &MaintenanceClient{
+			endpoint:   server.URL,
+			httpClient: server.Client(),
+		}
+		active, err := uut.NoSpaceAlarmActive()
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", prefix, err)
+		}
+		if !active {
+			t.Fatalf("Alarm not detected for %s", prefix)
+		}
+		calls = nil
+		err = uut.RemediateNoSpace()
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", prefix, err)
+		}
+		expected := []string{
+			prefix + "/kv/range",
+			prefix + "/kv/compaction",
+			prefix + "/maintenance/defragment",
+			prefix + "/maintenance/alarm",
+			prefix + "/maintenance/alarm",
+		}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Unexpected calls: %v", calls)
+		}
+		active, err = uut.NoSpaceAlarmActive()
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", prefix, err)
+		}
+		if active {
+			t.Fatalf("Alarm still active for %s", prefix)
+		}
+	}
+}
+
+// TestRemediateNoSpaceNoGateway checks whether a missing gateway is reported
+func TestRemediateNoSpaceNoGateway(t *testing.T) {
+	var calls []string
+	server := fakeGateway(t, "/v2", &calls, func() {})
+	defer server.Close()
+	uut := &MaintenanceClient{
+		endpoint:   server.URL,
+		httpClient: server.Client(),
+	}
+	_, err := uut.NoSpaceAlarmActive()
+	if err == nil {
+		t.Fatal("Missing gateway not reported")
+	}
+}
+
+// TestCheckNoSpaceBackground checks whether the automatic remediation runs in the background and only once at a time
+func TestCheckNoSpaceBackground(t *testing.T) {
+	var calls []string
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	server := fakeGateway(t, "/v3", &calls, func() {
+		started <- struct{}{}
+		<-release
+	})
+	defer server.Close()
+
+	uut := &EtcdHandler{
+		autoDefrag: true,
+		maintenance: &MaintenanceClient{
+			endpoint:   server.URL,
+			httpClient: server.Client(),
+		},
+	}
+	if !uut.checkNoSpace() {
+		t.Fatal("Alarm not reported while remediating")
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Remediation didn't start")
+	}
+	if !uut.checkNoSpace() {
+		t.Fatal("Alarm not reported while remediating")
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for uut.checkNoSpace() {
+		if time.Now().After(deadline) {
+			t.Fatal("Alarm still active after remediation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(started) != 0 {
+		t.Fatal("Remediation ran more than once")
+	}
+}
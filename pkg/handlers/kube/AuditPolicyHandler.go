@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
+	"io"
+)
+
+// defaultAuditPolicy records the metadata of all requests and the bodies of all changes, except for secrets and config
+// maps, whose contents might be sensitive. Health checks and the kubelet's node heartbeats are skipped, they would
+// drown everything else.
+const defaultAuditPolicy = `apiVersion: audit.k8s.io/v1beta1
+kind: Policy
+omitStages:
+- RequestReceived
+rules:
+- level: None
+  nonResourceURLs:
+  - /healthz*
+  - /version
+- level: None
+  userGroups:
+  - system:nodes
+  verbs:
+  - get
+  - update
+  - patch
+  resources:
+  - group: ""
+    resources:
+    - nodes
+    - nodes/status
+  - group: coordination.k8s.io
+    resources:
+    - leases
+- level: Metadata
+  resources:
+  - group: ""
+    resources:
+    - secrets
+    - configmaps
+- level: RequestResponse
+  verbs:
+  - create
+  - update
+  - patch
+  - delete
+  - deletecollection
+- level: Metadata
+`
+
+// CreateDefaultAuditPolicy writes the audit policy used if the user doesn't supply one to 'path'
+func CreateDefaultAuditPolicy(path string) error {
+	return atomicfile.Write(path, 0644, func(w io.Writer) error {
+		_, err := io.WriteString(w, defaultAuditPolicy)
+		return err
+	})
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io"
+	"strconv"
+	"text/template"
+)
+
+// KonnectivityServerPort is the port the konnectivity server accepts apiserver connections on. This has to match
+// manifests/konnectivity.yml.
+const KonnectivityServerPort = 8131
+
+// EgressSelectorV1beta1Version is the first kubernetes release that reads egress selector configs as
+// apiserver.k8s.io/v1beta1, 1.18 and 1.19 only know apiserver.k8s.io/v1alpha1
+var EgressSelectorV1beta1Version = helpers.BinaryVersion{Major: 1, Minor: 20}
+
+// egressSelectorConfigData contains data used when templating an egress selector config. For internal use only.
+type egressSelectorConfigData struct {
+	APIVersion string
+	URL        string
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+}
+
+// CreateKonnectivityEgressConfig creates an egress selector config that routes all apiserver-to-cluster traffic
+// through the konnectivity server deployed by the konnectivity addon and stores it in 'path'. The config uses the API
+// version the apiserver of 'version' understands, a zero 'version' (unknown) is treated as an older release.
+func CreateKonnectivityEgressConfig(path string, creds *pki.MicrokubeCredentials, execEnv handlers.ExecutionEnvironment,
+	version helpers.BinaryVersion) error {
+
+	data := egressSelectorConfigData{
+		APIVersion: "apiserver.k8s.io/v1alpha1",
+		URL:        "https://" + execEnv.ListenAddress.String() + ":" + strconv.Itoa(KonnectivityServerPort),
+		CAFile:     creds.KubeCA.CertPath,
+		CertFile:   creds.KubeClient.CertPath,
+		KeyFile:    creds.KubeClient.KeyPath,
+	}
+	if !version.Less(EgressSelectorV1beta1Version) {
+		data.APIVersion = "apiserver.k8s.io/v1beta1"
+	}
+	tmplStr := `apiVersion: {{ .APIVersion }}
+kind: EgressSelectorConfiguration
+egressSelections:
+- name: cluster
+  connection:
+    proxyProtocol: HTTPConnect
+    transport:
+      tcp:
+        url: "{{ .URL }}"
+        tlsConfig:
+          caBundle: "{{ .CAFile }}"
+          clientCert: "{{ .CertFile }}"
+          clientKey: "{{ .KeyFile }}"
+`
+	tmpl, err := template.New("EgressSelector").Parse(tmplStr)
+	if err != nil {
+		return errors.Wrap(err, "template init failed")
+	}
+	return atomicfile.Write(path, 0644, func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	})
+}
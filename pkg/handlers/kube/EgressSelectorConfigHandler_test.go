@@ -0,0 +1,65 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestCreateKonnectivityEgressConfig tests whether the egress selector config uses the API version of the apiserver
+func TestCreateKonnectivityEgressConfig(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-egress")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	creds := &pki.MicrokubeCredentials{
+		KubeCA:     &pki.RSACertificate{CertPath: "/tmp/ca.pem"},
+		KubeClient: &pki.RSACertificate{CertPath: "/tmp/client.pem", KeyPath: "/tmp/client.key"},
+	}
+	execEnv := handlers.ExecutionEnvironment{ListenAddress: net.ParseIP("10.0.0.1")}
+	testCases := []struct {
+		version    helpers.BinaryVersion
+		apiVersion string
+	}{
+		{version: helpers.BinaryVersion{}, apiVersion: "apiserver.k8s.io/v1alpha1"},
+		{version: helpers.BinaryVersion{Major: 1, Minor: 18, Patch: 3}, apiVersion: "apiserver.k8s.io/v1alpha1"},
+		{version: helpers.BinaryVersion{Major: 1, Minor: 20}, apiVersion: "apiserver.k8s.io/v1beta1"},
+	}
+	for _, tc := range testCases {
+		config := path.Join(tmpdir, "egress-selector.yaml")
+		if err := CreateKonnectivityEgressConfig(config, creds, execEnv, tc.version); err != nil {
+			t.Fatalf("config creation failed: %s", err)
+		}
+		data, err := ioutil.ReadFile(config)
+		if err != nil {
+			t.Fatalf("couldn't read config: %s", err)
+		}
+		assert.True(t, strings.HasPrefix(string(data), "apiVersion: "+tc.apiVersion+"\n"),
+			"wrong API version for %s", tc.version)
+		assert.Contains(t, string(data), "url: \"https://10.0.0.1:8131\"", "wrong konnectivity server URL")
+	}
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// featureGatesArgs returns the '--feature-gates' argument enabling or disabling 'gates', none if 'gates' is empty
+func featureGatesArgs(gates map[string]bool) []string {
+	if len(gates) == 0 {
+		return nil
+	}
+	var items []string
+	for gate, enabled := range gates {
+		items = append(items, gate+"="+strconv.FormatBool(enabled))
+	}
+	// Keep the command line stable, map iteration order is random
+	sort.Strings(items)
+	return []string{"--feature-gates=" + strings.Join(items, ",")}
+}
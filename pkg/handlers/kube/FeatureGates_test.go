@@ -0,0 +1,48 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"testing"
+)
+
+// TestFeatureGatesArgs checks whether the same feature gates are passed to all components
+func TestFeatureGatesArgs(t *testing.T) {
+	assert.Empty(t, featureGatesArgs(nil), "flag set without feature gates")
+
+	base := handlers.ExecutionEnvironment{FeatureGates: map[string]bool{
+		"PodShareProcessNamespace": true,
+		"CSIBlockVolume":           true,
+		"TaintBasedEvictions":      false,
+	}}
+	execEnv := handlers.ExecutionEnvironment{}
+	execEnv.CopyInformationFromBase(&base)
+	expected := "--feature-gates=CSIBlockVolume=true,PodShareProcessNamespace=true,TaintBasedEvictions=false"
+
+	components := map[string][]string{
+		"kube-apiserver":          (&KubeAPIServerHandler{featureGates: execEnv.FeatureGates}).args(),
+		"kube-controller-manager": (&ControllerManagerHandler{featureGates: execEnv.FeatureGates}).args(),
+		"kube-scheduler":          (&KubeSchedulerHandler{featureGates: execEnv.FeatureGates}).args(),
+		"kubelet": (&KubeletHandler{featureGates: execEnv.FeatureGates,
+			containerRuntimeEndpoint: "unix:///run/containerd/containerd.sock"}).args(),
+	}
+	for name, args := range components {
+		assert.Contains(t, args, expected, "wrong feature gates for "+name)
+	}
+}
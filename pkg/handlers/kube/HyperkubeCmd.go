@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers"
+	"net"
+	"strconv"
+)
+
+// hyperkubeEnv contains the settings for invoking hyperkube that all kube handlers share
+type hyperkubeEnv struct {
+	// Path to hyperkube binary
+	binary string
+	// Whether binary is the standalone binary of the component instead of hyperkube, which needs no subcommand
+	standalone bool
+	// Path to some sudo-like binary to run hyperkube with, empty to run it directly
+	sudoBin string
+	// Command line (binary first) of rootlesskit to run hyperkube in a user namespace with, empty to run it directly.
+	// Not used together with sudoBin.
+	rootlessKit []string
+	// User to run hyperkube as, empty for the current user. Not used together with sudoBin.
+	runAsUser string
+	// Additional environment variables
+	env []string
+	// Number of output lines to keep for crash diagnostics, 0 to keep none
+	outputTailLines int
+}
+
+// kubeletPort is the port the kubelet serves it's API on, the apiserver connects to it there
+const kubeletPort = 10250
+
+// rootlessKitCommandLine returns the rootlesskit command line to run a component in rootless mode with. The component
+// becomes root in a new user namespace, slirp4netns gives it network access without a privileged helper, and the
+// directories the kubelet and the container runtime write to are copied up so that they are writable inside the
+// namespace. The kubelet's health and API ports are forwarded from the host.
+func rootlessKitCommandLine(execEnv handlers.ExecutionEnvironment) []string {
+	return []string{
+		execEnv.RootlessKitBinary,
+		"--net=slirp4netns",
+		"--copy-up=/etc",
+		"--copy-up=/run",
+		"--copy-up=/var/lib",
+		"--propagation=rslave",
+		"--port-driver=builtin",
+		"--publish=127.0.0.1:" + strconv.Itoa(execEnv.KubeletHealthPort) + ":" +
+			strconv.Itoa(execEnv.KubeletHealthPort) + "/tcp",
+		"--publish=" + net.JoinHostPort(execEnv.ListenAddress.String(), strconv.Itoa(kubeletPort)) + ":" +
+			strconv.Itoa(kubeletPort) + "/tcp",
+	}
+}
+
+// newHyperkubeEnv extracts the hyperkube settings from 'execEnv'. Components that need root privileges (kubelet,
+// kube-proxy) are started using the sudo method instead of running as a specific user, or using rootlesskit in
+// rootless mode.
+func newHyperkubeEnv(execEnv handlers.ExecutionEnvironment, privileged bool) hyperkubeEnv {
+	env := hyperkubeEnv{
+		binary:          execEnv.Binary,
+		standalone:      execEnv.StandaloneBinary,
+		env:             execEnv.ExtraEnv,
+		outputTailLines: execEnv.OutputTailLines,
+	}
+	if privileged && execEnv.Rootless {
+		env.rootlessKit = rootlessKitCommandLine(execEnv)
+	} else if privileged {
+		env.sudoBin = execEnv.SudoMethod
+	} else {
+		env.runAsUser = execEnv.RunAsUser
+	}
+	return env
+}
+
+// newHyperkubeCmd creates a command handler running hyperkube's 'subcommand', see start. Settings that apply to every
+// kube component belong here, so that they only need to be added once. Handlers create it once, so that restarts only
+// replace the process and never the command handler other goroutines might be using.
+func newHyperkubeCmd(subcommand string, env hyperkubeEnv, exit handlers.ExitHandler,
+	out handlers.OutputHandler) *helpers.CmdHandler {
+
+	cmd := helpers.NewCmdHandler(env.commandLine(subcommand, nil)[0], nil, exit, out, out)
+	cmd.Env = env.env
+	cmd.OutputTailLines = env.outputTailLines
+	return cmd
+}
+
+// start starts 'cmd', created by newHyperkubeCmd, running hyperkube's 'subcommand' with 'args'. When running as another
+// user, that user has to be able to read the files in 'read' and write the paths in 'write'.
+func (env hyperkubeEnv) start(cmd *helpers.CmdHandler, subcommand string, args, read, write []string) error {
+	cmd.SetArgs(env.commandLine(subcommand, args)[1:])
+	err := cmd.RunAs(env.runAsUser)
+	if err != nil {
+		return err
+	}
+	err = cmd.CheckAccess(read, write)
+	if err != nil {
+		return errors.Wrapf(err, "%s can't run as '%s'", subcommand, env.runAsUser)
+	}
+	return cmd.Start()
+}
+
+// commandLine returns the full command line (binary first) running hyperkube's 'subcommand' with 'args'. A standalone
+// binary is run with 'args' only.
+func (env hyperkubeEnv) commandLine(subcommand string, args []string) []string {
+	commandLine := append([]string{env.binary, subcommand}, args...)
+	if env.standalone {
+		commandLine = append([]string{env.binary}, args...)
+	}
+	if len(env.rootlessKit) > 0 {
+		commandLine = append(append([]string{}, env.rootlessKit...), commandLine...)
+	}
+	if env.sudoBin != "" {
+		commandLine = append([]string{env.sudoBin}, commandLine...)
+	}
+	return commandLine
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"net"
+	"testing"
+)
+
+// TestNewHyperkubeEnv checks whether privileged components use the sudo method instead of a specific user
+func TestNewHyperkubeEnv(t *testing.T) {
+	execEnv := handlers.ExecutionEnvironment{
+		Binary:     "/usr/bin/hyperkube",
+		SudoMethod: "/usr/bin/pkexec",
+		RunAsUser:  "nobody",
+		ExtraEnv:   []string{"FOO=bar"},
+	}
+	assert.Equal(t, hyperkubeEnv{binary: "/usr/bin/hyperkube", runAsUser: "nobody", env: []string{"FOO=bar"}},
+		newHyperkubeEnv(execEnv, false), "wrong unprivileged environment")
+	assert.Equal(t, hyperkubeEnv{binary: "/usr/bin/hyperkube", sudoBin: "/usr/bin/pkexec", env: []string{"FOO=bar"}},
+		newHyperkubeEnv(execEnv, true), "wrong privileged environment")
+
+	execEnv.Rootless = true
+	execEnv.RootlessKitBinary = "/usr/bin/rootlesskit"
+	execEnv.ListenAddress = net.ParseIP("10.0.0.1")
+	execEnv.KubeletHealthPort = 7007
+	rootless := newHyperkubeEnv(execEnv, true)
+	assert.Empty(t, rootless.sudoBin, "sudo method used in rootless mode")
+	assert.Equal(t, "/usr/bin/rootlesskit", rootless.rootlessKit[0], "rootlesskit not used")
+	assert.Contains(t, rootless.rootlessKit, "--publish=127.0.0.1:7007:7007/tcp", "health port not forwarded")
+	assert.Contains(t, rootless.rootlessKit, "--publish=10.0.0.1:10250:10250/tcp", "kubelet port not forwarded")
+	assert.Equal(t, "nobody", newHyperkubeEnv(execEnv, false).runAsUser, "rootless mode changed unprivileged environment")
+
+	unknownUser := hyperkubeEnv{binary: "/usr/bin/hyperkube", runAsUser: "microkube-user-that-does-not-exist"}
+	err := unknownUser.start(newHyperkubeCmd("kube-scheduler", unknownUser, nil, nil), "kube-scheduler", nil, nil,
+		nil)
+	assert.Error(t, err, "unknown user accepted")
+	cmd := newHyperkubeCmd("kubelet", hyperkubeEnv{binary: "/usr/bin/hyperkube", sudoBin: "/usr/bin/pkexec",
+		env: []string{"GOMAXPROCS=2"}, outputTailLines: 50}, nil, nil)
+	assert.Equal(t, []string{"GOMAXPROCS=2"}, cmd.Env, "environment not passed on")
+	assert.Equal(t, 50, cmd.OutputTailLines, "output tail size not passed on")
+	cmd = newHyperkubeCmd("kubelet", hyperkubeEnv{binary: "/usr/bin/hyperkube"}, nil, nil)
+	assert.Equal(t, 0, cmd.OutputTailLines, "output tail kept although disabled")
+}
+
+// TestHyperkubeBuildArgs checks whether the printed command line matches the one the process is started with
+func TestHyperkubeBuildArgs(t *testing.T) {
+	env := hyperkubeEnv{binary: "/usr/bin/hyperkube", sudoBin: "/usr/bin/pkexec"}
+	assert.Equal(t, []string{"/usr/bin/pkexec", "/usr/bin/hyperkube", "kubelet", "--v", "2"},
+		env.commandLine("kubelet", []string{"--v", "2"}), "wrong privileged command line")
+	env.sudoBin = ""
+	assert.Equal(t, []string{"/usr/bin/hyperkube", "kube-proxy"}, env.commandLine("kube-proxy", nil),
+		"wrong unprivileged command line")
+	rootless := hyperkubeEnv{
+		binary:      "/usr/bin/hyperkube",
+		rootlessKit: []string{"/usr/bin/rootlesskit", "--net=slirp4netns"},
+	}
+	assert.Equal(t, []string{"/usr/bin/rootlesskit", "--net=slirp4netns", "/usr/bin/hyperkube", "kubelet"},
+		rootless.commandLine("kubelet", nil), "wrong rootless command line")
+	standalone := newHyperkubeEnv(handlers.ExecutionEnvironment{Binary: "/usr/bin/kubelet", StandaloneBinary: true,
+		SudoMethod: "/usr/bin/pkexec"}, true)
+	assert.Equal(t, []string{"/usr/bin/pkexec", "/usr/bin/kubelet", "--v", "2"},
+		standalone.commandLine("kubelet", []string{"--v", "2"}), "wrong standalone command line")
+
+	uut := KubeSchedulerHandler{hyperkube: env, config: "/tmp/kubesched/config.yaml"}
+	assert.Equal(t, []string{"/usr/bin/hyperkube", "kube-scheduler", "--config", "/tmp/kubesched/config.yaml"},
+		uut.BuildArgs(), "wrong scheduler command line")
+	var _ handlers.CommandLineBuilder = &uut
+}
@@ -27,6 +27,7 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // KubeAPIServerHandler handles invocation of the kubernetes apiserver
@@ -36,8 +37,8 @@ type KubeAPIServerHandler struct {
 	// command exec helper
 	cmd *helpers.CmdHandler
 
-	// Kube-apiserver binary location
-	binary string
+	// How to invoke hyperkube
+	hyperkube hyperkubeEnv
 	// Path to kube-apiserver certificate
 	kubeServerCert string
 	// Path to kube-apiserver certificate key
@@ -48,6 +49,11 @@ type KubeAPIServerHandler struct {
 	kubeClientKey string
 	// Path to CA used to sign the above certificates
 	kubeCACert string
+	// Path to the client certificate used to proxy requests to extension apiservers, empty to disable the aggregation
+	// layer
+	aggregatorClientCert string
+	// Path to the key matching the aggregator client certificate
+	aggregatorClientKey string
 	// Path to etcd ca
 	etcdCACert string
 	// Path to a client certificate allowed to access etcd
@@ -60,8 +66,10 @@ type KubeAPIServerHandler struct {
 	svcKey string
 	// Output handler
 	out handlers.OutputHandler
-	// Listen address
-	listenAddress string
+	// Address to bind to
+	bindAddress string
+	// Address to advertise to the cluster
+	advertiseAddress string
 	// Service network in CIDR notation
 	serviceNet string
 	// API listen port
@@ -70,33 +78,92 @@ type KubeAPIServerHandler struct {
 	kubeNodeApiPort int
 	// ETCD client port
 	etcdClientPort int
+	// Address of etcd (host:port) if it isn't reached via the client port on localhost
+	etcdAddress string
+	// Path to the egress selector config, empty if none
+	egressSelectorConfig string
+	// Path of the audit log, empty if auditing is disabled
+	auditLog string
+	// Path to the audit policy
+	auditPolicy string
+	// OpenID Connect authentication settings, disabled if empty
+	oidc OIDCConfig
+	// Admission plugins to enable and disable in addition to the defaults
+	enableAdmissionPlugins  []string
+	disableAdmissionPlugins []string
+	// Request limits, 0 for the apiserver's defaults
+	minRequestTimeout           time.Duration
+	maxRequestsInflight         int
+	maxMutatingRequestsInflight int
+	goawayChance                float64
+	// Whether to serve pprof endpoints, nil for the default
+	profiling *bool
+	// Feature gates to enable or disable
+	featureGates map[string]bool
 }
 
-// NewKubeAPIServerHandler creates a KubeAPIServerHandler from the arguments provided
-func NewKubeAPIServerHandler(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials, serviceNet string) *KubeAPIServerHandler {
+// NewKubeAPIServerHandler creates a KubeAPIServerHandler from the arguments provided. Fails if 'oidc' is invalid or an
+// admission plugin is both enabled and disabled in 'execEnv'.
+func NewKubeAPIServerHandler(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials, serviceNet string,
+	oidc OIDCConfig) (*KubeAPIServerHandler, error) {
+	err := oidc.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateAdmissionPlugins(execEnv.KubeAPIEnableAdmissionPlugins, execEnv.KubeAPIDisableAdmissionPlugins)
+	if err != nil {
+		return nil, err
+	}
 	obj := &KubeAPIServerHandler{
-		binary:          execEnv.Binary,
-		kubeServerCert:  creds.KubeServer.CertPath,
-		kubeServerKey:   creds.KubeServer.KeyPath,
-		kubeClientCert:  creds.KubeClient.CertPath,
-		kubeClientKey:   creds.KubeClient.KeyPath,
-		kubeCACert:      creds.KubeCA.CertPath,
-		etcdClientCert:  creds.EtcdClient.CertPath,
-		etcdClientKey:   creds.EtcdClient.KeyPath,
-		etcdCACert:      creds.EtcdCA.CertPath,
-		cmd:             nil,
-		out:             execEnv.OutputHandler,
-		listenAddress:   execEnv.ListenAddress.String(),
-		serviceNet:      serviceNet,
-		svcCert:         creds.KubeSvcSignCert.CertPath,
-		svcKey:          creds.KubeSvcSignCert.KeyPath,
-		kubeApiPort:     execEnv.KubeApiPort,
-		kubeNodeApiPort: execEnv.KubeNodeApiPort,
-		etcdClientPort:  execEnv.EtcdClientPort,
-	}
-	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun,
-		"https://"+obj.listenAddress+":"+strconv.Itoa(execEnv.KubeApiPort)+"/healthz", obj.stop, obj.Start, creds.KubeCA, creds.KubeClient)
-	return obj
+		hyperkube:        newHyperkubeEnv(execEnv, false),
+		kubeServerCert:   creds.KubeServer.CertPath,
+		kubeServerKey:    creds.KubeServer.KeyPath,
+		kubeClientCert:   creds.KubeClient.CertPath,
+		kubeClientKey:    creds.KubeClient.KeyPath,
+		kubeCACert:       creds.KubeCA.CertPath,
+		etcdClientCert:   creds.EtcdClient.CertPath,
+		etcdClientKey:    creds.EtcdClient.KeyPath,
+		etcdCACert:       creds.EtcdCA.CertPath,
+		out:              execEnv.OutputHandler,
+		bindAddress:      execEnv.BindAddress(execEnv.KubeAPIBindAddress).String(),
+		advertiseAddress: execEnv.ListenAddress.String(),
+		serviceNet:       serviceNet,
+		svcCert:          creds.KubeSvcSignCert.CertPath,
+		svcKey:           creds.KubeSvcSignCert.KeyPath,
+		kubeApiPort:      execEnv.KubeApiPort,
+		kubeNodeApiPort:  execEnv.KubeNodeApiPort,
+		etcdClientPort:   execEnv.EtcdClientPort,
+		etcdAddress:      execEnv.KubeAPIEtcdAddress,
+
+		egressSelectorConfig:        execEnv.KubeAPIEgressSelectorConfig,
+		auditLog:                    execEnv.KubeAPIAuditLog,
+		auditPolicy:                 execEnv.KubeAPIAuditPolicy,
+		minRequestTimeout:           execEnv.KubeAPIMinRequestTimeout,
+		maxRequestsInflight:         execEnv.KubeAPIMaxRequestsInflight,
+		maxMutatingRequestsInflight: execEnv.KubeAPIMaxMutatingRequestsInflight,
+		goawayChance:                execEnv.KubeAPIGoawayChance,
+		profiling:                   execEnv.Profiling,
+		featureGates:                execEnv.FeatureGates,
+		oidc:                        oidc,
+		enableAdmissionPlugins:      execEnv.KubeAPIEnableAdmissionPlugins,
+		disableAdmissionPlugins:     execEnv.KubeAPIDisableAdmissionPlugins,
+	}
+	if creds.KubeAggregatorClient != nil {
+		obj.aggregatorClientCert = creds.KubeAggregatorClient.CertPath
+		obj.aggregatorClientKey = creds.KubeAggregatorClient.KeyPath
+	}
+	baseURL := "https://" + execEnv.ConnectAddress(execEnv.KubeAPIBindAddress).String() + ":" +
+		strconv.Itoa(execEnv.KubeApiPort)
+	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun, baseURL+"/healthz",
+		obj.stop, obj.Start, creds.KubeCA, creds.KubeClient)
+	// The apiserver is live once it serves requests, but only ready once e.g. etcd is reachable and the bootstrap
+	// controllers are done. Releases before 1.16 only serve /healthz.
+	obj.BaseServiceHandler.SetProbeEndpoints(baseURL+"/livez", baseURL+"/readyz")
+	obj.BaseServiceHandler.SetMaxRestarts(execEnv.MaxRestarts)
+	obj.BaseServiceHandler.SetHealthCheckTimeout(execEnv.HealthCheckTimeout)
+	obj.hyperkube.env = append(execEnv.GoRuntimeEnv(), execEnv.ExtraEnv...)
+	obj.cmd = newHyperkubeCmd("kube-apiserver", obj.hyperkube, obj.BaseServiceHandler.HandleExit, obj.out)
+	return obj, nil
 }
 
 // Stop the child process
@@ -106,8 +173,70 @@ func (handler *KubeAPIServerHandler) stop() {
 	}
 }
 
+// Pid returns the process ID of the child process, see interface handlers.ProcessHandler
+func (handler *KubeAPIServerHandler) Pid() int {
+	if handler.cmd == nil {
+		return 0
+	}
+	return handler.cmd.Pid()
+}
+
+// LastOutput returns the last lines the process printed, see interface handlers.OutputTailer
+func (handler *KubeAPIServerHandler) LastOutput() []string {
+	if handler.cmd == nil {
+		return nil
+	}
+	return handler.cmd.LastOutput()
+}
+
+// etcdServer returns the address (host:port) to connect to etcd at
+func (handler *KubeAPIServerHandler) etcdServer() string {
+	if handler.etcdAddress != "" {
+		return handler.etcdAddress
+	}
+	return "127.0.0.1:" + strconv.Itoa(handler.etcdClientPort)
+}
+
 // Start starts the process, see interface docs
 func (handler *KubeAPIServerHandler) Start() error {
+	read, write := handler.accessedFiles()
+	return handler.hyperkube.start(handler.cmd, "kube-apiserver", handler.args(), read, write)
+}
+
+// accessedFiles returns the files kube-apiserver reads and the paths it writes
+func (handler *KubeAPIServerHandler) accessedFiles() (read, write []string) {
+	read = []string{
+		handler.kubeServerCert,
+		handler.kubeServerKey,
+		handler.kubeClientCert,
+		handler.kubeClientKey,
+		handler.kubeCACert,
+		handler.etcdCACert,
+		handler.etcdClientCert,
+		handler.etcdClientKey,
+		handler.svcCert,
+		handler.svcKey,
+	}
+	if handler.aggregatorClientCert != "" {
+		read = append(read, handler.aggregatorClientCert, handler.aggregatorClientKey)
+	}
+	if handler.egressSelectorConfig != "" {
+		read = append(read, handler.egressSelectorConfig)
+	}
+	if handler.auditLog != "" {
+		read = append(read, handler.auditPolicy)
+		write = append(write, handler.auditLog)
+	}
+	return read, write
+}
+
+// BuildArgs returns the full command line kube-apiserver is started with, see interface handlers.CommandLineBuilder
+func (handler *KubeAPIServerHandler) BuildArgs() []string {
+	return handler.hyperkube.commandLine("kube-apiserver", handler.args())
+}
+
+// args returns the command line arguments of kube-apiserver
+func (handler *KubeAPIServerHandler) args() []string {
 	lowerSVCPort := 7000
 	upperSVCPort := 9000
 	ports := []int{
@@ -123,10 +252,11 @@ func (handler *KubeAPIServerHandler) Start() error {
 			lowerSVCPort = port - 100
 		}
 	}
-	handler.cmd = helpers.NewCmdHandler(handler.binary, []string{
-		"kube-apiserver",
+	args := []string{
 		"--bind-address",
-		handler.listenAddress,
+		handler.bindAddress,
+		"--advertise-address",
+		handler.advertiseAddress,
 		"--secure-port",
 		strconv.Itoa(handler.kubeApiPort),
 		"--kubernetes-service-node-port",
@@ -149,7 +279,7 @@ func (handler *KubeAPIServerHandler) Start() error {
 		"--etcd-keyfile",
 		handler.etcdClientKey,
 		"--etcd-servers",
-		"https://127.0.0.1:" + strconv.Itoa(handler.etcdClientPort),
+		"https://" + handler.etcdServer(),
 		"--kubelet-certificate-authority",
 		handler.kubeCACert,
 		"--kubelet-client-certificate",
@@ -166,8 +296,91 @@ func (handler *KubeAPIServerHandler) Start() error {
 		handler.svcKey,
 		"--insecure-port", // This is deprecated, but until it is removed it defaults to 8080
 		"0",
-	}, handler.BaseServiceHandler.HandleExit, handler.out, handler.out)
-	return handler.cmd.Start()
+	}
+	args = append(args, profilingArgs(handler.profiling)...)
+	args = append(args, featureGatesArgs(handler.featureGates)...)
+	args = append(args, handler.aggregationArgs()...)
+	if handler.egressSelectorConfig != "" {
+		args = append(args, "--egress-selector-config-file", handler.egressSelectorConfig)
+	}
+	args = append(args, handler.auditArgs()...)
+	args = append(args, handler.oidc.args()...)
+	if len(handler.enableAdmissionPlugins) > 0 {
+		args = append(args, "--enable-admission-plugins", strings.Join(handler.enableAdmissionPlugins, ","))
+	}
+	if len(handler.disableAdmissionPlugins) > 0 {
+		args = append(args, "--disable-admission-plugins", strings.Join(handler.disableAdmissionPlugins, ","))
+	}
+	if handler.minRequestTimeout > 0 {
+		args = append(args, "--min-request-timeout", strconv.Itoa(int(handler.minRequestTimeout.Seconds())))
+	}
+	if handler.maxRequestsInflight > 0 {
+		args = append(args, "--max-requests-inflight", strconv.Itoa(handler.maxRequestsInflight))
+	}
+	if handler.maxMutatingRequestsInflight > 0 {
+		args = append(args, "--max-mutating-requests-inflight", strconv.Itoa(handler.maxMutatingRequestsInflight))
+	}
+	if handler.goawayChance > 0 {
+		args = append(args, "--goaway-chance", strconv.FormatFloat(handler.goawayChance, 'f', -1, 64))
+	}
+	return args
+}
+
+// aggregationArgs returns the command line arguments enabling the aggregation layer, if there is an aggregator client
+// certificate. Extension apiservers trust requests proxied with that certificate, which is signed by the kubernetes CA.
+// Requests are sent to the endpoints of their services directly, as the service network might not be routed on the
+// host yet.
+func (handler *KubeAPIServerHandler) aggregationArgs() []string {
+	if handler.aggregatorClientCert == "" {
+		return nil
+	}
+	return []string{
+		"--requestheader-client-ca-file",
+		handler.kubeCACert,
+		"--requestheader-allowed-names",
+		pki.AggregatorClientName,
+		"--requestheader-username-headers",
+		"X-Remote-User",
+		"--requestheader-group-headers",
+		"X-Remote-Group",
+		"--requestheader-extra-headers-prefix",
+		"X-Remote-Extra-",
+		"--proxy-client-cert-file",
+		handler.aggregatorClientCert,
+		"--proxy-client-key-file",
+		handler.aggregatorClientKey,
+		"--enable-aggregator-routing=true",
+	}
+}
+
+// ValidateAdmissionPlugins checks whether no admission plugin is both in 'enable' and 'disable'
+func ValidateAdmissionPlugins(enable, disable []string) error {
+	for _, enabled := range enable {
+		for _, disabled := range disable {
+			if enabled == disabled {
+				return errors.Errorf("admission plugin %s is both enabled and disabled", enabled)
+			}
+		}
+	}
+	return nil
+}
+
+// auditArgs returns the command line arguments enabling the audit log, if there is one. The log is rotated once it
+// reaches 100 MB, keeping three old files, so that it doesn't fill the disk of long-running clusters.
+func (handler *KubeAPIServerHandler) auditArgs() []string {
+	if handler.auditLog == "" {
+		return nil
+	}
+	return []string{
+		"--audit-log-path",
+		handler.auditLog,
+		"--audit-policy-file",
+		handler.auditPolicy,
+		"--audit-log-maxsize",
+		"100",
+		"--audit-log-maxbackup",
+		"3",
+	}
 }
 
 // Handle result of a health probe
@@ -188,7 +401,11 @@ func kubeApiServerConstructor(execEnv handlers.ExecutionEnvironment, creds *pki.
 	if err != nil {
 		return handlerList, errors.Wrap(err, "etcd startup prereq failed")
 	}
-	handlerList = append(handlerList, NewKubeAPIServerHandler(execEnv, creds, "127.0.0.0/16"))
+	handler, err := NewKubeAPIServerHandler(execEnv, creds, "127.0.0.0/16", OIDCConfig{})
+	if err != nil {
+		return handlerList, err
+	}
+	handlerList = append(handlerList, handler)
 
 	return handlerList, nil
 }
@@ -20,8 +20,10 @@ import (
 	"bufio"
 	"bytes"
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 	"github.com/vs-eth/microkube/pkg/helpers"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"path"
 	"strings"
@@ -123,3 +125,76 @@ func TestAPIServerKubeconfig(t *testing.T) {
 		}
 	}
 }
+
+// TestAPIServerBindAddressArgs checks whether the apiserver binds to its bind address but advertises the listen address
+func TestAPIServerBindAddressArgs(t *testing.T) {
+	uut := KubeAPIServerHandler{
+		bindAddress:      "0.0.0.0",
+		advertiseAddress: "10.0.0.5",
+	}
+	args := strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--bind-address 0.0.0.0", "unexpected bind address")
+	assert.Contains(t, args, "--advertise-address 10.0.0.5", "listen address not advertised")
+}
+
+// TestAPIServerAggregationArgs checks whether the aggregation layer is only enabled given an aggregator client
+// certificate
+func TestAPIServerAggregationArgs(t *testing.T) {
+	uut := KubeAPIServerHandler{
+		kubeCACert: "/ca.pem",
+	}
+	args := strings.Join(uut.args(), " ")
+	assert.NotContains(t, args, "--proxy-client-cert-file", "aggregation layer enabled without certificate")
+
+	uut.aggregatorClientCert = "/aggregator.pem"
+	uut.aggregatorClientKey = "/aggregator.key"
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--requestheader-client-ca-file /ca.pem", "requests proxied with the wrong CA")
+	assert.Contains(t, args, "--proxy-client-cert-file /aggregator.pem", "aggregator client certificate missing")
+	assert.Contains(t, args, "--proxy-client-key-file /aggregator.key", "aggregator client key missing")
+}
+
+// TestAPIServerAuditArgs checks whether the audit log is only enabled if it has a path
+func TestAPIServerAuditArgs(t *testing.T) {
+	uut := KubeAPIServerHandler{}
+	args := strings.Join(uut.args(), " ")
+	assert.NotContains(t, args, "--audit-log-path", "audit log enabled without path")
+
+	uut.auditLog = "/logs/audit.log"
+	uut.auditPolicy = "/kube/audit-policy.yaml"
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--audit-log-path /logs/audit.log", "audit log path missing")
+	assert.Contains(t, args, "--audit-policy-file /kube/audit-policy.yaml", "audit policy missing")
+}
+
+// TestCreateDefaultAuditPolicy checks whether the default audit policy is written
+func TestCreateDefaultAuditPolicy(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-auditpolicy")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	policyPath := path.Join(tmpdir, "audit-policy.yaml")
+	assert.NoError(t, CreateDefaultAuditPolicy(policyPath), "couldn't create audit policy")
+	policy, err := ioutil.ReadFile(policyPath)
+	assert.NoError(t, err, "couldn't read audit policy")
+	assert.Contains(t, string(policy), "kind: Policy", "unexpected audit policy")
+}
+
+// TestAPIServerAdmissionArgs checks whether admission plugins are only passed if given
+func TestAPIServerAdmissionArgs(t *testing.T) {
+	uut := KubeAPIServerHandler{}
+	args := strings.Join(uut.args(), " ")
+	assert.NotContains(t, args, "admission-plugins", "admission plugins changed without being given")
+
+	uut.enableAdmissionPlugins = []string{"PodSecurity", "NodeRestriction"}
+	uut.disableAdmissionPlugins = []string{"ServiceAccount"}
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--enable-admission-plugins PodSecurity,NodeRestriction", "enabled plugins missing")
+	assert.Contains(t, args, "--disable-admission-plugins ServiceAccount", "disabled plugins missing")
+
+	assert.NoError(t, ValidateAdmissionPlugins(uut.enableAdmissionPlugins, uut.disableAdmissionPlugins),
+		"unexpected error for distinct plugins")
+	assert.Error(t, ValidateAdmissionPlugins([]string{"ServiceAccount"}, []string{"ServiceAccount"}),
+		"missing error for plugin both enabled and disabled")
+}
@@ -34,8 +34,8 @@ type ControllerManagerHandler struct {
 	handlers.BaseServiceHandler
 	cmd *helpers.CmdHandler
 
-	// Path to kube-controller-manager binary
-	binary string
+	// How to invoke hyperkube
+	hyperkube hyperkubeEnv
 	// Path to kube server certificate
 	kubeServerCert string
 	// Path to kube server certificate's key
@@ -56,6 +56,12 @@ type ControllerManagerHandler struct {
 	out handlers.OutputHandler
 	// API listen port
 	kubeControllerManagerPort int
+	// Whether to serve pprof endpoints, nil for the default
+	profiling *bool
+	// Whether to acquire a lease before running controllers
+	leaderElect bool
+	// Feature gates to enable or disable
+	featureGates map[string]bool
 }
 
 // NewControllerManagerHandler creates a ControllerManagerHandler from the arguments provided
@@ -63,22 +69,28 @@ func NewControllerManagerHandler(execEnv handlers.ExecutionEnvironment, creds *p
 	podRange string) *ControllerManagerHandler {
 
 	obj := &ControllerManagerHandler{
-		binary:                    execEnv.Binary,
+		hyperkube:                 newHyperkubeEnv(execEnv, false),
 		kubeServerCert:            creds.KubeServer.CertPath,
 		kubeServerKey:             creds.KubeServer.KeyPath,
-		cmd:                       nil,
 		out:                       execEnv.OutputHandler,
 		kubeconfig:                creds.Kubeconfig,
-		bindAddress:               execEnv.ListenAddress.String(),
+		bindAddress:               execEnv.BindAddress(execEnv.KubeControllerManagerBindAddress).String(),
 		kubeClusterCACert:         creds.KubeClusterCA.CertPath,
 		kubeClusterCAKey:          creds.KubeClusterCA.KeyPath,
 		podRange:                  podRange,
 		kubeSvcKey:                creds.KubeSvcSignCert.KeyPath,
 		kubeControllerManagerPort: execEnv.KubeControllerManagerPort,
+		profiling:                 execEnv.Profiling,
+		leaderElect:               execEnv.EnableLeaderElection,
+		featureGates:              execEnv.FeatureGates,
 	}
 
 	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun,
-		"https://"+execEnv.ListenAddress.String()+":"+strconv.Itoa(obj.kubeControllerManagerPort)+"/healthz", obj.stop, obj.Start, creds.KubeCA, creds.KubeClient)
+		"https://"+execEnv.ConnectAddress(execEnv.KubeControllerManagerBindAddress).String()+":"+
+			strconv.Itoa(obj.kubeControllerManagerPort)+"/healthz", obj.stop, obj.Start, creds.KubeCA, creds.KubeClient)
+	obj.BaseServiceHandler.SetMaxRestarts(execEnv.MaxRestarts)
+	obj.BaseServiceHandler.SetHealthCheckTimeout(execEnv.HealthCheckTimeout)
+	obj.cmd = newHyperkubeCmd("kube-controller-manager", obj.hyperkube, obj.BaseServiceHandler.HandleExit, obj.out)
 	return obj
 }
 
@@ -89,10 +101,38 @@ func (handler *ControllerManagerHandler) stop() {
 	}
 }
 
+// Pid returns the process ID of the child process, see interface handlers.ProcessHandler
+func (handler *ControllerManagerHandler) Pid() int {
+	if handler.cmd == nil {
+		return 0
+	}
+	return handler.cmd.Pid()
+}
+
+// LastOutput returns the last lines the process printed, see interface handlers.OutputTailer
+func (handler *ControllerManagerHandler) LastOutput() []string {
+	if handler.cmd == nil {
+		return nil
+	}
+	return handler.cmd.LastOutput()
+}
+
 // Start starts the process, see interface docs
 func (handler *ControllerManagerHandler) Start() error {
-	handler.cmd = helpers.NewCmdHandler(handler.binary, []string{
-		"kube-controller-manager",
+	read := []string{handler.kubeServerCert, handler.kubeServerKey, handler.kubeClusterCACert,
+		handler.kubeClusterCAKey, handler.kubeSvcKey, handler.kubeconfig}
+	return handler.hyperkube.start(handler.cmd, "kube-controller-manager", handler.args(), read, nil)
+}
+
+// BuildArgs returns the full command line kube-controller-manager is started with, see interface
+// handlers.CommandLineBuilder
+func (handler *ControllerManagerHandler) BuildArgs() []string {
+	return handler.hyperkube.commandLine("kube-controller-manager", handler.args())
+}
+
+// args returns the command line arguments of kube-controller-manager
+func (handler *ControllerManagerHandler) args() []string {
+	args := []string{
 		"--allocate-node-cidrs",
 		"--cluster-cidr",
 		handler.podRange,
@@ -117,8 +157,10 @@ func (handler *ControllerManagerHandler) Start() error {
 		handler.kubeSvcKey,
 		"--port", // This is deprecated, but until it is removed it defaults to 10252
 		"0",
-	}, handler.BaseServiceHandler.HandleExit, handler.out, handler.out)
-	return handler.cmd.Start()
+		"--leader-elect=" + strconv.FormatBool(handler.leaderElect),
+	}
+	args = append(args, profilingArgs(handler.profiling)...)
+	return append(args, featureGatesArgs(handler.featureGates)...)
 }
 
 // Handle result of a health probe
@@ -17,8 +17,10 @@
 package kube
 
 import (
+	"github.com/stretchr/testify/assert"
 	"github.com/vs-eth/microkube/pkg/helpers"
 	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -40,3 +42,15 @@ func TestControllerManagerStartup(t *testing.T) {
 		item.Stop()
 	}
 }
+
+// TestControllerManagerLeaderElectArgs checks whether leader election is only used if requested
+func TestControllerManagerLeaderElectArgs(t *testing.T) {
+	uut := ControllerManagerHandler{}
+	args := strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--leader-elect=false", "leader election not disabled by default")
+
+	uut.leaderElect = true
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--leader-elect=true", "leader election not enabled")
+	assert.NotContains(t, args, "--leader-elect=false", "leader election still disabled")
+}
@@ -19,7 +19,8 @@ package kube
 import (
 	"github.com/pkg/errors"
 	"github.com/vs-eth/microkube/pkg/handlers"
-	"os"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
+	"io"
 	"text/template"
 )
 
@@ -29,6 +30,7 @@ type kubeProxyConfigData struct {
 	ClusterCIDR          string
 	KubeProxyHealthPort  int
 	KubeProxyMetricsPort int
+	EnableProfiling      bool
 }
 
 // CreateKubeProxyConfig creates a proxy config with most things hardcoded and stores it in 'path'
@@ -38,6 +40,7 @@ func CreateKubeProxyConfig(path, clusterCIDR, kubeconfig string, execEnv handler
 		ClusterCIDR:          clusterCIDR,
 		KubeProxyHealthPort:  execEnv.KubeProxyHealthPort,
 		KubeProxyMetricsPort: execEnv.KubeProxyMetricsPort,
+		EnableProfiling:      execEnv.Profiling != nil && *execEnv.Profiling,
 	}
 	tmplStr := `apiVersion: kubeproxy.config.k8s.io/v1alpha1
 bindAddress: 0.0.0.0
@@ -55,7 +58,7 @@ conntrack:
   min: 131072
   tcpCloseWaitTimeout: 1h0m0s
   tcpEstablishedTimeout: 24h0m0s
-enableProfiling: false
+enableProfiling: {{ .EnableProfiling }}
 healthzBindAddress: 127.0.0.1:{{ .KubeProxyHealthPort }}
 hostnameOverride: ""
 iptables:
@@ -81,10 +84,7 @@ udpIdleTimeout: 250ms
 	if err != nil {
 		return errors.Wrap(err, "template init failed")
 	}
-	file, err := os.Create(path)
-	if err != nil {
-		return errors.Wrap(err, "file creation failed")
-	}
-	defer file.Close()
-	return tmpl.Execute(file, data)
+	return atomicfile.Write(path, 0644, func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	})
 }
@@ -36,10 +36,8 @@ type KubeProxyHandler struct {
 	// command exec helper
 	cmd *helpers.CmdHandler
 
-	// Path to kube proxy binary
-	binary string
-	// Path to some sudo-like binary
-	sudoBin string
+	// How to invoke hyperkube
+	hyperkube hyperkubeEnv
 	// Path to kubeconfig
 	kubeconfig string
 	// Path to proxy config (!= kubeconfig, replacement for commandline flags)
@@ -50,15 +48,14 @@ type KubeProxyHandler struct {
 	out handlers.OutputHandler
 }
 
-// NewKubeProxyHandler creates a KubeProxyHandler from the arguments provided
+// NewKubeProxyHandler creates a KubeProxyHandler from the arguments provided. 'cidr' is the range kube-proxy
+// considers cluster-internal, which should be the pod range: service traffic from outside of it is masqueraded.
 func NewKubeProxyHandler(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials, cidr string) (*KubeProxyHandler, error) {
 	obj := &KubeProxyHandler{
-		binary:     execEnv.Binary,
-		cmd:        nil,
+		hyperkube:  newHyperkubeEnv(execEnv, true),
 		out:        execEnv.OutputHandler,
 		kubeconfig: creds.Kubeconfig,
 		config:     path.Join(execEnv.Workdir, "kube-proxy.cfg"),
-		sudoBin:    execEnv.SudoMethod,
 	}
 
 	err := CreateKubeProxyConfig(obj.config, cidr, creds.Kubeconfig, execEnv)
@@ -68,6 +65,9 @@ func NewKubeProxyHandler(execEnv handlers.ExecutionEnvironment, creds *pki.Micro
 
 	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun, "http://localhost:"+strconv.Itoa(execEnv.KubeProxyHealthPort)+"/healthz",
 		obj.stop, obj.Start, nil, nil)
+	obj.BaseServiceHandler.SetMaxRestarts(execEnv.MaxRestarts)
+	obj.BaseServiceHandler.SetHealthCheckTimeout(execEnv.HealthCheckTimeout)
+	obj.cmd = newHyperkubeCmd("kube-proxy", obj.hyperkube, obj.BaseServiceHandler.HandleExit, obj.out)
 	return obj, nil
 }
 
@@ -78,15 +78,38 @@ func (handler *KubeProxyHandler) stop() {
 	}
 }
 
+// Pid returns the process ID of the child process, see interface handlers.ProcessHandler
+func (handler *KubeProxyHandler) Pid() int {
+	if handler.cmd == nil {
+		return 0
+	}
+	return handler.cmd.Pid()
+}
+
+// LastOutput returns the last lines the process printed, see interface handlers.OutputTailer
+func (handler *KubeProxyHandler) LastOutput() []string {
+	if handler.cmd == nil {
+		return nil
+	}
+	return handler.cmd.LastOutput()
+}
+
 // Start starts the process, see interface docs
 func (handler *KubeProxyHandler) Start() error {
-	handler.cmd = helpers.NewCmdHandler(handler.sudoBin, []string{
-		handler.binary,
-		"kube-proxy",
+	return handler.hyperkube.start(handler.cmd, "kube-proxy", handler.args(), nil, nil)
+}
+
+// BuildArgs returns the full command line kube-proxy is started with, see interface handlers.CommandLineBuilder
+func (handler *KubeProxyHandler) BuildArgs() []string {
+	return handler.hyperkube.commandLine("kube-proxy", handler.args())
+}
+
+// args returns the command line arguments of kube-proxy
+func (handler *KubeProxyHandler) args() []string {
+	return []string{
 		"--config",
 		handler.config,
-	}, handler.BaseServiceHandler.HandleExit, handler.out, handler.out)
-	return handler.cmd.Start()
+	}
 }
 
 // Handle result of a health probe
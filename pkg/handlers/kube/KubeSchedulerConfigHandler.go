@@ -19,7 +19,8 @@ package kube
 import (
 	"github.com/pkg/errors"
 	"github.com/vs-eth/microkube/pkg/handlers"
-	"os"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
+	"io"
 	"text/template"
 )
 
@@ -28,6 +29,8 @@ type kubeSchedulerConfigData struct {
 	Kubeconfig               string
 	KubeSchedulerHealthPort  int
 	KubeSchedulerMetricsPort int
+	EnableProfiling          bool
+	LeaderElect              bool
 }
 
 // CreateKubeSchedulerConfig creates a proxy config with most things hardcoded and stores it in 'path'
@@ -36,6 +39,8 @@ func CreateKubeSchedulerConfig(path, kubeconfig string, execEnv handlers.Executi
 		Kubeconfig:               kubeconfig,
 		KubeSchedulerHealthPort:  execEnv.KubeSchedulerHealthPort,
 		KubeSchedulerMetricsPort: execEnv.KubeSchedulerMetricsPort,
+		EnableProfiling:          execEnv.Profiling != nil && *execEnv.Profiling,
+		LeaderElect:              execEnv.EnableLeaderElection,
 	}
 	tmplStr := `algorithmSource:
   provider: DefaultProvider
@@ -48,13 +53,13 @@ clientConnection:
   qps: 50
 disablePreemption: false
 enableContentionProfiling: false
-enableProfiling: false
+enableProfiling: {{ .EnableProfiling }}
 failureDomains: kubernetes.io/hostname,failure-domain.beta.kubernetes.io/zone,failure-domain.beta.kubernetes.io/region
 hardPodAffinitySymmetricWeight: 1
 healthzBindAddress: 127.0.0.1:{{ .KubeSchedulerHealthPort }}
 kind: KubeSchedulerConfiguration
 leaderElection:
-  leaderElect: true
+  leaderElect: {{ .LeaderElect }}
   leaseDuration: 15s
   lockObjectName: kube-scheduler
   lockObjectNamespace: kube-system
@@ -68,10 +73,7 @@ schedulerName: default-scheduler
 	if err != nil {
 		return errors.Wrap(err, "template init failed")
 	}
-	file, err := os.Create(path)
-	if err != nil {
-		return errors.Wrap(err, "file creation failed")
-	}
-	defer file.Close()
-	return tmpl.Execute(file, data)
+	return atomicfile.Write(path, 0644, func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	})
 }
@@ -34,13 +34,20 @@ type KubeSchedulerHandler struct {
 	handlers.BaseServiceHandler
 	cmd *helpers.CmdHandler
 
-	// Path to kubelet binary
-	binary string
+	// How to invoke hyperkube
+	hyperkube hyperkubeEnv
 
 	// Path to kubeconfig
 	kubeconfig string
 	// Path to scheduler config (!= kubeconfig, replacement for commandline flags)
 	config string
+	// Feature gates to enable or disable. These aren't part of the scheduler config, so they are passed as flag.
+	featureGates map[string]bool
+	// Port to serve health and metrics endpoints on over HTTPS, 0 to serve them over plain HTTP as configured in the
+	// scheduler config
+	securePort int
+	// Serving certificate and key and CA that client certificates have to be signed by, if securePort is set
+	kubeServerCert, kubeServerKey, kubeCACert string
 	// Output handler
 	out handlers.OutputHandler
 }
@@ -48,11 +55,12 @@ type KubeSchedulerHandler struct {
 // NewKubeSchedulerHandler creates a KubeSchedulerHandler from the arguments provided
 func NewKubeSchedulerHandler(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials) (*KubeSchedulerHandler, error) {
 	obj := &KubeSchedulerHandler{
-		binary:     execEnv.Binary,
-		cmd:        nil,
+		hyperkube:  newHyperkubeEnv(execEnv, false),
 		out:        execEnv.OutputHandler,
 		kubeconfig: creds.Kubeconfig,
 		config:     path.Join(execEnv.Workdir, "kube-scheduler.cfg"),
+
+		featureGates: execEnv.FeatureGates,
 	}
 
 	err := CreateKubeSchedulerConfig(obj.config, creds.Kubeconfig, execEnv)
@@ -60,8 +68,21 @@ func NewKubeSchedulerHandler(execEnv handlers.ExecutionEnvironment, creds *pki.M
 		return nil, err
 	}
 
-	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun, "http://localhost:"+strconv.Itoa(execEnv.KubeSchedulerHealthPort)+"/healthz",
-		obj.stop, obj.Start, nil, nil)
+	if execEnv.SecureHealthProbe {
+		obj.securePort = execEnv.KubeSchedulerHealthPort
+		obj.kubeServerCert = creds.KubeServer.CertPath
+		obj.kubeServerKey = creds.KubeServer.KeyPath
+		obj.kubeCACert = creds.KubeCA.CertPath
+		obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun,
+			"https://localhost:"+strconv.Itoa(obj.securePort)+"/healthz", obj.stop, obj.Start, creds.KubeCA,
+			creds.KubeClient)
+	} else {
+		obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun, "http://localhost:"+strconv.Itoa(execEnv.KubeSchedulerHealthPort)+"/healthz",
+			obj.stop, obj.Start, nil, nil)
+	}
+	obj.BaseServiceHandler.SetMaxRestarts(execEnv.MaxRestarts)
+	obj.BaseServiceHandler.SetHealthCheckTimeout(execEnv.HealthCheckTimeout)
+	obj.cmd = newHyperkubeCmd("kube-scheduler", obj.hyperkube, obj.BaseServiceHandler.HandleExit, obj.out)
 	return obj, nil
 }
 
@@ -72,14 +93,63 @@ func (handler *KubeSchedulerHandler) stop() {
 	}
 }
 
+// Pid returns the process ID of the child process, see interface handlers.ProcessHandler
+func (handler *KubeSchedulerHandler) Pid() int {
+	if handler.cmd == nil {
+		return 0
+	}
+	return handler.cmd.Pid()
+}
+
+// LastOutput returns the last lines the process printed, see interface handlers.OutputTailer
+func (handler *KubeSchedulerHandler) LastOutput() []string {
+	if handler.cmd == nil {
+		return nil
+	}
+	return handler.cmd.LastOutput()
+}
+
 // Start starts the process, see interface docs
 func (handler *KubeSchedulerHandler) Start() error {
-	handler.cmd = helpers.NewCmdHandler(handler.binary, []string{
-		"kube-scheduler",
+	read := []string{handler.kubeconfig, handler.config}
+	if handler.securePort != 0 {
+		read = append(read, handler.kubeServerCert, handler.kubeServerKey, handler.kubeCACert)
+	}
+	return handler.hyperkube.start(handler.cmd, "kube-scheduler", handler.args(), read, nil)
+}
+
+// BuildArgs returns the full command line kube-scheduler is started with, see interface handlers.CommandLineBuilder
+func (handler *KubeSchedulerHandler) BuildArgs() []string {
+	return handler.hyperkube.commandLine("kube-scheduler", handler.args())
+}
+
+// args returns the command line arguments of kube-scheduler
+func (handler *KubeSchedulerHandler) args() []string {
+	args := []string{
 		"--config",
 		handler.config,
-	}, handler.BaseServiceHandler.HandleExit, handler.out, handler.out)
-	return handler.cmd.Start()
+	}
+	if handler.securePort != 0 {
+		// Disabling the insecure port moves health and metrics endpoints to the secure one, where requests are
+		// authenticated and authorized against the apiserver
+		args = append(args,
+			"--port",
+			"0",
+			"--secure-port",
+			strconv.Itoa(handler.securePort),
+			"--tls-cert-file",
+			handler.kubeServerCert,
+			"--tls-private-key-file",
+			handler.kubeServerKey,
+			"--client-ca-file",
+			handler.kubeCACert,
+			"--authentication-kubeconfig",
+			handler.kubeconfig,
+			"--authorization-kubeconfig",
+			handler.kubeconfig,
+		)
+	}
+	return append(args, featureGatesArgs(handler.featureGates)...)
 }
 
 // Handle result of a health probe
@@ -17,8 +17,15 @@
 package kube
 
 import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
 	"github.com/vs-eth/microkube/pkg/helpers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -40,3 +47,56 @@ func TestKubeSchedulerStartup(t *testing.T) {
 		item.Stop()
 	}
 }
+
+// TestKubeSchedulerLeaderElectConfig checks whether leader election is only used if requested. The scheduler ignores
+// most command line flags if started with a config file, so the setting has to end up there.
+func TestKubeSchedulerLeaderElectConfig(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-kubesched")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	config := path.Join(tmpdir, "kube-scheduler.cfg")
+
+	execEnv := handlers.ExecutionEnvironment{}
+	assert.NoError(t, CreateKubeSchedulerConfig(config, "/tmp/kubeconfig", execEnv), "config creation failed")
+	data, err := ioutil.ReadFile(config)
+	assert.NoError(t, err, "couldn't read config")
+	assert.Contains(t, string(data), "leaderElect: false", "leader election not disabled by default")
+
+	execEnv.EnableLeaderElection = true
+	assert.NoError(t, CreateKubeSchedulerConfig(config, "/tmp/kubeconfig", execEnv), "config creation failed")
+	data, err = ioutil.ReadFile(config)
+	assert.NoError(t, err, "couldn't read config")
+	assert.Contains(t, string(data), "leaderElect: true", "leader election not enabled")
+}
+
+// TestKubeSchedulerSecureHealthProbe checks whether health and metrics endpoints are only moved to the authenticated
+// secure port if requested, and are probed over HTTPS then
+func TestKubeSchedulerSecureHealthProbe(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-kubesched")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	creds := &pki.MicrokubeCredentials{
+		Kubeconfig: "/tmp/kubeconfig",
+		KubeCA:     &pki.RSACertificate{CertPath: "/tmp/ca.pem"},
+		KubeClient: &pki.RSACertificate{CertPath: "/tmp/client.pem", KeyPath: "/tmp/client.key"},
+		KubeServer: &pki.RSACertificate{CertPath: "/tmp/server.pem", KeyPath: "/tmp/server.key"},
+	}
+	execEnv := handlers.ExecutionEnvironment{Workdir: tmpdir, KubeSchedulerHealthPort: 7008}
+
+	handler, err := NewKubeSchedulerHandler(execEnv, creds)
+	assert.NoError(t, err, "handler creation failed")
+	assert.NotContains(t, handler.args(), "--secure-port", "secure port enabled by default")
+
+	execEnv.SecureHealthProbe = true
+	handler, err = NewKubeSchedulerHandler(execEnv, creds)
+	assert.NoError(t, err, "handler creation failed")
+	args := strings.Join(handler.args(), " ")
+	assert.Contains(t, args, "--port 0 --secure-port 7008", "health endpoint not moved to secure port")
+	assert.Contains(t, args, "--tls-cert-file /tmp/server.pem --tls-private-key-file /tmp/server.key",
+		"serving certificate missing")
+	assert.Contains(t, args, "--client-ca-file /tmp/ca.pem", "client CA missing")
+}
@@ -17,13 +17,16 @@
 package kube
 
 import (
+	"bytes"
 	"encoding/base64"
 	"github.com/pkg/errors"
 	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
 	"github.com/vs-eth/microkube/pkg/pki"
 	"html/template"
+	"io"
 	"io/ioutil"
-	"os"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 // clientTemplateData contains data used when templating a kubeconfig. For internal use only.
@@ -38,6 +41,8 @@ type clientTemplateData struct {
 	Address string
 	// Kube API port
 	ApiPort int
+	// Name of the user the client certificate belongs to
+	User string
 }
 
 // Base64EncodedPem encodes file 'src' as base64 and return it as string
@@ -50,24 +55,50 @@ func Base64EncodedPem(src string) (string, error) {
 }
 
 // CreateClientKubeconfig creates a certificate-based kubeconfig with an apiserver at "https://<host>:7443" and stores
-// it in 'path'
+// it in 'path'. The CA certificate, client certificate and client key are embedded, so the kubeconfig can be copied to
+// other machines.
 func CreateClientKubeconfig(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials, path,
 	host string) error {
 
+	err := createKubeconfig(execEnv, creds.KubeCA, creds.KubeClient, "admin", path, host)
+	if err != nil {
+		return err
+	}
+	creds.Kubeconfig = path
+	return nil
+}
+
+// CreateReadOnlyKubeconfig creates a kubeconfig like CreateClientKubeconfig, using the read-only client certificate of
+// 'creds' instead of the admin one
+func CreateReadOnlyKubeconfig(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials, path,
+	host string) error {
+
+	if creds.KubeReadOnlyClient == nil {
+		return errors.New("read-only client certificate missing")
+	}
+	return createKubeconfig(execEnv, creds.KubeCA, creds.KubeReadOnlyClient, "readonly", path, host)
+}
+
+// createKubeconfig creates a kubeconfig for 'client' (named 'user') with an apiserver at "https://<host>:<port>"
+// serving a certificate signed by 'ca' and stores it in 'path'
+func createKubeconfig(execEnv handlers.ExecutionEnvironment, ca, client *pki.RSACertificate, user, path,
+	host string) error {
+
 	data := clientTemplateData{
 		Address: host,
 		ApiPort: execEnv.KubeApiPort,
+		User:    user,
 	}
 	var err error
-	data.Ca, err = Base64EncodedPem(creds.KubeCA.CertPath)
+	data.Ca, err = Base64EncodedPem(ca.CertPath)
 	if err != nil {
 		return errors.Wrap(err, "ca encode failed")
 	}
-	data.Clientcert, err = Base64EncodedPem(creds.KubeClient.CertPath)
+	data.Clientcert, err = Base64EncodedPem(client.CertPath)
 	if err != nil {
 		return errors.Wrap(err, "client cert encode failed")
 	}
-	data.Clientkey, err = Base64EncodedPem(creds.KubeClient.KeyPath)
+	data.Clientkey, err = Base64EncodedPem(client.KeyPath)
 	if err != nil {
 		return errors.Wrap(err, "client key encode failed")
 	}
@@ -79,25 +110,49 @@ clusters:
     server: https://{{ .Address }}:{{ .ApiPort }}
     certificate-authority-data: {{ .Ca }} 
 users:
-- name: admin
+- name: {{ .User }}
   user:
     client-certificate-data: {{ .Clientcert }}
     client-key-data: {{ .Clientkey }}
 contexts:
 - context:
     cluster: microkube
-    user: admin
+    user: {{ .User }}
   name: default-ctx
 current-context: default-ctx`
 	tmpl, err := template.New("Client").Parse(tmplStr)
 	if err != nil {
 		return errors.Wrap(err, "template init failed")
 	}
-	file, err := os.Create(path)
+	return atomicfile.Write(path, 0600, func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	})
+}
+
+// ClientKubeconfigMatches returns whether the kubeconfig in 'path' created by CreateClientKubeconfig still embeds the
+// current kubernetes CA and client certificate of 'creds' and points to 'server' (e.g. 'https://127.0.0.1:7002'), i.e.
+// whether it's still usable after certificates might have been regenerated or the apiserver might have moved
+func ClientKubeconfigMatches(creds *pki.MicrokubeCredentials, path, server string) (bool, error) {
+	config, err := clientcmd.LoadFromFile(path)
 	if err != nil {
-		return errors.Wrap(err, "file creation failed")
+		return false, errors.Wrap(err, "kubeconfig load failed")
 	}
-	defer file.Close()
-	creds.Kubeconfig = path
-	return tmpl.Execute(file, data)
+	cluster, ok := config.Clusters["microkube"]
+	if !ok || cluster.Server != server {
+		return false, nil
+	}
+	user, ok := config.AuthInfos["admin"]
+	if !ok {
+		return false, nil
+	}
+	ca, err := ioutil.ReadFile(creds.KubeCA.CertPath)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to read ca")
+	}
+	clientCert, err := ioutil.ReadFile(creds.KubeClient.CertPath)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to read client cert")
+	}
+	return bytes.Equal(cluster.CertificateAuthorityData, ca) && bytes.Equal(user.ClientCertificateData, clientCert),
+		nil
 }
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"k8s.io/client-go/tools/clientcmd"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestClientKubeconfigEmbedsCredentials checks whether the kubeconfig embeds the current credentials and is detected
+// as outdated once they change
+func TestClientKubeconfigEmbedsCredentials(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-unittests-kubeconfig")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := &pki.MicrokubeCredentials{KeyAlgorithm: pki.KeyAlgorithmECDSAP256}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	kubeconfig := path.Join(tmpDir, "kubeconfig")
+	err = CreateClientKubeconfig(handlers.ExecutionEnvironment{KubeApiPort: 7002}, creds, kubeconfig, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	config, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	ca, err := ioutil.ReadFile(creds.KubeCA.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	clientKey, err := ioutil.ReadFile(creds.KubeClient.KeyPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if assert.Contains(t, config.Clusters, "microkube", "cluster missing") {
+		assert.Equal(t, ca, config.Clusters["microkube"].CertificateAuthorityData, "CA doesn't match")
+		assert.Equal(t, "https://127.0.0.1:7002", config.Clusters["microkube"].Server, "unexpected server")
+	}
+	if assert.Contains(t, config.AuthInfos, "admin", "user missing") {
+		assert.Equal(t, clientKey, config.AuthInfos["admin"].ClientKeyData, "client key doesn't match")
+	}
+	matches, err := ClientKubeconfigMatches(creds, kubeconfig, "https://127.0.0.1:7002")
+	assert.NoError(t, err, "unexpected error")
+	assert.True(t, matches, "kubeconfig not detected as up to date")
+
+	// Moving the apiserver invalidates the kubeconfig
+	matches, err = ClientKubeconfigMatches(creds, kubeconfig, "https://127.0.0.1:7102")
+	assert.NoError(t, err, "unexpected error")
+	assert.False(t, matches, "kubeconfig for old apiserver address not detected")
+
+	// A regenerated CA invalidates the kubeconfig
+	creds.KubeCA = creds.EtcdCA
+	matches, err = ClientKubeconfigMatches(creds, kubeconfig, "https://127.0.0.1:7002")
+	assert.NoError(t, err, "unexpected error")
+	assert.False(t, matches, "outdated kubeconfig not detected")
+}
+
+// TestReadOnlyKubeconfig checks whether the read-only kubeconfig uses the read-only client certificate
+func TestReadOnlyKubeconfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-unittests-kubeconfig")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := &pki.MicrokubeCredentials{KeyAlgorithm: pki.KeyAlgorithmECDSAP256}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	kubeconfig := path.Join(tmpDir, "kubeconfig-readonly")
+	err = CreateReadOnlyKubeconfig(handlers.ExecutionEnvironment{KubeApiPort: 7002}, creds, kubeconfig, "127.0.0.1")
+	assert.Error(t, err, "kubeconfig created without read-only client certificate")
+
+	creds.ReadOnlyClient = true
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = CreateReadOnlyKubeconfig(handlers.ExecutionEnvironment{KubeApiPort: 7002}, creds, kubeconfig, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	config, err := clientcmd.LoadFromFile(kubeconfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	clientCert, err := ioutil.ReadFile(creds.KubeReadOnlyClient.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if assert.Contains(t, config.AuthInfos, "readonly", "user missing") {
+		assert.Equal(t, clientCert, config.AuthInfos["readonly"].ClientCertificateData, "client cert doesn't match")
+	}
+	assert.Empty(t, creds.Kubeconfig, "read-only kubeconfig used as admin kubeconfig")
+}
@@ -19,11 +19,19 @@ package kube
 import (
 	"github.com/pkg/errors"
 	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
+	kube2 "github.com/vs-eth/microkube/pkg/kube"
 	"github.com/vs-eth/microkube/pkg/pki"
 	"html/template"
-	"os"
+	"io"
 )
 
+// cpuManagerPolicies lists the CPU manager policies the kubelet supports
+var cpuManagerPolicies = []string{"none", "static"}
+
+// topologyManagerPolicies lists the topology manager policies the kubelet supports
+var topologyManagerPolicies = []string{"none", "best-effort", "restricted", "single-numa-node"}
+
 // kubeletConfigData contains data used when templating a kubelet config. For internal use only.
 type kubeletConfigData struct {
 	CAFile            string
@@ -32,6 +40,71 @@ type kubeletConfigData struct {
 	StaticPodPath     string
 	KubeletHealthPort int
 	ClusterDNS        string
+	SeccompDefault    bool
+	// Whether the kubelet runs in a user namespace, see handlers.ExecutionEnvironment.Rootless
+	Rootless bool
+	// Both empty if graceful node shutdown is disabled
+	ShutdownGracePeriod             string
+	ShutdownGracePeriodCriticalPods string
+	// Empty for the kubelet defaults
+	CPUManagerPolicy      string
+	TopologyManagerPolicy string
+	SystemReserved        map[string]string
+	KubeReserved          map[string]string
+}
+
+// ValidateKubeletConfig checks the resource management settings in 'execEnv'. The static CPU manager policy requires
+// some CPU to be reserved, as the kubelet refuses to start otherwise.
+func ValidateKubeletConfig(execEnv handlers.ExecutionEnvironment) error {
+	if execEnv.KubeletCPUManagerPolicy != "" && !containsString(cpuManagerPolicies, execEnv.KubeletCPUManagerPolicy) {
+		return errors.Errorf("unknown CPU manager policy '%s', expected one of %v", execEnv.KubeletCPUManagerPolicy,
+			cpuManagerPolicies)
+	}
+	if execEnv.KubeletTopologyManagerPolicy != "" &&
+		!containsString(topologyManagerPolicies, execEnv.KubeletTopologyManagerPolicy) {
+		return errors.Errorf("unknown topology manager policy '%s', expected one of %v",
+			execEnv.KubeletTopologyManagerPolicy, topologyManagerPolicies)
+	}
+	systemReserved, err := kube2.ParseResourceList(execEnv.KubeletSystemReserved)
+	if err != nil {
+		return errors.Wrap(err, "invalid system reserved resources")
+	}
+	kubeReserved, err := kube2.ParseResourceList(execEnv.KubeletKubeReserved)
+	if err != nil {
+		return errors.Wrap(err, "invalid kube reserved resources")
+	}
+	if execEnv.KubeletCPUManagerPolicy == "static" {
+		reservedCPU := systemReserved.Cpu()
+		reservedCPU.Add(*kubeReserved.Cpu())
+		if reservedCPU.IsZero() {
+			return errors.New("the static CPU manager policy requires CPU to be reserved in system reserved or " +
+				"kube reserved resources (e.g. 'cpu=500m')")
+		}
+	}
+	return nil
+}
+
+// containsString returns whether 'list' contains 'value'
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedResources converts a resource list like 'cpu=500m,memory=512Mi' to the map used in the kubelet config
+func reservedResources(spec string) (map[string]string, error) {
+	resources, err := kube2.ParseResourceList(spec)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for name, quantity := range resources {
+		result[string(name)] = quantity.String()
+	}
+	return result, nil
 }
 
 // CreateKubeletConfig creates a kubelet config from the arguments provided and stores it in 'path'
@@ -43,6 +116,26 @@ func CreateKubeletConfig(path string, creds *pki.MicrokubeCredentials, execEnv h
 		KeyFile:           creds.KubeServer.KeyPath,
 		KubeletHealthPort: execEnv.KubeletHealthPort,
 		ClusterDNS:        execEnv.DNSAddress.String(),
+		SeccompDefault:    execEnv.KubeletSeccompDefault,
+		Rootless:          execEnv.Rootless,
+	}
+	err := ValidateKubeletConfig(execEnv)
+	if err != nil {
+		return err
+	}
+	data.CPUManagerPolicy = execEnv.KubeletCPUManagerPolicy
+	data.TopologyManagerPolicy = execEnv.KubeletTopologyManagerPolicy
+	data.SystemReserved, err = reservedResources(execEnv.KubeletSystemReserved)
+	if err != nil {
+		return err
+	}
+	data.KubeReserved, err = reservedResources(execEnv.KubeletKubeReserved)
+	if err != nil {
+		return err
+	}
+	if execEnv.KubeletShutdownGracePeriod > 0 {
+		data.ShutdownGracePeriod = execEnv.KubeletShutdownGracePeriod.String()
+		data.ShutdownGracePeriodCriticalPods = execEnv.KubeletShutdownGracePeriodCriticalPods.String()
 	}
 	tmplStr := `kind: KubeletConfiguration
 apiVersion: kubelet.config.k8s.io/v1beta1
@@ -56,21 +149,49 @@ authentication:
 staticPodPath: {{ .StaticPodPath }}
 healthzBindAddress: 127.0.0.1
 healthzPort: {{ .KubeletHealthPort }}
+{{- if .Rootless }}
+cgroupDriver: systemd
+{{- else }}
 kubeletCgroups: "/systemd/system.slice"
+{{- end }}
 tlsCertFile: {{ .CertFile }}
 tlsPrivateKeyFile: {{ .KeyFile }}
 failSwapOn: False
 clusterDNS: 
   - {{ .ClusterDNS }}
+{{- if .SeccompDefault }}
+seccompDefault: true
+featureGates:
+  SeccompDefault: true
+{{- end }}
+{{- if .ShutdownGracePeriod }}
+shutdownGracePeriod: {{ .ShutdownGracePeriod }}
+shutdownGracePeriodCriticalPods: {{ .ShutdownGracePeriodCriticalPods }}
+{{- end }}
+{{- if .CPUManagerPolicy }}
+cpuManagerPolicy: {{ .CPUManagerPolicy }}
+{{- end }}
+{{- if .TopologyManagerPolicy }}
+topologyManagerPolicy: {{ .TopologyManagerPolicy }}
+{{- end }}
+{{- if .SystemReserved }}
+systemReserved:
+{{- range $name, $quantity := .SystemReserved }}
+  {{ $name }}: "{{ $quantity }}"
+{{- end }}
+{{- end }}
+{{- if .KubeReserved }}
+kubeReserved:
+{{- range $name, $quantity := .KubeReserved }}
+  {{ $name }}: "{{ $quantity }}"
+{{- end }}
+{{- end }}
 `
 	tmpl, err := template.New("Kubelet").Parse(tmplStr)
 	if err != nil {
 		return errors.Wrap(err, "template init failed")
 	}
-	file, err := os.Create(path)
-	if err != nil {
-		return errors.Wrap(err, "file creation failed")
-	}
-	defer file.Close()
-	return tmpl.Execute(file, data)
+	return atomicfile.Write(path, 0644, func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	})
 }
@@ -0,0 +1,161 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"github.com/vs-eth/microkube/pkg/pki"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// TestValidateKubeletConfig tests whether invalid resource management settings of the kubelet are rejected
+func TestValidateKubeletConfig(t *testing.T) {
+	testCases := []struct {
+		cpuManagerPolicy      string
+		topologyManagerPolicy string
+		systemReserved        string
+		kubeReserved          string
+		valid                 bool
+	}{
+		{"", "", "", "", true},
+		{"none", "best-effort", "", "", true},
+		{"static", "single-numa-node", "cpu=500m", "", true},
+		{"static", "", "memory=1Gi", "cpu=1", true},
+		{"static", "", "", "", false},
+		{"static", "", "memory=1Gi", "", false},
+		{"dynamic", "", "cpu=1", "", false},
+		{"", "numa", "", "", false},
+		{"", "", "cpu", "", false},
+		{"", "", "", "cpu=lots", false},
+	}
+	for _, testCase := range testCases {
+		execEnv := handlers.ExecutionEnvironment{
+			KubeletCPUManagerPolicy:      testCase.cpuManagerPolicy,
+			KubeletTopologyManagerPolicy: testCase.topologyManagerPolicy,
+			KubeletSystemReserved:        testCase.systemReserved,
+			KubeletKubeReserved:          testCase.kubeReserved,
+		}
+		err := ValidateKubeletConfig(execEnv)
+		if testCase.valid {
+			assert.NoError(t, err, "unexpected error for %+v", testCase)
+		} else {
+			assert.Error(t, err, "missing error for %+v", testCase)
+		}
+	}
+}
+
+// TestReservedResources tests whether reserved resources are converted to the kubelet config format
+func TestReservedResources(t *testing.T) {
+	resources, err := reservedResources("cpu=500m, memory=1Gi")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, map[string]string{"cpu": "500m", "memory": "1Gi"}, resources)
+
+	resources, err = reservedResources("")
+	assert.NoError(t, err, "unexpected error for empty list")
+	assert.Empty(t, resources)
+}
+
+// TestCreateKubeletConfigRootless tests whether a rootless kubelet uses the systemd cgroup driver instead of the system
+// slice
+func TestCreateKubeletConfigRootless(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-kubeletconfig")
+	if !assert.NoError(t, err, "tempdir creation failed") {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	creds := &pki.MicrokubeCredentials{
+		KubeCA:     &pki.RSACertificate{CertPath: "/tmp/ca.pem"},
+		KubeServer: &pki.RSACertificate{CertPath: "/tmp/server.pem", KeyPath: "/tmp/server.key"},
+	}
+	execEnv := handlers.ExecutionEnvironment{DNSAddress: net.ParseIP("10.0.0.2")}
+	config := path.Join(tmpdir, "kubelet.cfg")
+
+	assert.NoError(t, CreateKubeletConfig(config, creds, execEnv, tmpdir), "config creation failed")
+	data, err := ioutil.ReadFile(config)
+	assert.NoError(t, err, "couldn't read config")
+	assert.Contains(t, string(data), "kubeletCgroups:", "system slice missing")
+	assert.NotContains(t, string(data), "cgroupDriver:", "cgroup driver set")
+
+	execEnv.Rootless = true
+	assert.NoError(t, CreateKubeletConfig(config, creds, execEnv, tmpdir), "rootless config creation failed")
+	data, err = ioutil.ReadFile(config)
+	assert.NoError(t, err, "couldn't read rootless config")
+	assert.Contains(t, string(data), "\ncgroupDriver: systemd\n", "systemd cgroup driver missing")
+	assert.NotContains(t, string(data), "kubeletCgroups:", "system slice used in rootless mode")
+}
+
+// renderKubeletConfig creates a kubelet config for 'execEnv' and returns its contents
+func renderKubeletConfig(t *testing.T, execEnv handlers.ExecutionEnvironment) string {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-kubeletconfig")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	creds := &pki.MicrokubeCredentials{
+		KubeCA:     &pki.RSACertificate{CertPath: "/tmp/ca.pem"},
+		KubeServer: &pki.RSACertificate{CertPath: "/tmp/server.pem", KeyPath: "/tmp/server.key"},
+	}
+	execEnv.DNSAddress = net.ParseIP("10.0.0.2")
+	config := path.Join(tmpdir, "kubelet.cfg")
+	if err := CreateKubeletConfig(config, creds, execEnv, tmpdir); err != nil {
+		t.Fatalf("config creation failed: %s", err)
+	}
+	data, err := ioutil.ReadFile(config)
+	if err != nil {
+		t.Fatalf("couldn't read config: %s", err)
+	}
+	return string(data)
+}
+
+// TestCreateKubeletConfigShutdownGracePeriod tests whether the graceful node shutdown settings are only rendered if
+// enabled
+func TestCreateKubeletConfigShutdownGracePeriod(t *testing.T) {
+	data := renderKubeletConfig(t, handlers.ExecutionEnvironment{})
+	assert.NotContains(t, data, "shutdownGracePeriod", "graceful node shutdown enabled by default")
+
+	data = renderKubeletConfig(t, handlers.ExecutionEnvironment{
+		KubeletShutdownGracePeriod:             time.Minute,
+		KubeletShutdownGracePeriodCriticalPods: 15 * time.Second,
+	})
+	assert.Contains(t, data, "\nshutdownGracePeriod: 1m0s\n", "shutdown grace period missing")
+	assert.Contains(t, data, "\nshutdownGracePeriodCriticalPods: 15s\n", "critical pods grace period missing")
+
+	// Critical pods may get all of it, but they may also get none of it
+	data = renderKubeletConfig(t, handlers.ExecutionEnvironment{
+		KubeletShutdownGracePeriod: 30 * time.Second,
+	})
+	assert.Contains(t, data, "\nshutdownGracePeriod: 30s\n", "shutdown grace period missing")
+	assert.Contains(t, data, "\nshutdownGracePeriodCriticalPods: 0s\n", "critical pods grace period missing")
+}
+
+// TestCreateKubeletConfigSeccompDefault tests whether the default seccomp profile and the feature gate it requires are
+// only rendered if enabled
+func TestCreateKubeletConfigSeccompDefault(t *testing.T) {
+	data := renderKubeletConfig(t, handlers.ExecutionEnvironment{})
+	assert.NotContains(t, data, "seccompDefault", "default seccomp profile enabled by default")
+	assert.NotContains(t, data, "SeccompDefault", "feature gate enabled by default")
+
+	data = renderKubeletConfig(t, handlers.ExecutionEnvironment{KubeletSeccompDefault: true})
+	assert.Contains(t, data, "\nseccompDefault: true\n", "default seccomp profile missing")
+	assert.Contains(t, data, "\nfeatureGates:\n  SeccompDefault: true\n", "feature gate missing")
+}
@@ -31,15 +31,13 @@ import (
 )
 
 // KubeletHandler handles a kubelet instance, that is the thing that actually schedules pods on nodes, interacting with
-// docker
+// docker or a CRI runtime like containerd
 type KubeletHandler struct {
 	handlers.BaseServiceHandler
 	cmd *helpers.CmdHandler
 
-	// Path to kubelet binary
-	binary string
-	// Path to some sudo-like binary
-	sudoBin string
+	// How to invoke hyperkube
+	hyperkube hyperkubeEnv
 	// Path to kubernetes server certificate
 	kubeServerCert string
 	// Path to kubernetes server certificate's key
@@ -55,6 +53,12 @@ type KubeletHandler struct {
 	kubeconfig string
 	// Path to kubelet config (!= kubeconfig, replacement for commandline flags)
 	config string
+	// CRI endpoint of the container runtime (e.g. 'unix:///run/containerd/containerd.sock'), empty for docker
+	containerRuntimeEndpoint string
+	// Feature gates to enable or disable, in addition to the ones set in the kubelet config
+	featureGates map[string]bool
+	// Whether the kubelet runs in a user namespace instead of as root
+	rootless bool
 	// Output handler
 	out handlers.OutputHandler
 }
@@ -62,17 +66,19 @@ type KubeletHandler struct {
 // NewKubeletHandler creates a KubeletHandler from the arguments provided
 func NewKubeletHandler(execEnv handlers.ExecutionEnvironment, creds *pki.MicrokubeCredentials) (*KubeletHandler, error) {
 	obj := &KubeletHandler{
-		binary:         execEnv.Binary,
+		hyperkube:      newHyperkubeEnv(execEnv, true),
 		kubeServerCert: creds.KubeServer.CertPath,
 		kubeServerKey:  creds.KubeServer.KeyPath,
 		kubeCACert:     creds.KubeCA.CertPath,
-		cmd:            nil,
 		out:            execEnv.OutputHandler,
 		rootDir:        execEnv.Workdir,
 		kubeconfig:     creds.Kubeconfig,
 		listenAddress:  execEnv.ListenAddress.String(),
 		config:         path.Join(execEnv.Workdir, "kubelet.cfg"),
-		sudoBin:        execEnv.SudoMethod,
+
+		containerRuntimeEndpoint: execEnv.KubeletContainerRuntimeEndpoint,
+		featureGates:             execEnv.FeatureGates,
+		rootless:                 execEnv.Rootless,
 	}
 	os.Mkdir(path.Join(execEnv.Workdir, "kubelet"), 0770)
 	os.Mkdir(path.Join(execEnv.Workdir, "staticpods"), 0770)
@@ -85,6 +91,9 @@ func NewKubeletHandler(execEnv handlers.ExecutionEnvironment, creds *pki.Microku
 	obj.BaseServiceHandler = *handlers.NewHandler(execEnv.ExitHandler, obj.healthCheckFun,
 		"http://localhost:"+strconv.Itoa(execEnv.KubeletHealthPort)+"/healthz", obj.stop, obj.Start,
 		creds.KubeCA, creds.KubeClient)
+	obj.BaseServiceHandler.SetMaxRestarts(execEnv.MaxRestarts)
+	obj.BaseServiceHandler.SetHealthCheckTimeout(execEnv.HealthCheckTimeout)
+	obj.cmd = newHyperkubeCmd("kubelet", obj.hyperkube, obj.BaseServiceHandler.HandleExit, obj.out)
 	return obj, nil
 }
 
@@ -95,39 +104,85 @@ func (handler *KubeletHandler) stop() {
 	}
 }
 
+// Pid returns the process ID of the child process, see interface handlers.ProcessHandler
+func (handler *KubeletHandler) Pid() int {
+	if handler.cmd == nil {
+		return 0
+	}
+	return handler.cmd.Pid()
+}
+
+// LastOutput returns the last lines the process printed, see interface handlers.OutputTailer
+func (handler *KubeletHandler) LastOutput() []string {
+	if handler.cmd == nil {
+		return nil
+	}
+	return handler.cmd.LastOutput()
+}
+
 // Start starts the process, see interface docs
 func (handler *KubeletHandler) Start() error {
-	// Check whether CNI bin dir was prepared successfully
-	cniDir := path.Join(handler.rootDir, "kubelet/cni")
-	_, err := os.Stat(path.Join(cniDir, "bridge"))
-	if err != nil {
-		// Fall back to distribution default
-		cniDir = "/usr/lib/x86_64-linux-gnu/libexec/cni-plugins"
-	}
+	return handler.hyperkube.start(handler.cmd, "kubelet", handler.args(), nil, nil)
+}
+
+// BuildArgs returns the full command line kubelet is started with, see interface handlers.CommandLineBuilder
+func (handler *KubeletHandler) BuildArgs() []string {
+	return handler.hyperkube.commandLine("kubelet", handler.args())
+}
 
-	handler.cmd = helpers.NewCmdHandler(handler.sudoBin, []string{
-		handler.binary,
-		"kubelet",
+// args returns the command line arguments of the kubelet
+func (handler *KubeletHandler) args() []string {
+	args := []string{
 		"--config",
 		handler.config,
 		"--node-ip",
 		handler.listenAddress,
 		"--kubeconfig",
 		handler.kubeconfig,
-		"--cni-bin-dir",
-		cniDir,
 		"--root-dir",
 		path.Join(handler.rootDir, "kubelet"),
 		"--seccomp-profile-root",
 		path.Join(handler.rootDir, "kubelet/seccomp"),
-		"--bootstrap-checkpoint-path",
-		path.Join(handler.rootDir, "kubelet/checkpoint"),
+	}
+	if handler.featureGates["BootstrapCheckpointing"] {
+		// Only used by the alpha feature, later releases reject the flag
+		args = append(args, "--bootstrap-checkpoint-path", path.Join(handler.rootDir, "kubelet/checkpoint"))
+	}
+	featureGates := handler.featureGates
+	if handler.rootless {
+		// Inside the user namespace, the kubelet can't modify system settings like sysctls and OOM scores, this makes
+		// it ignore the resulting errors. The runtime's cgroups are managed by the user's systemd instance.
+		featureGates = map[string]bool{"KubeletInUserNamespace": true}
+		for gate, enabled := range handler.featureGates {
+			featureGates[gate] = enabled
+		}
+	} else {
+		args = append(args, "--runtime-cgroups", "/systemd/system.slice")
+	}
+	args = append(args, featureGatesArgs(featureGates)...)
+	if handler.containerRuntimeEndpoint != "" {
+		// Networking of pods is set up by the runtime itself
+		return append(args,
+			"--container-runtime",
+			"remote",
+			"--container-runtime-endpoint",
+			handler.containerRuntimeEndpoint,
+		)
+	}
+
+	// Check whether CNI bin dir was prepared successfully
+	cniDir := path.Join(handler.rootDir, "kubelet/cni")
+	_, err := os.Stat(path.Join(cniDir, "bridge"))
+	if err != nil {
+		// Fall back to distribution default
+		cniDir = "/usr/lib/x86_64-linux-gnu/libexec/cni-plugins"
+	}
+	return append(args,
+		"--cni-bin-dir",
+		cniDir,
 		"--network-plugin",
 		"kubenet",
-		"--runtime-cgroups",
-		"/systemd/system.slice",
-	}, handler.BaseServiceHandler.HandleExit, handler.out, handler.out)
-	return handler.cmd.Start()
+	)
 }
 
 // Handle result of a health probe
@@ -17,8 +17,10 @@
 package kube
 
 import (
+	"github.com/stretchr/testify/assert"
 	"github.com/vs-eth/microkube/pkg/helpers"
 	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -40,3 +42,48 @@ func TestKubeletStartup(t *testing.T) {
 		item.Stop()
 	}
 }
+
+// TestKubeletRuntimeArgs checks whether the kubelet uses a CRI runtime instead of docker and kubenet if requested
+func TestKubeletRuntimeArgs(t *testing.T) {
+	uut := KubeletHandler{rootDir: "/tmp/microkube-kubelet"}
+	args := strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--network-plugin kubenet", "kubenet not used with docker")
+	assert.NotContains(t, args, "--container-runtime", "runtime set without endpoint")
+
+	uut.containerRuntimeEndpoint = "unix:///run/containerd/containerd.sock"
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--container-runtime remote --container-runtime-endpoint "+
+		"unix:///run/containerd/containerd.sock", "endpoint missing")
+	assert.NotContains(t, args, "--network-plugin", "kubenet used with CRI runtime")
+}
+
+// TestKubeletCheckpointArgs checks whether the bootstrap checkpoint path is only passed with the feature enabled
+func TestKubeletCheckpointArgs(t *testing.T) {
+	uut := KubeletHandler{rootDir: "/tmp/microkube-kubelet"}
+	args := strings.Join(uut.args(), " ")
+	assert.NotContains(t, args, "--bootstrap-checkpoint-path", "checkpoint path passed without the feature")
+
+	uut.featureGates = map[string]bool{"BootstrapCheckpointing": true}
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--bootstrap-checkpoint-path /tmp/microkube-kubelet/kubelet/checkpoint",
+		"checkpoint path missing")
+}
+
+// TestKubeletRootlessArgs checks whether a rootless kubelet runs in a user namespace with the matching feature gate
+func TestKubeletRootlessArgs(t *testing.T) {
+	uut := KubeletHandler{
+		rootDir:                  "/tmp/microkube-kubelet",
+		containerRuntimeEndpoint: "unix:///run/user/1000/containerd/containerd.sock",
+		featureGates:             map[string]bool{"CSIBlockVolume": true},
+	}
+	args := strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--runtime-cgroups", "runtime cgroups missing")
+	assert.Contains(t, args, "--feature-gates=CSIBlockVolume=true", "feature gates missing")
+
+	uut.rootless = true
+	args = strings.Join(uut.args(), " ")
+	assert.NotContains(t, args, "--runtime-cgroups", "runtime cgroups set in rootless mode")
+	assert.Contains(t, args, "--feature-gates=CSIBlockVolume=true,KubeletInUserNamespace=true",
+		"user namespace feature gate missing")
+	assert.Len(t, uut.featureGates, 1, "feature gates of the handler modified")
+}
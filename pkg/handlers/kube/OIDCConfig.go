@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/pkg/errors"
+	"net/url"
+)
+
+// OIDCConfig configures the apiserver to authenticate users with ID tokens of an OpenID Connect provider, in addition
+// to client certificates. The zero value disables OIDC authentication.
+type OIDCConfig struct {
+	// URL of the provider, which has to use https (e.g. 'https://accounts.example.com')
+	IssuerURL string
+	// Client ID all tokens must be issued for
+	ClientID string
+	// Claim of the token to use as user name, empty for the apiserver's default ('sub')
+	UsernameClaim string
+}
+
+// Enabled returns whether OIDC authentication is configured
+func (o OIDCConfig) Enabled() bool {
+	return o != OIDCConfig{}
+}
+
+// Validate checks whether the configuration is complete and the issuer URL uses https, as the apiserver requires
+func (o OIDCConfig) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if o.IssuerURL == "" || o.ClientID == "" {
+		return errors.New("OIDC requires both an issuer URL and a client ID")
+	}
+	issuer, err := url.Parse(o.IssuerURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid OIDC issuer URL")
+	}
+	if issuer.Scheme != "https" || issuer.Host == "" {
+		return errors.Errorf("OIDC issuer URL '%s' isn't an https URL", o.IssuerURL)
+	}
+	return nil
+}
+
+// args returns the command line arguments of the apiserver enabling OIDC authentication, if configured
+func (o OIDCConfig) args() []string {
+	if !o.Enabled() {
+		return nil
+	}
+	args := []string{
+		"--oidc-issuer-url",
+		o.IssuerURL,
+		"--oidc-client-id",
+		o.ClientID,
+	}
+	if o.UsernameClaim != "" {
+		args = append(args, "--oidc-username-claim", o.UsernameClaim)
+	}
+	return args
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// TestOIDCConfigValidate checks whether incomplete configurations and issuers without https are rejected
+func TestOIDCConfigValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		config OIDCConfig
+		valid  bool
+	}{
+		{"disabled", OIDCConfig{}, true},
+		{"complete", OIDCConfig{IssuerURL: "https://accounts.example.com", ClientID: "microkube"}, true},
+		{"http issuer", OIDCConfig{IssuerURL: "http://accounts.example.com", ClientID: "microkube"}, false},
+		{"no host", OIDCConfig{IssuerURL: "https://", ClientID: "microkube"}, false},
+		{"no client ID", OIDCConfig{IssuerURL: "https://accounts.example.com"}, false},
+		{"only claim", OIDCConfig{UsernameClaim: "email"}, false},
+	}
+	for _, c := range cases {
+		err := c.config.Validate()
+		if c.valid {
+			assert.NoError(t, err, "unexpected error for case '%s'", c.name)
+		} else {
+			assert.Error(t, err, "missing error for case '%s'", c.name)
+		}
+	}
+}
+
+// TestAPIServerOIDCArgs checks whether the OIDC flags are only passed if configured
+func TestAPIServerOIDCArgs(t *testing.T) {
+	uut := KubeAPIServerHandler{}
+	args := strings.Join(uut.args(), " ")
+	assert.NotContains(t, args, "--oidc", "OIDC enabled without config")
+
+	uut.oidc = OIDCConfig{IssuerURL: "https://accounts.example.com", ClientID: "microkube", UsernameClaim: "email"}
+	args = strings.Join(uut.args(), " ")
+	assert.Contains(t, args, "--oidc-issuer-url https://accounts.example.com", "issuer URL missing")
+	assert.Contains(t, args, "--oidc-client-id microkube", "client ID missing")
+	assert.Contains(t, args, "--oidc-username-claim email", "username claim missing")
+}
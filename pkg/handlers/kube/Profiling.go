@@ -0,0 +1,30 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"strconv"
+)
+
+// profilingArgs returns the '--profiling' argument turning pprof endpoints on or off, none if 'profiling' is nil to
+// keep the component's default
+func profilingArgs(profiling *bool) []string {
+	if profiling == nil {
+		return nil
+	}
+	return []string{"--profiling=" + strconv.FormatBool(*profiling)}
+}
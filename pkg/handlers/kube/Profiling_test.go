@@ -0,0 +1,36 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestProfilingArgs checks whether '--profiling' is only passed when profiling was turned on or off explicitly
+func TestProfilingArgs(t *testing.T) {
+	enabled, disabled := true, false
+	for _, args := range [][]string{(&KubeAPIServerHandler{}).args(), (&ControllerManagerHandler{}).args()} {
+		for _, arg := range args {
+			assert.NotContains(t, arg, "--profiling", "profiling flag passed without being set")
+		}
+	}
+	assert.Contains(t, (&KubeAPIServerHandler{profiling: &enabled}).args(), "--profiling=true",
+		"profiling not enabled")
+	assert.Contains(t, (&ControllerManagerHandler{profiling: &disabled}).args(), "--profiling=false",
+		"profiling not disabled")
+}
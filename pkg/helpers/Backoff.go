@@ -0,0 +1,54 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"time"
+)
+
+// DefaultStartupBackoff is the backoff between health checks of a service that is starting up
+var DefaultStartupBackoff = Backoff{
+	Initial: time.Second,
+	Max:     8 * time.Second,
+}
+
+// Backoff describes exponentially growing delays between attempts, e.g. health checks of a starting service
+type Backoff struct {
+	// Delay before the first attempt
+	Initial time.Duration
+	// Upper bound of the delay, 0 for no bound
+	Max time.Duration
+	// Maximum number of attempts, 0 for no limit
+	MaxAttempts int
+}
+
+// Delay returns the delay before attempt 'attempt' (starting at 0), which is twice the previous one up to Max
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 0; i < attempt && (b.Max == 0 || delay < b.Max); i++ {
+		delay *= 2
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// Exhausted returns whether no attempts are left after 'attempts' attempts
+func (b Backoff) Exhausted(attempts int) bool {
+	return b.MaxAttempts > 0 && attempts >= b.MaxAttempts
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestBackoff checks whether delays double up to the maximum and attempts are limited
+func TestBackoff(t *testing.T) {
+	uut := Backoff{
+		Initial:     500 * time.Millisecond,
+		Max:         3 * time.Second,
+		MaxAttempts: 6,
+	}
+	expected := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 3 * time.Second,
+		3 * time.Second, 3 * time.Second}
+	for attempt, delay := range expected {
+		assert.Equal(t, delay, uut.Delay(attempt), "unexpected delay of attempt %d", attempt)
+		assert.False(t, uut.Exhausted(attempt), "exhausted before attempt %d", attempt)
+	}
+	assert.True(t, uut.Exhausted(6), "not exhausted after all attempts")
+
+	unbounded := Backoff{Initial: time.Second}
+	assert.Equal(t, 16*time.Second, unbounded.Delay(4), "unexpected unbounded delay")
+	assert.False(t, unbounded.Exhausted(1000), "unlimited attempts exhausted")
+}
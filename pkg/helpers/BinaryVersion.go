@@ -0,0 +1,104 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionQueryTimeout is how long a binary may take to report it's version
+const versionQueryTimeout = 10 * time.Second
+
+// versionPattern matches the first version number like 'v1.11.2' or '3.3.9' in the output of a binary
+var versionPattern = regexp.MustCompile(`v?(\d+)\.(\d+)\.(\d+)`)
+
+// BinaryVersion is the version number a binary reports
+type BinaryVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// String returns the version as 'major.minor.patch'
+func (v BinaryVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less returns whether 'v' is older than 'o'
+func (v BinaryVersion) Less(o BinaryVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// VersionRange is a range of versions, including Min but excluding Max
+type VersionRange struct {
+	Min BinaryVersion
+	Max BinaryVersion
+}
+
+// Contains returns whether 'v' is in the range
+func (r VersionRange) Contains(v BinaryVersion) bool {
+	return !v.Less(r.Min) && v.Less(r.Max)
+}
+
+// String returns the range as '>= min, < max'
+func (r VersionRange) String() string {
+	return ">= " + r.Min.String() + ", < " + r.Max.String()
+}
+
+// ParseBinaryVersion extracts the first version number from 'output', e.g. 'Kubernetes v1.11.2' or
+// 'etcd Version: 3.3.9'
+func ParseBinaryVersion(output string) (BinaryVersion, error) {
+	match := versionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return BinaryVersion{}, errors.Errorf("no version found in '%s'", strings.TrimSpace(output))
+	}
+	var parts [3]int
+	for idx := range parts {
+		var err error
+		parts[idx], err = strconv.Atoi(match[idx+1])
+		if err != nil {
+			return BinaryVersion{}, errors.Wrapf(err, "invalid version '%s'", match[0])
+		}
+	}
+	return BinaryVersion{Major: parts[0], Minor: parts[1], Patch: parts[2]}, nil
+}
+
+// QueryBinaryVersion runs 'binary' with 'args' and '--version' and parses the version it reports. For hyperkube,
+// 'args' is the subcommand to ask, as hyperkube itself doesn't report a version.
+func QueryBinaryVersion(binary string, args ...string) (BinaryVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), versionQueryTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, binary, append(args, "--version")...).CombinedOutput()
+	if err != nil {
+		return BinaryVersion{}, errors.Wrapf(err, "couldn't query version of %s: %s", binary,
+			strings.TrimSpace(string(output)))
+	}
+	return ParseBinaryVersion(string(output))
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"testing"
+)
+
+// TestParseBinaryVersion tests whether versions are extracted from the output of etcd and hyperkube
+func TestParseBinaryVersion(t *testing.T) {
+	testCases := map[string]BinaryVersion{
+		"Kubernetes v1.11.2": {1, 11, 2},
+		"etcd Version: 3.3.9\nGit SHA: fca8add78\nGo Version: go1.10.3": {3, 3, 9},
+		"Kubernetes v1.18.0-beta.2+a1b2c3":                              {1, 18, 0},
+	}
+	for output, expected := range testCases {
+		version, err := ParseBinaryVersion(output)
+		if err != nil {
+			t.Fatalf("Unexpected error for '%s': '%s'", output, err)
+		}
+		if version != expected {
+			t.Fatalf("Unexpected version %s for '%s', expected %s", version, output, expected)
+		}
+	}
+	_, err := ParseBinaryVersion("unknown flag: --version")
+	if err == nil {
+		t.Fatal("Output without version accepted")
+	}
+}
+
+// TestVersionRange tests whether the minimum is included in a range and the maximum isn't
+func TestVersionRange(t *testing.T) {
+	uut := VersionRange{Min: BinaryVersion{1, 11, 0}, Max: BinaryVersion{1, 19, 0}}
+	for version, expected := range map[BinaryVersion]bool{
+		{1, 10, 9}:  false,
+		{1, 11, 0}:  true,
+		{1, 18, 20}: true,
+		{1, 19, 0}:  false,
+		{2, 0, 0}:   false,
+	} {
+		if uut.Contains(version) != expected {
+			t.Fatalf("Unexpected result for %s in %s", version, uut)
+		}
+	}
+}
+
+// TestQueryBinaryVersion tests whether the version reported by a binary is parsed
+func TestQueryBinaryVersion(t *testing.T) {
+	// echo prints it's arguments, including '--version'
+	version, err := QueryBinaryVersion("/bin/echo", "Kubernetes", "v1.11.2")
+	if err != nil {
+		t.Fatalf("Unexpected error: '%s'", err)
+	}
+	if version != (BinaryVersion{1, 11, 2}) {
+		t.Fatalf("Unexpected version %s", version)
+	}
+	_, err = QueryBinaryVersion("/bin/false")
+	if err == nil {
+		t.Fatal("Failing binary accepted")
+	}
+}
@@ -22,113 +22,296 @@ import (
 	"github.com/vs-eth/microkube/pkg/handlers"
 	"os"
 	"os/exec"
-	"os/signal"
+	"os/user"
 	"path"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// DefaultStopGracePeriod is the time a process may take to exit after SIGTERM before it is killed
+const DefaultStopGracePeriod = 10 * time.Second
+
+// outputDrainTimeout is how long the exit handler is delayed at most to read the remaining output of a process
+const outputDrainTimeout = time.Second
+
 // CmdHandler is used to abstract the low-level handling of exec.Command, providing callbacks for events
 type CmdHandler struct {
+	// Env contains additional environment variables ('KEY=value') for the process, overriding inherited ones
+	Env []string
+	// StopGracePeriod is the time the process may take to exit after SIGTERM when stopping it before it's killed, 0
+	// to kill it right away
+	StopGracePeriod time.Duration
+	// OutputTailLines is the number of most recent lines of combined stdout and stderr to keep for LastOutput, 0 to
+	// keep none. Lines of both streams are kept in the order they were read, which might differ from the order they
+	// were written in. Changes apply when the process is started.
+	OutputTailLines int
+
 	binary string
-	args   []string
-	cmd    *exec.Cmd
 	exit   handlers.ExitHandler
 	stdout handlers.OutputHandler
 	stderr handlers.OutputHandler
+
+	// Protects the fields below, which change whenever the process is (re)started, e.g. from the exit handler of the
+	// previous process, while other goroutines stop or inspect it
+	lock sync.Mutex
+	args []string
+	cmd  *exec.Cmd
+	// Closed once the process exited
+	done chan struct{}
+	// Credentials to run the process with, nil to run it as the current user
+	credential *syscall.Credential
+	// Last lines of output of the process, nil if not kept
+	tail *outputTail
 }
 
 // NewCmdHandler creates a CmdHandler for the arguments provided
 func NewCmdHandler(binary string, args []string, exit handlers.ExitHandler, stdout handlers.OutputHandler, stderr handlers.OutputHandler) *CmdHandler {
 	return &CmdHandler{
-		binary: binary,
-		args:   args,
-		cmd:    nil,
-		exit:   exit,
-		stdout: stdout,
-		stderr: stderr,
+		StopGracePeriod: DefaultStopGracePeriod,
+		OutputTailLines: DefaultOutputTailLines,
+		binary:          binary,
+		args:            args,
+		cmd:             nil,
+		exit:            exit,
+		stdout:          stdout,
+		stderr:          stderr,
+	}
+}
+
+// RunAs makes the process run as 'username' (a user name or numeric ID), with the user's primary and supplementary
+// groups. This requires the current process to be privileged. An empty 'username' runs the process as the current user.
+func (handler *CmdHandler) RunAs(username string) error {
+	var credential *syscall.Credential
+	if username != "" {
+		var err error
+		credential, err = LookupCredential(username)
+		if err != nil {
+			return err
+		}
+	}
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.credential = credential
+	return nil
+}
+
+// CheckAccess checks whether the user set with RunAs can read all files in 'read' and write all paths in 'write', see
+// the function CheckAccess. Files aren't chowned for other users, so they need access before the process is started.
+func (handler *CmdHandler) CheckAccess(read, write []string) error {
+	handler.lock.Lock()
+	credential := handler.credential
+	handler.lock.Unlock()
+	return CheckAccess(credential, read, write)
+}
+
+// SetArgs replaces the arguments the process is started with. Changes apply when the process is started the next time.
+func (handler *CmdHandler) SetArgs(args []string) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.args = args
+}
+
+// LookupCredential returns the credentials to run a process as 'username' (a user name or numeric ID)
+func LookupCredential(username string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		u, err = user.LookupId(username)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't find user '%s'", username)
+		}
 	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid uid of user '%s'", username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid gid of user '%s'", username)
+	}
+	credential := &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't find groups of user '%s'", username)
+	}
+	for _, groupID := range groupIDs {
+		group, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid group of user '%s'", username)
+		}
+		credential.Groups = append(credential.Groups, uint32(group))
+	}
+	return credential, nil
 }
 
-// Stop stops a running process if there is one
+// Stop stops a running process if there is one, see StopWithTimeout. The process may take StopGracePeriod to exit.
 func (handler *CmdHandler) Stop() {
-	if handler.cmd != nil {
-		handler.cmd.Process.Kill()
+	handler.StopWithTimeout(handler.StopGracePeriod)
+}
+
+// StopWithTimeout stops a running process if there is one by sending SIGTERM, giving it time to e.g. flush it's data.
+// If it didn't exit after 'timeout', it is killed. This blocks until the process exited or was killed.
+func (handler *CmdHandler) StopWithTimeout(timeout time.Duration) {
+	handler.lock.Lock()
+	cmd, done := handler.cmd, handler.done
+	handler.lock.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	if timeout > 0 && cmd.Process.Signal(syscall.SIGTERM) == nil {
+		select {
+		case <-done:
+			return
+		case <-time.After(timeout):
+		}
+	}
+	cmd.Process.Kill()
+}
+
+// Pid returns the process ID of the process if it was started, 0 otherwise
+func (handler *CmdHandler) Pid() int {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	if handler.cmd == nil || handler.cmd.Process == nil {
+		return 0
+	}
+	return handler.cmd.Process.Pid
+}
+
+// LastOutput returns the most recent lines the process printed to stdout or stderr, oldest first, at most
+// OutputTailLines. Once the process exited, this includes all of it's output, so exit handlers can use it to explain
+// a crash.
+func (handler *CmdHandler) LastOutput() []string {
+	handler.lock.Lock()
+	tail := handler.tail
+	handler.lock.Unlock()
+	if tail == nil {
+		return nil
+	}
+	return tail.last()
+}
+
+// pipeOutput creates a pipe whose contents are passed to 'out' and 'tail' as output 'stream', either of which may be
+// nil, and returns it's write end for the process. Unlike the pipes of exec.Cmd, it is read until all writers closed
+// it instead of only until the process exited, so that the last output of a crashing process isn't lost.
+func pipeOutput(readers *sync.WaitGroup, stream int, out handlers.OutputHandler, tail *outputTail) (*os.File, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		defer reader.Close()
+		buf := make([]byte, 256)
+		for {
+			n, err := reader.Read(buf)
+			if n > 0 {
+				if tail != nil {
+					tail.write(stream, buf[0:n])
+				}
+				if out != nil {
+					out(buf[0:n])
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}()
+	return writer, nil
+}
+
+// waitForOutput waits until 'readers' read all output, but at most 'timeout'. Children of a process inherit it's
+// pipes and might keep them open after it exited.
+func waitForOutput(readers *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
 	}
 }
 
-// Start starts a new process and sets up all related handlers
+// Start starts a new process and sets up all related handlers. This may be called again once the previous process
+// exited, e.g. from the exit handler to restart it.
 func (handler *CmdHandler) Start() error {
-	handler.cmd = exec.Command(handler.binary, handler.args...)
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	cmd := exec.Command(handler.binary, handler.args...)
+	if len(handler.Env) > 0 {
+		// Later entries take precedence
+		cmd.Env = append(os.Environ(), handler.Env...)
+	}
 	// Detach from process group
-	handler.cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-		Pgid:    0,
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Pgid:       0,
+		Credential: handler.credential,
+	}
+	handler.cmd = cmd
+
+	var tail *outputTail
+	if handler.OutputTailLines > 0 {
+		tail = newOutputTail(handler.OutputTailLines)
 	}
+	handler.tail = tail
+	// Output is fully handled once these are done
+	readers := &sync.WaitGroup{}
+	// Write ends of the output pipes, the process gets it's own copies
+	var writers []*os.File
+	defer func() {
+		for _, writer := range writers {
+			writer.Close()
+		}
+	}()
 
 	// Handle stdout
-	if handler.stdout != nil {
-		pipe, err := handler.cmd.StdoutPipe()
+	if handler.stdout != nil || tail != nil {
+		writer, err := pipeOutput(readers, streamStdout, handler.stdout, tail)
 		if err != nil {
 			return errors.Wrap(err, "stdout pip creation failed")
 		}
-		go func() {
-			buf := make([]byte, 256)
-			for {
-				n, err := pipe.Read(buf)
-				if n > 0 {
-					handler.stdout(buf[0:n])
-				}
-				if err != nil {
-					break
-				}
-			}
-		}()
+		cmd.Stdout = writer
+		writers = append(writers, writer)
 	}
 
 	// Handle stderr
-	if handler.stderr != nil {
-		pipe, err := handler.cmd.StderrPipe()
+	if handler.stderr != nil || tail != nil {
+		writer, err := pipeOutput(readers, streamStderr, handler.stderr, tail)
 		if err != nil {
 			return errors.Wrap(err, "stderr pip creation failed")
 		}
-		go func() {
-			buf := make([]byte, 256)
-			for {
-				n, err := pipe.Read(buf)
-				if n > 0 {
-					handler.stderr(buf[0:n])
-				}
-				if err != nil {
-					break
-				}
-			}
-		}()
+		cmd.Stderr = writer
+		writers = append(writers, writer)
 	}
 
-	err := handler.cmd.Start()
+	err := cmd.Start()
 	if err != nil {
 		return errors.Wrap(err, "process start failed")
 	}
+	done := make(chan struct{})
+	handler.done = done
 
-	// In case this program is interrupted, stop the child!
-	sigchan := make(chan os.Signal, 2)
-	statechan := make(chan bool, 2)
-	go func() {
-		select { // Exit this because either...
-		// ... we got a signal, therefore terminating the process
-		case <-sigchan:
-			handler.cmd.Process.Kill()
-			return
-		// ... we got an exit notification, terminating the routine
-		case <-statechan:
-			return
-		}
-	}()
-	signal.Notify(sigchan, os.Interrupt, os.Kill)
+	// In case this program is interrupted during startup, stop the child!
+	process := cmd.Process
+	unregister := Signals().RegisterChild(func() {
+		process.Kill()
+	})
 
 	go func() {
-		result := handler.cmd.Wait()
-		statechan <- true
+		result := cmd.Wait()
+		// Let the exit handler see the output printed right before the exit, e.g. using LastOutput
+		waitForOutput(readers, outputDrainTimeout)
+		close(done)
+		unregister()
 		if handler.exit != nil {
 			if result == nil {
 				handler.exit(true, nil)
@@ -145,12 +328,12 @@ func (handler *CmdHandler) Start() error {
 }
 
 // FindBinary tries to find binary 'name'. The following locations are checked in this order:
-//  - cwd/../../../third_party/name
-//  - cwd/../../third_party/name
-//  - cwd/../third_party/name
-//  - cwd/third_party/name
-//  - 'appdir'/third_party/name
-//  - 'extraDir'/name
+//   - cwd/../../../third_party/name
+//   - cwd/../../third_party/name
+//   - cwd/../third_party/name
+//   - cwd/third_party/name
+//   - 'appdir'/third_party/name
+//   - 'extraDir'/name
 func FindBinary(name string, appDir, extraDir string) (string, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -176,3 +359,18 @@ func FindBinary(name string, appDir, extraDir string) (string, error) {
 
 	return "", errors.New("Couldn't find file")
 }
+
+// CheckExecutable returns an error describing the problem unless 'file' is an executable regular file
+func CheckExecutable(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return errors.Wrapf(err, "%s doesn't exist", file)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("%s isn't a regular file", file)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return errors.Errorf("%s isn't executable", file)
+	}
+	return nil
+}
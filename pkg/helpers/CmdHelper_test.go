@@ -18,8 +18,11 @@ package helpers
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -183,4 +186,208 @@ func TestProcessKill(t *testing.T) {
 	if rc {
 		t.Error("Unexpectedly successful return?")
 	}
+
+	// A process handling SIGTERM gets the chance to exit cleanly
+	handler = NewCmdHandler("/bin/bash", []string{
+		"-c",
+		"trap 'exit 0' TERM; while true; do sleep 0.1; done",
+	}, exitHandler, nil, nil)
+	err = handler.Start()
+	if err != nil {
+		t.Fatal("Couldn't start program", err)
+	}
+	time.Sleep(2 * time.Second)
+	go handler.StopWithTimeout(10 * time.Second)
+	if !<-exitWaiter {
+		t.Error("Process didn't exit cleanly on SIGTERM")
+	}
+
+	// A process ignoring SIGTERM is killed after the timeout
+	handler = NewCmdHandler("/bin/bash", []string{
+		"-c",
+		"trap '' TERM; while true; do sleep 0.1; done",
+	}, exitHandler, nil, nil)
+	err = handler.Start()
+	if err != nil {
+		t.Fatal("Couldn't start program", err)
+	}
+	time.Sleep(2 * time.Second)
+	start := time.Now()
+	go handler.StopWithTimeout(time.Second)
+	if <-exitWaiter {
+		t.Error("Unexpectedly successful return?")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Process killed before the timeout, after %s", elapsed)
+	}
+}
+
+// TestRunAs checks user lookup and, if running as root, whether the process really runs as another user
+func TestRunAs(t *testing.T) {
+	credential, err := LookupCredential(strconv.Itoa(os.Getuid()))
+	if err != nil {
+		t.Fatalf("Couldn't look up current user: %s", err)
+	}
+	if credential.Uid != uint32(os.Getuid()) {
+		t.Fatalf("Unexpected uid: %d", credential.Uid)
+	}
+
+	exitWaiter := make(chan bool, 1)
+	exitHandler := func(rc bool, error *exec.ExitError) {
+		exitWaiter <- rc
+	}
+	stdout := make(chan string, 10)
+	stdoutHandler := func(value []byte) {
+		stdout <- string(value)
+	}
+	handler := NewCmdHandler("/bin/sh", []string{"-c", "id -u"}, exitHandler, stdoutHandler, nil)
+	err = handler.RunAs("no-such-user-microkube")
+	if err == nil {
+		t.Fatal("Expected error missing for nonexistent user!")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("Dropping privileges requires root")
+	}
+	nobody, err := LookupCredential("nobody")
+	if err != nil {
+		t.Skip("User 'nobody' missing")
+	}
+	err = handler.RunAs("nobody")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = handler.Start()
+	if err != nil {
+		t.Fatalf("Couldn't start program: %s", err)
+	}
+	select {
+	case output := <-stdout:
+		if strings.TrimSpace(output) != strconv.FormatUint(uint64(nobody.Uid), 10) {
+			t.Fatalf("Unexpected uid: '%s'", output)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for output")
+	}
+	if !<-exitWaiter {
+		t.Fatal("Program failed")
+	}
+}
+
+// TestEnv checks whether additional environment variables are passed to the process, overriding inherited ones
+func TestEnv(t *testing.T) {
+	os.Setenv("MICROKUBE_TEST_INHERITED", "inherited")
+	defer os.Unsetenv("MICROKUBE_TEST_INHERITED")
+	exitWaiter := make(chan bool, 1)
+	exitHandler := func(rc bool, error *exec.ExitError) {
+		exitWaiter <- rc
+	}
+	stdout := make(chan string, 10)
+	stdoutHandler := func(value []byte) {
+		stdout <- string(value)
+	}
+	handler := NewCmdHandler("/bin/sh", []string{
+		"-c",
+		"echo \"$MICROKUBE_TEST_INJECTED $MICROKUBE_TEST_INHERITED\"",
+	}, exitHandler, stdoutHandler, nil)
+	handler.Env = []string{"MICROKUBE_TEST_INJECTED=injected", "MICROKUBE_TEST_INHERITED=overridden"}
+	err := handler.Start()
+	if err != nil {
+		t.Fatalf("Couldn't start program: %s", err)
+	}
+	select {
+	case output := <-stdout:
+		if strings.TrimSpace(output) != "injected overridden" {
+			t.Fatalf("Unexpected output: '%s'", output)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Output missing")
+	}
+	if !<-exitWaiter {
+		t.Fatal("Program failed")
+	}
+}
+
+// TestCheckExecutable tests whether missing, non-regular and non-executable files are rejected
+func TestCheckExecutable(t *testing.T) {
+	if err := CheckExecutable("/bin/sh"); err != nil {
+		t.Fatalf("Unexpected error: '%s'", err)
+	}
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-executable")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	file := path.Join(tmpdir, "hyperkube")
+	err = ioutil.WriteFile(file, []byte("#!/bin/sh\n"), 0644)
+	if err != nil {
+		t.Fatalf("file creation failed: '%s'", err)
+	}
+	for _, invalid := range []string{tmpdir, file, path.Join(tmpdir, "missing")} {
+		if CheckExecutable(invalid) == nil {
+			t.Fatalf("%s accepted", invalid)
+		}
+	}
+}
+
+// TestLastOutput checks whether the last lines of a crashed process are available to the exit handler
+func TestLastOutput(t *testing.T) {
+	lastOutput := make(chan []string, 1)
+	var handler *CmdHandler
+	handler = NewCmdHandler("/bin/sh", []string{
+		"-c",
+		// Order between stdout and stderr is only kept if they are written with a bit of time in between
+		"for i in 1 2 3 4; do echo line $i >&2; done; sleep 0.2; echo -n fatal; exit 1",
+	}, func(success bool, exitError *exec.ExitError) {
+		lastOutput <- handler.LastOutput()
+	}, nil, nil)
+	handler.OutputTailLines = 4
+	err := handler.Start()
+	if err != nil {
+		t.Fatalf("Couldn't start program: '%s'", err)
+	}
+	select {
+	case lines := <-lastOutput:
+		expected := "line 2,line 3,line 4,fatal"
+		if strings.Join(lines, ",") != expected {
+			t.Fatalf("Unexpected output %v, expected '%s'", lines, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exit handler wasn't called")
+	}
+}
+
+// TestRestartFromExitHandler checks whether the process can be restarted from the exit handler while other goroutines
+// inspect it. Run with -race to find unsynchronized accesses.
+func TestRestartFromExitHandler(t *testing.T) {
+	starts := make(chan struct{}, 10)
+	finished := make(chan struct{})
+	var handler *CmdHandler
+	handler = NewCmdHandler("/bin/sh", []string{"-c", "echo started"}, func(success bool, exitError *exec.ExitError) {
+		select {
+		case starts <- struct{}{}:
+			handler.SetArgs([]string{"-c", "echo restarted"})
+			handler.Start()
+		default:
+			close(finished)
+		}
+	}, nil, nil)
+	err := handler.Start()
+	if err != nil {
+		t.Fatalf("Couldn't start program: '%s'", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		handler.Pid()
+		handler.LastOutput()
+		select {
+		case <-finished:
+			done = true
+		case <-timeout:
+			t.Fatal("Process wasn't restarted")
+		default:
+		}
+	}
+	if lines := handler.LastOutput(); len(lines) != 1 || lines[0] != "restarted" {
+		t.Fatalf("Unexpected output %v of restarted process", lines)
+	}
 }
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// RunConcurrently runs all tasks with at most 'limit' of them running at the same time (a limit below 1 runs them
+// sequentially). It waits until all of them have finished, even if some fail, and returns an error listing all
+// failures, if any.
+func RunConcurrently(limit int, tasks ...func() error) error {
+	if limit < 1 {
+		limit = 1
+	}
+	slots := make(chan struct{}, limit)
+	results := make([]error, len(tasks))
+	wg := sync.WaitGroup{}
+	for i, task := range tasks {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, task func() error) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = task()
+		}(i, task)
+	}
+	wg.Wait()
+
+	messages := []string{}
+	for _, err := range results {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
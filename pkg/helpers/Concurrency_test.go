@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRunConcurrently checks whether the concurrency limit is honored and all failures are reported
+func TestRunConcurrently(t *testing.T) {
+	mutex := sync.Mutex{}
+	running, maxRunning, finished := 0, 0, 0
+	task := func(fail bool) func() error {
+		return func() error {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			running--
+			finished++
+			mutex.Unlock()
+			if fail {
+				return errors.New("task failed")
+			}
+			return nil
+		}
+	}
+
+	err := RunConcurrently(2, task(false), task(true), task(false), task(true), task(false))
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+	if strings.Count(err.Error(), "task failed") != 2 {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if finished != 5 {
+		t.Fatalf("Not all tasks finished: %d", finished)
+	}
+	if maxRunning != 2 {
+		t.Fatalf("Unexpected maximum number of concurrent tasks: %d", maxRunning)
+	}
+
+	maxRunning = 0
+	err = RunConcurrently(0, task(false), task(false))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if maxRunning != 1 {
+		t.Fatalf("Unexpected maximum number of concurrent tasks: %d", maxRunning)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FaultProxy is a TCP proxy that can inject faults into the connections passing through it, that is delay all data,
+// drop all connections or refuse new ones. As it works on the TCP level, TLS connections are passed through
+// unmodified.
+type FaultProxy struct {
+	// Listener accepting connections
+	listener net.Listener
+	// Address to forward connections to
+	target string
+	// Delay of each chunk of data in nanoseconds, accessed atomically
+	latency int64
+	// 1 if new connections are closed right away, accessed atomically
+	refusing int32
+	// Protects conns
+	lock sync.Mutex
+	// All open connections (both directions)
+	conns map[net.Conn]struct{}
+}
+
+// NewFaultProxy creates a FaultProxy listening on 'listenAddr' (e.g. '127.0.0.1:0') that forwards all connections to
+// 'target'. Initially, no faults are injected.
+func NewFaultProxy(listenAddr, target string) (*FaultProxy, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't listen")
+	}
+	proxy := &FaultProxy{
+		listener: listener,
+		target:   target,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go proxy.accept()
+	return proxy, nil
+}
+
+// Addr returns the address the proxy listens on
+func (p *FaultProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// SetLatency makes the proxy delay each chunk of data in each direction by 'latency'
+func (p *FaultProxy) SetLatency(latency time.Duration) {
+	atomic.StoreInt64(&p.latency, int64(latency))
+}
+
+// DisconnectAll closes all connections currently open, new connections are still accepted
+func (p *FaultProxy) DisconnectAll() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+		delete(p.conns, conn)
+	}
+}
+
+// SetRefusing makes the proxy drop all open connections and close new ones as soon as they are accepted, so that all
+// requests of clients fail, until it is called again with 'refusing' set to false
+func (p *FaultProxy) SetRefusing(refusing bool) {
+	if !refusing {
+		atomic.StoreInt32(&p.refusing, 0)
+		return
+	}
+	atomic.StoreInt32(&p.refusing, 1)
+	p.DisconnectAll()
+}
+
+// Close stops accepting connections and closes all open ones
+func (p *FaultProxy) Close() error {
+	err := p.listener.Close()
+	p.DisconnectAll()
+	return err
+}
+
+// accept accepts connections until the listener is closed
+func (p *FaultProxy) accept() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		if atomic.LoadInt32(&p.refusing) == 1 {
+			client.Close()
+			continue
+		}
+		go p.forward(client)
+	}
+}
+
+// forward connects 'client' to the target and copies data in both directions until either side closes
+func (p *FaultProxy) forward(client net.Conn) {
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		client.Close()
+		return
+	}
+	p.lock.Lock()
+	p.conns[client] = struct{}{}
+	p.conns[server] = struct{}{}
+	p.lock.Unlock()
+
+	done := make(chan struct{}, 2)
+	go p.copy(server, client, done)
+	go p.copy(client, server, done)
+	<-done
+	// One side is gone, tear down the other one as well
+	p.lock.Lock()
+	client.Close()
+	server.Close()
+	delete(p.conns, client)
+	delete(p.conns, server)
+	p.lock.Unlock()
+}
+
+// copy copies data from 'src' to 'dst', delaying each chunk by the current latency
+func (p *FaultProxy) copy(dst io.Writer, src io.Reader, done chan struct{}) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if latency := time.Duration(atomic.LoadInt64(&p.latency)); latency > 0 {
+				time.Sleep(latency)
+			}
+			_, writeErr := dst.Write(buf[:n])
+			if writeErr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	done <- struct{}{}
+}
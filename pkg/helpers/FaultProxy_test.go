@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startEchoServer starts a TCP server echoing everything back on a random port
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener
+}
+
+// echo sends a line through 'conn' and returns the time it took to receive it back
+func echo(t *testing.T, conn net.Conn, reader *bufio.Reader) time.Duration {
+	start := time.Now()
+	_, err := conn.Write([]byte("ping\n"))
+	if err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if line != "ping\n" {
+		t.Fatalf("Unexpected response: '%s'", line)
+	}
+	return time.Since(start)
+}
+
+// TestFaultProxy checks whether the proxy forwards data, delays it and drops or refuses connections on request
+func TestFaultProxy(t *testing.T) {
+	server := startEchoServer(t)
+	defer server.Close()
+	proxy, err := NewFaultProxy("127.0.0.1:0", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Couldn't create proxy: %s", err)
+	}
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatalf("Couldn't connect: %s", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	echo(t, conn, reader)
+
+	proxy.SetLatency(50 * time.Millisecond)
+	// Both directions are delayed
+	if rtt := echo(t, conn, reader); rtt < 100*time.Millisecond {
+		t.Fatalf("Latency not applied, round trip took %s", rtt)
+	}
+	proxy.SetLatency(0)
+
+	proxy.DisconnectAll()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = reader.ReadString('\n')
+	if err != io.EOF {
+		t.Fatalf("Expected EOF after disconnect, got: %v", err)
+	}
+
+	// New connections still work
+	conn2, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatalf("Couldn't reconnect: %s", err)
+	}
+	defer conn2.Close()
+	reader2 := bufio.NewReader(conn2)
+	echo(t, conn2, reader2)
+
+	proxy.SetRefusing(true)
+	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = reader2.ReadString('\n')
+	if err != io.EOF {
+		t.Fatalf("Expected EOF after refusing, got: %v", err)
+	}
+	conn3, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatalf("Couldn't connect: %s", err)
+	}
+	defer conn3.Close()
+	conn3.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = bufio.NewReader(conn3).ReadString('\n')
+	if err != io.EOF {
+		t.Fatalf("Expected EOF for refused connection, got: %v", err)
+	}
+
+	proxy.SetRefusing(false)
+	conn4, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatalf("Couldn't reconnect: %s", err)
+	}
+	defer conn4.Close()
+	echo(t, conn4, bufio.NewReader(conn4))
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Permission bits as used by the 'other' part of a file mode
+const (
+	accessExec  = 01
+	accessWrite = 02
+	accessRead  = 04
+)
+
+// CheckAccess returns an error naming the first path in 'read' that a process running with 'credential' can't read, or
+// in 'write' that it can't write. Directories in 'write' are checked recursively, and a path in 'write' that doesn't
+// exist yet requires a writable parent directory. A nil credential (the current user) and root always have access.
+func CheckAccess(credential *syscall.Credential, read, write []string) error {
+	if credential == nil || credential.Uid == 0 {
+		return nil
+	}
+	for _, file := range read {
+		err := checkAccess(credential, file, accessRead)
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range write {
+		_, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			err = checkAccess(credential, filepath.Dir(file), accessWrite|accessExec)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.Walk(file, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			want := uint32(accessRead | accessWrite)
+			if info.IsDir() {
+				want |= accessExec
+			}
+			return checkAccess(credential, path, want)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAccess checks whether 'credential' has the permissions 'want' on 'file' and may search all directories above it
+func checkAccess(credential *syscall.Credential, file string, want uint32) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't resolve %s", file)
+	}
+	var dirs []string
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for _, dir := range dirs {
+		err = checkPermission(credential, dir, accessExec)
+		if err != nil {
+			return err
+		}
+	}
+	return checkPermission(credential, file, want)
+}
+
+// checkPermission checks the mode of 'file' only, using the owner, group or other bits like the kernel does
+func checkPermission(credential *syscall.Credential, file string, want uint32) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't check access to %s", file)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		// Ownership is unknown, leave it to the process to fail
+		return nil
+	}
+	perm := uint32(info.Mode().Perm())
+	if stat.Uid == credential.Uid {
+		perm >>= 6
+	} else if hasGroup(credential, stat.Gid) {
+		perm >>= 3
+	}
+	if perm&want != want {
+		return errors.Errorf("uid %d needs %s access to %s, which is owned by %d:%d with mode %s", credential.Uid,
+			describeAccess(want), file, stat.Uid, stat.Gid, info.Mode().Perm())
+	}
+	return nil
+}
+
+// hasGroup returns whether 'gid' is the primary or a supplementary group of 'credential'
+func hasGroup(credential *syscall.Credential, gid uint32) bool {
+	if credential.Gid == gid {
+		return true
+	}
+	for _, group := range credential.Groups {
+		if group == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// describeAccess returns permission bits like 'rw-'
+func describeAccess(access uint32) string {
+	description := []byte("---")
+	for i, bit := range []uint32{accessRead, accessWrite, accessExec} {
+		if access&bit != 0 {
+			description[i] = "rwx"[i]
+		}
+	}
+	return string(description)
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+)
+
+// TestCheckAccess tests whether access of other users is derived from the owner, group and mode of files
+func TestCheckAccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-access")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0755)
+	if err != nil {
+		t.Fatalf("Couldn't change mode of temporary directory: %s", err)
+	}
+	key := path.Join(dir, "key.pem")
+	err = ioutil.WriteFile(key, []byte("key"), 0640)
+	if err != nil {
+		t.Fatalf("Couldn't write key: %s", err)
+	}
+	dataDir := path.Join(dir, "data")
+	err = os.Mkdir(dataDir, 0770)
+	if err != nil {
+		t.Fatalf("Couldn't create data directory: %s", err)
+	}
+	// Not affected by the umask, unlike Mkdir
+	err = os.Chmod(dataDir, 0770)
+	if err != nil {
+		t.Fatalf("Couldn't change mode of data directory: %s", err)
+	}
+	var stat syscall.Stat_t
+	err = syscall.Stat(key, &stat)
+	if err != nil {
+		t.Fatalf("Couldn't stat key: %s", err)
+	}
+
+	other := &syscall.Credential{Uid: stat.Uid + 1000, Gid: stat.Gid + 1000}
+	member := &syscall.Credential{Uid: stat.Uid + 1000, Gid: stat.Gid + 1000, Groups: []uint32{stat.Gid}}
+	owner := &syscall.Credential{Uid: stat.Uid, Gid: stat.Gid + 1000}
+
+	if err := CheckAccess(nil, []string{key}, []string{dataDir}); err != nil {
+		t.Fatalf("Current user denied access: %s", err)
+	}
+	if err := CheckAccess(&syscall.Credential{}, []string{key}, []string{dataDir}); err != nil {
+		t.Fatalf("Root denied access: %s", err)
+	}
+	if err := CheckAccess(other, []string{key}, nil); err == nil {
+		t.Fatal("Other user may read key")
+	}
+	if err := CheckAccess(member, []string{key}, nil); err != nil {
+		t.Fatalf("Group member may not read key: %s", err)
+	}
+	if err := CheckAccess(member, nil, []string{key}); err == nil {
+		t.Fatal("Group member may write key")
+	}
+	if err := CheckAccess(owner, nil, []string{key}); err != nil {
+		t.Fatalf("Owner may not write key: %s", err)
+	}
+	if err := CheckAccess(member, nil, []string{dataDir, path.Join(dataDir, "new")}); err != nil {
+		t.Fatalf("Group member may not write data directory: %s", err)
+	}
+	if err := CheckAccess(member, nil, []string{path.Join(dir, "new")}); err == nil {
+		t.Fatal("Group member may create files in read-only directory")
+	}
+	err = ioutil.WriteFile(path.Join(dataDir, "db"), []byte("db"), 0600)
+	if err != nil {
+		t.Fatalf("Couldn't write database: %s", err)
+	}
+	if err := CheckAccess(member, nil, []string{dataDir}); err == nil {
+		t.Fatal("Group member may write files of owner in data directory")
+	}
+	if err := CheckAccess(member, []string{path.Join(dir, "missing")}, nil); err == nil {
+		t.Fatal("Missing file readable")
+	}
+	err = os.Chmod(dir, 0750)
+	if err != nil {
+		t.Fatalf("Couldn't change mode of temporary directory: %s", err)
+	}
+	if err := CheckAccess(other, []string{path.Join(dataDir, "db")}, nil); err == nil {
+		t.Fatal("Other user may search directory")
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PrometheusContentType is the content type of the Prometheus text exposition format
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+	// OpenMetricsContentType is the content type of the OpenMetrics text exposition format
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// metricSample is a single value of a gauge or counter, identified by its labels
+type metricSample struct {
+	value     float64
+	timestamp time.Time
+}
+
+// metricFamily contains all samples of a gauge or counter
+type metricFamily struct {
+	help string
+	// Type of the family as written in '# TYPE' lines, either 'gauge' or 'counter'
+	kind    string
+	samples map[string]*metricSample
+}
+
+// Metrics holds gauges and counters and exposes them either in the Prometheus text format or in the OpenMetrics
+// format. Each sample carries the time it was recorded at, which allows correlating it with the component logs.
+type Metrics struct {
+	lock     sync.Mutex
+	families map[string]*metricFamily
+	// Functions updating metrics that are computed on demand, called before the metrics are written
+	collectors []func(m *Metrics)
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// Set sets the value of gauge 'name' with the labels provided, recording the current time
+func (m *Metrics) Set(name, help string, labels map[string]string, value float64) {
+	m.SetAt(name, help, labels, value, time.Now())
+}
+
+// SetAt sets the value of gauge 'name' with the labels provided as observed at 'timestamp'
+func (m *Metrics) SetAt(name, help string, labels map[string]string, value float64, timestamp time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sample := m.sample(name, help, "gauge", labels)
+	sample.value = value
+	sample.timestamp = timestamp
+}
+
+// Add increments counter 'name' with the labels provided by 'delta', recording the current time. Counter names end in
+// '_total', which is left out of the family name in the OpenMetrics format.
+func (m *Metrics) Add(name, help string, labels map[string]string, delta float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sample := m.sample(name, help, "counter", labels)
+	sample.value += delta
+	sample.timestamp = time.Now()
+}
+
+// SetCounter sets counter 'name' with the labels provided to 'value', recording the current time. This is meant for
+// counters that are maintained elsewhere and copied by a collector, see AddCollector.
+func (m *Metrics) SetCounter(name, help string, labels map[string]string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sample := m.sample(name, help, "counter", labels)
+	sample.value = value
+	sample.timestamp = time.Now()
+}
+
+// AddCollector registers 'collector' to be called before each Write, to update metrics that are computed on demand
+func (m *Metrics) AddCollector(collector func(m *Metrics)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.collectors = append(m.collectors, collector)
+}
+
+// sample returns the sample of metric 'name' of type 'kind' with the labels provided, creating it if necessary. The
+// lock has to be held.
+func (m *Metrics) sample(name, help, kind string, labels map[string]string) *metricSample {
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{
+			samples: make(map[string]*metricSample),
+		}
+		m.families[name] = family
+	}
+	family.help = help
+	family.kind = kind
+	key := formatLabels(labels)
+	sample, ok := family.samples[key]
+	if !ok {
+		sample = &metricSample{}
+		family.samples[key] = sample
+	}
+	return sample
+}
+
+// Write writes all metrics to 'w', in the OpenMetrics format if 'openMetrics' is set and in the Prometheus text format
+// otherwise. Families and samples are sorted to keep the output stable.
+func (m *Metrics) Write(w io.Writer, openMetrics bool) error {
+	m.lock.Lock()
+	collectors := m.collectors
+	m.lock.Unlock()
+	for _, collector := range collectors {
+		collector(m)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	out := bufio.NewWriter(w)
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family := m.families[name]
+		familyName := name
+		if openMetrics && family.kind == "counter" {
+			// OpenMetrics names counter families without the suffix of their samples
+			familyName = strings.TrimSuffix(name, "_total")
+		}
+		out.WriteString("# HELP " + familyName + " " + escapeHelp(family.help, openMetrics) + "\n")
+		out.WriteString("# TYPE " + familyName + " " + family.kind + "\n")
+
+		keys := make([]string, 0, len(family.samples))
+		for key := range family.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sample := family.samples[key]
+			out.WriteString(name + key + " " + strconv.FormatFloat(sample.value, 'g', -1, 64) + " ")
+			if openMetrics {
+				// OpenMetrics timestamps are in seconds, with fractions
+				out.WriteString(strconv.FormatFloat(float64(sample.timestamp.UnixNano())/1e9, 'f', 3, 64))
+			} else {
+				// Prometheus timestamps are in milliseconds
+				out.WriteString(strconv.FormatInt(sample.timestamp.UnixNano()/int64(time.Millisecond), 10))
+			}
+			out.WriteString("\n")
+		}
+	}
+	if openMetrics {
+		out.WriteString("# EOF\n")
+	}
+	return out.Flush()
+}
+
+// ServeHTTP serves all metrics, negotiating the format based on the request's Accept header, see http.Handler
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := AcceptsOpenMetrics(r.Header.Get("Accept"))
+	if openMetrics {
+		w.Header().Set("Content-Type", OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", PrometheusContentType)
+	}
+	m.Write(w, openMetrics)
+}
+
+// AcceptsOpenMetrics returns whether the OpenMetrics format should be served to a client sending 'accept' as it's
+// Accept header, that is, whether the client prefers OpenMetrics over the Prometheus text format. On equal preference,
+// the type listed first wins.
+func AcceptsOpenMetrics(accept string) bool {
+	openMetricsQ, textQ := -1.0, -1.0
+	openMetricsPos, textPos := 0, 0
+	for pos, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch mediaType {
+		case "application/openmetrics-text":
+			if openMetricsQ < 0 {
+				openMetricsQ, openMetricsPos = q, pos
+			}
+		case "text/plain", "text/*", "*/*":
+			if textQ < 0 {
+				textQ, textPos = q, pos
+			}
+		}
+	}
+	if openMetricsQ <= 0 {
+		return false
+	}
+	return openMetricsQ > textQ || (openMetricsQ == textQ && openMetricsPos < textPos)
+}
+
+// formatLabels formats 'labels' as used in both exposition formats (e.g. '{service="etcd"}'), sorted by name
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"=\""+escapeLabelValue(labels[name])+"\"")
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabelValue escapes backslashes, double quotes and line feeds in label values
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+// escapeHelp escapes backslashes and line feeds in help texts. OpenMetrics additionally requires escaping double
+// quotes.
+func escapeHelp(help string, openMetrics bool) string {
+	if openMetrics {
+		return escapeLabelValue(help)
+	}
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(help)
+}
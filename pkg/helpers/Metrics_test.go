@@ -0,0 +1,156 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMetrics returns metrics with a fixed timestamp
+func testMetrics() *Metrics {
+	ts := time.Unix(1500000000, 250*int64(time.Millisecond))
+	metrics := NewMetrics()
+	metrics.SetAt("microkube_service_healthy", "Whether a service is \"healthy\"", map[string]string{
+		"service": "etcd",
+	}, 1, ts)
+	metrics.SetAt("microkube_service_healthy", "Whether a service is \"healthy\"", map[string]string{
+		"service": "kube-api",
+	}, 0, ts)
+	metrics.SetAt("microkube_service_rss_bytes", "Resident memory of a service", map[string]string{
+		"service": "etcd",
+	}, 1048576, ts)
+	return metrics
+}
+
+// TestMetricsFormats checks the output in both exposition formats
+func TestMetricsFormats(t *testing.T) {
+	cases := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			accept:      "",
+			contentType: PrometheusContentType,
+			body: "# HELP microkube_service_healthy Whether a service is \"healthy\"\n" +
+				"# TYPE microkube_service_healthy gauge\n" +
+				"microkube_service_healthy{service=\"etcd\"} 1 1500000000250\n" +
+				"microkube_service_healthy{service=\"kube-api\"} 0 1500000000250\n" +
+				"# HELP microkube_service_rss_bytes Resident memory of a service\n" +
+				"# TYPE microkube_service_rss_bytes gauge\n" +
+				"microkube_service_rss_bytes{service=\"etcd\"} 1.048576e+06 1500000000250\n",
+		},
+		{
+			accept:      "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+			contentType: OpenMetricsContentType,
+			body: "# HELP microkube_service_healthy Whether a service is \\\"healthy\\\"\n" +
+				"# TYPE microkube_service_healthy gauge\n" +
+				"microkube_service_healthy{service=\"etcd\"} 1 1500000000.250\n" +
+				"microkube_service_healthy{service=\"kube-api\"} 0 1500000000.250\n" +
+				"# HELP microkube_service_rss_bytes Resident memory of a service\n" +
+				"# TYPE microkube_service_rss_bytes gauge\n" +
+				"microkube_service_rss_bytes{service=\"etcd\"} 1.048576e+06 1500000000.250\n" +
+				"# EOF\n",
+		},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", c.accept)
+		rec := httptest.NewRecorder()
+		testMetrics().ServeHTTP(rec, req)
+		if rec.Header().Get("Content-Type") != c.contentType {
+			t.Fatalf("Unexpected content type for '%s': %s", c.accept, rec.Header().Get("Content-Type"))
+		}
+		if rec.Body.String() != c.body {
+			t.Fatalf("Unexpected body for '%s':\n%s", c.accept, rec.Body.String())
+		}
+	}
+}
+
+// TestAcceptsOpenMetrics checks content negotiation
+func TestAcceptsOpenMetrics(t *testing.T) {
+	cases := map[string]bool{
+		"":                                 false,
+		"*/*":                              false,
+		"text/plain":                       false,
+		"application/openmetrics-text":     true,
+		"application/openmetrics-text;q=0": false,
+		"text/plain;q=0.9,application/openmetrics-text":          true,
+		"application/openmetrics-text;q=0.5,text/plain":          false,
+		"text/plain,application/openmetrics-text":                false,
+		"application/openmetrics-text, text/plain;version=0.0.4": true,
+	}
+	for accept, expected := range cases {
+		if AcceptsOpenMetrics(accept) != expected {
+			t.Fatalf("Unexpected result for '%s', expected %t", accept, expected)
+		}
+	}
+}
+
+// TestMetricsCounters checks that counters are typed as such in both formats and that collectors run before writing
+func TestMetricsCounters(t *testing.T) {
+	metrics := NewMetrics()
+	labels := map[string]string{
+		"service": "etcd",
+	}
+	metrics.Add("microkube_service_health_checks_total", "Number of health checks", labels, 1)
+	metrics.Add("microkube_service_health_checks_total", "Number of health checks", labels, 2)
+	collected := 0
+	metrics.AddCollector(func(m *Metrics) {
+		collected++
+		m.SetCounter("microkube_service_restarts_total", "Number of restarts", labels, float64(collected))
+	})
+
+	cases := []struct {
+		openMetrics bool
+		lines       []string
+	}{
+		{
+			openMetrics: false,
+			lines: []string{
+				"# TYPE microkube_service_health_checks_total counter",
+				"microkube_service_health_checks_total{service=\"etcd\"} 3 ",
+				"# TYPE microkube_service_restarts_total counter",
+				"microkube_service_restarts_total{service=\"etcd\"} 1 ",
+			},
+		},
+		{
+			openMetrics: true,
+			lines: []string{
+				"# TYPE microkube_service_health_checks counter",
+				"microkube_service_health_checks_total{service=\"etcd\"} 3 ",
+				"# TYPE microkube_service_restarts counter",
+				"microkube_service_restarts_total{service=\"etcd\"} 2 ",
+			},
+		},
+	}
+	for _, c := range cases {
+		out := bytes.Buffer{}
+		if err := metrics.Write(&out, c.openMetrics); err != nil {
+			t.Fatalf("Couldn't write metrics: %s", err)
+		}
+		for _, line := range c.lines {
+			if !strings.Contains(out.String(), line) {
+				t.Fatalf("Line '%s' missing (OpenMetrics: %t):\n%s", line, c.openMetrics, out.String())
+			}
+		}
+	}
+}
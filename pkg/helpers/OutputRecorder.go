@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vs-eth/microkube/pkg/handlers"
+	"io"
+	"os"
+	"sync"
+)
+
+// outputRecorder appends output to a file until it is closed, see RecordOutput
+type outputRecorder struct {
+	// Serializes writes, guards fd
+	lock sync.Mutex
+	// File to append to, nil once closed
+	fd *os.File
+}
+
+// write appends 'output' to the file, nothing happens once the recorder is closed
+func (r *outputRecorder) write(output []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.fd != nil {
+		r.fd.Write(output)
+	}
+}
+
+// Close closes the file, see io.Closer. Closing more than once has no effect.
+func (r *outputRecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.fd == nil {
+		return nil
+	}
+	err := r.fd.Close()
+	r.fd = nil
+	return err
+}
+
+// RecordOutput returns an OutputHandler that appends all raw process output to 'file' before passing it on to 'next'.
+// The resulting files can be replayed into the log parsers, e.g. to create test fixtures. Since stdout and stderr are
+// handled concurrently, writes are serialized. The file stays open until the closer returned is closed, output after
+// that is only passed on.
+func RecordOutput(file string, next handlers.OutputHandler) (handlers.OutputHandler, io.Closer, error) {
+	fd, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't open output recording")
+	}
+	recorder := &outputRecorder{fd: fd}
+	return func(output []byte) {
+		recorder.write(output)
+		if next != nil {
+			next(output)
+		}
+	}, recorder, nil
+}
+
+// RotateOutput moves the recording 'file' of a previous run to 'file.1', replacing an older one, so that RecordOutput
+// starts with an empty file. Nothing happens if 'file' doesn't exist.
+func RotateOutput(file string) error {
+	_, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	err = os.Rename(file, file+".1")
+	if err != nil {
+		return errors.Wrap(err, "couldn't rotate output recording")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestRecordOutput checks whether output is both recorded and passed on
+func TestRecordOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-record")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	passedOn := ""
+	handler, closer, err := RecordOutput(path.Join(dir, "out.log"), func(output []byte) {
+		passedOn += string(output)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	handler([]byte("first line\nsecond "))
+	handler([]byte("line\n"))
+	err = closer.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// Only passed on once closed
+	handler([]byte("late line\n"))
+	err = closer.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error on second close: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(path.Join(dir, "out.log"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(recorded) != "first line\nsecond line\n" {
+		t.Fatalf("Unexpected recording: '%s'", string(recorded))
+	}
+	if passedOn != string(recorded)+"late line\n" {
+		t.Fatalf("Unexpected output passed on: '%s'", passedOn)
+	}
+
+	_, _, err = RecordOutput(path.Join(dir, "missing", "out.log"), nil)
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+}
+
+// TestRotateOutput checks whether the recording of the previous run is kept and a new one is started
+func TestRotateOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-rotate")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "out.log")
+
+	// Nothing to rotate on the first run
+	err = RotateOutput(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, run := range []string{"first run\n", "second run\n", "third run\n"} {
+		err = RotateOutput(file)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		handler, closer, err := RecordOutput(file, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		handler([]byte(run))
+		closer.Close()
+	}
+
+	recorded, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	previous, err := ioutil.ReadFile(file + ".1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(recorded) != "third run\n" || string(previous) != "second run\n" {
+		t.Fatalf("Unexpected recordings: '%s', '%s'", string(recorded), string(previous))
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bytes"
+	"sync"
+)
+
+// DefaultOutputTailLines is the number of output lines a CmdHandler keeps for crash diagnostics
+const DefaultOutputTailLines = 20
+
+// maxPartialLineBytes is the length after which an incomplete line is kept as a complete one, so that a process
+// printing without newlines can't make the tail grow without bounds
+const maxPartialLineBytes = 4096
+
+// Streams of a process whose output is written to an outputTail
+const (
+	streamStdout = iota
+	streamStderr
+	streamCount
+)
+
+// outputTail keeps the last lines written to it in a ring buffer. Lines can be written in chunks from stdout and
+// stderr, incomplete lines are kept per stream until they are completed.
+type outputTail struct {
+	lock sync.Mutex
+	// Ring buffer of complete lines, 'next' is the index the next line is stored at
+	lines []string
+	next  int
+	// Whether the ring buffer wrapped around, that is, all entries of 'lines' are used
+	full bool
+	// Incomplete last line of each stream, indexed by stream
+	partial [streamCount][]byte
+}
+
+// newOutputTail creates an outputTail keeping the last 'size' lines
+func newOutputTail(size int) *outputTail {
+	return &outputTail{
+		lines: make([]string, size),
+	}
+}
+
+// write adds the output 'data' of 'stream' (e.g. streamStdout) to the tail
+func (t *outputTail) write(stream int, data []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	data = append(t.partial[stream], data...)
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break
+		}
+		t.add(string(bytes.TrimRight(data[:end], "\r")))
+		data = data[end+1:]
+	}
+	for len(data) > maxPartialLineBytes {
+		t.add(string(data[:maxPartialLineBytes]))
+		data = data[maxPartialLineBytes:]
+	}
+	// Copy, so that the buffer of the caller can be reused
+	t.partial[stream] = append([]byte{}, data...)
+}
+
+// add stores the complete line 'line', overwriting the oldest one if the ring buffer is full. The lock has to be held.
+func (t *outputTail) add(line string) {
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// last returns the lines in the tail, oldest first. Incomplete lines are included as well, as the process might have
+// crashed while printing them.
+func (t *outputTail) last() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var result []string
+	if t.full {
+		result = append(result, t.lines[t.next:]...)
+	}
+	result = append(result, t.lines[:t.next]...)
+	for _, partial := range t.partial {
+		if len(partial) > 0 {
+			result = append(result, string(partial))
+		}
+	}
+	if len(result) > len(t.lines) {
+		result = result[len(result)-len(t.lines):]
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestOutputTail checks whether only the last lines are kept and lines split across writes are reassembled per stream
+func TestOutputTail(t *testing.T) {
+	uut := newOutputTail(3)
+	if lines := uut.last(); len(lines) != 0 {
+		t.Fatalf("Unexpected lines in empty tail: %v", lines)
+	}
+	uut.write(streamStdout, []byte("one\ntw"))
+	uut.write(streamStderr, []byte("err"))
+	uut.write(streamStdout, []byte("o\r\n"))
+	expected := []string{"one", "two", "err"}
+	if lines := uut.last(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Unexpected lines %v, expected %v", lines, expected)
+	}
+
+	uut.write(streamStderr, []byte("or\nthree\nfour\n"))
+	expected = []string{"error", "three", "four"}
+	if lines := uut.last(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Unexpected lines %v, expected %v", lines, expected)
+	}
+	// An incomplete line replaces the oldest complete one
+	uut.write(streamStdout, []byte("fi"))
+	expected = []string{"three", "four", "fi"}
+	if lines := uut.last(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Unexpected lines %v, expected %v", lines, expected)
+	}
+}
+
+// TestOutputTailLongLine checks whether output without newlines is split into lines instead of being buffered forever
+func TestOutputTailLongLine(t *testing.T) {
+	uut := newOutputTail(3)
+	for i := 0; i < 3; i++ {
+		uut.write(streamStdout, bytes.Repeat([]byte("a"), maxPartialLineBytes/2+1))
+	}
+	if partial := len(uut.partial[streamStdout]); partial > maxPartialLineBytes {
+		t.Fatalf("Incomplete line of %d bytes kept, expected at most %d", partial, maxPartialLineBytes)
+	}
+	lines := uut.last()
+	if len(lines) != 2 || len(lines[0]) != maxPartialLineBytes {
+		t.Fatalf("Unexpected %d lines, expected a complete line and the rest", len(lines))
+	}
+	uut.write(streamStdout, []byte("\n"))
+	expected := []string{lines[0], lines[1]}
+	if lines := uut.last(); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Unexpected lines after newline")
+	}
+}
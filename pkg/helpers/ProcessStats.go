@@ -0,0 +1,136 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicksPerSecond is the unit of CPU times in /proc/<pid>/stat (USER_HZ), which is 100 on all relevant platforms
+const clockTicksPerSecond = 100
+
+// ProcessStats contains resource usage information about a process
+type ProcessStats struct {
+	// Total CPU time (user + system) consumed so far
+	CPUTime time.Duration
+	// Resident set size in bytes
+	RSS uint64
+}
+
+// procRoot is the mount point of procfs, changed during unit tests
+var procRoot = "/proc"
+
+// ParseProcStat extracts the parent PID and the consumed CPU time from the contents of /proc/<pid>/stat
+func ParseProcStat(stat string) (int, time.Duration, error) {
+	// The second field is the executable name in parentheses, which may itself contain spaces and parentheses
+	commEnd := strings.LastIndex(stat, ")")
+	if commEnd < 0 {
+		return 0, 0, errors.New("malformed stat: no command name")
+	}
+	// Fields starting at field 3 (state)
+	fields := strings.Fields(stat[commEnd+1:])
+	if len(fields) < 13 {
+		return 0, 0, errors.New("malformed stat: too few fields")
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "malformed stat: invalid ppid")
+	}
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "malformed stat: invalid utime")
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "malformed stat: invalid stime")
+	}
+	return ppid, time.Duration(utime+stime) * time.Second / clockTicksPerSecond, nil
+}
+
+// ParseProcStatusRSS extracts the resident set size in bytes from the contents of /proc/<pid>/status. Kernel threads
+// don't have an RSS, in which case 0 is returned
+func ParseProcStatusRSS(status string) (uint64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "VmRSS:" {
+			continue
+		}
+		rss, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "malformed status: invalid VmRSS")
+		}
+		// Always in kB
+		return rss * 1024, nil
+	}
+	return 0, nil
+}
+
+// ReadProcessTreeStats returns the resource usage of process 'pid' and all of it's descendants. Descendants are
+// included because some services are started through sudo-like wrappers that fork.
+func ReadProcessTreeStats(pid int) (ProcessStats, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return ProcessStats{}, errors.Wrap(err, "couldn't list processes")
+	}
+	children := make(map[int][]int)
+	cpuTimes := make(map[int]time.Duration)
+	for _, entry := range entries {
+		candidate, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile(path.Join(procRoot, entry.Name(), "stat"))
+		if err != nil {
+			// Process is already gone
+			continue
+		}
+		ppid, cpuTime, err := ParseProcStat(string(stat))
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], candidate)
+		cpuTimes[candidate] = cpuTime
+	}
+	if _, ok := cpuTimes[pid]; !ok {
+		return ProcessStats{}, errors.New("process " + strconv.Itoa(pid) + " not found")
+	}
+
+	result := ProcessStats{}
+	pending := []int{pid}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = append(pending[1:], children[current]...)
+		result.CPUTime += cpuTimes[current]
+		status, err := ioutil.ReadFile(path.Join(procRoot, strconv.Itoa(current), "status"))
+		if err != nil {
+			continue
+		}
+		rss, err := ParseProcStatusRSS(string(status))
+		if err != nil {
+			continue
+		}
+		result.RSS += rss
+	}
+	return result, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// writeFakeProcess creates the stat and status files of a fake process below 'root'
+func writeFakeProcess(t *testing.T, root, pid, ppid, utime, stime, rssKB string) {
+	dir := path.Join(root, pid)
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	stat := pid + " (fake (proc)) S " + ppid + " 1 1 0 -1 4194560 100 0 0 0 " + utime + " " + stime +
+		" 0 0 20 0 1 0 100 1000 100\n"
+	err = ioutil.WriteFile(path.Join(dir, "stat"), []byte(stat), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	status := "Name:\tfake\nPid:\t" + pid + "\nVmRSS:\t    " + rssKB + " kB\n"
+	err = ioutil.WriteFile(path.Join(dir, "status"), []byte(status), 0644)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+// TestParseProcStat checks parsing of /proc/<pid>/stat, including a command name with spaces and parentheses
+func TestParseProcStat(t *testing.T) {
+	ppid, cpuTime, err := ParseProcStat("42 (foo) bar) S 7 42 42 0 -1 4194560 100 0 0 0 150 50 0 0 20 0 1 0 1 2 3")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ppid != 7 {
+		t.Fatalf("Unexpected ppid: %d", ppid)
+	}
+	if cpuTime != 2*time.Second {
+		t.Fatalf("Unexpected CPU time: %s", cpuTime)
+	}
+
+	_, _, err = ParseProcStat("42 foo S 7")
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+}
+
+// TestReadProcessTreeStats checks whether resource usage of child processes is included
+func TestReadProcessTreeStats(t *testing.T) {
+	root, err := ioutil.TempDir("", "microkube-unittests-proc")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(root)
+	writeFakeProcess(t, root, "10", "1", "100", "100", "1000")
+	writeFakeProcess(t, root, "11", "10", "50", "50", "2000")
+	writeFakeProcess(t, root, "12", "11", "0", "100", "3000")
+	writeFakeProcess(t, root, "13", "1", "100", "100", "4000")
+
+	oldRoot := procRoot
+	procRoot = root
+	defer func() {
+		procRoot = oldRoot
+	}()
+
+	stats, err := ReadProcessTreeStats(10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if stats.CPUTime != 4*time.Second {
+		t.Fatalf("Unexpected CPU time: %s", stats.CPUTime)
+	}
+	if stats.RSS != 6000*1024 {
+		t.Fatalf("Unexpected RSS: %d", stats.RSS)
+	}
+
+	_, err = ReadProcessTreeStats(99)
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+}
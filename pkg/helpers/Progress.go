@@ -0,0 +1,124 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Statuses of progress events
+const (
+	// ProgressStarting is emitted when a phase begins
+	ProgressStarting = "starting"
+	// ProgressReady is emitted when a phase completed successfully
+	ProgressReady = "ready"
+	// ProgressFailed is emitted when a phase failed
+	ProgressFailed = "failed"
+)
+
+// ProgressEvent is a single machine-readable startup milestone
+type ProgressEvent struct {
+	// Phase the event is about, e.g. 'etcd' or 'node'
+	Phase string `json:"phase"`
+	// One of the Progress* constants
+	Status string `json:"status"`
+	// Seconds since the emitter was created
+	Elapsed float64 `json:"elapsed"`
+	// Error message of failed phases
+	Error string `json:"error,omitempty"`
+}
+
+// ProgressEmitter writes progress events as JSON lines, e.g. for a GUI wrapping microkube. A nil ProgressEmitter
+// discards all events, so that callers don't need to check whether progress reporting is enabled.
+type ProgressEmitter struct {
+	out   io.WriteCloser
+	start time.Time
+	// Serializes writes, events are emitted by concurrently starting services
+	lock sync.Mutex
+}
+
+// NewProgressEmitter creates a ProgressEmitter writing to 'out'. Elapsed times are measured from now on.
+func NewProgressEmitter(out io.WriteCloser) *ProgressEmitter {
+	return &ProgressEmitter{
+		out:   out,
+		start: time.Now(),
+	}
+}
+
+// OpenProgressFile creates a ProgressEmitter appending to 'file'
+func OpenProgressFile(file string) (*ProgressEmitter, error) {
+	fd, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open progress file")
+	}
+	return NewProgressEmitter(fd), nil
+}
+
+// DialProgressSocket creates a ProgressEmitter writing to the unix socket 'socket', which the consumer has to listen on
+func DialProgressSocket(socket string) (*ProgressEmitter, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't connect to progress socket")
+	}
+	return NewProgressEmitter(conn), nil
+}
+
+// Emit emits an event with status 'status' for phase 'phase'. 'err' is included in failure events and may be nil.
+// Write errors are only logged, since progress reporting must not interfere with startup.
+func (p *ProgressEmitter) Emit(phase, status string, err error) {
+	if p == nil {
+		return
+	}
+	event := ProgressEvent{
+		Phase:   phase,
+		Status:  status,
+		Elapsed: time.Since(p.start).Seconds(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	data, jsonErr := json.Marshal(event)
+	if jsonErr != nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, writeErr := p.out.Write(append(data, '\n'))
+	if writeErr != nil {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "progress",
+		}).WithError(writeErr).Debug("Couldn't write progress event")
+	}
+}
+
+// Close closes the underlying file or socket
+func (p *ProgressEmitter) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.out.Close()
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestProgressFile checks whether events are written to a file as JSON lines
+func TestProgressFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-progress")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	uut, err := OpenProgressFile(path.Join(dir, "progress.jsonl"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	uut.Emit("etcd", ProgressStarting, nil)
+	uut.Emit("etcd", ProgressFailed, errors.New("timeout"))
+	uut.Close()
+
+	fd, err := os.Open(path.Join(dir, "progress.jsonl"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer fd.Close()
+	events := []ProgressEvent{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		event := ProgressEvent{}
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatalf("Invalid event '%s': %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Unexpected number of events: %d", len(events))
+	}
+	if events[0].Phase != "etcd" || events[0].Status != ProgressStarting || events[0].Error != "" {
+		t.Fatalf("Unexpected event: %+v", events[0])
+	}
+	if events[1].Status != ProgressFailed || events[1].Error != "timeout" || events[1].Elapsed < events[0].Elapsed {
+		t.Fatalf("Unexpected event: %+v", events[1])
+	}
+
+	_, err = OpenProgressFile(path.Join(dir, "missing", "progress.jsonl"))
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+}
+
+// TestProgressSocket checks whether events are sent to a unix socket
+func TestProgressSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-progress")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "progress.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer listener.Close()
+
+	uut, err := DialProgressSocket(socket)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer uut.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	uut.Emit("node", ProgressReady, nil)
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	event := ProgressEvent{}
+	err = json.Unmarshal(line, &event)
+	if err != nil || event.Phase != "node" || event.Status != ProgressReady {
+		t.Fatalf("Unexpected event '%s': %v", string(line), err)
+	}
+}
+
+// TestNilProgressEmitter checks whether a nil emitter discards events
+func TestNilProgressEmitter(t *testing.T) {
+	var uut *ProgressEmitter
+	uut.Emit("etcd", ProgressReady, nil)
+	if uut.Close() != nil {
+		t.Fatal("Unexpected error")
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// SignalPhase describes how the SignalStateMachine reacts to an interrupt
+type SignalPhase int
+
+const (
+	// SignalPhaseStartup kills all registered child processes immediately on interrupt
+	SignalPhaseStartup SignalPhase = iota
+	// SignalPhaseRunning invokes the graceful shutdown handler on interrupt
+	SignalPhaseRunning
+	// SignalPhaseStopping is entered after the first interrupt. Any further interrupt kills all registered child
+	// processes immediately, so that a hanging graceful shutdown can always be aborted
+	SignalPhaseStopping
+)
+
+// SignalStateMachine owns the process' interrupt handling for all child processes and the graceful shutdown logic,
+// and guarantees that exactly one reaction is active per phase. OS signals are only handled after Notify was called,
+// so that programs embedding microkube keep their own signal handling.
+type SignalStateMachine struct {
+	// Makes sure that Notify only registers for OS signals once
+	notifyOnce sync.Once
+	// Protects all fields below
+	lock sync.Mutex
+	// Whether OS signals are handled, see Notify. Children aren't tracked otherwise.
+	enabled bool
+	// Current phase
+	phase SignalPhase
+	// Functions killing child processes, indexed by registration ID
+	killers map[int]func()
+	// Next registration ID to hand out
+	nextID int
+	// Graceful shutdown handler, only set in SignalPhaseRunning
+	graceful func()
+	// Reload handler invoked on SIGHUP in SignalPhaseRunning, nil if none
+	reload func()
+}
+
+// signalStateMachine is the process-wide instance returned by Signals()
+var signalStateMachine *SignalStateMachine
+
+// signalStateMachineOnce guards the initialization of signalStateMachine
+var signalStateMachineOnce sync.Once
+
+// newSignalStateMachine creates a SignalStateMachine in startup phase that isn't connected to any OS signals
+func newSignalStateMachine() *SignalStateMachine {
+	return &SignalStateMachine{
+		phase:   SignalPhaseStartup,
+		killers: make(map[int]func()),
+	}
+}
+
+// Signals returns the process-wide SignalStateMachine. It doesn't handle any OS signals until Notify is called.
+func Signals() *SignalStateMachine {
+	signalStateMachineOnce.Do(func() {
+		signalStateMachine = newSignalStateMachine()
+	})
+	return signalStateMachine
+}
+
+// Notify registers the state machine for interrupts and SIGHUP, replacing Go's default handling of them. This is
+// meant to be called by the main program (e.g. microkubed) before starting any child process, library code must not
+// call it. Calling it more than once has no further effect.
+func (s *SignalStateMachine) Notify() {
+	s.notifyOnce.Do(func() {
+		s.lock.Lock()
+		s.enabled = true
+		s.lock.Unlock()
+		sigChan := make(chan os.Signal, 2)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGHUP)
+		go func() {
+			for sig := range sigChan {
+				s.handle(sig)
+			}
+		}()
+	})
+}
+
+// Phase returns the current phase
+func (s *SignalStateMachine) Phase() SignalPhase {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.phase
+}
+
+// RegisterChild registers a function that kills a child process when an interrupt is received during startup (or
+// repeatedly during shutdown). The function returned unregisters it again and should be called once the child exited.
+// Without Notify, nothing is registered and interrupts are left to the program embedding microkube.
+func (s *SignalStateMachine) RegisterChild(kill func()) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.enabled {
+		return func() {}
+	}
+	id := s.nextID
+	s.nextID++
+	s.killers[id] = kill
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.killers, id)
+	}
+}
+
+// EnterStartup switches to SignalPhaseStartup, dropping the graceful shutdown handler if there is one. This is
+// used when (re-)starting services.
+func (s *SignalStateMachine) EnterStartup() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.phase = SignalPhaseStartup
+	s.graceful = nil
+}
+
+// EnterRunning switches to SignalPhaseRunning. The next interrupt will invoke 'graceful' (exactly once, in it's own
+// goroutine) instead of killing child processes.
+func (s *SignalStateMachine) EnterRunning(graceful func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.phase = SignalPhaseRunning
+	s.graceful = graceful
+}
+
+// SetReloadHandler sets the function invoked (in it's own goroutine) on SIGHUP. SIGHUP is ignored outside of
+// SignalPhaseRunning and if no reload handler is set, it never counts as an interrupt.
+func (s *SignalStateMachine) SetReloadHandler(reload func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reload = reload
+}
+
+// handle reacts to a single signal according to the current phase
+func (s *SignalStateMachine) handle(sig os.Signal) {
+	if sig == syscall.SIGHUP {
+		s.handleReload()
+		return
+	}
+	s.lock.Lock()
+	phase := s.phase
+	graceful := s.graceful
+	var killers []func()
+	for _, kill := range s.killers {
+		killers = append(killers, kill)
+	}
+	s.phase = SignalPhaseStopping
+	s.graceful = nil
+	s.lock.Unlock()
+
+	if phase == SignalPhaseRunning && graceful != nil {
+		go graceful()
+		return
+	}
+	for _, kill := range killers {
+		kill()
+	}
+}
+
+// handleReload invokes the reload handler if the process is running
+func (s *SignalStateMachine) handleReload() {
+	s.lock.Lock()
+	reload := s.reload
+	if s.phase != SignalPhaseRunning {
+		reload = nil
+	}
+	s.lock.Unlock()
+
+	if reload != nil {
+		go reload()
+	}
+}
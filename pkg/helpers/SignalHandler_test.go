@@ -0,0 +1,178 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newEnabledSignalStateMachine creates a SignalStateMachine that tracks children as if Notify was called, without
+// registering for OS signals
+func newEnabledSignalStateMachine() *SignalStateMachine {
+	uut := newSignalStateMachine()
+	uut.enabled = true
+	return uut
+}
+
+// TestSignalWithoutNotify checks whether children aren't tracked unless the program opted in to signal handling
+func TestSignalWithoutNotify(t *testing.T) {
+	uut := newSignalStateMachine()
+	killed := 0
+	uut.RegisterChild(func() { killed++ })()
+	uut.RegisterChild(func() { killed++ })
+
+	uut.handle(os.Interrupt)
+	if killed != 0 {
+		t.Fatalf("Unexpected kill count: %d", killed)
+	}
+}
+
+// TestSignalStartupKillsChildren checks whether an interrupt during startup kills all registered children
+func TestSignalStartupKillsChildren(t *testing.T) {
+	uut := newEnabledSignalStateMachine()
+	killed := 0
+	uut.RegisterChild(func() { killed++ })
+	unregister := uut.RegisterChild(func() { killed += 10 })
+	unregister()
+
+	uut.handle(os.Interrupt)
+	if killed != 1 {
+		t.Fatalf("Unexpected kill count: %d", killed)
+	}
+	if uut.Phase() != SignalPhaseStopping {
+		t.Fatalf("Unexpected phase: %d", uut.Phase())
+	}
+}
+
+// TestSignalStartupFailureThenShutdown checks the transition from a failed startup (which got interrupted) over a
+// retried startup to a graceful shutdown: only the graceful handler may run, and it may run only once
+func TestSignalStartupFailureThenShutdown(t *testing.T) {
+	uut := newEnabledSignalStateMachine()
+	killed := 0
+	unregister := uut.RegisterChild(func() { killed++ })
+
+	// Startup fails
+	uut.handle(os.Interrupt)
+	unregister()
+	if killed != 1 {
+		t.Fatalf("Unexpected kill count: %d", killed)
+	}
+
+	// Retry
+	uut.EnterStartup()
+	if uut.Phase() != SignalPhaseStartup {
+		t.Fatalf("Unexpected phase: %d", uut.Phase())
+	}
+	uut.RegisterChild(func() { killed++ })
+	gracefulChan := make(chan bool, 2)
+	uut.EnterRunning(func() { gracefulChan <- true })
+
+	// Shutdown
+	uut.handle(os.Interrupt)
+	select {
+	case <-gracefulChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Graceful handler wasn't invoked")
+	}
+	if killed != 1 {
+		t.Fatalf("Children were killed despite graceful shutdown, kill count: %d", killed)
+	}
+	if uut.Phase() != SignalPhaseStopping {
+		t.Fatalf("Unexpected phase: %d", uut.Phase())
+	}
+
+	// A second interrupt aborts the graceful shutdown
+	uut.handle(os.Interrupt)
+	if killed != 2 {
+		t.Fatalf("Unexpected kill count: %d", killed)
+	}
+	select {
+	case <-gracefulChan:
+		t.Fatal("Graceful handler was invoked twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestSignalEnterStartupDropsGracefulHandler checks whether re-entering startup deactivates the graceful handler
+func TestSignalEnterStartupDropsGracefulHandler(t *testing.T) {
+	uut := newEnabledSignalStateMachine()
+	killed := 0
+	uut.RegisterChild(func() { killed++ })
+	gracefulChan := make(chan bool, 1)
+	uut.EnterRunning(func() { gracefulChan <- true })
+	uut.EnterStartup()
+
+	uut.handle(os.Interrupt)
+	if killed != 1 {
+		t.Fatalf("Unexpected kill count: %d", killed)
+	}
+	select {
+	case <-gracefulChan:
+		t.Fatal("Graceful handler was invoked during startup")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestSignalReload checks whether SIGHUP invokes the reload handler only while running and is never treated as an
+// interrupt
+func TestSignalReload(t *testing.T) {
+	uut := newEnabledSignalStateMachine()
+	killed := 0
+	uut.RegisterChild(func() { killed++ })
+	reloadChan := make(chan bool, 2)
+	uut.SetReloadHandler(func() { reloadChan <- true })
+
+	// Ignored during startup
+	uut.handle(syscall.SIGHUP)
+	select {
+	case <-reloadChan:
+		t.Fatal("Reload handler was invoked during startup")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if killed != 0 || uut.Phase() != SignalPhaseStartup {
+		t.Fatalf("SIGHUP was treated as interrupt, kill count: %d, phase: %d", killed, uut.Phase())
+	}
+
+	gracefulChan := make(chan bool, 1)
+	uut.EnterRunning(func() { gracefulChan <- true })
+	uut.handle(syscall.SIGHUP)
+	select {
+	case <-reloadChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reload handler wasn't invoked")
+	}
+	if uut.Phase() != SignalPhaseRunning {
+		t.Fatalf("Unexpected phase: %d", uut.Phase())
+	}
+	select {
+	case <-gracefulChan:
+		t.Fatal("Graceful handler was invoked on SIGHUP")
+	default:
+	}
+
+	// Ignored while stopping
+	uut.handle(os.Interrupt)
+	uut.handle(syscall.SIGHUP)
+	select {
+	case <-reloadChan:
+		t.Fatal("Reload handler was invoked while stopping")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
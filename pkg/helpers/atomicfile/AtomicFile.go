@@ -0,0 +1,79 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package atomicfile writes files atomically, so that readers either see the old or the new content, but never a
+// partially written file. It doesn't depend on any other package of microkube, which allows using it everywhere
+// (e.g. in pkg/pki).
+package atomicfile
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write calls 'write' to produce the new content of the file at 'path' and atomically replaces that file with it.
+// The content is first written to a temporary file in the same directory, which is renamed to 'path' once it is
+// complete. If 'write' fails or the process dies in between, the previous file is left untouched.
+func Write(path string, perm os.FileMode, write func(io.Writer) error) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "temporary file creation failed")
+	}
+	// Only does something if we bail out before the rename
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(perm)
+	if err == nil {
+		err = write(tmp)
+	}
+	if err == nil {
+		// Make sure the content is on disk before it becomes visible under the final name
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "write failed")
+	}
+	if closeErr != nil {
+		return errors.Wrap(closeErr, "write failed")
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "rename failed")
+	}
+	// Persist the rename as well. This is best effort, not every file system supports syncing directories.
+	if dirFd, err := os.Open(dir); err == nil {
+		dirFd.Sync()
+		dirFd.Close()
+	}
+	return nil
+}
+
+// WriteBytes atomically replaces the file at 'path' with 'data', see Write
+func WriteBytes(path string, perm os.FileMode, data []byte) error {
+	return Write(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
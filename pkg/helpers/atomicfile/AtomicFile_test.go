@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package atomicfile
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// TestWrite checks whether the file is replaced and gets the right permissions
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-atomicfile")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "config.yml")
+
+	err = WriteBytes(file, 0640, []byte("old content\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = WriteBytes(file, 0640, []byte("new content\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(content) != "new content\n" {
+		t.Fatalf("Unexpected content: '%s'", string(content))
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("Unexpected permissions: %s", info.Mode())
+	}
+}
+
+// TestInterruptedWrite simulates a write that fails halfway through and checks that the old file survives intact
+// and no temporary files are left behind
+func TestInterruptedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "microkube-unittests-atomicfile")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "kubeconfig")
+
+	err = WriteBytes(file, 0600, []byte("apiVersion: v1\nkind: Config\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = Write(file, 0600, func(w io.Writer) error {
+		w.Write([]byte("apiVersion: v1\nki"))
+		return errors.New("killed")
+	})
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(content) != "apiVersion: v1\nkind: Config\n" {
+		t.Fatalf("Old file was modified: '%s'", string(content))
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Temporary file left behind, directory contains %d entries", len(entries))
+	}
+}
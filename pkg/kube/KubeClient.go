@@ -24,10 +24,20 @@ import (
 	av1 "k8s.io/api/core/v1"
 	"k8s.io/api/policy/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,14 +45,24 @@ import (
 // kubeBoolPatch is used to serialize a boolean change to JSON
 type kubeMergePatch map[string]interface{}
 
+// applyPatchType is the content type used for server-side apply patches. client-go doesn't know about it yet.
+const applyPatchType = types.PatchType("application/apply-patch+yaml")
+
+// dryRunFieldManager is the field manager recorded for dry runs. Since nothing is persisted, it's never visible.
+const dryRunFieldManager = "microkube-validate"
+
 // KubeClient abstracts operations on a running kubernetes cluster
 type KubeClient struct {
 	// Kubernetes client set for interacting with the real API
 	client kubernetes.Interface
 	// Name of the single node
 	node string
+	// Name of the node to operate on if the cluster has more than one, empty if it's the only node
+	pinnedNode string
 	// Object reference to the single node
 	nodeRef *av1.Node
+	// Maps object kinds to API resources, populated lazily from the discovery API
+	mapper meta.RESTMapper
 }
 
 // NewKubeClient creates a KubeClient object, configuring it from the provided kubeconfig. The connection will be
@@ -63,11 +83,71 @@ func NewKubeClient(kubeconfig string) (*KubeClient, error) {
 	return &obj, nil
 }
 
-// findNode ensures that there is only one node and updates the internal fields 'node' and 'nodeRef' to reference it
+// NewKubeClientWithRetry creates a KubeClient like NewKubeClient and checks that the apiserver accepts requests. Right
+// after the apiserver reports healthy, connections may still be refused for a moment, therefore this is retried with
+// a backoff that is doubled starting at .1 seconds until the limit of 7 seconds is exceeded or 'ctx' expires.
+func NewKubeClientWithRetry(ctx context.Context, kubeconfig string) (*KubeClient, error) {
+	waitTime := 100 * time.Millisecond
+	for {
+		client, err := NewKubeClient(kubeconfig)
+		if err == nil {
+			_, err = client.client.Discovery().ServerVersion()
+			if err == nil {
+				return client, nil
+			}
+			err = errors.Wrap(err, "apiserver not reachable")
+		}
+		if waitTime > 7*time.Second {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "kube-interface",
+			"wait":      waitTime,
+		}).WithError(err).Debug("Kube client init failed, retrying")
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(waitTime):
+		}
+		waitTime = 2 * waitTime
+	}
+}
+
+// NewKubeClientForInterface creates a KubeClient object using an existing client set, e.g. a fake one in tests
+func NewKubeClientForInterface(client kubernetes.Interface) *KubeClient {
+	return &KubeClient{
+		client: client,
+	}
+}
+
+// LocalNodeName returns the name the kubelet on this host registers its node as, that is the lower case hostname
+func LocalNodeName() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't get hostname")
+	}
+	return strings.ToLower(strings.TrimSpace(hostname)), nil
+}
+
+// SetNode makes the client operate on the node 'name' (e.g. when waiting for or draining the node) instead of
+// requiring the cluster to have a single node. This allows other nodes to join the cluster.
+func (k *KubeClient) SetNode(name string) {
+	k.pinnedNode = name
+	k.node = ""
+	k.nodeRef = nil
+}
+
+// findNode ensures that there is only one node, or finds the node set with SetNode, and updates the internal fields
+// 'node' and 'nodeRef' to reference it
 func (k *KubeClient) findNode() {
 	if k.node != "" {
 		return
 	}
+	if k.pinnedNode != "" {
+		k.findPinnedNode()
+		return
+	}
 	nodeList, err := k.client.CoreV1().Nodes().List(v1.ListOptions{})
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -94,45 +174,70 @@ func (k *KubeClient) findNode() {
 	k.node = k.nodeRef.Name
 }
 
-func (k *KubeClient) FindDashboardAdminSecret() string {
-	k.findNode()
-	if k.node == "" {
-		return ""
+// findPinnedNode updates the internal fields 'node' and 'nodeRef' to reference the node set with SetNode
+func (k *KubeClient) findPinnedNode() {
+	node, err := k.client.CoreV1().Nodes().Get(k.pinnedNode, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "kube-interface",
+			"node":      k.pinnedNode,
+		}).Info("Node not registered yet")
+		return
 	}
-
-	secList, err := k.client.CoreV1().Secrets("kube-system").List(v1.ListOptions{})
 	if err != nil {
 		log.WithFields(log.Fields{
 			"app":       "microkube",
 			"component": "kube-interface",
-		}).WithError(err).Warn("Couldn't search for secrets!")
-	}
-	for _, sec := range secList.Items {
-		if strings.HasPrefix(sec.ObjectMeta.Name, "admin-user-token-") {
-			encodedValue := sec.Data["token"]
-			if encodedValue == nil {
-				log.WithFields(log.Fields{
-					"app":       "microkube",
-					"component": "kube-interface",
-					"secret":    sec.ObjectMeta.Name,
-				}).Warn("Found secret, but it didn't contain a token?")
-				continue
-			}
-			/*buf := make([]byte, base64.StdEncoding.DecodedLen(len(encodedValue)))
-			n, err := base64.StdEncoding.Decode(buf, encodedValue)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"app":       "microkube",
-					"component": "kube-interface",
-					"secret":    sec.ObjectMeta.Name,
-					"n":         n,
-				}).WithError(err).Warn("Found secret, but token was invalid base64")
-				continue
-			}*/
-			return string(encodedValue)
+			"node":      k.pinnedNode,
+		}).WithError(err).Warn("Couldn't get node")
+		return
+	}
+	k.nodeRef = node
+	k.node = node.Name
+}
+
+// FindServiceAccountToken returns the token of the service account 'serviceAccount' in 'namespace', e.g. to sign in
+// to the dashboard. The token is taken from the token secret the token controller created for the service account.
+func (k *KubeClient) FindServiceAccountToken(namespace, serviceAccount string) (string, error) {
+	sa, err := k.client.CoreV1().ServiceAccounts(namespace).Get(serviceAccount, v1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't get service account")
+	}
+	for _, ref := range sa.Secrets {
+		secret, err := k.client.CoreV1().Secrets(namespace).Get(ref.Name, v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return "", errors.Wrap(err, "couldn't get secret "+ref.Name)
+		}
+		if token := serviceAccountToken(*secret, serviceAccount); token != "" {
+			return token, nil
+		}
+	}
+	// Token secrets that were created manually aren't referenced by the service account
+	secrets, err := k.client.CoreV1().Secrets(namespace).List(v1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", string(av1.SecretTypeServiceAccountToken)).String(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't list secrets")
+	}
+	for _, secret := range secrets.Items {
+		if token := serviceAccountToken(secret, serviceAccount); token != "" {
+			return token, nil
 		}
 	}
-	return ""
+	return "", errors.Errorf("no token secret found for service account %s/%s", namespace, serviceAccount)
+}
+
+// serviceAccountToken returns the token stored in 'secret' if it is a token secret of 'serviceAccount', an empty
+// string otherwise
+func serviceAccountToken(secret av1.Secret, serviceAccount string) string {
+	if secret.Type != av1.SecretTypeServiceAccountToken ||
+		secret.Annotations[av1.ServiceAccountNameKey] != serviceAccount {
+		return ""
+	}
+	return string(secret.Data[av1.ServiceAccountTokenKey])
 }
 
 func (k *KubeClient) FindService(serviceName string) (string, int32) {
@@ -159,48 +264,94 @@ func (k *KubeClient) FindService(serviceName string) (string, int32) {
 
 // setNodeUnschedulable sets a node (un)schedulable.
 func (k *KubeClient) setNodeUnschedulable(unschedulable bool) {
+	err := k.patchNodeUnschedulable(unschedulable)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "kube-interface",
+			"node":      k.nodeRef.ObjectMeta.Name,
+		}).WithError(err).Warn("Couldn't (un)cordon node!")
+	}
+}
+
+// patchNodeUnschedulable patches 'unschedulable' into the spec of the node
+func (k *KubeClient) patchNodeUnschedulable(unschedulable bool) error {
 	patch := kubeMergePatch{}
 	specMap := make(map[string]interface{})
 	specMap["unschedulable"] = unschedulable
 	patch["spec"] = specMap
 	payloadBin, _ := json.Marshal(patch)
 	_, err := k.client.CoreV1().Nodes().Patch(k.nodeRef.ObjectMeta.Name, types.StrategicMergePatchType, payloadBin)
+	return err
+}
+
+// Cordon marks the node unschedulable, so that no new pods are scheduled to it while pods already running on it keep
+// running, e.g. to reproduce pending pods. Cordoning a node that is cordoned already does nothing.
+func (k *KubeClient) Cordon(ctx context.Context) error {
+	return k.cordon(ctx, true)
+}
+
+// Uncordon marks the node schedulable again. Uncordoning a node that isn't cordoned does nothing.
+func (k *KubeClient) Uncordon(ctx context.Context) error {
+	return k.cordon(ctx, false)
+}
+
+// cordon sets the node (un)schedulable unless it already is
+func (k *KubeClient) cordon(ctx context.Context, unschedulable bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	k.findNode()
+	if k.nodeRef == nil {
+		return errors.New("no node registered")
+	}
+	node, err := k.client.CoreV1().Nodes().Get(k.nodeRef.Name, v1.GetOptions{})
 	if err != nil {
-		log.WithFields(log.Fields{
-			"app":       "microkube",
-			"component": "kube-interface",
-			"node":      k.nodeRef.ObjectMeta.Name,
-		}).WithError(err).Warn("Couldn't (un)cordon node!")
+		return errors.Wrap(err, "couldn't get node")
+	}
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
 	}
+	return errors.Wrap(k.patchNodeUnschedulable(unschedulable), "couldn't patch node")
 }
 
-// DrainNode drains a node, that is stopping all pods on it
+// DrainNode drains a node, that is stopping all pods on it. If no node is registered (yet), there is nothing to do.
 func (k *KubeClient) DrainNode(ctx context.Context) error {
 	// Force client to refresh node
 	k.node = ""
+	k.nodeRef = nil
 	k.findNode()
 	if k.nodeRef == nil {
+		// Shutdown before the node registered, there is nothing to drain
 		log.WithFields(log.Fields{
 			"app":       "microkube",
 			"component": "kube-interface",
-		}).Warn("No node found while draining node?")
-		return errors.New("No node found while draining node?")
+		}).Info("No node registered, nothing to drain")
+		return nil
 	}
-	// Step 1: Disable scheduling on the node
-	k.setNodeUnschedulable(true)
-	// Step 2: Try to remove all pods. This needs to be done pod-by-pod
 	pods, err := k.client.CoreV1().Pods(av1.NamespaceAll).List(v1.ListOptions{})
 	if err != nil {
 		log.WithFields(log.Fields{
 			"app":       "microkube",
 			"component": "kube-interface",
-		}).WithError(err).Fatalf("Couldn't list pods")
-		return errors.New("list pods failed")
+		}).WithError(err).Warn("Couldn't list pods")
+		return errors.Wrap(err, "list pods failed")
 	}
+	evictPods := k.podsToEvict(pods.Items)
+	if len(evictPods) == 0 {
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "kube-interface",
+		}).Info("No pods to evict, skipping drain")
+		return nil
+	}
+	// Step 1: Disable scheduling on the node
+	k.setNodeUnschedulable(true)
+	// Step 2: Try to remove all pods. This needs to be done pod-by-pod
 	var pendingPods []av1.Pod
-	for _, pod := range pods.Items {
-		if deadline, ok := ctx.Deadline(); ok && deadline.Before(time.Now()) {
-			return context.DeadlineExceeded
+	for _, pod := range evictPods {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 		// Create eviction for this pod
 		TEN := int64(10) // We require a pointer to this!
@@ -240,8 +391,8 @@ func (k *KubeClient) DrainNode(ctx context.Context) error {
 		"component": "kube-interface",
 	}).Info("Waiting for evicted pods to stop...")
 	for {
-		if deadline, ok := ctx.Deadline(); ok && deadline.Before(time.Now()) {
-			return context.DeadlineExceeded
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 		runningPods := 0
 		for _, pod := range pendingPods {
@@ -270,16 +421,50 @@ func (k *KubeClient) DrainNode(ctx context.Context) error {
 			}).Info("All pods gone!")
 			return nil
 		}
-		time.Sleep(2 * time.Second)
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// podsToEvict returns the pods of 'pods' that have to be evicted to drain the node. Pods on other nodes (if the node is
+// pinned), pods of daemon sets (which would be recreated right away) and mirror pods of static pods (which can't be
+// evicted) are left alone.
+func (k *KubeClient) podsToEvict(pods []av1.Pod) []av1.Pod {
+	var result []av1.Pod
+	for _, pod := range pods {
+		if k.pinnedNode != "" && pod.Spec.NodeName != k.node {
+			// Running on another node
+			continue
+		}
+		if _, ok := pod.Annotations[av1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+		controller := v1.GetControllerOf(&pod)
+		if controller != nil && controller.Kind == "DaemonSet" {
+			continue
+		}
+		result = append(result, pod)
 	}
+	return result
+}
+
+// NodeCount returns the number of nodes registered in the cluster
+func (k *KubeClient) NodeCount() (int, error) {
+	nodes, err := k.client.CoreV1().Nodes().List(v1.ListOptions{})
+	if err != nil {
+		return 0, errors.Wrap(err, "node list failed")
+	}
+	return len(nodes.Items), nil
 }
 
 // WaitForNode delays execution until a single node exists and is in state 'Ready', removing the unschedulable taint
 // if possible
 func (k *KubeClient) WaitForNode(ctx context.Context) error {
 	for {
-		if deadline, ok := ctx.Deadline(); ok && deadline.Before(time.Now()) {
-			return context.DeadlineExceeded
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 		// Always refresh
 		k.node = ""
@@ -317,3 +502,342 @@ func (k *KubeClient) WaitForNode(ctx context.Context) error {
 		time.Sleep(1 * time.Second)
 	}
 }
+
+// WaitForPodRunning waits until the pod 'name' in 'namespace' is running. If 'ctx' expires first or the pod terminates,
+// the returned error explains why by listing the state of its containers (e.g. ImagePullBackOff, CrashLoopBackOff) and
+// the events recorded for it
+func (k *KubeClient) WaitForPodRunning(ctx context.Context, namespace, name string) error {
+	for {
+		pod, err := k.client.CoreV1().Pods(namespace).Get(name, v1.GetOptions{})
+		if err == nil {
+			switch pod.Status.Phase {
+			case av1.PodRunning:
+				return nil
+			case av1.PodSucceeded, av1.PodFailed:
+				return errors.Errorf("pod %s/%s terminated in phase %s: %s", namespace, name, pod.Status.Phase,
+					k.describePod(pod))
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "couldn't get pod")
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return errors.Wrap(err, "pod didn't appear")
+			}
+			return errors.Errorf("pod %s/%s not running (phase %s): %s", namespace, name, pod.Status.Phase,
+				k.describePod(pod))
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// describePod summarizes why 'pod' isn't running, based on the state of its containers and its events
+func (k *KubeClient) describePod(pod *av1.Pod) string {
+	var reasons []string
+	statuses := append(append([]av1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil {
+			reasons = append(reasons, "container "+status.Name+" waiting: "+status.State.Waiting.Reason+
+				" ("+status.State.Waiting.Message+")")
+		} else if status.State.Terminated != nil {
+			reasons = append(reasons, "container "+status.Name+" terminated: "+status.State.Terminated.Reason+
+				" (exit code "+strconv.Itoa(int(status.State.Terminated.ExitCode))+")")
+		}
+	}
+	events, err := k.podEvents(pod)
+	if err != nil {
+		reasons = append(reasons, err.Error())
+	}
+	for _, event := range events {
+		reasons = append(reasons, "event "+event.Type+" "+event.Reason+": "+event.Message)
+	}
+	if len(reasons) == 0 {
+		return "no container states or events recorded"
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// podEvents returns the events recorded for 'pod', oldest first
+func (k *KubeClient) podEvents(pod *av1.Pod) ([]av1.Event, error) {
+	eventList, err := k.client.CoreV1().Events(pod.Namespace).List(v1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "Pod",
+			"involvedObject.name": pod.Name,
+		}.String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list pod events")
+	}
+	var events []av1.Event
+	for _, event := range eventList.Items {
+		// Skip events of earlier pods with the same name
+		if event.InvolvedObject.Kind != "Pod" || event.InvolvedObject.Name != pod.Name ||
+			(event.InvolvedObject.UID != "" && event.InvolvedObject.UID != pod.UID) {
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	return events, nil
+}
+
+// CheckAPIServerEndpoints waits until the 'kubernetes' service in namespace 'default' has an endpoint that accepts
+// connections. The apiserver publishes it's advertise address there, so if this fails, in-cluster clients can't reach
+// the apiserver even though it is healthy.
+func (k *KubeClient) CheckAPIServerEndpoints(ctx context.Context) error {
+	var lastErr error
+	for {
+		lastErr = k.checkAPIServerEndpoints(ctx)
+		if lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(lastErr, "apiserver not reachable through the kubernetes service, is the advertise "+
+				"address correct?")
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// checkAPIServerEndpoints tries to connect to all endpoints of the 'kubernetes' service once, returning nil as soon as
+// one accepts the connection
+func (k *KubeClient) checkAPIServerEndpoints(ctx context.Context) error {
+	endpoints, err := k.client.CoreV1().Endpoints("default").Get("kubernetes", v1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "couldn't get endpoints of the kubernetes service")
+	}
+	var addresses []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			for _, port := range subset.Ports {
+				addresses = append(addresses, net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return errors.New("the kubernetes service has no endpoints")
+	}
+	for _, address := range addresses {
+		dialer := net.Dialer{Timeout: 2 * time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "kube-interface",
+			"endpoint":  address,
+		}).WithError(err).Debug("Endpoint of the kubernetes service unreachable")
+	}
+	return errors.Errorf("no endpoint of the kubernetes service is reachable: %s", strings.Join(addresses, ", "))
+}
+
+// WaitForServiceEndpoints waits until the service 'name' in 'namespace' has at least one ready endpoint address. A
+// service exists as soon as it's created, but requests to it fail until one of its pods is ready. If 'ctx' expires
+// first, the returned error includes the number of ready and not ready addresses seen last.
+func (k *KubeClient) WaitForServiceEndpoints(ctx context.Context, namespace, name string) error {
+	for {
+		ready, notReady := 0, 0
+		endpoints, err := k.client.CoreV1().Endpoints(namespace).Get(name, v1.GetOptions{})
+		if err == nil {
+			for _, subset := range endpoints.Subsets {
+				ready += len(subset.Addresses)
+				notReady += len(subset.NotReadyAddresses)
+			}
+			if ready > 0 {
+				return nil
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "couldn't get endpoints")
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return errors.Wrap(err, "endpoints didn't appear")
+			}
+			return errors.Errorf("service %s/%s has %d ready and %d not ready endpoint addresses", namespace, name,
+				ready, notReady)
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+// ServerSideApply applies 'obj' using the apiserver's server-side apply, recording 'fieldManager' as the owner of all
+// fields set in 'obj'. Unlike a naive create-or-update, this only touches the fields contained in 'obj' and reports
+// fields owned by other managers as a conflict instead of overwriting them. 'obj' needs to have apiVersion and kind
+// set. This requires an apiserver with the ServerSideApply feature enabled.
+func (k *KubeClient) ServerSideApply(ctx context.Context, obj runtime.Object, fieldManager string) error {
+	return k.apply(ctx, obj, fieldManager, false, false)
+}
+
+// ForceServerSideApply is like ServerSideApply, but takes ownership of fields owned by other managers instead of
+// reporting a conflict. This is meant for objects 'fieldManager' is solely responsible for, e.g. cluster addons that
+// were created with kubectl before.
+func (k *KubeClient) ForceServerSideApply(ctx context.Context, obj runtime.Object, fieldManager string) error {
+	return k.apply(ctx, obj, fieldManager, true, false)
+}
+
+// DryRunApply validates 'obj' by running a server-side apply with dryRun=All, so that the apiserver runs defaulting,
+// validation and admission without persisting anything. The returned error describes why 'obj' is invalid. 'obj'
+// needs to have apiVersion and kind set. This requires an apiserver with the DryRun and ServerSideApply features.
+func (k *KubeClient) DryRunApply(ctx context.Context, obj runtime.Object) error {
+	return k.apply(ctx, obj, dryRunFieldManager, false, true)
+}
+
+// apply implements ServerSideApply, ForceServerSideApply and DryRunApply
+func (k *KubeClient) apply(ctx context.Context, obj runtime.Object, fieldManager string, force, dryRun bool) error {
+	kind, namespace, name, objPath, err := k.objectLocation(obj)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrap(err, "couldn't serialize object")
+	}
+	logCtx := log.WithFields(log.Fields{
+		"app":          "microkube",
+		"component":    "kube-interface",
+		"kind":         kind,
+		"namespace":    namespace,
+		"name":         name,
+		"fieldManager": fieldManager,
+		"dryRun":       dryRun,
+	})
+
+	request := k.client.CoreV1().RESTClient().Patch(applyPatchType).
+		Context(ctx).
+		AbsPath(objPath).
+		Param("fieldManager", fieldManager)
+	if force {
+		request = request.Param("force", "true")
+	}
+	if dryRun {
+		request = request.Param("dryRun", "All")
+	}
+	err = request.Body(payload).Do().Error()
+	if err != nil {
+		if dryRun {
+			return errors.Wrap(err, "server-side dry run failed")
+		}
+		if apierrors.IsConflict(err) {
+			logCtx.WithError(err).Warn("Server-side apply conflicts with another field manager")
+			return errors.Wrap(err, "field manager conflict")
+		}
+		return errors.Wrap(err, "server-side apply failed")
+	}
+	logCtx.Debug("Object applied")
+	return nil
+}
+
+// DeleteObject deletes 'obj', which is identified by its apiVersion, kind, namespace and name. Dependents (e.g. the
+// pods of a deployment) are deleted in the background. Objects that don't exist are ignored.
+func (k *KubeClient) DeleteObject(ctx context.Context, obj runtime.Object) error {
+	kind, namespace, name, objPath, err := k.objectLocation(obj)
+	if err != nil {
+		return err
+	}
+	propagation := v1.DeletePropagationBackground
+	payload, err := json.Marshal(v1.DeleteOptions{
+		TypeMeta: v1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "DeleteOptions",
+		},
+		PropagationPolicy: &propagation,
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't serialize delete options")
+	}
+	logCtx := log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "kube-interface",
+		"kind":      kind,
+		"namespace": namespace,
+		"name":      name,
+	})
+
+	err = k.client.CoreV1().RESTClient().Delete().
+		Context(ctx).
+		AbsPath(objPath).
+		Body(payload).
+		Do().
+		Error()
+	if apierrors.IsNotFound(err) {
+		logCtx.Debug("Object doesn't exist")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "delete failed")
+	}
+	logCtx.Debug("Object deleted")
+	return nil
+}
+
+// objectLocation returns kind, namespace, name and REST path of 'obj'. Namespaced objects without namespace are
+// located in the default namespace.
+func (k *KubeClient) objectLocation(obj runtime.Object) (kind, namespace, name, objPath string, err error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Kind == "" || gvk.Version == "" {
+		return "", "", "", "", errors.New("object has no apiVersion/kind")
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", "", "", "", errors.Wrap(err, "couldn't access object metadata")
+	}
+	if accessor.GetName() == "" {
+		return "", "", "", "", errors.New("object has no name")
+	}
+	mapping, err := k.restMapping(gvk)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace = accessor.GetNamespace()
+		if namespace == "" {
+			namespace = av1.NamespaceDefault
+		}
+	}
+	return gvk.Kind, namespace, accessor.GetName(), applyPath(mapping.Resource, namespace, accessor.GetName()), nil
+}
+
+// restMapping finds the API resource of kind 'gvk', refreshing the discovery information once if the kind is unknown
+// (e.g. because a CRD was created in the meantime)
+func (k *KubeClient) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	if k.mapper != nil {
+		mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err == nil {
+			return mapping, nil
+		}
+		if !meta.IsNoMatchError(err) {
+			return nil, errors.Wrap(err, "couldn't map "+gvk.String()+" to a resource")
+		}
+	}
+	groupResources, err := restmapper.GetAPIGroupResources(k.client.Discovery())
+	if err != nil {
+		return nil, errors.Wrap(err, "API discovery failed")
+	}
+	k.mapper = restmapper.NewDiscoveryRESTMapper(groupResources)
+	mapping, err := k.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't map "+gvk.String()+" to a resource")
+	}
+	return mapping, nil
+}
+
+// applyPath returns the REST path of the object 'name' of resource 'gvr', which is cluster-scoped if 'namespace' is
+// empty
+func applyPath(gvr schema.GroupVersionResource, namespace, name string) string {
+	segments := []string{"/apis", gvr.Group, gvr.Version}
+	if gvr.Group == "" {
+		segments = []string{"/api", gvr.Version}
+	}
+	if namespace != "" {
+		segments = append(segments, "namespaces", namespace)
+	}
+	return path.Join(append(segments, gvr.Resource, name)...)
+}
@@ -20,10 +20,19 @@ import (
 	"context"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/fake"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -144,6 +153,163 @@ func TestKubeClientDrain(t *testing.T) {
 	}
 }
 
+// TestKubeClientDrainWithoutNode tests whether draining succeeds if no node is registered yet
+func TestKubeClientDrainWithoutNode(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	uut := NewKubeClientForInterface(mockClientWithNode("test", false, false))
+	ctx, cfunc := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	err := uut.DrainNode(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: '%s'", err)
+	}
+}
+
+// TestKubeClientDrainOnlyDaemonSets tests whether draining is skipped if only pods of daemon sets run on the node
+func TestKubeClientDrainOnlyDaemonSets(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	fakeKube := mockClientWithNode("test", false, true)
+	err := fakeKube.CoreV1().Pods("default").Delete("dummyPod", &metav1.DeleteOptions{})
+	assert.NoError(t, err, "couldn't delete dummy pod")
+	isController := true
+	_, err = fakeKube.CoreV1().Pods("default").Create(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "proxy",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "DaemonSet", Name: "proxy", Controller: &isController},
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "test",
+		},
+	})
+	assert.NoError(t, err, "couldn't create daemon set pod")
+	uut := NewKubeClientForInterface(fakeKube)
+	ctx, cfunc := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.NoError(t, uut.WaitForNode(ctx), "node not found")
+	assert.NoError(t, uut.DrainNode(ctx), "drain failed")
+	node, err := fakeKube.CoreV1().Nodes().Get("test", metav1.GetOptions{})
+	assert.NoError(t, err, "couldn't get node")
+	assert.False(t, node.Spec.Unschedulable, "node cordoned without pods to evict")
+}
+
+// TestKubeClientCordon tests whether cordoning and uncordoning toggle the node's schedulability and can be repeated
+func TestKubeClientCordon(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	fakeKube := mockClientWithNode("test", false, true)
+	uut := KubeClient{
+		client: fakeKube,
+	}
+	unschedulable := func() bool {
+		node, err := fakeKube.CoreV1().Nodes().Get("test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Couldn't get node: '%s'", err)
+		}
+		return node.Spec.Unschedulable
+	}
+
+	ctx := context.Background()
+	assert.NoError(t, uut.Uncordon(ctx), "couldn't uncordon schedulable node")
+	assert.False(t, unschedulable(), "node unschedulable after uncordon")
+	assert.NoError(t, uut.Cordon(ctx), "couldn't cordon node")
+	assert.True(t, unschedulable(), "node schedulable after cordon")
+	assert.NoError(t, uut.Cordon(ctx), "couldn't cordon cordoned node")
+	assert.True(t, unschedulable(), "node schedulable after second cordon")
+	assert.NoError(t, uut.Uncordon(ctx), "couldn't uncordon node")
+	assert.NoError(t, uut.Uncordon(ctx), "couldn't uncordon node twice")
+	assert.False(t, unschedulable(), "node unschedulable after uncordon")
+
+	uut = KubeClient{
+		client: mockClientWithNode("test", false, false),
+	}
+	assert.Error(t, uut.Cordon(ctx), "cordoned missing node")
+}
+
+// TestPodsToEvict tests whether pods of daemon sets, mirror pods and pods on other nodes are left alone when draining
+func TestPodsToEvict(t *testing.T) {
+	isController := true
+	pod := func(name, node, ownerKind string, annotations map[string]string) v1.Pod {
+		result := v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: annotations,
+			},
+			Spec: v1.PodSpec{
+				NodeName: node,
+			},
+		}
+		if ownerKind != "" {
+			result.OwnerReferences = []metav1.OwnerReference{
+				{Kind: ownerKind, Name: name, Controller: &isController},
+			}
+		}
+		return result
+	}
+	pods := []v1.Pod{
+		pod("plain", "test", "", nil),
+		pod("replicated", "test", "ReplicaSet", nil),
+		pod("daemon", "test", "DaemonSet", nil),
+		pod("mirror", "test", "", map[string]string{v1.MirrorPodAnnotationKey: "abc"}),
+		pod("remote", "worker", "", nil),
+	}
+	names := func(pods []v1.Pod) []string {
+		var result []string
+		for _, pod := range pods {
+			result = append(result, pod.Name)
+		}
+		return result
+	}
+
+	uut := KubeClient{node: "test"}
+	assert.Equal(t, []string{"plain", "replicated", "remote"}, names(uut.podsToEvict(pods)),
+		"wrong pods for unpinned node")
+	uut.pinnedNode = "test"
+	assert.Equal(t, []string{"plain", "replicated"}, names(uut.podsToEvict(pods)), "wrong pods for pinned node")
+}
+
+// TestKubeClientPinnedNode tests whether KubeClient only operates on the node it was told to in a cluster with more
+// than one node
+func TestKubeClientPinnedNode(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	fakeKube := mockClientWithNode("test", true, true)
+	_, err := fakeKube.CoreV1().Nodes().Create(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "worker",
+		},
+		Spec: v1.NodeSpec{
+			Unschedulable: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Couldn't create second node: '%s'", err)
+	}
+	uut := NewKubeClientForInterface(fakeKube)
+	uut.SetNode("test")
+	ctx, cfunc := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	err = uut.WaitForNode(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: '%s'", err)
+	}
+	worker, err := fakeKube.CoreV1().Nodes().Get("worker", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Couldn't get second node: '%s'", err)
+	}
+	assert.True(t, worker.Spec.Unschedulable, "other node modified")
+
+	uut.SetNode("missing")
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	err = uut.WaitForNode(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "missing node accepted")
+}
+
 // TestKubeClientFindFunctions tests whether KubeClient correctly returns error values in a cluster with unexpected
 // structur
 func TestKubeClientFindFunctions(t *testing.T) {
@@ -153,9 +319,337 @@ func TestKubeClientFindFunctions(t *testing.T) {
 	uut := KubeClient{
 		client: fakeKube,
 	}
-	res := uut.FindDashboardAdminSecret()
-	assert.Equal(t, res, "", "Unexpectedly found admin secret")
+	_, err := uut.FindServiceAccountToken("kube-system", "admin-user")
+	assert.Error(t, err, "Unexpectedly found admin token")
 	res, port := uut.FindService("dummy")
 	assert.Equal(t, res, "", "Unexpectedly found dashboard IP")
 	assert.Equal(t, port == 0, true, "Unexpectedly found dashboard port")
 }
+
+// TestFindServiceAccountToken tests whether tokens are found both through the service account and by annotation
+func TestFindServiceAccountToken(t *testing.T) {
+	tokenSecret := func(name, serviceAccount, token string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "kube-system",
+				Annotations: map[string]string{v1.ServiceAccountNameKey: serviceAccount},
+			},
+			Type: v1.SecretTypeServiceAccountToken,
+			Data: map[string][]byte{v1.ServiceAccountTokenKey: []byte(token)},
+		}
+	}
+	referenced := v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "dashboard-admin", Namespace: "kube-system"},
+		Secrets:    []v1.ObjectReference{{Name: "missing"}, {Name: "dashboard-admin-token-abcde"}},
+	}
+	unreferenced := v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "admin-user", Namespace: "kube-system"},
+	}
+	uut := KubeClient{
+		client: fake.NewSimpleClientset(&referenced, &unreferenced,
+			tokenSecret("dashboard-admin-token-abcde", "dashboard-admin", "token1"),
+			tokenSecret("admin-user-token", "admin-user", "token2"),
+			tokenSecret("other-token", "other", "token3")),
+	}
+
+	token, err := uut.FindServiceAccountToken("kube-system", "dashboard-admin")
+	assert.NoError(t, err, "couldn't find referenced token")
+	assert.Equal(t, "token1", token, "unexpected token")
+	token, err = uut.FindServiceAccountToken("kube-system", "admin-user")
+	assert.NoError(t, err, "couldn't find unreferenced token")
+	assert.Equal(t, "token2", token, "unexpected token")
+	_, err = uut.FindServiceAccountToken("default", "admin-user")
+	assert.Error(t, err, "found token of missing service account")
+}
+
+// TestApplyPath tests whether server-side apply requests are sent to the right resource path
+func TestApplyPath(t *testing.T) {
+	assert.Equal(t, "/api/v1/namespaces/kube-system/configmaps/coredns",
+		applyPath(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, "kube-system", "coredns"),
+		"wrong path for namespaced core resource")
+	assert.Equal(t, "/apis/apps/v1/namespaces/default/deployments/test",
+		applyPath(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, "default", "test"),
+		"wrong path for namespaced group resource")
+	assert.Equal(t, "/apis/rbac.authorization.k8s.io/v1/clusterroles/admin",
+		applyPath(schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1",
+			Resource: "clusterroles"}, "", "admin"),
+		"wrong path for cluster-scoped resource")
+}
+
+// TestServerSideApplyInvalid tests whether ServerSideApply rejects objects it can't address
+func TestServerSideApplyInvalid(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	uut := KubeClient{
+		client: mockClientWithNode("test", false, true),
+	}
+	err := uut.ServerSideApply(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+		},
+	}, "microkube")
+	if assert.Error(t, err) {
+		assert.Equal(t, "object has no apiVersion/kind", err.Error(), "wrong error returned")
+	}
+	err = uut.ServerSideApply(context.Background(), &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+	}, "microkube")
+	if assert.Error(t, err) {
+		assert.Equal(t, "object has no name", err.Error(), "wrong error returned")
+	}
+}
+
+// kubernetesEndpoints returns the endpoints of the 'kubernetes' service pointing at 'address'
+func kubernetesEndpoints(address string) *v1.Endpoints {
+	host, portStr, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(portStr)
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kubernetes",
+			Namespace: "default",
+		},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{{IP: host}},
+				Ports:     []v1.EndpointPort{{Name: "https", Port: int32(port)}},
+			},
+		},
+	}
+}
+
+// TestCheckAPIServerEndpoints tests whether missing and unreachable endpoints of the kubernetes service are detected
+func TestCheckAPIServerEndpoints(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: '%s'", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	// Find a port nobody listens on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Couldn't listen: '%s'", err)
+	}
+	closedAddress := closed.Addr().String()
+	closed.Close()
+
+	// No endpoints at all
+	uut := KubeClient{
+		client: fake.NewSimpleClientset(),
+	}
+	ctx, cfunc := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.Error(t, uut.CheckAPIServerEndpoints(ctx), "missing error for missing endpoints")
+
+	// Wrong advertise address
+	uut = KubeClient{
+		client: fake.NewSimpleClientset(kubernetesEndpoints(closedAddress)),
+	}
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	err = uut.CheckAPIServerEndpoints(ctx)
+	if assert.Error(t, err, "missing error for unreachable endpoint") {
+		assert.Contains(t, err.Error(), closedAddress, "endpoint missing from error")
+	}
+
+	// Reachable endpoint
+	uut = KubeClient{
+		client: fake.NewSimpleClientset(kubernetesEndpoints(listener.Addr().String())),
+	}
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.NoError(t, uut.CheckAPIServerEndpoints(ctx), "unexpected error for reachable endpoint")
+}
+
+// TestWaitForPodRunning tests whether waiting for a pod succeeds for running pods and explains why other pods aren't
+// running
+func TestWaitForPodRunning(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	running := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "default"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	stuck := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "stuck", Namespace: "default", UID: "stuck-uid"},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name: "app",
+					State: v1.ContainerState{
+						Waiting: &v1.ContainerStateWaiting{
+							Reason:  "ImagePullBackOff",
+							Message: "Back-off pulling image",
+						},
+					},
+				},
+			},
+		},
+	}
+	event := v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "stuck.1", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod",
+			Name: "stuck",
+			UID:  "stuck-uid",
+		},
+		Type:    v1.EventTypeWarning,
+		Reason:  "Failed",
+		Message: "Failed to pull image",
+	}
+	uut := KubeClient{
+		client: fake.NewSimpleClientset(&running, &stuck, &event),
+	}
+
+	ctx, cfunc := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.NoError(t, uut.WaitForPodRunning(ctx, "default", "running"), "unexpected error for running pod")
+
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	err := uut.WaitForPodRunning(ctx, "default", "stuck")
+	if assert.Error(t, err, "missing error for stuck pod") {
+		assert.Contains(t, err.Error(), "ImagePullBackOff", "container state missing from error")
+		assert.Contains(t, err.Error(), "Failed to pull image", "event missing from error")
+	}
+
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.Error(t, uut.WaitForPodRunning(ctx, "default", "missing"), "missing error for missing pod")
+}
+
+// TestWaitForServiceEndpoints tests whether waiting for endpoints only succeeds once a ready address exists
+func TestWaitForServiceEndpoints(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	ready := v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "kube-system"},
+		Subsets: []v1.EndpointSubset{
+			{Addresses: []v1.EndpointAddress{{IP: "10.233.42.2"}}},
+		},
+	}
+	notReady := v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-ready", Namespace: "kube-system"},
+		Subsets: []v1.EndpointSubset{
+			{NotReadyAddresses: []v1.EndpointAddress{{IP: "10.233.42.3"}, {IP: "10.233.42.4"}}},
+		},
+	}
+	uut := KubeClient{
+		client: fake.NewSimpleClientset(&ready, &notReady),
+	}
+
+	ctx, cfunc := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.NoError(t, uut.WaitForServiceEndpoints(ctx, "kube-system", "ready"), "unexpected error for ready service")
+
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	err := uut.WaitForServiceEndpoints(ctx, "kube-system", "not-ready")
+	if assert.Error(t, err, "missing error for service without ready endpoints") {
+		assert.Contains(t, err.Error(), "0 ready and 2 not ready", "endpoint count missing from error")
+	}
+
+	ctx, cfunc = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cfunc()
+	assert.Error(t, uut.WaitForServiceEndpoints(ctx, "kube-system", "missing"), "missing error for missing service")
+}
+
+// TestNewKubeClientWithRetry tests whether client creation is retried until the apiserver accepts requests
+func TestNewKubeClientWithRetry(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	requests := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first two requests like an apiserver that isn't fully up yet
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"major":"1","minor":"18","gitVersion":"v1.18.0"}`))
+	}))
+	defer server.Close()
+
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-kubeclient")
+	if err != nil {
+		t.Fatalf("Couldn't create tempdir: '%s'", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	kubeconfig := path.Join(tmpdir, "kubeconfig")
+	err = ioutil.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: `+server.URL+`
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`), 0600)
+	if err != nil {
+		t.Fatalf("Couldn't write kubeconfig: '%s'", err)
+	}
+
+	ctx, cfunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cfunc()
+	client, err := NewKubeClientWithRetry(ctx, kubeconfig)
+	assert.NoError(t, err, "unexpected error once the apiserver accepts requests")
+	assert.NotNil(t, client, "client missing")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "unexpected number of requests")
+
+	ctx, cfunc = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cfunc()
+	_, err = NewKubeClientWithRetry(ctx, path.Join(tmpdir, "missing"))
+	assert.Error(t, err, "missing error for missing kubeconfig")
+}
+
+// TestDryRunApplyInvalid tests whether DryRunApply rejects objects it can't address before contacting the apiserver
+func TestDryRunApplyInvalid(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	uut := KubeClient{
+		client: mockClientWithNode("test", false, true),
+	}
+	err := uut.DryRunApply(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+		},
+	})
+	if assert.Error(t, err) {
+		assert.Equal(t, "object has no apiVersion/kind", err.Error(), "wrong error returned")
+	}
+}
+
+// TestDeleteObjectInvalid tests whether DeleteObject rejects objects it can't address
+func TestDeleteObjectInvalid(t *testing.T) {
+	logrus.SetLevel(logrus.FatalLevel)
+
+	uut := KubeClient{
+		client: mockClientWithNode("test", false, true),
+	}
+	err := uut.DeleteObject(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+		},
+	})
+	if assert.Error(t, err) {
+		assert.Equal(t, "object has no apiVersion/kind", err.Error(), "wrong error returned")
+	}
+}
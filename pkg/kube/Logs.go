@@ -0,0 +1,123 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	av1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"sync"
+)
+
+// PodLogs streams the logs of all pods in 'namespace' that match 'labelSelector' (e.g. 'k8s-app=kube-dns'), in order
+// of their names. Each pod's log is preceded by a header line '==> namespace/name <=='. If 'follow' is set, the logs
+// of all pods are streamed at the same time instead, since they never end, and each line is prefixed with the name of
+// it's pod. A pod whose log can't be read (e.g. because it didn't start yet) is reported in the stream. The stream
+// ends once all logs are read or 'ctx' is cancelled, the caller has to close it.
+func (k *KubeClient) PodLogs(ctx context.Context, namespace, labelSelector string, follow bool) (io.ReadCloser,
+	error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(v1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list pods")
+	}
+	if len(pods.Items) == 0 {
+		return nil, errors.Errorf("no pods match '%s' in namespace %s", labelSelector, namespace)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+	reader, writer := io.Pipe()
+	go func() {
+		if follow {
+			k.followPodLogs(ctx, pods.Items, writer)
+			writer.Close()
+			return
+		}
+		writer.CloseWithError(k.copyPodLogs(ctx, pods.Items, writer))
+	}()
+	return reader, nil
+}
+
+// podLogStream opens the log of 'pod'
+func (k *KubeClient) podLogStream(ctx context.Context, pod av1.Pod, follow bool) (io.ReadCloser, error) {
+	return k.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &av1.PodLogOptions{Follow: follow}).
+		Context(ctx).
+		Stream()
+}
+
+// copyPodLogs writes the logs of all 'pods' to 'out' one after another, each preceded by a header
+func (k *KubeClient) copyPodLogs(ctx context.Context, pods []av1.Pod, out io.Writer) error {
+	for _, pod := range pods {
+		_, err := fmt.Fprintf(out, "==> %s/%s <==\n", pod.Namespace, pod.Name)
+		if err != nil {
+			return err
+		}
+		stream, err := k.podLogStream(ctx, pod, false)
+		if err != nil {
+			_, err = fmt.Fprintf(out, "couldn't get logs: %s\n", err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		_, err = io.Copy(out, stream)
+		stream.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followPodLogs writes the logs of all 'pods' to 'out' as they are written, prefixing each line with the name of it's
+// pod. Returns once all logs ended.
+func (k *KubeClient) followPodLogs(ctx context.Context, pods []av1.Pod, out io.Writer) {
+	var outLock sync.Mutex
+	writeLine := func(pod av1.Pod, line string) error {
+		outLock.Lock()
+		defer outLock.Unlock()
+		_, err := fmt.Fprintf(out, "[%s] %s\n", pod.Name, line)
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, pod := range pods {
+		wg.Add(1)
+		go func(pod av1.Pod) {
+			defer wg.Done()
+			stream, err := k.podLogStream(ctx, pod, true)
+			if err != nil {
+				writeLine(pod, "couldn't get logs: "+err.Error())
+				return
+			}
+			defer stream.Close()
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				if writeLine(pod, scanner.Text()) != nil {
+					// Nobody is reading anymore
+					return
+				}
+			}
+		}(pod)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+const dnsPodsJSON = `{
+  "kind": "PodList",
+  "apiVersion": "v1",
+  "metadata": {},
+  "items": [
+    {"metadata": {"name": "coredns-b", "namespace": "kube-system", "labels": {"k8s-app": "kube-dns"}}},
+    {"metadata": {"name": "coredns-a", "namespace": "kube-system", "labels": {"k8s-app": "kube-dns"}}}
+  ]
+}`
+
+// TestPodLogs checks whether the logs of all matching pods are concatenated in order of their names
+func TestPodLogs(t *testing.T) {
+	client, server := mockMetricsClient(t, map[string]string{
+		"/api/v1/namespaces/kube-system/pods":               dnsPodsJSON,
+		"/api/v1/namespaces/kube-system/pods/coredns-a/log": "a1\na2\n",
+		"/api/v1/namespaces/kube-system/pods/coredns-b/log": "b1\n",
+	})
+	defer server.Close()
+
+	stream, err := client.PodLogs(context.Background(), "kube-system", "k8s-app=kube-dns", false)
+	assert.NoError(t, err, "couldn't get logs")
+	defer stream.Close()
+	logs, err := ioutil.ReadAll(stream)
+	assert.NoError(t, err, "couldn't read logs")
+	assert.Equal(t, "==> kube-system/coredns-a <==\na1\na2\n==> kube-system/coredns-b <==\nb1\n", string(logs),
+		"unexpected logs")
+}
+
+// TestPodLogsFollow checks whether each followed line is prefixed with it's pod
+func TestPodLogsFollow(t *testing.T) {
+	client, server := mockMetricsClient(t, map[string]string{
+		"/api/v1/namespaces/kube-system/pods":               dnsPodsJSON,
+		"/api/v1/namespaces/kube-system/pods/coredns-a/log": "a1\n",
+		"/api/v1/namespaces/kube-system/pods/coredns-b/log": "b1\n",
+	})
+	defer server.Close()
+
+	stream, err := client.PodLogs(context.Background(), "kube-system", "k8s-app=kube-dns", true)
+	assert.NoError(t, err, "couldn't get logs")
+	defer stream.Close()
+	logs, err := ioutil.ReadAll(stream)
+	assert.NoError(t, err, "couldn't read logs")
+	// Lines of different pods may be interleaved
+	assert.Contains(t, string(logs), "[coredns-a] a1\n", "line of first pod missing")
+	assert.Contains(t, string(logs), "[coredns-b] b1\n", "line of second pod missing")
+}
+
+// TestPodLogsNoPods checks whether an error is returned if no pod matches
+func TestPodLogsNoPods(t *testing.T) {
+	client, server := mockMetricsClient(t, map[string]string{
+		"/api/v1/namespaces/kube-system/pods": `{"kind": "PodList", "apiVersion": "v1", "metadata": {}, "items": []}`,
+	})
+	defer server.Close()
+
+	_, err := client.PodLogs(context.Background(), "kube-system", "k8s-app=kube-dns", false)
+	assert.Error(t, err, "logs returned without pods")
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	av1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// defaultLimitsName is the name of the ResourceQuota and LimitRange objects managed by microkube
+const defaultLimitsName = "microkube-default"
+
+// ParseResourceList parses a comma-separated list of resource quantities like 'cpu=2,memory=4Gi,pods=10'
+func ParseResourceList(spec string) (av1.ResourceList, error) {
+	result := av1.ResourceList{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("expected 'resource=quantity', got '" + item + "'")
+		}
+		quantity, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid quantity for '"+parts[0]+"'")
+		}
+		result[av1.ResourceName(parts[0])] = quantity
+	}
+	return result, nil
+}
+
+// EnsureNamespace creates namespace 'name' if it doesn't exist yet
+func (k *KubeClient) EnsureNamespace(name string) error {
+	_, err := k.client.CoreV1().Namespaces().Get(name, v1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "namespace lookup failed")
+	}
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "kube-interface",
+		"namespace": name,
+	}).Info("Creating namespace")
+	_, err = k.client.CoreV1().Namespaces().Create(&av1.Namespace{
+		ObjectMeta: v1.ObjectMeta{
+			Name: name,
+		},
+	})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, "namespace creation failed")
+	}
+	return nil
+}
+
+// ApplyResourceQuota creates or updates a ResourceQuota limiting the total resource usage in 'namespace' to 'hard'
+func (k *KubeClient) ApplyResourceQuota(namespace string, hard av1.ResourceList) error {
+	quotas := k.client.CoreV1().ResourceQuotas(namespace)
+	quota, err := quotas.Get(defaultLimitsName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = quotas.Create(&av1.ResourceQuota{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      defaultLimitsName,
+				Namespace: namespace,
+			},
+			Spec: av1.ResourceQuotaSpec{
+				Hard: hard,
+			},
+		})
+		return errors.Wrap(err, "resource quota creation failed")
+	}
+	if err != nil {
+		return errors.Wrap(err, "resource quota lookup failed")
+	}
+	quota.Spec.Hard = hard
+	_, err = quotas.Update(quota)
+	return errors.Wrap(err, "resource quota update failed")
+}
+
+// ApplyLimitRange creates or updates a LimitRange in 'namespace' that applies 'limits' as default limits and requests
+// to all containers that don't specify their own
+func (k *KubeClient) ApplyLimitRange(namespace string, limits av1.ResourceList) error {
+	spec := av1.LimitRangeSpec{
+		Limits: []av1.LimitRangeItem{
+			{
+				Type:           av1.LimitTypeContainer,
+				Default:        limits,
+				DefaultRequest: limits,
+			},
+		},
+	}
+	limitRanges := k.client.CoreV1().LimitRanges(namespace)
+	limitRange, err := limitRanges.Get(defaultLimitsName, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = limitRanges.Create(&av1.LimitRange{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      defaultLimitsName,
+				Namespace: namespace,
+			},
+			Spec: spec,
+		})
+		return errors.Wrap(err, "limit range creation failed")
+	}
+	if err != nil {
+		return errors.Wrap(err, "limit range lookup failed")
+	}
+	limitRange.Spec = spec
+	_, err = limitRanges.Update(limitRange)
+	return errors.Wrap(err, "limit range update failed")
+}
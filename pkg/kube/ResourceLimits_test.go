@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+// TestParseResourceList checks parsing of resource lists
+func TestParseResourceList(t *testing.T) {
+	list, err := ParseResourceList("cpu=500m, memory=1Gi,pods=10")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, 3, len(list), "unexpected number of resources")
+	assert.Equal(t, resource.MustParse("500m"), list[v1.ResourceCPU], "unexpected cpu")
+	assert.Equal(t, resource.MustParse("1Gi"), list[v1.ResourceMemory], "unexpected memory")
+
+	_, err = ParseResourceList("cpu")
+	assert.Error(t, err, "expected error missing")
+	_, err = ParseResourceList("cpu=lots")
+	assert.Error(t, err, "expected error missing")
+}
+
+// TestApplyDefaultLimits checks whether namespace, quota and limit range are created and updated
+func TestApplyDefaultLimits(t *testing.T) {
+	uut := KubeClient{
+		client: mockClientWithNode("node", false, true),
+	}
+	assert.NoError(t, uut.EnsureNamespace("quota-test"), "namespace creation failed")
+	assert.NoError(t, uut.EnsureNamespace("quota-test"), "namespace creation isn't idempotent")
+
+	hard, _ := ParseResourceList("pods=5")
+	assert.NoError(t, uut.ApplyResourceQuota("quota-test", hard), "quota creation failed")
+	hard, _ = ParseResourceList("pods=10")
+	assert.NoError(t, uut.ApplyResourceQuota("quota-test", hard), "quota update failed")
+	quota, err := uut.client.CoreV1().ResourceQuotas("quota-test").Get(defaultLimitsName, metav1.GetOptions{})
+	assert.NoError(t, err, "quota missing")
+	assert.Equal(t, resource.MustParse("10"), quota.Spec.Hard[v1.ResourcePods], "quota not updated")
+
+	limits, _ := ParseResourceList("cpu=100m")
+	assert.NoError(t, uut.ApplyLimitRange("quota-test", limits), "limit range creation failed")
+	limitRange, err := uut.client.CoreV1().LimitRanges("quota-test").Get(defaultLimitsName, metav1.GetOptions{})
+	assert.NoError(t, err, "limit range missing")
+	assert.Equal(t, resource.MustParse("100m"), limitRange.Spec.Limits[0].Default[v1.ResourceCPU],
+		"unexpected default limit")
+}
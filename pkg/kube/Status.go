@@ -0,0 +1,135 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/pkg/errors"
+	av1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"time"
+)
+
+// maxStatusEvents is the number of most recent events included in a ClusterStatus
+const maxStatusEvents = 20
+
+// NodeStatus summarizes the state of a node
+type NodeStatus struct {
+	Name          string
+	Ready         bool
+	Unschedulable bool
+}
+
+// PodStatus summarizes the state of a pod
+type PodStatus struct {
+	Namespace string
+	Name      string
+	Phase     av1.PodPhase
+	// Number of containers that are ready and total number of containers
+	ReadyContainers int
+	Containers      int
+	// Sum of the restarts of all containers
+	Restarts int32
+}
+
+// EventStatus summarizes an event
+type EventStatus struct {
+	Time      time.Time
+	Type      string
+	Namespace string
+	// Kind and name of the object the event is about, e.g. 'Pod/kube-dns-1234'
+	Object  string
+	Reason  string
+	Message string
+}
+
+// ClusterStatus is a snapshot of the cluster's nodes, pods and recent events
+type ClusterStatus struct {
+	Nodes []NodeStatus
+	Pods  []PodStatus
+	// Most recent events, newest first
+	Events []EventStatus
+}
+
+// Status returns a snapshot of the cluster's nodes, pods and recent events
+func (k *KubeClient) Status() (*ClusterStatus, error) {
+	status := &ClusterStatus{}
+	nodes, err := k.client.CoreV1().Nodes().List(v1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "node list failed")
+	}
+	for _, node := range nodes.Items {
+		nodeStatus := NodeStatus{
+			Name:          node.Name,
+			Unschedulable: node.Spec.Unschedulable,
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == av1.NodeReady {
+				nodeStatus.Ready = condition.Status == av1.ConditionTrue
+			}
+		}
+		status.Nodes = append(status.Nodes, nodeStatus)
+	}
+
+	pods, err := k.client.CoreV1().Pods(av1.NamespaceAll).List(v1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "pod list failed")
+	}
+	for _, pod := range pods.Items {
+		podStatus := PodStatus{
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			Phase:      pod.Status.Phase,
+			Containers: len(pod.Spec.Containers),
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.Ready {
+				podStatus.ReadyContainers++
+			}
+			podStatus.Restarts += container.RestartCount
+		}
+		status.Pods = append(status.Pods, podStatus)
+	}
+	sort.Slice(status.Pods, func(i, j int) bool {
+		if status.Pods[i].Namespace != status.Pods[j].Namespace {
+			return status.Pods[i].Namespace < status.Pods[j].Namespace
+		}
+		return status.Pods[i].Name < status.Pods[j].Name
+	})
+
+	events, err := k.client.CoreV1().Events(av1.NamespaceAll).List(v1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "event list failed")
+	}
+	for _, event := range events.Items {
+		status.Events = append(status.Events, EventStatus{
+			Time:      event.LastTimestamp.Time,
+			Type:      event.Type,
+			Namespace: event.Namespace,
+			Object:    event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name,
+			Reason:    event.Reason,
+			Message:   event.Message,
+		})
+	}
+	sort.SliceStable(status.Events, func(i, j int) bool {
+		return status.Events[i].Time.After(status.Events[j].Time)
+	})
+	if len(status.Events) > maxStatusEvents {
+		status.Events = status.Events[:maxStatusEvents]
+	}
+	return status, nil
+}
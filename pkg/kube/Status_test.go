@@ -0,0 +1,92 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+// TestStatus checks whether nodes, pods and events are summarized
+func TestStatus(t *testing.T) {
+	client := mockClientWithNode("node", false, true)
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "kubedns"}, {Name: "sidecar"}},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "kubedns", Ready: true, RestartCount: 2},
+				{Name: "sidecar", Ready: false, RestartCount: 1},
+			},
+		},
+	}
+	old := v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "old", Namespace: "kube-system"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "kube-dns"},
+		LastTimestamp:  metav1.NewTime(time.Date(2018, 8, 1, 12, 0, 0, 0, time.UTC)),
+		Type:           v1.EventTypeNormal,
+		Reason:         "Scheduled",
+	}
+	recent := v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "recent", Namespace: "kube-system"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "kube-dns"},
+		LastTimestamp:  metav1.NewTime(time.Date(2018, 8, 1, 12, 5, 0, 0, time.UTC)),
+		Type:           v1.EventTypeWarning,
+		Reason:         "BackOff",
+	}
+	if _, err := client.CoreV1().Pods("kube-system").Create(&pod); err != nil {
+		t.Fatalf("Couldn't create pod: '%s'", err)
+	}
+	for _, event := range []*v1.Event{&old, &recent} {
+		if _, err := client.CoreV1().Events("kube-system").Create(event); err != nil {
+			t.Fatalf("Couldn't create event: '%s'", err)
+		}
+	}
+	uut := KubeClient{client: client}
+
+	status, err := uut.Status()
+	assert.NoError(t, err, "unexpected error")
+	if assert.Len(t, status.Nodes, 1, "unexpected number of nodes") {
+		assert.Equal(t, NodeStatus{Name: "node", Ready: true}, status.Nodes[0], "unexpected node status")
+	}
+	// The mock client contains a pod in namespace 'default' as well
+	if assert.Len(t, status.Pods, 2, "unexpected number of pods") {
+		assert.Equal(t, "dummyPod", status.Pods[0].Name, "pods not sorted")
+		assert.Equal(t, PodStatus{
+			Namespace:       "kube-system",
+			Name:            "kube-dns",
+			Phase:           v1.PodRunning,
+			ReadyContainers: 1,
+			Containers:      2,
+			Restarts:        3,
+		}, status.Pods[1], "unexpected pod status")
+	}
+	if assert.Len(t, status.Events, 2, "unexpected number of events") {
+		assert.Equal(t, "BackOff", status.Events[0].Reason, "events not sorted newest first")
+		assert.Equal(t, "Pod/kube-dns", status.Events[0].Object, "unexpected event object")
+	}
+
+	_, err = (&KubeClient{client: fake.NewSimpleClientset()}).Status()
+	assert.NoError(t, err, "unexpected error for empty cluster")
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	av1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// metricsAPIPath is the base path of the resource metrics API served by metrics-server
+const metricsAPIPath = "/apis/metrics.k8s.io/v1beta1"
+
+// ResourceUsage describes the resource usage of a node or pod as reported by the resource metrics API
+type ResourceUsage struct {
+	// Name of the node or pod
+	Name string
+	// Namespace of the pod, empty for nodes
+	Namespace string
+	// CPU usage in cores
+	CPU resource.Quantity
+	// Memory usage (working set) in bytes
+	Memory resource.Quantity
+	// Time the usage was measured at
+	Timestamp time.Time
+	// Time window the CPU usage was averaged over
+	Window time.Duration
+}
+
+// nodeMetrics mirrors metrics.k8s.io/v1beta1 NodeMetrics, since the metrics client isn't vendored
+type nodeMetrics struct {
+	Metadata  v1.ObjectMeta    `json:"metadata"`
+	Timestamp v1.Time          `json:"timestamp"`
+	Window    v1.Duration      `json:"window"`
+	Usage     av1.ResourceList `json:"usage"`
+}
+
+// containerMetrics mirrors metrics.k8s.io/v1beta1 ContainerMetrics
+type containerMetrics struct {
+	Name  string           `json:"name"`
+	Usage av1.ResourceList `json:"usage"`
+}
+
+// podMetrics mirrors metrics.k8s.io/v1beta1 PodMetrics
+type podMetrics struct {
+	Metadata   v1.ObjectMeta      `json:"metadata"`
+	Timestamp  v1.Time            `json:"timestamp"`
+	Window     v1.Duration        `json:"window"`
+	Containers []containerMetrics `json:"containers"`
+}
+
+// TopNode returns the current resource usage of the node. This requires metrics-server to be running.
+func (k *KubeClient) TopNode(ctx context.Context) (*ResourceUsage, error) {
+	k.findNode()
+	if k.node == "" {
+		return nil, errors.New("no node registered")
+	}
+	data, err := k.getMetrics(ctx, metricsAPIPath+"/nodes/"+k.node)
+	if err != nil {
+		return nil, err
+	}
+	metrics := nodeMetrics{}
+	err = json.Unmarshal(data, &metrics)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't decode node metrics")
+	}
+	usage := ResourceUsage{
+		Name:      metrics.Metadata.Name,
+		CPU:       metrics.Usage[av1.ResourceCPU],
+		Memory:    metrics.Usage[av1.ResourceMemory],
+		Timestamp: metrics.Timestamp.Time,
+		Window:    metrics.Window.Duration,
+	}
+	return &usage, nil
+}
+
+// TopPods returns the current resource usage of all pods in 'namespace' (all namespaces if empty), summing up the
+// usage of all containers of a pod. This requires metrics-server to be running.
+func (k *KubeClient) TopPods(ctx context.Context, namespace string) ([]ResourceUsage, error) {
+	metricsPath := metricsAPIPath + "/pods"
+	if namespace != "" {
+		metricsPath = metricsAPIPath + "/namespaces/" + namespace + "/pods"
+	}
+	data, err := k.getMetrics(ctx, metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	metricsList := struct {
+		Items []podMetrics `json:"items"`
+	}{}
+	err = json.Unmarshal(data, &metricsList)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't decode pod metrics")
+	}
+
+	usages := make([]ResourceUsage, 0, len(metricsList.Items))
+	for _, metrics := range metricsList.Items {
+		usage := ResourceUsage{
+			Name:      metrics.Metadata.Name,
+			Namespace: metrics.Metadata.Namespace,
+			Timestamp: metrics.Timestamp.Time,
+			Window:    metrics.Window.Duration,
+		}
+		for _, container := range metrics.Containers {
+			usage.CPU.Add(container.Usage[av1.ResourceCPU])
+			usage.Memory.Add(container.Usage[av1.ResourceMemory])
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// getMetrics fetches 'metricsPath' from the resource metrics API, which is served by metrics-server through the
+// aggregation layer
+func (k *KubeClient) getMetrics(ctx context.Context, metricsPath string) ([]byte, error) {
+	data, err := k.client.CoreV1().RESTClient().Get().
+		Context(ctx).
+		AbsPath(metricsPath).
+		DoRaw()
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsServiceUnavailable(err) {
+			return nil, errors.Wrap(err, "resource metrics API unavailable, is metrics-server running?")
+		}
+		return nil, errors.Wrap(err, "couldn't query resource metrics API")
+	}
+	return data, nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kube
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const nodeMetricsJSON = `{
+  "kind": "NodeMetrics",
+  "apiVersion": "metrics.k8s.io/v1beta1",
+  "metadata": {"name": "testnode"},
+  "timestamp": "2018-08-01T12:00:00Z",
+  "window": "30s",
+  "usage": {"cpu": "250m", "memory": "1Gi"}
+}`
+
+const podMetricsJSON = `{
+  "kind": "PodMetricsList",
+  "apiVersion": "metrics.k8s.io/v1beta1",
+  "metadata": {},
+  "items": [
+    {
+      "metadata": {"name": "kube-dns", "namespace": "kube-system"},
+      "timestamp": "2018-08-01T12:00:00Z",
+      "window": "30s",
+      "containers": [
+        {"name": "kubedns", "usage": {"cpu": "5m", "memory": "10Mi"}},
+        {"name": "sidecar", "usage": {"cpu": "1m", "memory": "6Mi"}}
+      ]
+    }
+  ]
+}`
+
+// mockMetricsClient returns a client talking to a fake metrics API serving 'responses' by path
+func mockMetricsClient(t *testing.T, responses map[string]string) (*KubeClient, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatalf("Couldn't create client: %s", err)
+	}
+	return &KubeClient{client: client, node: "testnode"}, server
+}
+
+// TestTopNode checks whether node metrics are decoded correctly
+func TestTopNode(t *testing.T) {
+	client, server := mockMetricsClient(t, map[string]string{
+		"/apis/metrics.k8s.io/v1beta1/nodes/testnode": nodeMetricsJSON,
+	})
+	defer server.Close()
+
+	usage, err := client.TopNode(context.Background())
+	assert.Nil(t, err, "Unexpected error")
+	if usage == nil {
+		t.Fatal("Node usage missing")
+	}
+	assert.Equal(t, "testnode", usage.Name, "Unexpected node name")
+	assert.Equal(t, int64(250), usage.CPU.MilliValue(), "Unexpected CPU usage")
+	assert.Equal(t, int64(1024*1024*1024), usage.Memory.Value(), "Unexpected memory usage")
+	assert.Equal(t, 30*time.Second, usage.Window, "Unexpected window")
+	assert.Equal(t, time.Date(2018, 8, 1, 12, 0, 0, 0, time.UTC), usage.Timestamp.UTC(), "Unexpected timestamp")
+}
+
+// TestTopPods checks whether container usage is summed up per pod
+func TestTopPods(t *testing.T) {
+	client, server := mockMetricsClient(t, map[string]string{
+		"/apis/metrics.k8s.io/v1beta1/namespaces/kube-system/pods": podMetricsJSON,
+	})
+	defer server.Close()
+
+	usages, err := client.TopPods(context.Background(), "kube-system")
+	assert.Nil(t, err, "Unexpected error")
+	if len(usages) != 1 {
+		t.Fatalf("Unexpected number of pods: %d", len(usages))
+	}
+	assert.Equal(t, "kube-dns", usages[0].Name, "Unexpected pod name")
+	assert.Equal(t, "kube-system", usages[0].Namespace, "Unexpected pod namespace")
+	assert.Equal(t, int64(6), usages[0].CPU.MilliValue(), "Unexpected CPU usage")
+	assert.Equal(t, int64(16*1024*1024), usages[0].Memory.Value(), "Unexpected memory usage")
+}
+
+// TestTopMetricsUnavailable checks whether a missing metrics API is reported as such
+func TestTopMetricsUnavailable(t *testing.T) {
+	client, server := mockMetricsClient(t, map[string]string{})
+	defer server.Close()
+
+	_, err := client.TopPods(context.Background(), "")
+	assert.NotNil(t, err, "Expected error missing")
+	assert.Contains(t, err.Error(), "metrics-server", "Unexpected error")
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package log allows selecting log parsers for the output of services by name. Parsers for custom output formats
+// can be registered from outside of this module.
+package log
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+)
+
+// Parser is the interface type of all log parsers.
+// A Parser is used to handle the output of child processes and re-log it using the logger of the main process,
+// unifying all logs into a single log with a coherent structure. Parsers are made available by name through
+// RegisterParser, so that custom formats can be supported without changing the services.
+type Parser interface {
+	// HandleData takes all necessary actions with the provided buffer. Output is passed in chunks as it is read, so
+	// a chunk may contain partial or multiple lines. It may be called from multiple goroutines (stdout and stderr).
+	HandleData(data []byte) error
+}
+
+// ParserFactory creates a Parser for the output of the application named by 'app' (e.g. 'kube-api')
+type ParserFactory func(app string) Parser
+
+// parserRegistry contains all registered parser factories, indexed by parser name
+var parserRegistry = make(map[string]ParserFactory)
+
+// parserRegistryMutex secures access to parserRegistry
+var parserRegistryMutex = sync.Mutex{}
+
+// RegisterParser makes a parser available as 'name', e.g. for selecting it on the command line. Custom parsers
+// should register themselves from an init function of the package implementing them, which must be imported by the
+// program embedding the cluster. Registering the same name twice is an error.
+func RegisterParser(name string, factory ParserFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("parser name and factory are required")
+	}
+	parserRegistryMutex.Lock()
+	defer parserRegistryMutex.Unlock()
+	if _, ok := parserRegistry[name]; ok {
+		return errors.Errorf("parser '%s' is already registered", name)
+	}
+	parserRegistry[name] = factory
+	return nil
+}
+
+// MustRegisterParser registers a parser like RegisterParser, but panics on error. It is meant for init functions.
+func MustRegisterParser(name string, factory ParserFactory) {
+	err := RegisterParser(name, factory)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewParser creates a parser registered as 'name' for the output of the application named by 'app'
+func NewParser(name, app string) (Parser, error) {
+	parserRegistryMutex.Lock()
+	factory, ok := parserRegistry[name]
+	parserRegistryMutex.Unlock()
+	if !ok {
+		return nil, errors.Errorf("unknown log parser '%s', available: %v", name, ParserNames())
+	}
+	return factory(app), nil
+}
+
+// ParserNames returns the names of all registered parsers, sorted
+func ParserNames() []string {
+	parserRegistryMutex.Lock()
+	defer parserRegistryMutex.Unlock()
+	names := make([]string, 0, len(parserRegistry))
+	for name := range parserRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"testing"
+)
+
+// recordingParser is a Parser that only records the data passed to it
+type recordingParser struct {
+	app  string
+	data []byte
+}
+
+func (p *recordingParser) HandleData(data []byte) error {
+	p.data = append(p.data, data...)
+	return nil
+}
+
+// TestParserRegistry tests whether custom parsers can be registered and created by name
+func TestParserRegistry(t *testing.T) {
+	_, err := NewParser("custom-test", "kube-api")
+	if err == nil {
+		t.Fatal("Expected error missing for unknown parser!")
+	}
+	err = RegisterParser("custom-test", func(app string) Parser {
+		return &recordingParser{app: app}
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	err = RegisterParser("custom-test", func(app string) Parser {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Expected error missing for duplicate registration!")
+	}
+	err = RegisterParser("", func(app string) Parser {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Expected error missing for empty name!")
+	}
+	parser, err := NewParser("custom-test", "kube-api")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	parser.HandleData([]byte("custom format\n"))
+	recorder := parser.(*recordingParser)
+	if recorder.app != "kube-api" || string(recorder.data) != "custom format\n" {
+		t.Fatalf("Unexpected parser state: %#v", recorder)
+	}
+
+	names := ParserNames()
+	if len(names) != 1 || names[0] != "custom-test" {
+		t.Fatalf("Unexpected parser names: %v", names)
+	}
+}
@@ -18,28 +18,79 @@
 package pki
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
 	"io"
+	"io/ioutil"
 	"math/big"
 	insecure_rand "math/rand"
 	"net"
-	"os"
 	"path"
 	"time"
 )
 
+// DefaultCertValidity is how long new certificates are valid unless specified otherwise
+const DefaultCertValidity = 365 * 24 * time.Hour
+
+// caExpiryTolerance is how far a certificate's requested validity may exceed it's CA's without a warning. A CA and
+// the certificates issued right after it are created with the same validity, so theirs always ends a moment later.
+const caExpiryTolerance = time.Minute
+
+// KeyAlgorithm describes the type of key of a certificate
+type KeyAlgorithm int
+
+const (
+	// KeyAlgorithmUnknown is used for certificates whose key hasn't been loaded
+	KeyAlgorithmUnknown KeyAlgorithm = iota
+	// KeyAlgorithmRSA uses RSA keys of the CertManager's key size
+	KeyAlgorithmRSA
+	// KeyAlgorithmECDSAP256 uses ECDSA keys on the NIST P-256 curve, which are much faster to generate than RSA keys
+	KeyAlgorithmECDSAP256
+)
+
+// String returns the name of the algorithm as accepted by ParseKeyAlgorithm
+func (a KeyAlgorithm) String() string {
+	switch a {
+	case KeyAlgorithmRSA:
+		return "rsa"
+	case KeyAlgorithmECDSAP256:
+		return "ecdsa"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseKeyAlgorithm parses the name of a key algorithm ('rsa' or 'ecdsa')
+func ParseKeyAlgorithm(name string) (KeyAlgorithm, error) {
+	switch name {
+	case "rsa":
+		return KeyAlgorithmRSA, nil
+	case "ecdsa":
+		return KeyAlgorithmECDSAP256, nil
+	default:
+		return KeyAlgorithmUnknown, errors.Errorf("unknown key algorithm '%s', expected 'rsa' or 'ecdsa'", name)
+	}
+}
+
 // CertManager manages a x509 PKI with RSA certificates
 type CertManager struct {
 	// Where to store certificates
 	workdir string
-	// Size of the keys to create
+	// Type of the keys to create
+	keyAlgorithm KeyAlgorithm
+	// Size of the keys to create, RSA only
 	keysize int
-	// How long should keys be valid
+	// How long should keys be valid if not specified otherwise
 	validity time.Duration
 	// Where to get randomness from
 	randReader io.Reader
@@ -50,22 +101,30 @@ type RSACertificate struct {
 	// Certificate as parsed golang struct
 	cert *x509.Certificate
 	// Private key as parsed golang struct
-	key *rsa.PrivateKey
+	key crypto.Signer
 	// Public key as parsed golang struct
-	pubkey *rsa.PublicKey
+	pubkey crypto.PublicKey
+	// Algorithm of the key, KeyAlgorithmUnknown if it hasn't been loaded
+	Algorithm KeyAlgorithm
 	// CertPath contains the full path to a PEM-encoded representation of this certificate
 	CertPath string
 	// CertPath contains the full path to a PEM-encoded representation of this certificate's private key
 	KeyPath string
 }
 
-// NewManager creates a CertManager that stores certificates in 'workdir'
+// NewManager creates a CertManager that stores certificates with RSA keys in 'workdir'
 func NewManager(workdir string) *CertManager {
+	return NewManagerWithKeyType(workdir, KeyAlgorithmRSA)
+}
+
+// NewManagerWithKeyType creates a CertManager that stores certificates with keys of type 'keyAlgorithm' in 'workdir'
+func NewManagerWithKeyType(workdir string, keyAlgorithm KeyAlgorithm) *CertManager {
 	return &CertManager{
-		workdir:    workdir,
-		keysize:    2048,
-		validity:   time.Hour * 24 * 365,
-		randReader: rand.Reader,
+		workdir:      workdir,
+		keyAlgorithm: keyAlgorithm,
+		keysize:      2048,
+		validity:     DefaultCertValidity,
+		randReader:   rand.Reader,
 	}
 }
 
@@ -76,45 +135,219 @@ func (manager *CertManager) UutMode() {
 	manager.randReader = insecure_rand.New(insecure_rand.NewSource(time.Now().UnixNano()))
 }
 
+// generateKey creates a new private key of the CertManager's key type
+func (manager *CertManager) generateKey() (crypto.Signer, error) {
+	switch manager.keyAlgorithm {
+	case KeyAlgorithmRSA:
+		return rsa.GenerateKey(manager.randReader, manager.keysize)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), manager.randReader)
+	default:
+		return nil, errors.Errorf("unsupported key algorithm '%s'", manager.keyAlgorithm)
+	}
+}
+
+// keyUsage returns the key usages of a leaf certificate with the CertManager's key type. Key encipherment is only
+// possible with RSA keys, ECDSA keys are used for key agreement instead.
+func (manager *CertManager) keyUsage() x509.KeyUsage {
+	if manager.keyAlgorithm == KeyAlgorithmRSA {
+		return x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// marshalPrivateKey encodes 'key' as PEM block
+func marshalPrivateKey(key crypto.Signer) (*pem.Block, error) {
+	switch privateKey := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't encode EC key")
+		}
+		return &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: der,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %T", key)
+	}
+}
+
+// parsePrivateKey decodes a PEM-encoded RSA or ECDSA private key, detecting the algorithm from the PEM block
+func parsePrivateKey(data []byte) (crypto.Signer, KeyAlgorithm, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, KeyAlgorithmUnknown, errors.New("no PEM data found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, KeyAlgorithmUnknown, errors.Errorf("unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, KeyAlgorithmUnknown, errors.Wrap(err, "couldn't parse private key")
+	}
+	switch privateKey := key.(type) {
+	case *rsa.PrivateKey:
+		return privateKey, KeyAlgorithmRSA, nil
+	case *ecdsa.PrivateKey:
+		if privateKey.Curve != elliptic.P256() {
+			return nil, KeyAlgorithmUnknown, errors.Errorf("unsupported curve '%s'", privateKey.Curve.Params().Name)
+		}
+		return privateKey, KeyAlgorithmECDSAP256, nil
+	default:
+		return nil, KeyAlgorithmUnknown, errors.Errorf("unsupported key type %T", key)
+	}
+}
+
 // writeCertToFiles writes the given certificate to workdir/name.pem and workdir/name.key
-func (manager *CertManager) writeCertToFiles(name string, privateKey *rsa.PrivateKey, cert *[]byte, certTmpl *x509.Certificate) (*RSACertificate, error) {
+func (manager *CertManager) writeCertToFiles(name string, privateKey crypto.Signer, cert *[]byte, certTmpl *x509.Certificate) (*RSACertificate, error) {
 	// Write two PEM files
 	// Key
+	keyBlock, err := marshalPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
 	keypath := path.Join(manager.workdir, name+".key")
-	keyOut, err := os.OpenFile(keypath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	err = atomicfile.Write(keypath, 0640, func(keyOut io.Writer) error {
+		return pem.Encode(keyOut, keyBlock)
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "keyfile creation failed")
 	}
-	pem.Encode(keyOut, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
-	keyOut.Close()
 	// Cert
 	certpath := path.Join(manager.workdir, name+".pem")
-	certOut, err := os.OpenFile(certpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	err = atomicfile.Write(certpath, 0644, func(certOut io.Writer) error {
+		return pem.Encode(certOut, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: *cert,
+		})
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "certificate file creation failed")
 	}
-	pem.Encode(certOut, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: *cert,
-	})
-	keyOut.Close()
 
 	return &RSACertificate{
-		key:      privateKey,
-		pubkey:   &privateKey.PublicKey,
-		cert:     certTmpl,
-		CertPath: certpath,
-		KeyPath:  keypath,
+		key:       privateKey,
+		pubkey:    privateKey.Public(),
+		Algorithm: manager.keyAlgorithm,
+		cert:      certTmpl,
+		CertPath:  certpath,
+		KeyPath:   keypath,
+	}, nil
+}
+
+// loadRSACertificate loads the certificate in 'certPath' and it's private key in 'keyPath', e.g. to sign further
+// certificates with an existing CA
+func loadRSACertificate(certPath, keyPath string) (*RSACertificate, error) {
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read private key")
+	}
+	key, algorithm, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid private key in '%s'", keyPath)
+	}
+	if !publicKeysEqual(key.Public(), cert.PublicKey) {
+		return nil, errors.Errorf("private key in '%s' doesn't match certificate '%s'", keyPath, certPath)
+	}
+	return &RSACertificate{
+		cert:      cert,
+		key:       key,
+		pubkey:    key.Public(),
+		Algorithm: algorithm,
+		CertPath:  certPath,
+		KeyPath:   keyPath,
 	}, nil
 }
 
-// NewSelfSignedCACert creates a new self-signed CA certificate
-func (manager *CertManager) NewSelfSignedCACert(name string, x509Name pkix.Name, serial int64) (*RSACertificate, error) {
+// publicKeysEqual returns whether 'a' and 'b' are the same public key
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	derA, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	derB, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(derA, derB)
+}
+
+// LoadCACert loads the existing CA certificate in 'certPath' with it's private key in 'keyPath', e.g. to chain all
+// certificates to a corporate CA instead of a self-signed one. Both are copied to workdir/name.pem and
+// workdir/name.key. The returned certificate can be used to sign certificates with NewCert. An error is returned if
+// the key doesn't match the certificate or the certificate isn't allowed to sign certificates.
+func (manager *CertManager) LoadCACert(name, certPath, keyPath string) (*RSACertificate, error) {
+	ca, err := loadRSACertificate(certPath, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load CA")
+	}
+	if !ca.cert.IsCA || (ca.cert.KeyUsage != 0 && ca.cert.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return nil, errors.Errorf("'%s' isn't a CA certificate", certPath)
+	}
+	for src, dest := range map[string]string{
+		certPath: path.Join(manager.workdir, name+".pem"),
+		keyPath:  path.Join(manager.workdir, name+".key"),
+	} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't read CA")
+		}
+		err = atomicfile.WriteBytes(dest, 0640, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't copy CA")
+		}
+	}
+	ca.CertPath = path.Join(manager.workdir, name+".pem")
+	ca.KeyPath = path.Join(manager.workdir, name+".key")
+	return ca, nil
+}
+
+// existingCertificate returns the certificate in 'certPath' with it's private key in 'keyPath' without loading it.
+// The algorithm is detected from the key if possible.
+func existingCertificate(certPath, keyPath string) *RSACertificate {
+	cert := &RSACertificate{
+		CertPath: certPath,
+		KeyPath:  keyPath,
+	}
+	data, err := ioutil.ReadFile(keyPath)
+	if err == nil {
+		_, cert.Algorithm, _ = parsePrivateKey(data)
+	}
+	return cert
+}
+
+// notAfter returns the end of the validity window of a certificate created now that is valid for 'validity', or the
+// manager's default if 'validity' is 0
+func (manager *CertManager) notAfter(validity time.Duration) time.Time {
+	if validity <= 0 {
+		validity = manager.validity
+	}
+	return time.Now().Add(validity)
+}
+
+// NewSelfSignedCACert creates a new self-signed CA certificate valid for 'validity' (0 for the default)
+func (manager *CertManager) NewSelfSignedCACert(name string, x509Name pkix.Name, serial int64,
+	validity time.Duration) (*RSACertificate, error) {
 	// Generate cert
-	privateKey, err := rsa.GenerateKey(manager.randReader, manager.keysize)
+	privateKey, err := manager.generateKey()
 	if err != nil {
 		return nil, errors.Wrap(err, "key creation failed")
 	}
@@ -122,12 +355,12 @@ func (manager *CertManager) NewSelfSignedCACert(name string, x509Name pkix.Name,
 		SerialNumber:          big.NewInt(serial),
 		Subject:               x509Name,
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(manager.validity),
+		NotAfter:              manager.notAfter(validity),
 		KeyUsage:              x509.KeyUsageCertSign,
 		BasicConstraintsValid: true,
 		IsCA: true,
 	}
-	cert, err := x509.CreateCertificate(manager.randReader, &certTmpl, &certTmpl, &privateKey.PublicKey, privateKey)
+	cert, err := x509.CreateCertificate(manager.randReader, &certTmpl, &certTmpl, privateKey.Public(), privateKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "certificate template creation failed")
 	}
@@ -135,10 +368,11 @@ func (manager *CertManager) NewSelfSignedCACert(name string, x509Name pkix.Name,
 	return manager.writeCertToFiles(name, privateKey, &cert, &certTmpl)
 }
 
-// NewSelfSignedCert creates a new self-signed certificate
-func (manager *CertManager) NewSelfSignedCert(name string, x509Name pkix.Name, serial int64) (*RSACertificate, error) {
+// NewSelfSignedCert creates a new self-signed certificate valid for 'validity' (0 for the default)
+func (manager *CertManager) NewSelfSignedCert(name string, x509Name pkix.Name, serial int64,
+	validity time.Duration) (*RSACertificate, error) {
 	// Generate cert
-	privateKey, err := rsa.GenerateKey(manager.randReader, manager.keysize)
+	privateKey, err := manager.generateKey()
 	if err != nil {
 		return nil, errors.Wrap(err, "key creation failed")
 	}
@@ -146,12 +380,12 @@ func (manager *CertManager) NewSelfSignedCert(name string, x509Name pkix.Name, s
 		SerialNumber:          big.NewInt(serial),
 		Subject:               x509Name,
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(manager.validity),
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:              manager.notAfter(validity),
+		KeyUsage:              x509.KeyUsageCertSign | manager.keyUsage(),
 		BasicConstraintsValid: true,
 		IsCA: false,
 	}
-	cert, err := x509.CreateCertificate(manager.randReader, &certTmpl, &certTmpl, &privateKey.PublicKey, privateKey)
+	cert, err := x509.CreateCertificate(manager.randReader, &certTmpl, &certTmpl, privateKey.Public(), privateKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "certificate template creation failed")
 	}
@@ -159,10 +393,12 @@ func (manager *CertManager) NewSelfSignedCert(name string, x509Name pkix.Name, s
 	return manager.writeCertToFiles(name, privateKey, &cert, &certTmpl)
 }
 
-// NewCert creates a new certificate signed by 'ca'
-func (manager *CertManager) NewCert(name string, x509Name pkix.Name, serial int64, isServer bool, isClient bool, sans []string, ca *RSACertificate) (*RSACertificate, error) {
+// NewCert creates a new certificate signed by 'ca' valid for 'validity' (0 for the default). A certificate can't be
+// valid for longer than it's issuer, so if 'ca' expires earlier, the certificate expires together with 'ca' instead.
+func (manager *CertManager) NewCert(name string, x509Name pkix.Name, serial int64, isServer bool, isClient bool,
+	sans []string, ca *RSACertificate, validity time.Duration) (*RSACertificate, error) {
 	// Generate cert
-	privateKey, err := rsa.GenerateKey(manager.randReader, manager.keysize)
+	privateKey, err := manager.generateKey()
 	if err != nil {
 		return nil, errors.Wrap(err, "key creation failed")
 	}
@@ -170,13 +406,13 @@ func (manager *CertManager) NewCert(name string, x509Name pkix.Name, serial int6
 		SerialNumber:          big.NewInt(serial),
 		Subject:               x509Name,
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(manager.validity),
+		NotAfter:              manager.notAfter(validity),
 		BasicConstraintsValid: true,
 		IsCA:        false,
 		ExtKeyUsage: []x509.ExtKeyUsage{},
 	}
 	if isServer {
-		certTmpl.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+		certTmpl.KeyUsage = manager.keyUsage()
 		certTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
 
 		for _, item := range sans {
@@ -188,11 +424,22 @@ func (manager *CertManager) NewCert(name string, x509Name pkix.Name, serial int6
 		}
 	}
 	if isClient {
-		certTmpl.KeyUsage |= x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+		certTmpl.KeyUsage |= manager.keyUsage()
 		certTmpl.ExtKeyUsage = append(certTmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
 	}
+	if ca.cert.NotAfter.Before(certTmpl.NotAfter) {
+		if ca.cert.NotAfter.Add(caExpiryTolerance).Before(certTmpl.NotAfter) {
+			log.WithFields(log.Fields{
+				"app":       "microkube",
+				"component": "pki",
+				"cert":      name,
+				"notAfter":  ca.cert.NotAfter,
+			}).Warn("CA expires before the requested validity ends, limiting certificate to the CA's validity")
+		}
+		certTmpl.NotAfter = ca.cert.NotAfter
+	}
 
-	cert, err := x509.CreateCertificate(manager.randReader, &certTmpl, ca.cert, &privateKey.PublicKey, ca.key)
+	cert, err := x509.CreateCertificate(manager.randReader, &certTmpl, ca.cert, privateKey.Public(), ca.key)
 	if err != nil {
 		return nil, errors.Wrap(err, "certificate template creation failed")
 	}
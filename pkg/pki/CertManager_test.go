@@ -19,10 +19,13 @@ package pki
 import (
 	"bufio"
 	"crypto/x509/pkix"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 // checkCertProperties validates a given certificate's properties using the openssl commandline utility
@@ -190,7 +193,7 @@ func TestSelfSignedCACertProperties(t *testing.T) {
 	manager.UutMode()
 	cert, err := manager.NewSelfSignedCACert("Testcert", pkix.Name{
 		CommonName: "Testcert",
-	}, 123)
+	}, 123, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating cert", err)
 	}
@@ -210,7 +213,7 @@ func TestSelfSignedCACertMatch(t *testing.T) {
 	manager.UutMode()
 	cert, err := manager.NewSelfSignedCACert("Testcert", pkix.Name{
 		CommonName: "Testcert",
-	}, 123)
+	}, 123, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating cert", err)
 	}
@@ -229,7 +232,7 @@ func TestSelfSignedCertProperties(t *testing.T) {
 	manager.UutMode()
 	cert, err := manager.NewSelfSignedCert("Testcert", pkix.Name{
 		CommonName: "Testcert",
-	}, 123)
+	}, 123, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating cert", err)
 	}
@@ -249,7 +252,7 @@ func TestSelfSignedCertMatch(t *testing.T) {
 	manager.UutMode()
 	cert, err := manager.NewSelfSignedCert("Testcert", pkix.Name{
 		CommonName: "Testcert",
-	}, 123)
+	}, 123, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating cert", err)
 	}
@@ -268,14 +271,14 @@ func TestCASignedClientCert(t *testing.T) {
 	manager.UutMode()
 	caCert, err := manager.NewSelfSignedCACert("Testcert", pkix.Name{
 		CommonName: "Testcert",
-	}, 123)
+	}, 123, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating CA cert", err)
 	}
 	cert, err := manager.NewCert("Testclient", pkix.Name{
 		Organization: []string{"system:masters"},
 		CommonName:   "Testclient",
-	}, 124, false, true, nil, caCert)
+	}, 124, false, true, nil, caCert, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating client cert", err)
 	}
@@ -297,7 +300,7 @@ func TestCASignedServerCert(t *testing.T) {
 	manager.UutMode()
 	caCert, err := manager.NewSelfSignedCACert("Testcert", pkix.Name{
 		CommonName: "Testcert",
-	}, 123)
+	}, 123, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating CA cert", err)
 	}
@@ -306,7 +309,7 @@ func TestCASignedServerCert(t *testing.T) {
 	}, 124, true, false, []string{
 		"127.0.0.1",
 		"example.com",
-	}, caCert)
+	}, caCert, 0)
 	if err != nil {
 		t.Error("Unexpected error when generating client cert", err)
 	}
@@ -317,3 +320,157 @@ func TestCASignedServerCert(t *testing.T) {
 	checkCertKeyMatch(t, cert)
 	checkCertKeyMatch(t, caCert)
 }
+
+// TestECDSACert tests creation of an ECDSA CA and a CA-signed server cert, and whether both can be loaded again
+func TestECDSACert(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	manager := NewManagerWithKeyType(tempDir, KeyAlgorithmECDSAP256)
+	manager.UutMode()
+	caCert, err := manager.NewSelfSignedCACert("ca", pkix.Name{
+		CommonName: "Testcert",
+	}, 123, 0)
+	if err != nil {
+		t.Fatal("Unexpected error when generating CA cert", err)
+	}
+	cert, err := manager.NewCert("server", pkix.Name{
+		CommonName: "Testserver",
+	}, 124, true, false, []string{"127.0.0.1"}, caCert, 0)
+	if err != nil {
+		t.Fatal("Unexpected error when generating server cert", err)
+	}
+	if cert.Algorithm != KeyAlgorithmECDSAP256 {
+		t.Fatalf("Unexpected algorithm '%s'", cert.Algorithm)
+	}
+	checkCertProperties(t, cert, "Serial Number: 124 (0x7c)", "Issuer: CN = Testcert",
+		"Subject: CN = Testserver", "Digital Signature", "CA:FALSE", "TLS Web Server Authentication",
+		"IP Address:127.0.0.1")
+
+	loaded, err := loadRSACertificate(cert.CertPath, cert.KeyPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if loaded.Algorithm != KeyAlgorithmECDSAP256 {
+		t.Fatalf("Unexpected algorithm '%s' after reload", loaded.Algorithm)
+	}
+	if existingCertificate(caCert.CertPath, caCert.KeyPath).Algorithm != KeyAlgorithmECDSAP256 {
+		t.Fatal("Algorithm of existing certificate not detected")
+	}
+	_, err = loadRSACertificate(cert.CertPath, caCert.KeyPath)
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Fatalf("Unexpected error for mismatched key: %v", err)
+	}
+}
+
+// TestParseKeyAlgorithm tests whether key algorithm names round-trip
+func TestParseKeyAlgorithm(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{KeyAlgorithmRSA, KeyAlgorithmECDSAP256} {
+		parsed, err := ParseKeyAlgorithm(algorithm.String())
+		if err != nil || parsed != algorithm {
+			t.Fatalf("Algorithm '%s' didn't round-trip: %v", algorithm, err)
+		}
+	}
+	_, err := ParseKeyAlgorithm("dsa")
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+}
+
+// TestCertValidityDuration tests whether the requested validity is used and leaf certificates don't outlive their CA
+func TestCertValidityDuration(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	manager := NewManager(tempDir)
+	manager.UutMode()
+	caCert, err := manager.NewSelfSignedCACert("ca", pkix.Name{
+		CommonName: "Testcert",
+	}, 123, 48*time.Hour)
+	if err != nil {
+		t.Fatal("Unexpected error when generating CA cert", err)
+	}
+	short, err := manager.NewCert("short", pkix.Name{
+		CommonName: "Short",
+	}, 124, false, true, nil, caCert, time.Hour)
+	if err != nil {
+		t.Fatal("Unexpected error when generating client cert", err)
+	}
+	long, err := manager.NewCert("long", pkix.Name{
+		CommonName: "Long",
+	}, 125, false, true, nil, caCert, DefaultCertValidity)
+	if err != nil {
+		t.Fatal("Unexpected error when generating client cert", err)
+	}
+
+	for _, test := range []struct {
+		cert     *RSACertificate
+		validity time.Duration
+	}{
+		{caCert, 48 * time.Hour},
+		{short, time.Hour},
+		{long, 48 * time.Hour},
+	} {
+		cert, err := LoadCertificate(test.cert.CertPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		validity := cert.NotAfter.Sub(cert.NotBefore)
+		if validity < test.validity-time.Minute || validity > test.validity+time.Minute {
+			t.Fatalf("Unexpected validity of '%s': %s", test.cert.CertPath, validity)
+		}
+	}
+}
+
+// TestLoadCACert tests whether an existing CA can be loaded to sign certificates and invalid CAs are rejected
+func TestLoadCACert(t *testing.T) {
+	externalDir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(externalDir)
+	workDir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	external := NewManager(externalDir)
+	external.UutMode()
+	externalCA, err := external.NewSelfSignedCACert("corporate", pkix.Name{CommonName: "Corporate CA"}, 1, 0)
+	if err != nil {
+		t.Fatal("Unexpected error when generating CA cert", err)
+	}
+	leaf, err := external.NewCert("leaf", pkix.Name{CommonName: "Leaf"}, 2, false, true, nil, externalCA, 0)
+	if err != nil {
+		t.Fatal("Unexpected error when generating client cert", err)
+	}
+
+	manager := NewManager(workDir)
+	manager.UutMode()
+	_, err = manager.LoadCACert("ca", externalCA.CertPath, leaf.KeyPath)
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Fatalf("Unexpected error for mismatched key: %v", err)
+	}
+	_, err = manager.LoadCACert("ca", leaf.CertPath, leaf.KeyPath)
+	if err == nil || !strings.Contains(err.Error(), "isn't a CA certificate") {
+		t.Fatalf("Unexpected error for leaf certificate: %v", err)
+	}
+
+	ca, err := manager.LoadCACert("ca", externalCA.CertPath, externalCA.KeyPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ca.CertPath != path.Join(workDir, "ca.pem") || ca.KeyPath != path.Join(workDir, "ca.key") {
+		t.Fatalf("CA not copied: %s %s", ca.CertPath, ca.KeyPath)
+	}
+	cert, err := manager.NewCert("client", pkix.Name{CommonName: "Client"}, 3, false, true, nil, ca, 0)
+	if err != nil {
+		t.Fatal("Unexpected error when generating client cert", err)
+	}
+	checkCertProperties(t, cert, "", "Issuer: CN = Corporate CA", "Subject: CN = Client", "", "", "", "")
+}
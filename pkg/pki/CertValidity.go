@@ -0,0 +1,89 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"time"
+)
+
+// DefaultClockSkewTolerance is how far the host clock may be off before a certificate is considered outside of its
+// validity window
+const DefaultClockSkewTolerance = 5 * time.Minute
+
+// CertValidityError is returned if a certificate isn't valid at the time it is checked, even when allowing for clock
+// skew
+type CertValidityError struct {
+	// Subject of the certificate
+	Subject string
+	// Validity window of the certificate
+	NotBefore, NotAfter time.Time
+	// Time the certificate was checked at
+	Now time.Time
+}
+
+// Error returns a description of the validity problem, see error
+func (e *CertValidityError) Error() string {
+	if e.NotYetValid() {
+		return fmt.Sprintf("certificate '%s' is not valid before %s (now: %s)", e.Subject,
+			e.NotBefore.Format(time.RFC3339), e.Now.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate '%s' expired at %s (now: %s)", e.Subject, e.NotAfter.Format(time.RFC3339),
+		e.Now.Format(time.RFC3339))
+}
+
+// NotYetValid returns whether the certificate only becomes valid in the future. As certificates are created with the
+// current time as start of their validity, this usually means that the host clock is off.
+func (e *CertValidityError) NotYetValid() bool {
+	return e.Now.Before(e.NotBefore)
+}
+
+// LoadCertificate parses the first PEM-encoded certificate in 'certPath'
+func LoadCertificate(certPath string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read certificate")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.Errorf("no PEM-encoded certificate found in '%s'", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse certificate '%s'", certPath)
+	}
+	return cert, nil
+}
+
+// CheckValidity checks whether 'cert' is valid at 'now', allowing the clock to be off by up to 'tolerance' in either
+// direction. This way, a certificate that becomes valid in a moment (e.g. after the clock jumped on resume) isn't
+// treated as broken. Returns a *CertValidityError if the certificate isn't valid.
+func CheckValidity(cert *x509.Certificate, now time.Time, tolerance time.Duration) error {
+	if now.Add(tolerance).Before(cert.NotBefore) || now.Add(-tolerance).After(cert.NotAfter) {
+		return &CertValidityError{
+			Subject:   cert.Subject.CommonName,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			Now:       now,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pki
+
+import (
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestCheckValidity checks whether clock skew within the tolerance is accepted and larger skew is reported
+func TestCheckValidity(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	manager := NewManager(tmpdir)
+	manager.UutMode()
+	ca, err := manager.NewSelfSignedCACert("ca", pkix.Name{CommonName: "Validity CA"}, 1, 0)
+	if err != nil {
+		t.Fatalf("CA creation failed: %s", err)
+	}
+
+	cert, err := LoadCertificate(ca.CertPath)
+	if err != nil {
+		t.Fatalf("Couldn't load certificate: %s", err)
+	}
+	if cert.Subject.CommonName != "Validity CA" {
+		t.Fatalf("Unexpected subject: %s", cert.Subject.CommonName)
+	}
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		valid       bool
+		notYetValid bool
+	}{
+		{"now", cert.NotBefore.Add(time.Hour), true, false},
+		{"valid in a moment", cert.NotBefore.Add(-time.Minute), true, false},
+		{"clock far behind", cert.NotBefore.Add(-time.Hour), false, true},
+		{"expired a moment ago", cert.NotAfter.Add(time.Minute), true, false},
+		{"expired", cert.NotAfter.Add(time.Hour), false, false},
+	}
+	for _, test := range tests {
+		err := CheckValidity(cert, test.now, DefaultClockSkewTolerance)
+		if test.valid {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		validityErr, ok := err.(*CertValidityError)
+		if !ok {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if validityErr.NotYetValid() != test.notYetValid {
+			t.Fatalf("%s: unexpected error kind: %s", test.name, err)
+		}
+	}
+
+	if CheckValidity(cert, cert.NotBefore.Add(-time.Minute), 0) == nil {
+		t.Fatal("Expected error missing without tolerance!")
+	}
+}
+
+// TestLoadCertificateInvalid checks whether files without a certificate are rejected
+func TestLoadCertificateInvalid(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("tempfile creation failed: %s", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.WriteString("not a certificate")
+	tmpfile.Close()
+
+	_, err = LoadCertificate(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Expected error missing!")
+	}
+}
@@ -1,13 +1,35 @@
 package pki
 
 import (
+	"bytes"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/vs-eth/microkube/pkg/helpers/atomicfile"
+	"io/ioutil"
 	"net"
 	"os"
 	"path"
+	"strings"
+	"time"
 )
 
+// ReadOnlyGroup is the group (O) of the read-only kubernetes client certificate. Microkube binds it to the 'view'
+// cluster role.
+const ReadOnlyGroup = "microkube:readonly"
+
+// AggregatorClientName is the name (CN) of the client certificate the apiserver uses to proxy requests to extension
+// apiservers like metrics-server. These only accept proxied requests from clients with this name.
+const AggregatorClientName = "Microkube Kubernetes Aggregator"
+
+// metricsServerSANs are the names metrics-server is reached at through its service in the cluster
+var metricsServerSANs = []string{
+	"metrics-server.kube-system.svc",
+	"metrics-server.kube-system.svc.cluster.local",
+}
+
 // MicrokubeCredentials manages all credentials needed for the different components of Microkube using PKI
 type MicrokubeCredentials struct {
 	// CA certificate for etcd
@@ -26,10 +48,39 @@ type MicrokubeCredentials struct {
 	KubeClusterCA *RSACertificate
 	// Signing certificate for kubernetes service account tokens
 	KubeSvcSignCert *RSACertificate
+	// Client certificate for kubernetes in ReadOnlyGroup, nil unless ReadOnlyClient is set
+	KubeReadOnlyClient *RSACertificate
+	// Client certificate the apiserver uses to proxy requests to extension apiservers, nil unless MetricsServer is set
+	KubeAggregatorClient *RSACertificate
+	// Server certificate for metrics-server, nil unless MetricsServer is set
+	KubeMetricsServer *RSACertificate
 
 	// Path to kubernetes client config file
 	Kubeconfig string
 
+	// How far the host clock may be off when checking the validity of existing certificates
+	ClockSkewTolerance time.Duration
+	// Additional IPs or DNS names to include in the etcd server certificate, e.g. addresses etcd is reachable at other
+	// than localhost. An existing certificate lacking any of them is regenerated.
+	EtcdSANs []string
+	// Additional IPs or DNS names to include in the kube-apiserver serving certificate, e.g. the hostname of a reverse
+	// proxy in front of it. An existing certificate lacking any of them is regenerated.
+	KubeSANs []string
+	// How long new certificates are valid, DefaultCertValidity if unset. Existing certificates are kept even if their
+	// validity differs.
+	CertValidity time.Duration
+	// Paths of an existing CA certificate and it's key to use as kubernetes CA instead of a self-signed one, empty if
+	// unset. If the CA changes, the kubernetes server and client certificates are regenerated.
+	ExternalKubeCACert string
+	ExternalKubeCAKey  string
+	// Type of keys to create for new certificates, RSA if unset. Existing certificates are loaded regardless of their
+	// key type.
+	KeyAlgorithm KeyAlgorithm
+	// Whether to create KubeReadOnlyClient
+	ReadOnlyClient bool
+	// Whether to create KubeAggregatorClient and KubeMetricsServer
+	MetricsServer bool
+
 	// Weak certificates, testing only, you have been warned
 	uutMode bool
 }
@@ -39,16 +90,43 @@ func (m *MicrokubeCredentials) CreateOrLoadCertificates(baseDir string, bindAddr
 	var err error
 	os.Mkdir(path.Join(baseDir, "etcdtls"), 0750)
 	m.EtcdCA, m.EtcdServer, m.EtcdClient, err = m.ensureFullPKI(path.Join(baseDir, "etcdtls"), "Microkube ETCD",
-		false, true, []string{bindAddr.String()})
+		false, true, append([]string{bindAddr.String()}, m.EtcdSANs...))
 	if err != nil {
 		return fmt.Errorf("etcd pki creation failed: %s", err)
 	}
 	os.Mkdir(path.Join(baseDir, "kubetls"), 0750)
+	err = m.dropReplacedKubeCA(path.Join(baseDir, "kubetls"))
+	if err != nil {
+		return fmt.Errorf("kube pki creation failed: %s", err)
+	}
 	m.KubeCA, m.KubeServer, m.KubeClient, err = m.ensureFullPKI(path.Join(baseDir, "kubetls"), "Microkube Kubernetes",
-		true, false, []string{bindAddr.String(), serviceAddr.String()})
+		true, false, append([]string{bindAddr.String(), serviceAddr.String()}, m.KubeSANs...))
 	if err != nil {
 		return fmt.Errorf("kube pki creation failed: %s", err)
 	}
+	if m.ReadOnlyClient {
+		m.KubeReadOnlyClient, err = m.ensureClientCert(path.Join(baseDir, "kubetls"), "readonly", pkix.Name{
+			CommonName:   "Microkube Kubernetes Read-Only Client",
+			Organization: []string{ReadOnlyGroup},
+		})
+		if err != nil {
+			return fmt.Errorf("kube read-only client certificate creation failed: %s", err)
+		}
+	}
+	if m.MetricsServer {
+		m.KubeAggregatorClient, err = m.ensureClientCert(path.Join(baseDir, "kubetls"), "aggregator", pkix.Name{
+			CommonName: AggregatorClientName,
+		})
+		if err != nil {
+			return fmt.Errorf("kube aggregator client certificate creation failed: %s", err)
+		}
+		m.KubeMetricsServer, err = m.ensureServerCert(path.Join(baseDir, "kubetls"), "metrics-server", pkix.Name{
+			CommonName: "Microkube Metrics Server",
+		}, metricsServerSANs)
+		if err != nil {
+			return fmt.Errorf("metrics-server certificate creation failed: %s", err)
+		}
+	}
 	os.Mkdir(path.Join(baseDir, "kubectls"), 0750)
 	m.KubeClusterCA, err = m.ensureCA(path.Join(baseDir, "kubectls"), "Microkube Cluster CA")
 	if err != nil {
@@ -59,9 +137,88 @@ func (m *MicrokubeCredentials) CreateOrLoadCertificates(baseDir string, bindAddr
 	if err != nil {
 		return fmt.Errorf("kube service signing cert creation failed: %s", err)
 	}
+	m.checkValidity(time.Now())
 	return nil
 }
 
+// WriteCABundle writes the PEM encoded etcd and kubernetes CA certificates to 'bundlePath', e.g. for clients that
+// expect a single file of trusted CAs. Missing parent directories are created and an existing file is replaced.
+func (m *MicrokubeCredentials) WriteCABundle(bundlePath string) error {
+	bundle := bytes.Buffer{}
+	for _, ca := range []*RSACertificate{m.EtcdCA, m.KubeCA} {
+		if ca == nil {
+			return errors.New("credentials not loaded")
+		}
+		data, err := ioutil.ReadFile(ca.CertPath)
+		if err != nil {
+			return errors.Wrap(err, "couldn't read CA")
+		}
+		bundle.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			bundle.WriteByte('\n')
+		}
+	}
+	err := os.MkdirAll(path.Dir(bundlePath), 0750)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create bundle directory")
+	}
+	err = atomicfile.WriteBytes(bundlePath, 0644, bundle.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "couldn't write bundle")
+	}
+	return nil
+}
+
+// newManager creates a CertManager for 'root' creating keys of type KeyAlgorithm
+func (m *MicrokubeCredentials) newManager(root string) *CertManager {
+	keyAlgorithm := m.KeyAlgorithm
+	if keyAlgorithm == KeyAlgorithmUnknown {
+		keyAlgorithm = KeyAlgorithmRSA
+	}
+	certMgr := NewManagerWithKeyType(root, keyAlgorithm)
+	if m.uutMode {
+		certMgr.UutMode()
+	}
+	return certMgr
+}
+
+// checkValidity warns about certificates that aren't valid at 'now', even when allowing for ClockSkewTolerance.
+// Certificates are never regenerated because of this, as a certificate that isn't valid yet usually means that the
+// host clock is off and new certificates wouldn't help.
+func (m *MicrokubeCredentials) checkValidity(now time.Time) {
+	certs := []*RSACertificate{m.EtcdCA, m.EtcdServer, m.EtcdClient, m.KubeCA, m.KubeServer, m.KubeClient,
+		m.KubeClusterCA, m.KubeSvcSignCert}
+	for _, optional := range []*RSACertificate{m.KubeReadOnlyClient, m.KubeAggregatorClient, m.KubeMetricsServer} {
+		if optional != nil {
+			certs = append(certs, optional)
+		}
+	}
+	for _, cert := range certs {
+		logCtx := log.WithFields(log.Fields{
+			"app":       "microkube",
+			"component": "pki",
+			"cert":      cert.CertPath,
+		})
+		parsed, err := LoadCertificate(cert.CertPath)
+		if err != nil {
+			logCtx.WithError(err).Warn("Couldn't check certificate validity")
+			continue
+		}
+		err = CheckValidity(parsed, now, m.ClockSkewTolerance)
+		if validityErr, ok := err.(*CertValidityError); ok {
+			if validityErr.NotYetValid() {
+				logCtx.WithError(err).WithField("clockOffset", validityErr.NotBefore.Sub(now).String()).Warn(
+					"Certificate isn't valid yet, the host clock appears to be significantly off")
+			} else {
+				logCtx.WithError(err).Warn("Certificate expired")
+			}
+		} else if now.Before(parsed.NotBefore) {
+			logCtx.WithField("notBefore", parsed.NotBefore).Debug("Certificate becomes valid within clock skew " +
+				"tolerance")
+		}
+	}
+}
+
 // ensureFullPKI ensures that a full PKI for 'name' exists in 'root', that is:
 //  - A CA certificate with name 'name CA' in ca.pem and ca.key
 //  - A server certificate with SANs 'ip' and name 'name Server' in server.pem and server.key
@@ -70,30 +227,31 @@ func (m *MicrokubeCredentials) CreateOrLoadCertificates(baseDir string, bindAddr
 func (m *MicrokubeCredentials) ensureFullPKI(root, name string, isKubeCA, isETCDCA bool,
 	ip []string) (ca *RSACertificate, server *RSACertificate, client *RSACertificate, err error) {
 
+	ip, err = defaultServerSANs(ip)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	caFile := path.Join(root, "ca.pem")
 	_, err = os.Stat(caFile)
 	if err != nil {
 		// File doesn't exist
-		certMgr := NewManager(root)
-		if m.uutMode {
-			certMgr.UutMode()
+		certMgr := m.newManager(root)
+		var ca *RSACertificate
+		if isKubeCA && m.ExternalKubeCACert != "" {
+			ca, err = certMgr.LoadCACert("ca", m.ExternalKubeCACert, m.ExternalKubeCAKey)
+		} else {
+			// Reuse CA code ;)
+			ca, err = m.ensureCA(root, name)
 		}
-		// Reuse CA code ;)
-		ca, err := m.ensureCA(root, name)
 		if err != nil {
 			// Already logged
 			return nil, nil, nil, err
 		}
 
-		hostname, err := os.Hostname()
-		if err != nil {
-			return nil, nil, nil, err
-		}
-
-		ip = append(ip, "127.0.0.1", "localhost", hostname)
 		server, err := certMgr.NewCert("server", pkix.Name{
 			CommonName: name + " Server",
-		}, 2, true, isETCDCA, ip, ca)
+		}, 2, true, isETCDCA, ip, ca, m.CertValidity)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -104,7 +262,7 @@ func (m *MicrokubeCredentials) ensureFullPKI(root, name string, isKubeCA, isETCD
 		if isKubeCA {
 			clientName.Organization = []string{"system:masters"}
 		}
-		client, err := certMgr.NewCert("client", clientName, 3, false, true, nil, ca)
+		client, err := certMgr.NewCert("client", clientName, 3, false, true, nil, ca, m.CertValidity)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -112,17 +270,155 @@ func (m *MicrokubeCredentials) ensureFullPKI(root, name string, isKubeCA, isETCD
 		return ca, server, client, nil
 	}
 
-	// Certs already exist
-	return &RSACertificate{
-			KeyPath:  path.Join(root, "ca.key"),
-			CertPath: path.Join(root, "ca.pem"),
-		}, &RSACertificate{
-			KeyPath:  path.Join(root, "server.key"),
-			CertPath: path.Join(root, "server.pem"),
-		}, &RSACertificate{
-			KeyPath:  path.Join(root, "client.key"),
-			CertPath: path.Join(root, "client.pem"),
-		}, nil
+	// Certs already exist, but e.g. the listen address might have changed since they were created
+	server, err = m.ensureServerSANs(root, name, isETCDCA, ip)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return existingCertificate(path.Join(root, "ca.pem"), path.Join(root, "ca.key")), server,
+		existingCertificate(path.Join(root, "client.pem"), path.Join(root, "client.key")), nil
+}
+
+// dropReplacedKubeCA removes the kubernetes CA and all certificates signed by it from 'root' if an external kubernetes
+// CA is given that differs from it, so that they are recreated using the external CA
+func (m *MicrokubeCredentials) dropReplacedKubeCA(root string) error {
+	if m.ExternalKubeCACert == "" {
+		return nil
+	}
+	current, err := ioutil.ReadFile(path.Join(root, "ca.pem"))
+	if err != nil {
+		// Nothing to replace
+		return nil
+	}
+	external, err := ioutil.ReadFile(m.ExternalKubeCACert)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read external CA")
+	}
+	if bytes.Equal(current, external) {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "pki",
+		"ca":        m.ExternalKubeCACert,
+	}).Info("Kubernetes CA changed, regenerating kubernetes certificates")
+	for _, name := range []string{"ca", "server", "client", "readonly", "aggregator", "metrics-server"} {
+		for _, ext := range []string{".pem", ".key"} {
+			err = os.Remove(path.Join(root, name+ext))
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "couldn't remove replaced certificate")
+			}
+		}
+	}
+	return nil
+}
+
+// defaultServerSANs returns 'sans' extended by the SANs every server certificate contains
+func defaultServerSANs(sans []string) ([]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string{}, sans...), "127.0.0.1", "localhost", hostname), nil
+}
+
+// ensureServerSANs ensures that the existing server certificate in 'root' contains all SANs in 'sans', regenerating it
+// with the CA in 'root' otherwise. SANs of the existing certificate are kept.
+func (m *MicrokubeCredentials) ensureServerSANs(root, name string, isETCDCA bool,
+	sans []string) (*RSACertificate, error) {
+
+	server := existingCertificate(path.Join(root, "server.pem"), path.Join(root, "server.key"))
+	existing, err := LoadCertificate(server.CertPath)
+	if err != nil {
+		// Reported by the validity check later on
+		return server, nil
+	}
+	existingSANs := append([]string{}, existing.DNSNames...)
+	for _, ip := range existing.IPAddresses {
+		existingSANs = append(existingSANs, ip.String())
+	}
+	var missing []string
+	for _, san := range sans {
+		if !containsSAN(existing, san) {
+			missing = append(missing, san)
+		}
+	}
+	if len(missing) == 0 {
+		return server, nil
+	}
+
+	log.WithFields(log.Fields{
+		"app":       "microkube",
+		"component": "pki",
+		"cert":      server.CertPath,
+		"missing":   missing,
+	}).Info("Server certificate lacks requested SANs, regenerating it")
+	ca, err := loadRSACertificate(path.Join(root, "ca.pem"), path.Join(root, "ca.key"))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load CA")
+	}
+	certMgr := m.newManager(root)
+	// Serial numbers need to differ from the original certificate's
+	return certMgr.NewCert("server", pkix.Name{
+		CommonName: name + " Server",
+	}, time.Now().UnixNano(), true, isETCDCA, append(existingSANs, missing...), ca, m.CertValidity)
+}
+
+// ensureClientCert ensures that a client certificate with name 'x509Name' signed by the CA in 'root' exists in
+// 'root'/'certName'.pem and 'root'/'certName'.key, (re)creating it if it is missing or signed by another CA
+func (m *MicrokubeCredentials) ensureClientCert(root, certName string, x509Name pkix.Name) (*RSACertificate, error) {
+	return m.ensureSignedCert(root, certName, x509Name, false, nil)
+}
+
+// ensureServerCert ensures that a server certificate with name 'x509Name' and SANs 'sans' signed by the CA in 'root'
+// exists in 'root'/'certName'.pem and 'root'/'certName'.key, (re)creating it if it is missing, signed by another CA or
+// lacks any of 'sans'
+func (m *MicrokubeCredentials) ensureServerCert(root, certName string, x509Name pkix.Name,
+	sans []string) (*RSACertificate, error) {
+
+	return m.ensureSignedCert(root, certName, x509Name, true, sans)
+}
+
+// ensureSignedCert implements ensureClientCert and ensureServerCert
+func (m *MicrokubeCredentials) ensureSignedCert(root, certName string, x509Name pkix.Name, isServer bool,
+	sans []string) (*RSACertificate, error) {
+
+	cert := existingCertificate(path.Join(root, certName+".pem"), path.Join(root, certName+".key"))
+	ca, err := loadRSACertificate(path.Join(root, "ca.pem"), path.Join(root, "ca.key"))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't load CA")
+	}
+	existing, err := LoadCertificate(cert.CertPath)
+	if err == nil && existing.CheckSignatureFrom(ca.cert) == nil {
+		complete := true
+		for _, san := range sans {
+			complete = complete && containsSAN(existing, san)
+		}
+		if complete {
+			return cert, nil
+		}
+	}
+	certMgr := m.newManager(root)
+	// Serial numbers need to differ from certificates created earlier
+	return certMgr.NewCert(certName, x509Name, time.Now().UnixNano(), isServer, !isServer, sans, ca, m.CertValidity)
+}
+
+// containsSAN returns whether 'cert' is valid for the IP or DNS name 'san'
+func containsSAN(cert *x509.Certificate, san string) bool {
+	if ip := net.ParseIP(san); ip != nil {
+		for _, certIP := range cert.IPAddresses {
+			if certIP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, san) {
+			return true
+		}
+	}
+	return false
 }
 
 // EnsureCA ensures that a full CA for 'name' exists in 'root', that is:
@@ -132,13 +428,10 @@ func (m *MicrokubeCredentials) ensureCA(root, name string) (ca *RSACertificate,
 	_, err = os.Stat(caFile)
 	if err != nil {
 		// File doesn't exist
-		certMgr := NewManager(root)
-		if m.uutMode {
-			certMgr.UutMode()
-		}
+		certMgr := m.newManager(root)
 		ca, err := certMgr.NewSelfSignedCACert("ca", pkix.Name{
 			CommonName: name + " CA",
-		}, 1)
+		}, 1, m.CertValidity)
 		if err != nil {
 			return nil, err
 		}
@@ -146,10 +439,7 @@ func (m *MicrokubeCredentials) ensureCA(root, name string) (ca *RSACertificate,
 	}
 
 	// Certs already exist
-	return &RSACertificate{
-		KeyPath:  path.Join(root, "ca.key"),
-		CertPath: path.Join(root, "ca.pem"),
-	}, nil
+	return existingCertificate(path.Join(root, "ca.pem"), path.Join(root, "ca.key")), nil
 }
 
 // EnsureSigningCert ensures that a signing cert for 'name' exists in 'root', that is:
@@ -159,13 +449,10 @@ func (m *MicrokubeCredentials) ensureSigningCert(root, name string) (ca *RSACert
 	_, err = os.Stat(caFile)
 	if err != nil {
 		// File doesn't exist
-		certMgr := NewManager(root)
-		if m.uutMode {
-			certMgr.UutMode()
-		}
+		certMgr := m.newManager(root)
 		ca, err := certMgr.NewSelfSignedCert("cert", pkix.Name{
 			CommonName: name + " Signing Cert",
-		}, 1)
+		}, 1, m.CertValidity)
 		if err != nil {
 			return nil, err
 		}
@@ -173,8 +460,5 @@ func (m *MicrokubeCredentials) ensureSigningCert(root, name string) (ca *RSACert
 	}
 
 	// Certs already exist
-	return &RSACertificate{
-		KeyPath:  path.Join(root, "cert.key"),
-		CertPath: path.Join(root, "cert.pem"),
-	}, nil
+	return existingCertificate(path.Join(root, "cert.pem"), path.Join(root, "cert.key")), nil
 }
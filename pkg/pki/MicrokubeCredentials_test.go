@@ -17,9 +17,12 @@
 package pki
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io/ioutil"
 	"net"
 	"os"
+	"path"
 	"strings"
 	"testing"
 )
@@ -240,3 +243,352 @@ func TestCreateOrLoadCertificates(t *testing.T) {
 		}
 	}
 }
+
+// TestEnsureFullPKIRegeneratesSANs checks whether an existing server certificate lacking requested SANs is regenerated
+func TestEnsureFullPKIRegeneratesSANs(t *testing.T) {
+	directory, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(directory)
+	creds := MicrokubeCredentials{uutMode: true}
+
+	ca, _, _, err := creds.ensureFullPKI(directory, "testpki", false, true, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	_, server, _, err := creds.ensureFullPKI(directory, "testpki", false, true,
+		[]string{"127.0.0.1", "10.1.2.3", "ETCD.example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	cert, err := LoadCertificate(server.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, san := range []string{"127.0.0.1", "localhost", "10.1.2.3", "etcd.example.com"} {
+		if !containsSAN(cert, san) {
+			t.Fatalf("Regenerated certificate lacks SAN '%s'", san)
+		}
+	}
+	caCert, err := LoadCertificate(ca.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("Regenerated certificate not signed by CA: %s", err)
+	}
+}
+
+// TestCreateOrLoadCertificatesECDSA checks whether ECDSA credentials are created and detected again on reload
+func TestCreateOrLoadCertificatesECDSA(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := MicrokubeCredentials{KeyAlgorithm: KeyAlgorithmECDSAP256, uutMode: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Reloading detects the key type, even if RSA keys are requested for new certificates
+	creds = MicrokubeCredentials{uutMode: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, cert := range []*RSACertificate{creds.EtcdCA, creds.EtcdServer, creds.EtcdClient, creds.KubeCA,
+		creds.KubeServer, creds.KubeClient, creds.KubeClusterCA, creds.KubeSvcSignCert} {
+		if cert.Algorithm != KeyAlgorithmECDSAP256 {
+			t.Fatalf("Unexpected algorithm '%s' of '%s'", cert.Algorithm, cert.CertPath)
+		}
+		_, err = loadRSACertificate(cert.CertPath, cert.KeyPath)
+		if err != nil {
+			t.Fatalf("Couldn't load '%s': %s", cert.CertPath, err)
+		}
+	}
+}
+
+// TestCreateOrLoadCertificatesKubeSANs checks whether extra SANs end up in the kube server certificate on reload
+func TestCreateOrLoadCertificatesKubeSANs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := MicrokubeCredentials{uutMode: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	creds = MicrokubeCredentials{uutMode: true, KubeSANs: []string{"kube.example.com"}}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cert, err := LoadCertificate(creds.KubeServer.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, san := range []string{"kube.example.com", "127.0.0.1", "127.1.1.1"} {
+		if !containsSAN(cert, san) {
+			t.Fatalf("Kube server certificate lacks SAN '%s'", san)
+		}
+	}
+	etcdCert, err := LoadCertificate(creds.EtcdServer.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if containsSAN(etcdCert, "kube.example.com") {
+		t.Fatal("Kube SAN ended up in etcd server certificate")
+	}
+}
+
+// TestCreateOrLoadCertificatesExternalKubeCA checks whether kubernetes certificates are signed by an external CA and
+// regenerated once it changes
+func TestCreateOrLoadCertificatesExternalKubeCA(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	externalDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(externalDir)
+	external := NewManager(externalDir)
+	external.UutMode()
+
+	for i, caName := range []string{"first", "second"} {
+		externalCA, err := external.NewSelfSignedCACert(caName, pkix.Name{CommonName: caName}, 1, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		creds := MicrokubeCredentials{
+			uutMode:            true,
+			ExternalKubeCACert: externalCA.CertPath,
+			ExternalKubeCAKey:  externalCA.KeyPath,
+		}
+		err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+		if err != nil {
+			t.Fatalf("Unexpected error in round %d: %s", i, err)
+		}
+		caCert, err := LoadCertificate(externalCA.CertPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		for _, cert := range []*RSACertificate{creds.KubeServer, creds.KubeClient} {
+			loaded, err := LoadCertificate(cert.CertPath)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if err := loaded.CheckSignatureFrom(caCert); err != nil {
+				t.Fatalf("'%s' not signed by external CA '%s' in round %d: %s", cert.CertPath, caName, i, err)
+			}
+		}
+		etcdCA, err := LoadCertificate(creds.EtcdCA.CertPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if etcdCA.Subject.CommonName == caName {
+			t.Fatal("External kubernetes CA used for etcd")
+		}
+	}
+}
+
+// TestCreateOrLoadCertificatesListenAddressChange checks whether server certificates are regenerated for a new listen
+// address
+func TestCreateOrLoadCertificatesListenAddressChange(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := MicrokubeCredentials{uutMode: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	kubeCA, err := ioutil.ReadFile(creds.KubeCA.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	creds = MicrokubeCredentials{uutMode: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("10.2.3.4"), net.ParseIP("127.2.2.2"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for server, sans := range map[*RSACertificate][]string{
+		creds.KubeServer: {"10.2.3.4", "127.2.2.2", "localhost"},
+		creds.EtcdServer: {"10.2.3.4", "localhost"},
+	} {
+		cert, err := LoadCertificate(server.CertPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		for _, san := range sans {
+			if !containsSAN(cert, san) {
+				t.Fatalf("'%s' lacks SAN '%s'", server.CertPath, san)
+			}
+		}
+	}
+	// The CA is kept, so existing clients still trust the cluster
+	reloadedCA, err := ioutil.ReadFile(creds.KubeCA.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(reloadedCA) != string(kubeCA) {
+		t.Fatal("Kube CA was regenerated")
+	}
+}
+
+// TestWriteCABundle checks whether the CA bundle contains both CAs and can be written repeatedly
+func TestWriteCABundle(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := MicrokubeCredentials{uutMode: true}
+	err = creds.WriteCABundle(path.Join(tmpDir, "bundle.pem"))
+	if err == nil {
+		t.Fatal("Expected error for credentials that weren't loaded")
+	}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	bundlePath := path.Join(tmpDir, "nested", "dir", "bundle.pem")
+	for i := 0; i < 2; i++ {
+		err = creds.WriteCABundle(bundlePath)
+		if err != nil {
+			t.Fatalf("Unexpected error in round %d: %s", i, err)
+		}
+	}
+	bundle, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		t.Fatal("Bundle doesn't contain any certificates")
+	}
+	if len(pool.Subjects()) != 2 {
+		t.Fatalf("Unexpected number of certificates in bundle: %d", len(pool.Subjects()))
+	}
+	for _, server := range []*RSACertificate{creds.EtcdServer, creds.KubeServer} {
+		cert, err := LoadCertificate(server.CertPath)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"})
+		if err != nil {
+			t.Fatalf("'%s' not trusted by bundle: %s", server.CertPath, err)
+		}
+	}
+}
+
+// TestCreateOrLoadCertificatesReadOnly checks whether the read-only client certificate is created on request, kept on
+// reload and carries the read-only group
+func TestCreateOrLoadCertificatesReadOnly(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := MicrokubeCredentials{uutMode: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if creds.KubeReadOnlyClient != nil {
+		t.Fatal("Read-only client certificate created without being requested")
+	}
+
+	creds = MicrokubeCredentials{uutMode: true, ReadOnlyClient: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	checkFilesExist([]string{creds.KubeReadOnlyClient.CertPath, creds.KubeReadOnlyClient.KeyPath}, t)
+	cert, err := LoadCertificate(creds.KubeReadOnlyClient.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != ReadOnlyGroup {
+		t.Fatalf("Unexpected organization %v", cert.Subject.Organization)
+	}
+	caCert, err := LoadCertificate(creds.KubeCA.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("Read-only client certificate not signed by kubernetes CA: %s", err)
+	}
+
+	// The existing certificate is kept on reload
+	creds = MicrokubeCredentials{uutMode: true, ReadOnlyClient: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	reloaded, err := LoadCertificate(creds.KubeReadOnlyClient.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reloaded.Equal(cert) {
+		t.Fatal("Read-only client certificate regenerated on reload")
+	}
+}
+
+// TestCreateOrLoadCertificatesMetricsServer checks whether the aggregator client and metrics-server certificates are
+// created on request and the latter is valid for the metrics-server service
+func TestCreateOrLoadCertificatesMetricsServer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-helper-unittests")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	creds := MicrokubeCredentials{uutMode: true, MetricsServer: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	checkFilesExist([]string{creds.KubeAggregatorClient.CertPath, creds.KubeAggregatorClient.KeyPath,
+		creds.KubeMetricsServer.CertPath, creds.KubeMetricsServer.KeyPath}, t)
+	client, err := LoadCertificate(creds.KubeAggregatorClient.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if client.Subject.CommonName != AggregatorClientName {
+		t.Fatalf("Unexpected aggregator client name '%s'", client.Subject.CommonName)
+	}
+	server, err := LoadCertificate(creds.KubeMetricsServer.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := server.VerifyHostname("metrics-server.kube-system.svc"); err != nil {
+		t.Fatalf("metrics-server certificate not valid for its service: %s", err)
+	}
+
+	// Existing certificates are kept on reload
+	creds = MicrokubeCredentials{uutMode: true, MetricsServer: true}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	reloaded, err := LoadCertificate(creds.KubeMetricsServer.CertPath)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reloaded.Equal(server) {
+		t.Fatal("metrics-server certificate regenerated on reload")
+	}
+}
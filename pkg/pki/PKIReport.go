@@ -0,0 +1,190 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"time"
+)
+
+// CertReport describes a certificate of a MicrokubeCredentials instance and the result of all checks on it
+type CertReport struct {
+	// Name of the certificate, e.g. 'kube server'
+	Name string
+	// Paths of the certificate and it's key
+	CertPath, KeyPath string
+	// Parsed certificate, nil if it couldn't be loaded
+	Cert *x509.Certificate
+	// Error loading the certificate, if any
+	LoadErr error
+	// Error checking the validity window, if any (a *CertValidityError if the certificate could be loaded)
+	ValidityErr error
+	// Error verifying the chain up to the issuing CA, if any
+	ChainErr error
+	// Error checking whether the private key matches the certificate, if any
+	KeyErr error
+}
+
+// OK returns whether all checks on the certificate passed
+func (r *CertReport) OK() bool {
+	return r.LoadErr == nil && r.ValidityErr == nil && r.ChainErr == nil && r.KeyErr == nil
+}
+
+// SANs returns all subject alternative names of the certificate
+func (r *CertReport) SANs() []string {
+	if r.Cert == nil {
+		return nil
+	}
+	sans := append([]string{}, r.Cert.DNSNames...)
+	for _, ip := range r.Cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// LoadCertificates loads existing certificates from 'baseDir' without creating missing ones. An error is returned if
+// any of the files is missing.
+func (m *MicrokubeCredentials) LoadCertificates(baseDir string) error {
+	certs := []struct {
+		target **RSACertificate
+		dir    string
+		name   string
+	}{
+		{&m.EtcdCA, "etcdtls", "ca"},
+		{&m.EtcdServer, "etcdtls", "server"},
+		{&m.EtcdClient, "etcdtls", "client"},
+		{&m.KubeCA, "kubetls", "ca"},
+		{&m.KubeServer, "kubetls", "server"},
+		{&m.KubeClient, "kubetls", "client"},
+		{&m.KubeClusterCA, "kubectls", "ca"},
+		{&m.KubeSvcSignCert, "kubestls", "cert"},
+	}
+	for _, cert := range certs {
+		loaded := &RSACertificate{
+			CertPath: path.Join(baseDir, cert.dir, cert.name+".pem"),
+			KeyPath:  path.Join(baseDir, cert.dir, cert.name+".key"),
+		}
+		for _, file := range []string{loaded.CertPath, loaded.KeyPath} {
+			_, err := os.Stat(file)
+			if err != nil {
+				return errors.Wrap(err, "missing credentials")
+			}
+		}
+		*cert.target = loaded
+	}
+	return nil
+}
+
+// namedCertificate is a certificate of a MicrokubeCredentials instance together with it's name and issuer
+type namedCertificate struct {
+	name   string
+	cert   *RSACertificate
+	issuer *RSACertificate
+}
+
+// namedCertificates returns all certificates of 'm', some of which may be nil
+func (m *MicrokubeCredentials) namedCertificates() []namedCertificate {
+	return []namedCertificate{
+		{"etcd CA", m.EtcdCA, m.EtcdCA},
+		{"etcd server", m.EtcdServer, m.EtcdCA},
+		{"etcd client", m.EtcdClient, m.EtcdCA},
+		{"kube CA", m.KubeCA, m.KubeCA},
+		{"kube server", m.KubeServer, m.KubeCA},
+		{"kube client", m.KubeClient, m.KubeCA},
+		{"kube cluster CA", m.KubeClusterCA, m.KubeClusterCA},
+		{"kube service account signing cert", m.KubeSvcSignCert, m.KubeSvcSignCert},
+	}
+}
+
+// CheckExpiry returns the names (e.g. 'kube server') of all certificates that expire within 'within' from now,
+// including those that already expired. If a certificate can't be loaded, the remaining certificates are still checked
+// and the first error is returned.
+func (m *MicrokubeCredentials) CheckExpiry(within time.Duration) ([]string, error) {
+	deadline := time.Now().Add(within)
+	var expiring []string
+	var firstErr error
+	for _, cert := range m.namedCertificates() {
+		if cert.cert == nil {
+			continue
+		}
+		parsed, err := LoadCertificate(cert.cert.CertPath)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "couldn't check expiry of %s", cert.name)
+			}
+			continue
+		}
+		if parsed.NotAfter.Before(deadline) {
+			expiring = append(expiring, cert.name)
+		}
+	}
+	return expiring, firstErr
+}
+
+// Inspect checks all certificates of 'm' at 'now', allowing for ClockSkewTolerance. For each certificate, it's validity
+// window, chain up to the issuing CA and whether the private key matches are checked.
+func (m *MicrokubeCredentials) Inspect(now time.Time) []CertReport {
+	certs := m.namedCertificates()
+	reports := make([]CertReport, 0, len(certs))
+	for _, cert := range certs {
+		if cert.cert == nil {
+			continue
+		}
+		report := CertReport{
+			Name:     cert.name,
+			CertPath: cert.cert.CertPath,
+			KeyPath:  cert.cert.KeyPath,
+		}
+		report.Cert, report.LoadErr = LoadCertificate(cert.cert.CertPath)
+		if report.LoadErr == nil {
+			report.ValidityErr = CheckValidity(report.Cert, now, m.ClockSkewTolerance)
+			report.ChainErr = verifyChain(report.Cert, cert.issuer, now)
+		}
+		_, report.KeyErr = tls.LoadX509KeyPair(cert.cert.CertPath, cert.cert.KeyPath)
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// verifyChain checks whether 'cert' was issued by 'issuer' and is valid for any purpose. The validity window is checked
+// separately, so 'now' is moved into it to keep clock skew from showing up twice.
+func verifyChain(cert *x509.Certificate, issuer *RSACertificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		now = cert.NotBefore
+	} else if now.After(cert.NotAfter) {
+		now = cert.NotAfter
+	}
+	if issuer == nil {
+		return errors.New("no issuer")
+	}
+	issuerCert, err := LoadCertificate(issuer.CertPath)
+	if err != nil {
+		return errors.Wrap(err, "couldn't load issuer")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuerCert)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
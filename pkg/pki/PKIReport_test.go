@@ -0,0 +1,141 @@
+/*
+ * Copyright 2018 The microkube authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pki
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// copyFile copies 'src' to 'dst', failing the test on errors
+func copyFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatalf("Couldn't read '%s': %s", src, err)
+	}
+	err = ioutil.WriteFile(dst, data, 0600)
+	if err != nil {
+		t.Fatalf("Couldn't write '%s': %s", dst, err)
+	}
+}
+
+// TestInspect checks whether a freshly created PKI passes all checks and broken chains and keys are detected
+func TestInspect(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	creds := MicrokubeCredentials{}
+	err = creds.LoadCertificates(tmpDir)
+	if err == nil {
+		t.Fatal("Expected error missing when loading nonexistent credentials!")
+	}
+
+	creds.uutMode = true
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	loaded := MicrokubeCredentials{}
+	err = loaded.LoadCertificates(tmpDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	reports := loaded.Inspect(time.Now())
+	if len(reports) != 8 {
+		t.Fatalf("Unexpected number of reports: %d", len(reports))
+	}
+	for _, report := range reports {
+		if !report.OK() {
+			t.Fatalf("Unexpected problem with %s: %v %v %v %v", report.Name, report.LoadErr, report.ValidityErr,
+				report.ChainErr, report.KeyErr)
+		}
+		if report.Name == "kube server" {
+			found := false
+			for _, san := range report.SANs() {
+				found = found || san == "127.1.1.1"
+			}
+			if !found {
+				t.Fatalf("Service address missing from SANs: %v", report.SANs())
+			}
+		}
+	}
+
+	// Replace the kube server cert with the one from etcd and mix up the kube client key
+	copyFile(t, path.Join(tmpDir, "etcdtls", "server.pem"), path.Join(tmpDir, "kubetls", "server.pem"))
+	copyFile(t, path.Join(tmpDir, "etcdtls", "server.key"), path.Join(tmpDir, "kubetls", "server.key"))
+	copyFile(t, path.Join(tmpDir, "etcdtls", "client.key"), path.Join(tmpDir, "kubetls", "client.key"))
+	for _, report := range loaded.Inspect(time.Now()) {
+		switch report.Name {
+		case "kube server":
+			if report.ChainErr == nil || report.KeyErr != nil {
+				t.Fatalf("Unexpected results for kube server: %v %v", report.ChainErr, report.KeyErr)
+			}
+		case "kube client":
+			if report.ChainErr != nil || report.KeyErr == nil {
+				t.Fatalf("Unexpected results for kube client: %v %v", report.ChainErr, report.KeyErr)
+			}
+		default:
+			if !report.OK() {
+				t.Fatalf("Unexpected problem with %s", report.Name)
+			}
+		}
+	}
+
+	// Far in the future, everything should have expired
+	for _, report := range loaded.Inspect(time.Now().Add(10 * 365 * 24 * time.Hour)) {
+		if _, ok := report.ValidityErr.(*CertValidityError); !ok {
+			t.Fatalf("Unexpected validity result for %s: %v", report.Name, report.ValidityErr)
+		}
+	}
+}
+
+// TestCheckExpiry checks whether certificates expiring within the window are reported
+func TestCheckExpiry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "microkube-unittests-pki")
+	if err != nil {
+		t.Fatalf("tempdir creation failed: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	creds := MicrokubeCredentials{uutMode: true, CertValidity: 72 * time.Hour}
+	err = creds.CreateOrLoadCertificates(tmpDir, net.ParseIP("127.0.0.1"), net.ParseIP("127.1.1.1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expiring, err := creds.CheckExpiry(24 * time.Hour)
+	if err != nil || len(expiring) != 0 {
+		t.Fatalf("Unexpected result: %v %v", expiring, err)
+	}
+	expiring, err = creds.CheckExpiry(7 * 24 * time.Hour)
+	if err != nil || len(expiring) != 8 || expiring[4] != "kube server" {
+		t.Fatalf("Unexpected result: %v %v", expiring, err)
+	}
+
+	os.Remove(creds.KubeClient.CertPath)
+	expiring, err = creds.CheckExpiry(7 * 24 * time.Hour)
+	if err == nil || len(expiring) != 7 {
+		t.Fatalf("Unexpected result: %v %v", expiring, err)
+	}
+}